@@ -3,10 +3,14 @@ package backends
 import (
 	"context"
 	"fmt"
-	"log"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/Microkubes/microservice-tools/config"
 )
@@ -25,11 +29,44 @@ func NewFilter() Filter {
 // For example:
 // 		filter := backends.NewFilter().Match("id", "0001")
 // would match the entry with ID equals to "0001".
+//
+// A nil value, including a nil pointer, map or slice, means the property IS NULL: it matches the
+// entries with a null value of the property and the entries without the property, on all the
+// backends. To leave the property unconstrained, like for a missing request parameter, use
+// MatchAny instead.
 func (f Filter) Match(property string, value interface{}) Filter {
+	if isNilValue(value) {
+		value = nil
+	}
 	f[property] = value
 	return f
 }
 
+// MatchAny explicitly leaves the property unconstrained: it matches every entry, whatever the
+// value of the property, and replaces the conditions set for the property before. For example:
+// 		filter := backends.NewFilter().Match("role", "user")
+// 		if role == nil {
+// 			filter.MatchAny("role")
+// 		}
+// It is encoded as the "$any" operator, which the backends skip.
+func (f Filter) MatchAny(property string) Filter {
+	f[property] = map[string]interface{}{"$any": true}
+	return f
+}
+
+// isNilValue checks if the value is nil or a nil pointer, map or slice.
+func isNilValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
 // MatchPattern sets a pattern match for the given property.
 // The match works similar to how 'LIKE' pattern matching works
 // in SQL:
@@ -37,6 +74,9 @@ func (f Filter) Match(property string, value interface{}) Filter {
 // "a%" matches "ab" but not "ba"
 // "%ab%" matches anything that contains "ab"
 // "ab" does an exact match to "ab"
+// A literal "%" is escaped as "%%", so "100%%%" matches the values starting with "100%".
+// An escaped "%" next to a wildcard is read from the left ("%%%a" is a literal "%", then a
+// wildcard, then "a"), so to match a literal prefix or suffix use StartsWith or EndsWith.
 func (f Filter) MatchPattern(property, value string) Filter {
 	f[property] = map[string]string{
 		"$pattern": value,
@@ -44,19 +84,749 @@ func (f Filter) MatchPattern(property, value string) Filter {
 	return f
 }
 
+// StartsWith matches the entries with the property value starting with the prefix.
+// Unlike MatchPattern, the prefix is matched literally, so "%" and the other characters
+// do not need to be escaped.
+func (f Filter) StartsWith(property, prefix string) Filter {
+	return f.withOperator(property, "$startsWith", prefix)
+}
+
+// EndsWith matches the entries with the property value ending with the suffix. The suffix
+// is matched literally, like the prefix of StartsWith. DynamoDB has no condition on the end
+// of a value, so there the suffix matches anywhere in the value, like MatchPattern("%suffix").
+func (f Filter) EndsWith(property, suffix string) Filter {
+	return f.withOperator(property, "$endsWith", suffix)
+}
+
 // Set is an alias for Filter.Match - do an exact match on the given property.
 func (f Filter) Set(property string, value interface{}) Filter {
-	f[property] = value
+	return f.Match(property, value)
+}
+
+// Gt matches the entries with the property value greater than the given value.
+func (f Filter) Gt(property string, value interface{}) Filter {
+	return f.withOperator(property, "$gt", value)
+}
+
+// Gte matches the entries with the property value greater than or equal to the given value.
+func (f Filter) Gte(property string, value interface{}) Filter {
+	return f.withOperator(property, "$gte", value)
+}
+
+// Lt matches the entries with the property value less than the given value.
+func (f Filter) Lt(property string, value interface{}) Filter {
+	return f.withOperator(property, "$lt", value)
+}
+
+// Lte matches the entries with the property value less than or equal to the given value.
+func (f Filter) Lte(property string, value interface{}) Filter {
+	return f.withOperator(property, "$lte", value)
+}
+
+// Between matches the entries with the property value in the range [from, to] (inclusive).
+// For example:
+// 		filter := backends.NewFilter().Between("age", 18, 65)
+func (f Filter) Between(property string, from, to interface{}) Filter {
+	return f.Gte(property, from).Lte(property, to)
+}
+
+// After matches the entries with the time property after the given time (exclusive).
+// The time is converted to UTC, so it compares with the times stored by the backends in UTC
+// regardless of the time zone of t.
+func (f Filter) After(property string, t time.Time) Filter {
+	return f.Gt(property, t.UTC())
+}
+
+// Before matches the entries with the time property before the given time (exclusive).
+// The time is converted to UTC, like in After.
+func (f Filter) Before(property string, t time.Time) Filter {
+	return f.Lt(property, t.UTC())
+}
+
+// WithinLast matches the entries with the time property in the last d, up to now. For example:
+// 		filter := backends.NewFilter().WithinLast("created_at", 24*time.Hour)
+// matches the entries created in the last 24 hours. Note that the current time is captured when
+// the filter is built, not when it is run, so a filter that is kept and reused keeps matching
+// the same time window. The bound is inclusive and in UTC.
+func (f Filter) WithinLast(property string, d time.Duration) Filter {
+	return f.Gte(property, time.Now().UTC().Add(-d))
+}
+
+// In matches the entries with the property value equal to any of the given values.
+// For example:
+// 		filter := backends.NewFilter().In("role", "admin", "owner")
+func (f Filter) In(property string, values ...interface{}) Filter {
+	return f.withOperator(property, "$in", values)
+}
+
+// MatchField matches the entries where the property compares to another property of the same
+// entry with the operator: "=", "!=", ">", ">=", "<" or "<=". For example:
+// 		filter := backends.NewFilter().MatchField("updated_at", ">", "created_at")
+// matches the entries that were updated after they were created.
+//
+// The comparison never matches an entry that is missing either of the properties or has a null
+// value in them. The properties should hold values of the same type; how values of different
+// types compare is backend specific. A property has one field comparison, so calling MatchField
+// again for the same property replaces it. A backend that cannot compare fields returns an error
+// for the filter, as for any other unknown operator.
+func (f Filter) MatchField(property, operator, otherProperty string) Filter {
+	return f.withOperator(property, "$fieldCmp", map[string]interface{}{
+		"op":    operator,
+		"field": otherProperty,
+	})
+}
+
+// fieldComparisonOperators are the operators supported by Filter.MatchField.
+var fieldComparisonOperators = map[string]bool{
+	"=":  true,
+	"!=": true,
+	">":  true,
+	">=": true,
+	"<":  true,
+	"<=": true,
+}
+
+// fieldComparison returns the operator and the other property of a comparison set with
+// Filter.MatchField.
+func fieldComparison(operand interface{}) (string, string, error) {
+	spec := map[string]interface{}{}
+	switch v := operand.(type) {
+	case map[string]interface{}:
+		spec = v
+	case map[string]string:
+		for key, value := range v {
+			spec[key] = value
+		}
+	default:
+		return "", "", fmt.Errorf("field comparison must be a map with op and field")
+	}
+
+	operator, _ := spec["op"].(string)
+	if !fieldComparisonOperators[operator] {
+		return "", "", fmt.Errorf("unknown field comparison operator %q", operator)
+	}
+	field, _ := spec["field"].(string)
+	if field == "" {
+		return "", "", fmt.Errorf("the field to compare to is required")
+	}
+	return operator, field, nil
+}
+
+// ArrayContains matches the entries with an array property that contains the given value.
+// For example:
+// 		filter := backends.NewFilter().ArrayContains("tags", "go")
+// matches the entry with tags ["go", "mongo"], but not the one with tags ["java"].
+// A scalar (non-array) property never matches, even when it equals the value.
+// To match several values, use ArrayContainsAll.
+func (f Filter) ArrayContains(property string, value interface{}) Filter {
+	return f.withOperator(property, "$contains", value)
+}
+
+// ArrayContainsAll matches the entries with an array property that contains all of the given
+// values, in any order. For example:
+// 		filter := backends.NewFilter().ArrayContainsAll("tags", "go", "mongo")
+// A scalar (non-array) property never matches. At least one value must be given.
+func (f Filter) ArrayContainsAll(property string, values ...interface{}) Filter {
+	return f.withOperator(property, "$all", values)
+}
+
+// Mod matches the entries with an integer property whose remainder of the division by the
+// divisor equals the remainder, for example to split the records between workers without
+// coordination:
+// 		filter := backends.NewFilter().Mod("seq", int64(shardCount), int64(shardIndex))
+// The remainder has the sign of the value, like the % operator of Go, so the negative values
+// never match a non-negative remainder. The entries without the property, or with a value that
+// is not an integer, don't match. The divisor must not be zero.
+func (f Filter) Mod(property string, divisor, remainder int64) Filter {
+	return f.withOperator(property, "$mod", []int64{divisor, remainder})
+}
+
+// modOperands returns the divisor and the remainder of a condition set with Filter.Mod.
+func modOperands(operand interface{}) (int64, int64, error) {
+	var values []interface{}
+	switch v := operand.(type) {
+	case []int64:
+		for _, value := range v {
+			values = append(values, value)
+		}
+	case []interface{}:
+		values = v
+	default:
+		return 0, 0, fmt.Errorf("$mod must be a list of the divisor and the remainder")
+	}
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("$mod must be a list of the divisor and the remainder")
+	}
+
+	operands := make([]int64, 2)
+	for i, value := range values {
+		integer, ok := integerValue(value)
+		if !ok {
+			return 0, 0, fmt.Errorf("the divisor and the remainder of $mod must be integers, got %v", value)
+		}
+		operands[i] = integer
+	}
+	if operands[0] == 0 {
+		return 0, 0, fmt.Errorf("the divisor of $mod must not be zero")
+	}
+	return operands[0], operands[1], nil
+}
+
+// integerValue returns the value as an int64 if it is an integer, including the integral float64
+// values decoded from JSON.
+func integerValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) || math.Abs(v) >= 1<<63 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// Not matches the entries that do not match the given filter, that is the entries for which
+// at least one of the conditions of the filter does not hold. For example:
+// 		filter := backends.NewFilter().Not(backends.NewFilter().Match("role", "admin").Match("active", true))
+// matches all entries except the active admins.
+//
+// A negated condition matches the entries that don't have the property as well. A negated
+// pattern matches the entries with a value that does not match the pattern, and a negated
+// range (Gt, Between etc.) matches the entries with a value outside of the range, including
+// the values of different type that cannot be compared. The negated filter must not be empty.
+// The filter holds one negation, so calling Not again replaces the previous one.
+func (f Filter) Not(filter Filter) Filter {
+	f[NotOperator] = filter
+	return f
+}
+
+// NotOperator is the filter key of the filter negated with Filter.Not.
+const NotOperator = "$not"
+
+// AnyFieldMatches matches the entries with the value in any of the fields, like a search box
+// matching a term against several fields. For example:
+// 		filter := backends.NewFilter().Match("active", true).AnyFieldMatches(term, "name", "email", "phone")
+// matches the active entries whose name, email or phone equals the term. The other conditions
+// of the filter must match as well. The backends match it as (name = term OR email = term OR
+// phone = term). At least one field is required. The filter holds one set of alternatives, so
+// calling AnyFieldMatches again replaces the previous one.
+func (f Filter) AnyFieldMatches(value interface{}, fields ...string) Filter {
+	alternatives := make([]Filter, len(fields))
+	for i, field := range fields {
+		alternatives[i] = Filter{field: value}
+	}
+	f[OrOperator] = alternatives
+	return f
+}
+
+// OrOperator is the filter key of the alternatives set with Filter.AnyFieldMatches: a list of
+// filters, of which at least one must match.
+const OrOperator = "$or"
+
+// TextSearch matches the entries that have any of the words of the query in the given fields,
+// as a full-text search. For example:
+// 		filter := backends.NewFilter().TextSearch("coffee shop", "title", "description")
+// matches the entries with "coffee" or "shop" in the title or in the description.
+//
+// MongoDB runs the search with $text, which requires a text index on the collection, defined
+// with the fields prefixed with "$text:", like NewNonUniqueIndex("$text:title", "$text:description").
+// The fields searched are the fields of the text index, so the given fields are not used. The
+// in-memory backend matches the words regardless of case in the given fields, or in all the
+// string properties if no field is given. DynamoDB has no full-text search, so the filter is
+// ErrUnsupported there. The filter holds one text search, so calling TextSearch again replaces
+// the previous one.
+func (f Filter) TextSearch(query string, fields ...string) Filter {
+	f[TextOperator] = map[string]interface{}{
+		"query":  query,
+		"fields": fields,
+	}
+	return f
+}
+
+// TextOperator is the filter key of the text search set with Filter.TextSearch.
+const TextOperator = "$text"
+
+// textSearch returns the query and the fields of the text search set with Filter.TextSearch.
+// The fields may be set as []interface{} as well, like when decoded from JSON.
+func textSearch(value interface{}) (string, []string, error) {
+	spec, ok := value.(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("the text search must be set with the query and the fields")
+	}
+	query, ok := spec["query"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("the query of the text search must be a string")
+	}
+
+	fields := []string{}
+	switch declared := spec["fields"].(type) {
+	case nil:
+	case []string:
+		fields = append(fields, declared...)
+	case []interface{}:
+		for _, field := range declared {
+			str, ok := field.(string)
+			if !ok {
+				return "", nil, fmt.Errorf("the fields of the text search must be strings")
+			}
+			fields = append(fields, str)
+		}
+	default:
+		return "", nil, fmt.Errorf("the fields of the text search must be a list of strings")
+	}
+	return query, fields, nil
+}
+
+// textWords splits the text in lower case words.
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// negatedFilter returns the filter negated with Filter.Not. The negated filter may be set
+// as a plain map as well, like when decoded from JSON.
+func negatedFilter(value interface{}) (Filter, error) {
+	var filter Filter
+	switch v := value.(type) {
+	case Filter:
+		filter = v
+	case map[string]interface{}:
+		filter = Filter(v)
+	default:
+		return nil, fmt.Errorf("negated filter must be a filter")
+	}
+	if len(filter) == 0 {
+		return nil, fmt.Errorf("negated filter must not be empty")
+	}
+	return filter, nil
+}
+
+// alternativeFilters returns the alternatives set with Filter.AnyFieldMatches. The alternatives
+// may be set as plain maps as well, like when decoded from JSON.
+func alternativeFilters(value interface{}) ([]Filter, error) {
+	var alternatives []Filter
+	switch v := value.(type) {
+	case []Filter:
+		alternatives = v
+	case []interface{}:
+		for _, alternative := range v {
+			switch a := alternative.(type) {
+			case Filter:
+				alternatives = append(alternatives, a)
+			case map[string]interface{}:
+				alternatives = append(alternatives, Filter(a))
+			default:
+				return nil, fmt.Errorf("the alternatives must be filters")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("the alternatives must be a list of filters")
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("at least one alternative is required")
+	}
+	for _, alternative := range alternatives {
+		if len(alternative) == 0 {
+			return nil, fmt.Errorf("the alternatives must not be empty")
+		}
+	}
+	return alternatives, nil
+}
+
+// withOperator adds the operator to the operators already set for the property.
+// The operators for one property are kept in a map - {"$gte": 18, "$lte": 65}.
+func (f Filter) withOperator(property, operator string, value interface{}) Filter {
+	specs, ok := operatorSpecs(f[property])
+	if !ok {
+		specs = map[string]interface{}{}
+	}
+	specs[operator] = value
+	f[property] = specs
 	return f
 }
 
+// operatorSpecs returns the operators set for a property value. The value holds operators
+// if it is a non-empty map with "$"-prefixed keys, like the ones set by MatchPattern or Gt.
+// Any other value is matched exactly.
+func operatorSpecs(value interface{}) (map[string]interface{}, bool) {
+	specs := map[string]interface{}{}
+	switch v := value.(type) {
+	case map[string]string:
+		for key, val := range v {
+			specs[key] = val
+		}
+	case map[string]interface{}:
+		for key, val := range v {
+			specs[key] = val
+		}
+	default:
+		return nil, false
+	}
+
+	if len(specs) == 0 {
+		return nil, false
+	}
+	for key := range specs {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return specs, true
+}
+
+// inValues returns the values of the "$in" operator as a list.
+func inValues(operand interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(operand)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	values := make([]interface{}, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		values[i] = v.Index(i).Interface()
+	}
+	return values, true
+}
+
+// SortKey is a property to sort the records by. The sorting is "asc" (the default) or "desc", see
+// SortDirection.
+type SortKey struct {
+	Property string
+	Sorting  string
+}
+
+// ReadOpts holds the per-operation options for reading data.
+// The zero value means the backend defaults are used.
+type ReadOpts struct {
+	// Consistent requests a strongly consistent read - a read that reflects all writes that
+	// were acknowledged before it. By default reads are eventually consistent on DynamoDB and
+	// monotonic on MongoDB. On MongoDB this reads from the primary.
+	Consistent bool
+	// ForcePrimary routes the read to the primary endpoint of a backend with a read replica
+	// configured, for read-after-write consistency. See ReadEndpointSuffix.
+	ForcePrimary bool
+	// ReadPreference selects the member of the replica set that serves the read, like the
+	// nearest one. Nil for the default of the backend. See ReadPreference.
+	ReadPreference *ReadPreference
+	// Collation sets how the string values are compared when sorting the results of
+	// GetAllWithOpts. By default the backend collation is used. See Collation.
+	Collation *Collation
+	// BatchSize is the number of records fetched per round trip to the backend, to fetch the
+	// large records in smaller batches and the small records in larger ones. It overrides the
+	// batch size of the repository definition. Zero for the default. It is used by MongoDB; the
+	// DynamoDB scans of GetAllWithOpts and the in-memory reads ignore it. Negative is ErrInvalidInput.
+	BatchSize int
+	// StrictDecode fails the read with ErrInvalidInput if a record has a property that the
+	// result type does not have. By default such properties are ignored. See Repository.GetAll.
+	StrictDecode bool
+	// SkipDecodeErrors leaves the records that cannot be decoded into the results type out of the
+	// results of GetAllWithOpts, instead of failing the read. The read returns the decoded records
+	// together with a DecodeErrors error that holds the error of each skipped record:
+	// 		results, err := repo.GetAllWithOpts(filter, &User{}, "name", "asc", 0, 0, backends.ReadOpts{SkipDecodeErrors: true})
+	// 		var decodeErrs backends.DecodeErrors
+	// 		if errors.As(err, &decodeErrs) {
+	// 			log.Println(decodeErrs)
+	// 		} else if err != nil {
+	// 			return err
+	// 		}
+	// By default a record that cannot be decoded, like one with a string in an int field, fails
+	// the whole read.
+	SkipDecodeErrors bool
+}
+
+// Collation holds the rules for comparing strings when sorting.
+// For example, to sort a user list alphabetically regardless of case:
+// 		repo.GetAllWithOpts(filter, &User{}, "name", "asc", 0, 0, backends.ReadOpts{
+// 			Collation: &backends.Collation{CaseInsensitive: true},
+// 		})
+// returns "Apple", "apple", "banana" instead of "Apple", "banana", "apple". Strings that differ
+// only in case are ordered by their code points, so upper case comes first.
+//
+// The MongoDB driver does not support collations, so on MongoDB the matched documents are
+// sorted on the client and the collation fetches all of them. The DynamoDB scans are not
+// ordered, so the collation has no effect on DynamoDB.
+type Collation struct {
+	// CaseInsensitive compares the strings regardless of case.
+	CaseInsensitive bool
+	// Locale is the language of the strings, like "en" or "tr". The locale sets the rules of
+	// the case mapping, so "I" and "ı" are the same letter in Turkish ("tr" and "az").
+	Locale string
+}
+
+// fold returns the string in the form in which it is compared.
+func (c *Collation) fold(value string) string {
+	if !c.CaseInsensitive {
+		return value
+	}
+	switch strings.ToLower(strings.SplitN(strings.SplitN(c.Locale, "-", 2)[0], "_", 2)[0]) {
+	case "tr", "az":
+		return strings.ToLowerSpecial(unicode.TurkishCase, value)
+	}
+	return strings.ToLower(value)
+}
+
+// ForcePrimary returns read options that route the read to the primary endpoint.
+// For example:
+// 		repo.GetOneWithOpts(filter, &user, backends.ForcePrimary())
+func ForcePrimary() ReadOpts {
+	return ReadOpts{ForcePrimary: true}
+}
+
+// WriteOpts holds the per-operation options for writing data.
+// The zero value means the backend defaults are used.
+type WriteOpts struct {
+	// Durable requests the write to be acknowledged only after it is persisted. On MongoDB this
+	// is a "majority" write concern with journaling, by default the write is acknowledged by the
+	// primary only. DynamoDB writes are always durable, so the option is ignored there.
+	Durable bool
+}
+
+// RepositoryStats holds the size and the capacity of the collection/table of a repository, as
+// reported by the backend. The values that the backend does not report are zero.
+type RepositoryStats struct {
+	// ItemCount is the number of records. DynamoDB updates it about every six hours.
+	ItemCount int64
+	// SizeBytes is the size of the stored records in bytes.
+	SizeBytes int64
+	// ReadCapacity and WriteCapacity are the provisioned read and write capacity units of the
+	// DynamoDB table. They are zero for the on-demand tables.
+	ReadCapacity  int64
+	WriteCapacity int64
+}
+
 // Repository defines the interface for accessing the data
 type Repository interface {
 	GetOne(filter Filter, result interface{}) (interface{}, error)
+	GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error)
+	// GetAll fetches the matched records, sorted by the order property in the direction of the
+	// sorting: "asc" (or empty) or "desc", or their legacy spellings, see ParseSortDirection. Any
+	// other sorting is ErrInvalidInput. GetAllSorted takes the typed SortDirection instead.
+	// The results type hint is an example of one record: a struct or a map with string keys, or a
+	// pointer to one, like &User{}; any other hint, like nil or a slice, is ErrInvalidInput.
+	// The results are a pointer to a slice of pointers to the type of the hint, like *[]*User.
+	// A limit of zero or less means no limit. A negative offset is ErrInvalidInput. The same
+	// sorting, hint, limit and offset rules apply to all the GetAll variants.
+	//
+	// A sorted page of the records (with a limit or an offset) is sorted by the ID last, so the
+	// records with equal values of the order property are always in the same order, and paging
+	// through them with the offset returns every record exactly once. An unsorted page (no order
+	// and no default sort) is in the natural order of the backend: the insertion order of the
+	// in-memory backend and of the capped collections, and the _id order of MongoDB, as its
+	// natural order is not stable. The DynamoDB scans are not sorted.
+	//
+	// The results type may be a lighter type (DTO) than the stored records. The records are
+	// decoded leniently: the properties that the results type does not have are ignored, and the
+	// fields that the record does not have are left zero. To fail on the properties the results
+	// type does not have instead, read with ReadOpts.StrictDecode. The same applies to GetOne.
+	// A record with a value that does not fit the field of the results type fails the read; to
+	// skip such records instead, read with ReadOpts.SkipDecodeErrors.
 	GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error)
+	// GetFirst fetches the first of the matched records in the given order, as a pointer to the
+	// type of the results hint. If no record matches the filter, ErrNotFound is returned.
+	GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error)
+	GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error)
+	GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error)
+	// QueryRange fetches the records with the value of the hash key and the value of the range key
+	// matching the range operator, ordered by the range key. The operators are "$eq", "$gt",
+	// "$gte", "$lt", "$lte", "$startsWith" (a string prefix) and "$between" (the range value is
+	// []interface{}{from, to}, inclusive). DynamoDB queries the table with the key condition
+	// instead of scanning it; the other backends match the two fields with a filter.
+	// ErrInvalidInput is returned if the definition has no hash key and range key.
+	QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error)
+	// GetAllWithHint fetches all matched records like GetAll, hinting the backend to use the named
+	// index. The hint is for the queries that the query planner runs with a worse index. MongoDB is
+	// forced to use the index, which must be defined for the collection. DynamoDB queries the global
+	// secondary index if the filter has an exact match on its key, and scans the table otherwise.
+	// The backends without indexes, like the in-memory backend, ignore the hint.
+	GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error)
+	// ParallelScan reads all the records matching the filter, for exports of large tables. The
+	// records are read in the given number of segments scanned concurrently, and fn is called with
+	// each batch of records, a slice of pointers to the type of the results hint. The calls of fn
+	// may run concurrently, and every matched record is in exactly one batch. If fn (or a segment)
+	// fails, the other segments are cancelled and the error is returned. DynamoDB runs a parallel
+	// scan; MongoDB falls back to a single pass in the order of the ID, calling fn sequentially.
+	// Segments lower than 1 are ErrInvalidInput.
+	ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error
+	// Save creates a new record from the object if the filter is nil, otherwise it updates the
+	// record matching the filter with the properties of the object. The object must be a pointer
+	// to a struct or *map[string]interface{}. The result is the object itself (the same pointer)
+	// with the record as stored decoded into it, so the fields generated by the backend, like the
+	// ID of a new record, are populated. On update the properties of the record that are not set
+	// by the object are populated as well.
 	Save(object interface{}, filter Filter) (interface{}, error)
+	SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error)
+	// SaveWithTimestamp saves the object like Save and returns the time at which the write
+	// committed. MongoDB reports the time of the clock of the server right after the write, and
+	// the in-memory backend the time of the write itself. DynamoDB does not report the time of
+	// its writes, so the time there is of the client clock when the write returned and cannot be
+	// used to order the writes of different clients: BackendCapabilities.ServerTimestamps reports
+	// if the time is of the backend.
+	SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error)
+	// SaveUpsert updates the record matching the filter like Save, or inserts a new record if no
+	// record matches the filter. The new record gets the properties of the object and the exact
+	// match properties of the filter that the object does not set. The created flag reports
+	// whether a new record was inserted. The filter must not be empty.
+	SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error)
+	SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error)
+	// FindAndModify claims up to limit records matching the filter, in the order of the sort keys:
+	// it sets the properties of the update on each record and returns the updated records as
+	// []map[string]interface{}. Every record is matched and updated atomically, so when the
+	// update makes the record no longer match the filter (like setting "status" to "processing"
+	// when claiming the "queued" records), concurrent calls never claim the same record. It is
+	// meant for work queues. Returns an empty slice if no record matches the filter.
+	FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error)
+	// UpsertAll inserts or updates each of the objects. An object updates the record that has the
+	// same values of the conflict keys (top-level properties of the object), or it is inserted as a
+	// new record if there is no such record. Like Save with a filter, the update sets the properties
+	// of the object and keeps the other properties of the record.
+	// The objects are written in order, so when two objects collide on the conflict keys, the later
+	// object is applied over the earlier one. The result has one value per object: the record as
+	// written, of the same type as the object. The colliding objects all get the final record.
+	// If an object cannot be written, UpsertError with the index of the object is returned. The
+	// objects before it are written and the objects after it are not.
+	UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error)
+	// Bulk returns a BulkOp that accumulates inserts, updates and deletes and executes them
+	// together, in the order they were added. MongoDB sends each run of consecutive operations of
+	// the same kind as one bulk write. The in-memory and DynamoDB backends execute the operations
+	// one at a time, as DynamoDB cannot update or delete by a filter in a batch.
+	Bulk() BulkOp
+	// ReplaceOne atomically replaces the record matching the filter with the object and returns
+	// the record as it was before the replace, of the same type as the object. Unlike Save, the
+	// properties missing in the object are removed from the record. The ID of the record is kept.
+	// If no record matches the filter, ErrNotFound is returned.
+	ReplaceOne(filter Filter, object interface{}) (interface{}, error)
 	DeleteOne(filter Filter) error
+	// DeleteIf deletes the record matching the filter only if the record also matches the
+	// condition, like the state the caller last read, so a record changed by another process in
+	// the meantime is not deleted. The check and the delete are atomic. The deleted flag reports
+	// whether the record was deleted. If no record matches the filter, ErrNotFound is returned.
+	DeleteIf(filter Filter, condition Filter) (deleted bool, err error)
 	DeleteAll(filter Filter) error
+	// DeleteAllReturning deletes all the records matching the filter, like DeleteAll, and returns
+	// the IDs of the deleted records, for the cache invalidation or the events that follow a bulk
+	// delete. MongoDB and DynamoDB read the IDs of the matching records before deleting them,
+	// which costs an extra read of the records, so use DeleteAll when the IDs are not needed.
+	DeleteAllReturning(filter Filter) ([]interface{}, error)
+	// UpdateFieldsReturning sets the fields on all the records matching the filter and returns the
+	// IDs of the updated records. The other properties of the records are kept. The ID (and the
+	// keys of a DynamoDB table) cannot be updated. Like DeleteAllReturning, MongoDB and DynamoDB
+	// read the matching records first, and DynamoDB updates them one at a time.
+	UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error)
+	Exists(filter Filter) (bool, error)
+	Count(filter Filter) (int, error)
+	// CountBoth returns the number of the records matching the filter and the number of all the
+	// records of the repository, like for the grids showing the filtered and the total count.
+	// MongoDB runs two count commands, or one for an empty filter, and the in-memory backend
+	// counts in one pass; DynamoDB scans the table twice, or once for an empty filter.
+	CountBoth(filter Filter) (filtered int64, total int64, err error)
+	GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error)
+	// DescribeRepository returns the stats of the live collection/table, unlike the capacity in
+	// the repository definition, which is the declared one. See RepositoryStats.
+	DescribeRepository() (RepositoryStats, error)
+	// LastQueryStats returns the stats of the most recent GetAll (or GetAllWithOpts, GetFirst,
+	// GetAllByIndex, GetAllWithHint) of the repository, or zero stats if there was none. The stats
+	// are kept per repository instance, and the copies returned by WithContext have their own.
+	// It is safe to use the repository concurrently, but then the stats are of whichever query
+	// completed last, so use a WithContext copy per request to get the stats of a given query.
+	LastQueryStats() QueryStats
+	// EnsureIndexes creates the indexes that the collection/table does not have yet, so an index
+	// can be added to an existing repository without defining it again. It is safe to call it
+	// more than once. The existing indexes that are not in the list are logged, not dropped.
+	EnsureIndexes(indexes []Index) error
+	// ArrayAppend appends the values to the array of the field of all the records matching the
+	// filter, atomically per record, without reading the records first: the concurrent appends
+	// are not lost. A missing (or nil) field is set to an array of the values. It fails with
+	// ErrInvalidInput if the field of a matched record holds a value that is not an array. It
+	// returns the number of the updated records.
+	ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error)
+	// ArrayRemove removes all the elements equal to any of the values from the array of the field
+	// of all the records matching the filter, atomically per record. The records with no such
+	// elements, or without the field, are left as they are. It fails with ErrInvalidInput if the
+	// field of a matched record holds a value that is not an array. It returns the number of the
+	// records whose arrays were changed.
+	ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error)
+	// UpdatePath sets the value at the dot path of all the records matching the filter, like
+	// "settings.notifications.email", without rewriting the rest of the records. The missing
+	// documents on the path are created. A segment of the path on an array is the index of an
+	// element, like "addresses.0.city"; the element must exist on the in-memory and DynamoDB
+	// backends, while MongoDB pads the array with nulls up to the index. It fails with
+	// ErrInvalidInput if the path crosses a value that is not a document or an array, or starts
+	// with a key property. It returns the number of the updated records.
+	UpdatePath(filter Filter, path string, value interface{}) (int64, error)
+	// UsesIndex returns the index that the reads with the filter use: an index whose fields are
+	// all matched exactly by the filter. If several indexes match, the most selective one is
+	// returned: a unique index first, then the index with the most fields. It returns false if
+	// the filter matches no index, so the reads scan the whole collection/table. The IDs are
+	// indexed by MongoDB and the in-memory collection, the hash and range keys and the GSIs by
+	// DynamoDB, whose GetOne and Exists query the index instead of scanning the table.
+	UsesIndex(filter Filter) (Index, bool)
+	// Dump returns the query that the operation (DumpFind, DumpCount or DumpDelete) with the
+	// filter runs, without running it, for the audit logs and the debugging of the filters.
+	// MongoDB renders the shell command with the filter as JSON; DynamoDB renders the scan with
+	// the filter expression, with the values of its placeholders listed separately after ARGS.
+	// The in-memory backend has no query language, so it renders the filter itself. The output
+	// is meant for people to read and its format may change. Unlike the reads, Dump needs no
+	// connection to the database.
+	Dump(op string, filter Filter) (string, error)
+	// Migrate migrates the records of the repository from the schema version from to the
+	// version to, usually the one of RepositoryDefinition.GetSchemaVersion. The migration to the
+	// version v is migrations[v], and the migrations are applied in the order of the versions.
+	// The applied version is recorded after each migration in the SchemaVersionsRepository of
+	// the backend, so the migrations already applied, like by another instance of the service
+	// during a rolling deploy, are skipped. It fails with ErrInvalidInput if a migration is
+	// missing or the recorded version is lower than from, and with MigrationError if a
+	// migration fails. The migrations should be idempotent, as a migration that fails midway is
+	// applied again by the next Migrate.
+	Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error
+	// SaveIdempotent saves a new record like Save, unless the idempotency key was processed by a
+	// save of the repository within its TTL, so the redelivered messages of an at-least-once
+	// queue do not create duplicates. A repeated key is not applied again: deduped is true and
+	// the result is the record of the first save read into the object (or the object unchanged,
+	// if the record is gone). The keys are recorded in the IdempotencyKeysRepository of the
+	// backend, for at most MaxIdempotencyTTL. It fails with ErrInvalidInput if the key is empty
+	// or the TTL is out of range. If the save fails, the key is released so it can be retried.
+	SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (result interface{}, deduped bool, err error)
+	// Watch subscribes to the changes of the records of the repository, pushed by the change
+	// stream of the backend, like for invalidating a cache in real time. The inserts and the
+	// updates whose document matches the filter are sent on the channel, and all the deletes, as
+	// their document is gone. The channel is closed when the context is cancelled or the stream
+	// ends, and its goroutines exit with it. The backends without change streams return
+	// ErrUnsupported, see BackendCapabilities.ChangeStreams.
+	Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error)
+	WithContext(ctx context.Context) Repository
+	// WithSession returns a copy of the repository bound to the session, whose reads observe the
+	// writes made earlier in the session. See Session.
+	WithSession(session *Session) Repository
+}
+
+// GSIDefinition is a global secondary index of a DynamoDB table, parsed from the "GSI" entry
+// of the repository definition. The GSI entry maps the hash key attribute of each index to its
+// settings:
+// 		"GSI": map[string]interface{}{
+// 			"email": map[string]interface{}{
+// 				"readCapacity":  1,
+// 				"writeCapacity": 1,
+// 				"rangeKey":      "created_at", // optional
+// 				"rangeKeyType":  "N",          // optional, defaults to "S"
+// 				"projection":    "KEYS_ONLY",  // optional, defaults to "ALL"
+// 			},
+// 		}
+// The name of the index is the hash key attribute with the "-index" suffix ("email-index").
+type GSIDefinition struct {
+	Name          string
+	HashKey       string
+	RangeKey      string
+	HashKeyType   string
+	RangeKeyType  string
+	Projection    string
+	ReadCapacity  int64
+	WriteCapacity int64
 }
 
 type Index interface {
@@ -79,16 +849,85 @@ type RepositoryDefinition interface {
 	GetReadCapacity() int64
 	GetWriteCapacity() int64
 	GetGSI() map[string]interface{}
+	// GetGSIDefs returns the global secondary indexes sorted by name, or ErrInvalidInput if
+	// the GSI entry is malformed. See GSIDefinition.
+	GetGSIDefs() ([]GSIDefinition, error)
 	IsCustomID() bool
+	GetIDField() string
+	// GetFieldTypes returns the types of the fields, used to convert the filter values before
+	// querying. See CoerceFilter.
+	GetFieldTypes() map[string]string
+	// GetFields returns the declared fields of the records. If fields are declared, the fields of
+	// the indexes must be among them. Empty if the schema is not declared.
+	GetFields() []string
+	// GetBatchSize returns the number of records read or written per request by the scans and
+	// the bulk writes. Zero for the default of the backend.
+	GetBatchSize() int
+	// GetFieldMapping returns the mapping of the names of the properties of the Go types to the
+	// names of the stored fields. See FieldMappingRepository.
+	GetFieldMapping() map[string]string
+	// GetDeleteLimit returns the maximum number of records that a delete may delete, see
+	// DeleteLimitRepository. Zero for no limit.
+	GetDeleteLimit() int
+	// GetRequireIndex reports if the operations whose filters are not backed by an index are
+	// refused, see RequireIndexRepository.
+	GetRequireIndex() bool
+	// GetUnindexedQueries returns the fields of the filters of the queries allowed without an
+	// index when GetRequireIndex is set.
+	GetUnindexedQueries() [][]string
+	// GetSchemaVersion returns the version of the schema of the records that the code expects,
+	// see Repository.Migrate. Zero if not versioned.
+	GetSchemaVersion() int
+	// GetIndexCreationMode returns how DefineRepository handles the declared indexes, see
+	// IndexCreationMode.
+	GetIndexCreationMode() IndexCreationMode
+	// GetDefaultSort returns the sort keys of the reads of GetAll and its variants that are
+	// given no order, so the pages of the results are reproducible. Empty for the order of the
+	// backend, which may change between the reads.
+	GetDefaultSort() []SortKey
+	// IsCapped returns true for a fixed-size repository, which deletes its oldest records when a
+	// new record exceeds GetMaxDocuments or GetMaxBytes. The reads without an order return the
+	// records in the order they were inserted.
+	IsCapped() bool
+	// GetMaxDocuments returns the maximum number of records of a capped repository. Zero for no
+	// limit of the records.
+	GetMaxDocuments() int
+	// GetMaxBytes returns the maximum size in bytes of the records of a capped repository. Zero
+	// for no limit of the size.
+	GetMaxBytes() int
+	// GetEnums returns the enums of the enum fields, which are stored as the values of their
+	// names and queried and read as the names. See NewEnumRepository.
+	GetEnums() map[string]Enum
+	// GetSerializer returns the name of the serializer of the records stored as opaque blobs, see
+	// SerializerByName and SerializingRepository. Empty for the records stored as documents.
+	GetSerializer() string
+	// GetQueryTimeout returns the timeout of the operations of the repository, which overrides the
+	// default timeout of the backend (WithDefaultQueryTimeout). Zero for the backend default.
+	GetQueryTimeout() time.Duration
+	// GetOptions returns the options of the repository, for the settings of the custom backends.
+	// See DecodeOptions.
+	GetOptions() Options
 }
 
 // Backend defines interface for defining the repository
 type Backend interface {
 	DefineRepository(name string, def RepositoryDefinition) (Repository, error)
+	DefineRepositoryDryRun(name string, def RepositoryDefinition) (*RepositoryPlan, error)
 	GetRepository(name string) (Repository, error)
 	GetConfig() *config.DBInfo
+	Configure(opts ...BackendOption)
+	PhysicalName(name string) string
 	GetFromContext(key string) interface{}
 	SetInContext(key string, value interface{})
+	// ContextKeys returns the keys of the values set in the context of the backend with
+	// SetInContext, including the ones set by the builders and the options of the backend, in
+	// the order they were first set.
+	ContextKeys() []string
+	// StartSession starts a session for read-your-writes consistency of the repositories bound
+	// to it with Repository.WithSession. See Session.
+	StartSession() *Session
+	// Capabilities returns the features that the repositories of the backend support.
+	Capabilities() BackendCapabilities
 	Shutdown()
 }
 
@@ -96,8 +935,28 @@ type Backend interface {
 type BackendManager interface {
 	GetBackend(backendType string) (Backend, error)
 	SupportBackend(backendType string, builder BackendBuilder, properties map[string]interface{})
+	SupportBackendWithSchema(backendType string, builder BackendBuilder, schema []PropertySpec)
 	GetSupportedBackends() []string
 	GetRequiredBackendProperties(backendType string) (map[string]interface{}, error)
+	GetBackendPropertySchema(backendType string) ([]PropertySpec, error)
+	ShutdownAll(ctx context.Context) error
+	PrewarmBackends(ctx context.Context, types ...string) error
+}
+
+// PropertySpec describes a configuration property of a backend.
+type PropertySpec struct {
+	// Name is the name of the property in the backend configuration.
+	Name string
+	// Type is the type of the property, like "string", "bool", "int", "string array" or "map".
+	Type string
+	// Required is true if the backend cannot be used without the property.
+	Required bool
+	// Default is the value used when the property is not set. Nil means there is no default.
+	Default interface{}
+	// Description is a human readable description of the property.
+	Description string
+	// Properties are the nested properties of a property of type "map".
+	Properties []PropertySpec
 }
 
 // BackendBuilder builds the backend
@@ -106,6 +965,91 @@ type BackendBuilder func(conf *config.DBInfo, manager BackendManager) (Backend,
 // RepoBuilder builds the repo (collection or table)
 type RepoBuilder func(def RepositoryDefinition, backend Backend) (Repository, error)
 
+// RepoPlanner plans the operations that the RepoBuilder would execute on the backend for
+// the given definition, without executing them.
+type RepoPlanner func(def RepositoryDefinition, backend Backend) (*RepositoryPlan, error)
+
+// BackendOption configures optional behaviour of the RepositoriesBackend.
+type BackendOption func(backend *RepositoriesBackend)
+
+// WithRepoPlanner sets the planner used by DefineRepositoryDryRun.
+func WithRepoPlanner(planner RepoPlanner) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		backend.repositoryPlanner = planner
+	}
+}
+
+// WithNamePrefix sets a prefix that is prepended to the name of every collection/table
+// created by the backend, like "staging_" for "staging_users". The repositories are still
+// defined and looked up by their logical name (without the prefix).
+func WithNamePrefix(prefix string) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		backend.namePrefix = prefix
+	}
+}
+
+// WithReadReplica sets the backend of the read endpoint. The repositories defined on the
+// backend read from the replica and write to the primary endpoint.
+func WithReadReplica(replica Backend) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		replica.SetInContext(READ_REPLICA_CTX_KEY, true)
+		backend.readReplica = replica
+	}
+}
+
+// WithDefaultQueryTimeout sets the timeout of the operations of the repositories defined on the
+// backend. The timeout applies only when the context the repository is bound to with
+// Repository.WithContext has no deadline; the deadline of the caller always takes precedence,
+// even when it is later than the default timeout. A repository may override the default timeout
+// with the "queryTimeout" entry of its definition, so the timeout of an operation is, in order of
+// precedence: the deadline of the caller, the timeout of the repository and the default timeout.
+func WithDefaultQueryTimeout(timeout time.Duration) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		backend.queryTimeout = timeout
+	}
+}
+
+// Actions of the planned operations
+const (
+	// ActionCreateRepository creates the collection/table.
+	ActionCreateRepository = "create repository"
+	// ActionCreateIndex creates an index (GSI for dynamoDB).
+	ActionCreateIndex = "create index"
+	// ActionEnableTTL enables the TTL on an attribute.
+	ActionEnableTTL = "enable ttl"
+)
+
+// PlannedOperation is an operation that would be executed on the backend when defining a repository.
+type PlannedOperation struct {
+	Action string
+	Name   string
+	Fields []string
+}
+
+// String returns a human readable description of the operation, like "create index email on fields [email]".
+func (o PlannedOperation) String() string {
+	if len(o.Fields) == 0 {
+		return fmt.Sprintf("%s %s", o.Action, o.Name)
+	}
+	return fmt.Sprintf("%s %s on fields %v", o.Action, o.Name, o.Fields)
+}
+
+// RepositoryPlan holds the operations that DefineRepository would execute on the backend.
+// An empty list of operations means the repository is already set up.
+type RepositoryPlan struct {
+	Repository string
+	Operations []PlannedOperation
+}
+
+// Add appends an operation to the plan.
+func (p *RepositoryPlan) Add(action, name string, fields ...string) {
+	p.Operations = append(p.Operations, PlannedOperation{
+		Action: action,
+		Name:   name,
+		Fields: fields,
+	})
+}
+
 // RepositoryDefinitionMap is the configuration map
 type RepositoryDefinitionMap map[string]interface{}
 
@@ -116,8 +1060,14 @@ type BackendCleanup func()
 type DefaultBackendManager struct {
 	backendBuilders map[string]BackendBuilder
 	backends        map[string]Backend
-	backendProps    map[string]interface{}
+	backendSchemas  map[string][]PropertySpec
+	backendOptions  map[string]Options
 	dbConfig        map[string]*config.DBInfo
+	lastAccess      map[string]time.Time
+	building        map[string]*backendBuild
+	connectRetry    ConnectRetryPolicy
+	now             Clock
+	logger          Logger
 	mutex           *sync.Mutex
 }
 
@@ -125,13 +1075,26 @@ type DefaultBackendManager struct {
 type RepositoriesBackend struct {
 	repositories      map[string]Repository
 	repositoryBuilder RepoBuilder
+	repositoryPlanner RepoPlanner
+	namePrefix        string
+	readReplica       Backend
+	queryTimeout      time.Duration
+	capabilities      BackendCapabilities
 	mutex             *sync.Mutex
 	DBInfo            *config.DBInfo
 	ctx               context.Context
+	ctxMutex          sync.RWMutex
+	contextKeys       []string
 	cleanupFn         BackendCleanup
+
+	// built are the repositories as built by the repository builder, without the layers added
+	// by DefineRepository
+	built map[string]Repository
 }
 
-// GetIndexes returns the indexes for colletion or table
+// GetIndexes returns the indexes for colletion or table.
+// It panics if the indexes are not defined as []Index; DefineRepository rejects such
+// definitions with ErrInvalidInput before the indexes are read.
 func (m RepositoryDefinitionMap) GetIndexes() []Index {
 	indexes := []Index{}
 
@@ -139,7 +1102,7 @@ func (m RepositoryDefinitionMap) GetIndexes() []Index {
 		if idxArrayOfIndex, ok := idxArr.([]Index); ok {
 			return idxArrayOfIndex
 		}
-		log.Fatal("The indexes must be defined as []Index")
+		panic("backends: the indexes must be defined as []Index")
 	}
 
 	return indexes
@@ -155,6 +1118,313 @@ func (m RepositoryDefinitionMap) IsCustomID() bool {
 	return false
 }
 
+// GetIDField returns the name of the property that holds the ID of the records. The backends
+// generate the ID in this property when a new record is saved without one. Defaults to "id".
+// On DynamoDB the items are identified by the hash key (and the range key), so for a table keyed
+// by the ID the hash key should be the ID field. On MongoDB the ID is mapped to "_id".
+func (m RepositoryDefinitionMap) GetIDField() string {
+	if idField, ok := m["idField"].(string); ok && idField != "" {
+		return idField
+	}
+	return "id"
+}
+
+// GetFieldTypes returns the field types from the "fieldTypes" entry, which maps the fields to
+// one of "string", "int", "float", "bool" or "time". The entries with non-string types are skipped.
+func (m RepositoryDefinitionMap) GetFieldTypes() map[string]string {
+	fieldTypes := map[string]string{}
+	switch types := m["fieldTypes"].(type) {
+	case map[string]string:
+		for field, fieldType := range types {
+			fieldTypes[field] = fieldType
+		}
+	case map[string]interface{}:
+		for field, fieldType := range types {
+			if str, ok := fieldType.(string); ok {
+				fieldTypes[field] = str
+			}
+		}
+	}
+	return fieldTypes
+}
+
+// GetFields returns the declared fields from the "fields" entry, as []string or []interface{}
+// of strings. The entries that are not strings are skipped.
+func (m RepositoryDefinitionMap) GetFields() []string {
+	fields := []string{}
+	switch declared := m["fields"].(type) {
+	case []string:
+		fields = append(fields, declared...)
+	case []interface{}:
+		for _, field := range declared {
+			if str, ok := field.(string); ok {
+				fields = append(fields, str)
+			}
+		}
+	}
+	return fields
+}
+
+// GetBatchSize returns the batch size from the "batchSize" entry, or zero if it is not set or
+// is not an integer (see parseIntEntry).
+func (m RepositoryDefinitionMap) GetBatchSize() int {
+	batchSize, _ := parseIntEntry("batchSize", m["batchSize"])
+	return batchSize
+}
+
+// intEntries are the entries of the definition maps holding integers, checked by
+// DefineRepository.
+var intEntries = []string{"batchSize", "deleteLimit", "schemaVersion", "maxDocuments", "maxBytes"}
+
+// parseIntEntry parses the integer entry of the definition map: an integer, a whole float64, as
+// in a definition decoded from JSON, or a numeric string. Zero if it is not set. It fails with
+// ErrInvalidInput for the other values.
+func parseIntEntry(name string, entry interface{}) (int, error) {
+	switch value := entry.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return value, nil
+	case int32:
+		return int(value), nil
+	case int64:
+		return int(value), nil
+	case float64:
+		if value == float64(int(value)) {
+			return int(value), nil
+		}
+	case string:
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed, nil
+		}
+	}
+	return 0, ErrInvalidInput(fmt.Sprintf("the %s must be an integer, got %v", name, entry))
+}
+
+// checkIntEntries checks that the integer entries of the definition map are integers, see
+// parseIntEntry. The other definitions are not checked.
+func checkIntEntries(def RepositoryDefinition) error {
+	defMap, ok := def.(RepositoryDefinitionMap)
+	if !ok {
+		return nil
+	}
+	for _, name := range intEntries {
+		if _, err := parseIntEntry(name, defMap[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFieldMapping returns the field mapping from the "fieldMapping" entry, which maps the names
+// of the properties to the names of the stored fields. The entries with non-string names are skipped.
+func (m RepositoryDefinitionMap) GetFieldMapping() map[string]string {
+	mapping := map[string]string{}
+	switch names := m["fieldMapping"].(type) {
+	case map[string]string:
+		for name, stored := range names {
+			mapping[name] = stored
+		}
+	case map[string]interface{}:
+		for name, stored := range names {
+			if str, ok := stored.(string); ok {
+				mapping[name] = str
+			}
+		}
+	}
+	return mapping
+}
+
+// GetDeleteLimit returns the delete limit from the "deleteLimit" entry, or zero if it is not set
+// or is not an integer (see parseIntEntry).
+func (m RepositoryDefinitionMap) GetDeleteLimit() int {
+	deleteLimit, _ := parseIntEntry("deleteLimit", m["deleteLimit"])
+	return deleteLimit
+}
+
+// GetRequireIndex returns the "requireIndex" entry, false if it is not set.
+func (m RepositoryDefinitionMap) GetRequireIndex() bool {
+	requireIndex, _ := m["requireIndex"].(bool)
+	return requireIndex
+}
+
+// GetUnindexedQueries returns the fields of the filters from the "unindexedQueries" entry, a list
+// of the lists of the fields. The entries that are not lists of strings are skipped.
+func (m RepositoryDefinitionMap) GetUnindexedQueries() [][]string {
+	queries := [][]string{}
+	switch entry := m["unindexedQueries"].(type) {
+	case [][]string:
+		for _, fields := range entry {
+			queries = append(queries, append([]string{}, fields...))
+		}
+	case []interface{}:
+		for _, query := range entry {
+			switch fields := query.(type) {
+			case []string:
+				queries = append(queries, append([]string{}, fields...))
+			case []interface{}:
+				names := []string{}
+				for _, field := range fields {
+					if name, ok := field.(string); ok {
+						names = append(names, name)
+					}
+				}
+				if len(names) == len(fields) {
+					queries = append(queries, names)
+				}
+			}
+		}
+	}
+	return queries
+}
+
+// GetSchemaVersion returns the schema version from the "schemaVersion" entry, or zero if it is not
+// set or is not an integer (see parseIntEntry).
+func (m RepositoryDefinitionMap) GetSchemaVersion() int {
+	schemaVersion, _ := parseIntEntry("schemaVersion", m["schemaVersion"])
+	return schemaVersion
+}
+
+// GetIndexCreationMode returns the "indexCreation" entry, IndexCreateIfMissing if it is not set.
+func (m RepositoryDefinitionMap) GetIndexCreationMode() IndexCreationMode {
+	switch mode := m["indexCreation"].(type) {
+	case IndexCreationMode:
+		if mode != "" {
+			return mode
+		}
+	case string:
+		if mode != "" {
+			return IndexCreationMode(mode)
+		}
+	}
+	return IndexCreateIfMissing
+}
+
+// IsCapped returns the "capped" entry, false if it is not set.
+func (m RepositoryDefinitionMap) IsCapped() bool {
+	capped, _ := m["capped"].(bool)
+	return capped
+}
+
+// GetMaxDocuments returns the "maxDocuments" entry, zero if it is not set or is not an integer
+// (see parseIntEntry).
+func (m RepositoryDefinitionMap) GetMaxDocuments() int {
+	maxDocuments, _ := parseIntEntry("maxDocuments", m["maxDocuments"])
+	return maxDocuments
+}
+
+// GetMaxBytes returns the "maxBytes" entry, zero if it is not set or is not an integer (see
+// parseIntEntry).
+func (m RepositoryDefinitionMap) GetMaxBytes() int {
+	maxBytes, _ := parseIntEntry("maxBytes", m["maxBytes"])
+	return maxBytes
+}
+
+// GetEnums returns the enums from the "enums" entry, which maps the enum fields to an Enum or to
+// a map of the names to the stored values. The entries of other types are skipped.
+func (m RepositoryDefinitionMap) GetEnums() map[string]Enum {
+	enums := map[string]Enum{}
+	switch entry := m["enums"].(type) {
+	case map[string]Enum:
+		for field, enum := range entry {
+			enums[field] = enum
+		}
+	case map[string]map[string]interface{}:
+		for field, enum := range entry {
+			enums[field] = Enum(enum)
+		}
+	case map[string]interface{}:
+		for field, enum := range entry {
+			switch enum := enum.(type) {
+			case Enum:
+				enums[field] = enum
+			case map[string]interface{}:
+				enums[field] = Enum(enum)
+			}
+		}
+	}
+	return enums
+}
+
+// GetSerializer returns the "serializer" entry, empty if it is not set.
+func (m RepositoryDefinitionMap) GetSerializer() string {
+	serializer, _ := m["serializer"].(string)
+	return serializer
+}
+
+// GetQueryTimeout returns the "queryTimeout" entry, a time.Duration, a duration string like
+// "30s" or a number of seconds, like 30 or 0.5 in a definition decoded from JSON. Zero if it is
+// not set or is not a valid timeout; DefineRepository rejects the invalid ones.
+func (m RepositoryDefinitionMap) GetQueryTimeout() time.Duration {
+	timeout, err := parseQueryTimeout(m["queryTimeout"])
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+// parseQueryTimeout parses the "queryTimeout" entry, see GetQueryTimeout. It fails with
+// ErrInvalidInput if the entry is of another type or is not a valid duration.
+func parseQueryTimeout(entry interface{}) (time.Duration, error) {
+	switch timeout := entry.(type) {
+	case nil:
+		return 0, nil
+	case time.Duration:
+		return timeout, nil
+	case string:
+		duration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return 0, ErrInvalidInput(fmt.Sprintf("the query timeout %q is not a valid duration", timeout))
+		}
+		return duration, nil
+	case int:
+		return time.Duration(timeout) * time.Second, nil
+	case int64:
+		return time.Duration(timeout) * time.Second, nil
+	case float64:
+		return time.Duration(timeout * float64(time.Second)), nil
+	}
+	return 0, ErrInvalidInput(fmt.Sprintf("the query timeout must be a duration or a number of seconds, got %T", entry))
+}
+
+// GetDefaultSort returns the sort keys from the "defaultSort" entry, a list of SortKey or of maps
+// with the "property" and the optional "sorting". The entries of other types are skipped.
+func (m RepositoryDefinitionMap) GetDefaultSort() []SortKey {
+	sortKeys := []SortKey{}
+	switch entry := m["defaultSort"].(type) {
+	case []SortKey:
+		sortKeys = append(sortKeys, entry...)
+	case []interface{}:
+		for _, key := range entry {
+			switch key := key.(type) {
+			case SortKey:
+				sortKeys = append(sortKeys, key)
+			case map[string]interface{}:
+				property, _ := key["property"].(string)
+				sorting, _ := key["sorting"].(string)
+				sortKeys = append(sortKeys, SortKey{Property: property, Sorting: sorting})
+			}
+		}
+	}
+	return sortKeys
+}
+
+// GetOptions returns the options from the "options" entry, empty if the entry is not a map.
+func (m RepositoryDefinitionMap) GetOptions() Options {
+	opts := Options{}
+	switch entry := m["options"].(type) {
+	case Options:
+		for key, value := range entry {
+			opts[key] = value
+		}
+	case map[string]interface{}:
+		for key, value := range entry {
+			opts[key] = value
+		}
+	}
+	return opts
+}
+
 // GetName returns the collection/table name
 func (m RepositoryDefinitionMap) GetName() string {
 	if name, ok := m["name"]; ok {
@@ -236,6 +1506,118 @@ func (m RepositoryDefinitionMap) GetGSI() map[string]interface{} {
 	return nil
 }
 
+// GetGSIDefs parses the global secondary indexes. Unlike GetGSI, it does not panic on a
+// malformed GSI entry, but returns ErrInvalidInput describing the problem.
+func (m RepositoryDefinitionMap) GetGSIDefs() ([]GSIDefinition, error) {
+	raw, ok := m["GSI"]
+	if !ok || raw == nil {
+		return []GSIDefinition{}, nil
+	}
+	gsi, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("GSI must be a map, got %T", raw))
+	}
+
+	hashKeys := []string{}
+	for hashKey := range gsi {
+		hashKeys = append(hashKeys, hashKey)
+	}
+	sort.Strings(hashKeys)
+
+	defs := []GSIDefinition{}
+	for _, hashKey := range hashKeys {
+		def, err := parseGSIDefinition(hashKey, gsi[hashKey])
+		if err != nil {
+			return nil, ErrInvalidInput(fmt.Sprintf("GSI %s: %s", hashKey, err.Error()))
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// parseGSIDefinition parses the settings of the index with the given hash key.
+func parseGSIDefinition(hashKey string, raw interface{}) (GSIDefinition, error) {
+	if hashKey == "" {
+		return GSIDefinition{}, fmt.Errorf("the hash key must not be empty")
+	}
+	settings, ok := raw.(map[string]interface{})
+	if !ok {
+		return GSIDefinition{}, fmt.Errorf("the settings must be a map, got %T", raw)
+	}
+
+	def := GSIDefinition{
+		Name:         fmt.Sprintf("%s-index", hashKey),
+		HashKey:      hashKey,
+		HashKeyType:  "S",
+		RangeKeyType: "S",
+		Projection:   "ALL",
+	}
+
+	strSettings := map[string]*string{
+		"hashKeyType":  &def.HashKeyType,
+		"rangeKey":     &def.RangeKey,
+		"rangeKeyType": &def.RangeKeyType,
+		"projection":   &def.Projection,
+	}
+	for name, target := range strSettings {
+		value, ok := settings[name]
+		if !ok {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok || str == "" {
+			return GSIDefinition{}, fmt.Errorf("%s must be a non-empty string, got %v", name, value)
+		}
+		*target = str
+	}
+
+	capacities := map[string]*int64{
+		"readCapacity":  &def.ReadCapacity,
+		"writeCapacity": &def.WriteCapacity,
+	}
+	for name, target := range capacities {
+		value, ok := settings[name]
+		if !ok {
+			return GSIDefinition{}, fmt.Errorf("%s is missing", name)
+		}
+		capacity, ok := capacityUnits(value)
+		if !ok {
+			return GSIDefinition{}, fmt.Errorf("%s must be a positive integer, got %v", name, value)
+		}
+		*target = capacity
+	}
+
+	return def, nil
+}
+
+// capacityUnits converts the capacity units given as an integer, a whole float (as decoded
+// from JSON) or a numeric string. The units must be positive.
+func capacityUnits(value interface{}) (int64, bool) {
+	var units int64
+	switch v := value.(type) {
+	case int:
+		units = int64(v)
+	case int32:
+		units = int64(v)
+	case int64:
+		units = v
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, false
+		}
+		units = int64(v)
+	case string:
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		units = parsed
+	default:
+		return 0, false
+	}
+	return units, units > 0
+}
+
 // GetHashKeyType return the type of the hash key - AWS DynamoDB specific. Type may be "S", "N", "SS", "SN".
 func (m RepositoryDefinitionMap) GetHashKeyType() string {
 	if hashKeyType, ok := m["hashKeyType"]; ok {
@@ -262,15 +1644,216 @@ func (m *RepositoriesBackend) DefineRepository(name string, def RepositoryDefini
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	fieldTypes := def.GetFieldTypes()
+	for field, fieldType := range fieldTypes {
+		switch fieldType {
+		case FieldTypeString, FieldTypeInt, FieldTypeFloat, FieldTypeBool, FieldTypeTime:
+		default:
+			return nil, ErrInvalidInput(fmt.Sprintf("unknown type %s of the field %s", fieldType, field))
+		}
+	}
+
+	if err := checkIndexFields(def); err != nil {
+		return nil, err
+	}
+	if err := checkIntEntries(def); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(def.GetBatchSize()); err != nil {
+		return nil, err
+	}
+	if err := checkDefaultSort(def); err != nil {
+		return nil, err
+	}
+	if err := checkCapped(def); err != nil {
+		return nil, err
+	}
+	enums := def.GetEnums()
+	if err := checkEnums(enums); err != nil {
+		return nil, err
+	}
+	for field := range enums {
+		if _, ok := fieldTypes[field]; ok {
+			return nil, ErrInvalidInput(fmt.Sprintf("the field %s cannot have both a type and an enum", field))
+		}
+	}
+	var serializer Serializer
+	if name := def.GetSerializer(); name != "" {
+		var err error
+		if serializer, err = SerializerByName(name); err != nil {
+			return nil, err
+		}
+	}
+	if defMap, ok := def.(RepositoryDefinitionMap); ok {
+		if _, err := parseQueryTimeout(defMap["queryTimeout"]); err != nil {
+			return nil, err
+		}
+	}
+	if timeout := def.GetQueryTimeout(); timeout < 0 {
+		return nil, ErrInvalidInput(fmt.Sprintf("the query timeout must not be negative, got %s", timeout))
+	}
+	if deleteLimit := def.GetDeleteLimit(); deleteLimit < 0 {
+		return nil, ErrInvalidInput(fmt.Sprintf("the delete limit must not be negative, got %d", deleteLimit))
+	}
+	if schemaVersion := def.GetSchemaVersion(); schemaVersion < 0 {
+		return nil, ErrInvalidInput(fmt.Sprintf("the schema version must not be negative, got %d", schemaVersion))
+	}
+	fieldMapping := def.GetFieldMapping()
+	if err := checkFieldMapping(fieldMapping); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkIndexCreationMode(def); err != nil {
+		return nil, err
+	}
+
 	repository, err := m.repositoryBuilder(def, m)
 	if err != nil {
 		return nil, err
 	}
 
+	if m.built == nil {
+		m.built = map[string]Repository{}
+	}
+	m.built[name] = repository
+
+	if m.readReplica != nil {
+		replica, err := m.defineReplica(name, def)
+		if err != nil {
+			return nil, err
+		}
+		repository = NewReadWriteRepository(repository, replica)
+	}
+
+	if serializer != nil {
+		repository = NewSerializingRepository(repository, serializer, def.GetIDField())
+	}
+
+	if len(fieldMapping) > 0 {
+		repository = NewFieldMappingRepository(repository, fieldMapping)
+	}
+
+	if len(enums) > 0 {
+		repository = NewEnumRepository(repository, enums)
+	}
+
+	if len(fieldTypes) > 0 {
+		repository = NewCoercingRepository(repository, fieldTypes)
+	}
+
+	if deleteLimit := def.GetDeleteLimit(); deleteLimit > 0 {
+		repository = NewDeleteLimitRepository(repository, deleteLimit)
+	}
+
+	if def.GetRequireIndex() {
+		repository = NewRequireIndexRepository(repository, def.GetUnindexedQueries())
+	}
+
+	queryTimeout := m.queryTimeout
+	if timeout := def.GetQueryTimeout(); timeout > 0 {
+		queryTimeout = timeout
+	}
+	if queryTimeout > 0 {
+		repository = NewTimeoutRepository(repository, queryTimeout)
+	}
+
 	m.repositories[name] = repository
 	return repository, nil
 }
 
+// defineReplica defines the repository on the read replica and returns it as built, without the
+// layers that translate the records (serializer, field mapping, enums, coercion), as these are
+// added around the ReadWriteRepository, so the records read from the replica are translated once.
+// The repository of a custom replica backend is returned as it is defined.
+func (m *RepositoriesBackend) defineReplica(name string, def RepositoryDefinition) (Repository, error) {
+	replica, err := m.readReplica.DefineRepository(name, def)
+	if err != nil {
+		return nil, err
+	}
+	if backend, ok := m.readReplica.(*RepositoriesBackend); ok {
+		backend.mutex.Lock()
+		defer backend.mutex.Unlock()
+		if built, ok := backend.built[name]; ok {
+			return built, nil
+		}
+	}
+	return replica, nil
+}
+
+// checkIndexFields checks that the fields of the indexes are declared in the definition, so
+// a typo in an index does not create an index on a field the records do not have. The ID
+// field is always declared. Definitions without declared fields are not checked.
+// The indexes of a definition map must be defined as []Index.
+func checkIndexFields(def RepositoryDefinition) error {
+	if defMap, ok := def.(RepositoryDefinitionMap); ok {
+		if indexes, ok := defMap["indexes"]; ok {
+			if _, ok := indexes.([]Index); !ok {
+				return ErrInvalidInput(fmt.Sprintf("the indexes must be defined as []Index, got %T", indexes))
+			}
+		}
+	}
+	fields := def.GetFields()
+	if len(fields) == 0 {
+		return nil
+	}
+	declared := map[string]bool{def.GetIDField(): true}
+	for _, field := range fields {
+		declared[field] = true
+	}
+	for _, index := range def.GetIndexes() {
+		for _, field := range index.GetFields() {
+			if !declared[strings.TrimPrefix(strings.TrimPrefix(field, "$text:"), "$2dsphere:")] {
+				return ErrInvalidInput(fmt.Sprintf("the index %s uses the undeclared field %s", index.GetName(), field))
+			}
+		}
+	}
+	return nil
+}
+
+// checkDefaultSort checks that the default sort keys have a valid sorting and, if the fields of
+// the definition are declared, that their properties are declared.
+func checkDefaultSort(def RepositoryDefinition) error {
+	declared := map[string]bool{def.GetIDField(): true}
+	for _, field := range def.GetFields() {
+		declared[field] = true
+	}
+	for _, key := range def.GetDefaultSort() {
+		if key.Property == "" {
+			return ErrInvalidInput("the property of a default sort key is required")
+		}
+		if err := checkSorting(key.Sorting); err != nil {
+			return ErrInvalidInput(fmt.Sprintf("unknown sorting %q of the default sort key %s", key.Sorting, key.Property))
+		}
+		if len(def.GetFields()) > 0 && !declared[key.Property] {
+			return ErrInvalidInput(fmt.Sprintf("the default sort uses the undeclared field %s", key.Property))
+		}
+	}
+	return nil
+}
+
+// DefineRepositoryDryRun returns the operations that DefineRepository would execute on the
+// backend for the given definition, without executing them and without defining the repository.
+func (m *RepositoriesBackend) DefineRepositoryDryRun(name string, def RepositoryDefinition) (*RepositoryPlan, error) {
+	if _, ok := m.repositories[name]; ok {
+		return &RepositoryPlan{
+			Repository: def.GetName(),
+		}, nil
+	}
+
+	if m.repositoryPlanner == nil {
+		return nil, ErrBackendError("dry run is not supported by the backend")
+	}
+	if err := checkIndexFields(def); err != nil {
+		return nil, err
+	}
+
+	plan, err := m.repositoryPlanner(def, m)
+	if err != nil {
+		return nil, err
+	}
+	return withoutIndexCreation(plan, def), nil
+}
+
 // GetRepository return the repository (collection/table)
 func (m *RepositoriesBackend) GetRepository(name string) (Repository, error) {
 	if repo, ok := m.repositories[name]; ok {
@@ -285,17 +1868,75 @@ func (m *RepositoriesBackend) GetConfig() *config.DBInfo {
 	return m.DBInfo
 }
 
-// GetFromContext returns from config
+// Configure applies the options to the backend. The options should be set before any
+// repository is defined.
+func (m *RepositoriesBackend) Configure(opts ...BackendOption) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ctxMutex.Lock()
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.ctxMutex.Unlock()
+	if m.readReplica != nil {
+		m.readReplica.Configure(WithNamePrefix(m.namePrefix), WithLogger(BackendLogger(m)))
+	}
+}
+
+// PhysicalName returns the actual name of the collection/table in the backend for
+// the given logical name. The name prefix (if set) is prepended to the logical name.
+func (m *RepositoriesBackend) PhysicalName(name string) string {
+	return m.namePrefix + name
+}
+
+// GetFromContext returns the value set in the context of the backend for the key. The
+// repositories defined on the backend see the values set after they were defined as well.
 func (m *RepositoriesBackend) GetFromContext(key string) interface{} {
+	m.ctxMutex.RLock()
+	defer m.ctxMutex.RUnlock()
+
+	if m.ctx == nil {
+		return nil
+	}
 	return m.ctx.Value(key)
 }
 
-// SetInContext sets in context
+// SetInContext sets the value in the context of the backend.
 func (m *RepositoriesBackend) SetInContext(key string, value interface{}) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	m.ctxMutex.Lock()
+	defer m.ctxMutex.Unlock()
+
+	m.setInContext(key, value)
+}
 
+// ContextKeys returns the keys of the values set in the context of the backend, in the order
+// they were first set. The keys of the context the backend was created with are not listed.
+func (m *RepositoriesBackend) ContextKeys() []string {
+	m.ctxMutex.RLock()
+	defer m.ctxMutex.RUnlock()
+
+	return append([]string{}, m.contextKeys...)
+}
+
+// StartSession starts a session for the repositories of the backend.
+func (m *RepositoriesBackend) StartSession() *Session {
+	return newSession()
+}
+
+// setInContext sets the value in the context and records its key. The caller must hold the
+// context lock, unless the backend is not shared yet.
+func (m *RepositoriesBackend) setInContext(key string, value interface{}) {
+	if m.ctx == nil {
+		m.ctx = context.Background()
+	}
 	m.ctx = context.WithValue(m.ctx, key, value)
+	for _, k := range m.contextKeys {
+		if k == key {
+			return
+		}
+	}
+	m.contextKeys = append(m.contextKeys, key)
 }
 
 // Shutdown close the session
@@ -303,27 +1944,135 @@ func (m *RepositoriesBackend) Shutdown() {
 	if m.cleanupFn != nil {
 		m.cleanupFn()
 	}
+	if m.readReplica != nil {
+		m.readReplica.Shutdown()
+	}
 }
 
-// GetBackend returns the RepositoryBackend
+// GetBackend returns the RepositoryBackend. The backend is built on the first call for the type
+// and cached. The builder runs without the lock of the manager held, so a builder may get the
+// other backends it depends on with GetBackend. The concurrent calls for a type that is being
+// built wait for the build instead of building the backend again, so a builder must not get the
+// backend of its own type, directly or through the builders of the other types.
 func (m *DefaultBackendManager) GetBackend(backendType string) (Backend, error) {
+	m.mutex.Lock()
 	if backend, ok := m.backends[backendType]; ok {
+		m.touch(backendType)
+		m.mutex.Unlock()
 		return backend, nil
 	}
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	if build, ok := m.building[backendType]; ok {
+		m.mutex.Unlock()
+		<-build.done
+		return build.backend, build.err
+	}
 
-	backend, err := m.buildBackend(backendType)
-	if err != nil {
-		return nil, err
+	build := &backendBuild{done: make(chan struct{})}
+	if m.building == nil {
+		m.building = map[string]*backendBuild{}
 	}
-	return backend, nil
+	m.building[backendType] = build
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		if m.building[backendType] == build {
+			delete(m.building, backendType)
+		}
+		// a build in progress during ShutdownAll is shut down by ShutdownAll instead of cached
+		if build.err == nil && build.backend != nil && !build.shutdown {
+			m.backends[backendType] = build.backend
+			m.touch(backendType)
+		}
+		m.mutex.Unlock()
+		close(build.done)
+	}()
+
+	// the waiting calls get this error if the builder panics
+	build.err = fmt.Errorf("backend build failed")
+	build.backend, build.err = m.buildBackend(backendType)
+	return build.backend, build.err
+}
+
+// backendBuild is a build of a backend in progress. The GetBackend calls for the same type
+// wait until it is done and get its result.
+type backendBuild struct {
+	done    chan struct{}
+	backend Backend
+	err     error
+
+	// shutdown is set by ShutdownAll, which shuts the backend down once it is built
+	shutdown bool
+}
+
+// PrewarmBackends builds the backends of the given types at startup, instead of on the first
+// GetBackend, so a misconfigured database fails the service fast instead of the first request.
+// With no types it builds all the supported backends that are configured. The backends are
+// built concurrently and PrewarmBackends waits for them until the context is done. The types
+// that fail to build, or are not built in time, are listed in the returned ErrBackendError;
+// the backends that were built are cached either way.
+func (m *DefaultBackendManager) PrewarmBackends(ctx context.Context, types ...string) error {
+	if len(types) == 0 {
+		m.mutex.Lock()
+		for backendType := range m.backendBuilders {
+			if m.dbConfig[backendType] != nil {
+				types = append(types, backendType)
+			}
+		}
+		m.mutex.Unlock()
+	}
+
+	type buildResult struct {
+		backendType string
+		err         error
+	}
+	results := make(chan buildResult, len(types))
+	pending := map[string]bool{}
+	for _, backendType := range types {
+		if pending[backendType] {
+			continue
+		}
+		pending[backendType] = true
+		go func(backendType string) {
+			_, err := m.GetBackend(backendType)
+			results <- buildResult{backendType, err}
+		}(backendType)
+	}
+
+	failed := []string{}
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.backendType)
+			if result.err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", result.backendType, result.err.Error()))
+			}
+		case <-ctx.Done():
+			for backendType := range pending {
+				failed = append(failed, fmt.Sprintf("%s: %s", backendType, ctx.Err().Error()))
+			}
+			pending = nil
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return ErrBackendError(fmt.Sprintf("failed to prewarm the backends: %s", strings.Join(failed, "; ")))
+	}
+	return nil
 }
 
-// SupportBackend register the DB builder function and required props for the DB
+// SupportBackend register the DB builder function and required props for the DB.
+// All properties are considered required. Use SupportBackendWithSchema to describe
+// the properties in more detail.
 func (m *DefaultBackendManager) SupportBackend(backendType string, builder BackendBuilder, properties map[string]interface{}) {
+	m.SupportBackendWithSchema(backendType, builder, schemaFromProperties(properties))
+}
+
+// SupportBackendWithSchema registers the DB builder function and the schema of the configuration properties for the DB
+func (m *DefaultBackendManager) SupportBackendWithSchema(backendType string, builder BackendBuilder, schema []PropertySpec) {
 	m.backendBuilders[backendType] = builder
-	m.backendProps[backendType] = properties
+	m.backendSchemas[backendType] = schema
 }
 
 // GetSupportedBackends returns the supported backedns
@@ -337,34 +2086,102 @@ func (m *DefaultBackendManager) GetSupportedBackends() []string {
 	return supported
 }
 
-// GetRequiredBackendProperties returns the required props for the selected backend
+// GetRequiredBackendProperties returns the required props for the selected backend.
+// The props are derived from the property schema - property name => type, or a map of
+// the nested props for the properties of type "map".
 func (m *DefaultBackendManager) GetRequiredBackendProperties(backendType string) (map[string]interface{}, error) {
-	if props, ok := m.backendProps[backendType]; ok {
-		return props.(map[string]interface{}), nil
+	if schema, ok := m.backendSchemas[backendType]; ok {
+		return propertiesFromSchema(schema), nil
 	}
 	return nil, fmt.Errorf("backend not supported")
 }
 
-// buildBackend builds new backend
-func (m *DefaultBackendManager) buildBackend(backendType string) (Backend, error) {
-	if backendBuilder, ok := m.backendBuilders[backendType]; ok {
-		dbInfo, ok := m.dbConfig[backendType]
-		if !ok || dbInfo == nil {
-			return nil, fmt.Errorf("backend not configured")
+// GetBackendPropertySchema returns the schema of the configuration properties for the selected backend
+func (m *DefaultBackendManager) GetBackendPropertySchema(backendType string) ([]PropertySpec, error) {
+	if schema, ok := m.backendSchemas[backendType]; ok {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("backend not supported")
+}
+
+// schemaFromProperties converts the property name => type map to a schema.
+func schemaFromProperties(properties map[string]interface{}) []PropertySpec {
+	names := []string{}
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema := []PropertySpec{}
+	for _, name := range names {
+		spec := PropertySpec{
+			Name:     name,
+			Required: true,
+		}
+		if nested, ok := properties[name].(map[string]interface{}); ok {
+			spec.Type = "map"
+			spec.Properties = schemaFromProperties(nested)
+		} else {
+			spec.Type = fmt.Sprintf("%v", properties[name])
+		}
+		schema = append(schema, spec)
+	}
+	return schema
+}
+
+// propertiesFromSchema converts the schema to a property name => type map.
+func propertiesFromSchema(schema []PropertySpec) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, spec := range schema {
+		if spec.Type == "map" && len(spec.Properties) > 0 {
+			properties[spec.Name] = propertiesFromSchema(spec.Properties)
+			continue
 		}
-		backend, err := backendBuilder(dbInfo, m)
+		properties[spec.Name] = spec.Type
+	}
+	return properties
+}
+
+// buildBackend builds new backend. It is called without the lock held, so the builder can
+// get the other backends from the manager. The builds that fail to connect to the database are
+// retried with the policy set with WithConnectRetry.
+func (m *DefaultBackendManager) buildBackend(backendType string) (Backend, error) {
+	m.mutex.Lock()
+	backendBuilder, supported := m.backendBuilders[backendType]
+	dbInfo := m.dbConfig[backendType]
+	readInfo := m.dbConfig[backendType+ReadEndpointSuffix]
+	logger := m.logger
+	retry := m.connectRetry
+	retryLogger := m.getLogger()
+	m.mutex.Unlock()
+
+	if !supported {
+		return nil, fmt.Errorf("backend not supported")
+	}
+	if dbInfo == nil {
+		return nil, fmt.Errorf("backend not configured")
+	}
+	backend, err := buildWithRetry(backendBuilder, dbInfo, m, retry, retryLogger)
+	if err != nil {
+		return nil, err
+	}
+	if readInfo != nil {
+		replica, err := buildWithRetry(backendBuilder, readInfo, m, retry, retryLogger)
 		if err != nil {
+			backend.Shutdown()
 			return nil, err
 		}
-		m.backends[backendType] = backend
-		return backend, nil
+		backend.Configure(WithReadReplica(replica))
 	}
-	return nil, fmt.Errorf("backend not supported")
+	if logger != nil {
+		backend.Configure(WithLogger(logger))
+	}
+	return backend, nil
 }
 
 // NewRepositoriesBackend sets new RepositoriesBackend
-func NewRepositoriesBackend(ctx context.Context, dbInfo *config.DBInfo, repoBuilder RepoBuilder, cleanup BackendCleanup) Backend {
-	return &RepositoriesBackend{
+func NewRepositoriesBackend(ctx context.Context, dbInfo *config.DBInfo, repoBuilder RepoBuilder, cleanup BackendCleanup, opts ...BackendOption) Backend {
+	backend := &RepositoriesBackend{
 		DBInfo:            dbInfo,
 		mutex:             &sync.Mutex{},
 		repositories:      map[string]Repository{},
@@ -372,15 +2189,23 @@ func NewRepositoriesBackend(ctx context.Context, dbInfo *config.DBInfo, repoBuil
 		ctx:               ctx,
 		cleanupFn:         cleanup,
 	}
+	for _, opt := range opts {
+		opt(backend)
+	}
+	return backend
 }
 
 // NewBackendManager returns new backend manager
 func NewBackendManager(dbConfig map[string]*config.DBInfo) BackendManager {
 	return &DefaultBackendManager{
 		backendBuilders: map[string]BackendBuilder{},
-		backendProps:    map[string]interface{}{},
+		backendSchemas:  map[string][]PropertySpec{},
+		backendOptions:  map[string]Options{},
 		backends:        map[string]Backend{},
 		dbConfig:        dbConfig,
+		lastAccess:      map[string]time.Time{},
+		building:        map[string]*backendBuild{},
+		now:             time.Now,
 		mutex:           &sync.Mutex{},
 	}
 }
@@ -423,6 +2248,16 @@ func indexNameFromFields(fields ...string) string {
 	return name
 }
 
+// findIndex looks up the index with the given name in the repository definition.
+func findIndex(repoDef RepositoryDefinition, name string) (Index, bool) {
+	for _, index := range repoDef.GetIndexes() {
+		if index.GetName() == name {
+			return index, true
+		}
+	}
+	return nil, false
+}
+
 func NewUniqueIndex(fields ...string) Index {
 	return NewIndex(indexNameFromFields(fields...), true, fields...)
 }
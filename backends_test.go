@@ -2,8 +2,13 @@ package backends
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Microkubes/microservice-tools/config"
 	"github.com/guregu/dynamo"
@@ -52,8 +57,8 @@ var repoBuilder = &RepositoriesBackend{
 
 var backendManager = &DefaultBackendManager{
 	backendBuilders: map[string]BackendBuilder{},
-	backendProps: map[string]interface{}{
-		"key": "value",
+	backendSchemas: map[string][]PropertySpec{
+		"key": []PropertySpec{{Name: "key", Type: "value"}},
 	},
 	backends: map[string]Backend{},
 	dbConfig: map[string]*config.DBInfo{
@@ -68,8 +73,8 @@ func backendBuilderFn(dbInfo *config.DBInfo, manager BackendManager) (Backend, e
 
 func repoBuilderFn(repoDef RepositoryDefinition, backend Backend) (Repository, error) {
 	repo := DynamoCollection{
-		&dynamo.Table{},
-		&collectionInfo,
+		Table:                &dynamo.Table{},
+		RepositoryDefinition: &collectionInfo,
 	}
 
 	return &repo, nil
@@ -131,6 +136,17 @@ func TestGetRangeKey(t *testing.T) {
 	}
 }
 
+func TestGetIDField(t *testing.T) {
+	if idField := collectionInfo.GetIDField(); idField != "id" {
+		t.Errorf("Expected the default ID field id, got %s", idField)
+	}
+
+	idField := RepositoryDefinitionMap{"name": "users", "idField": "userId"}.GetIDField()
+	if idField != "userId" {
+		t.Errorf("Expected ID field userId, got %s", idField)
+	}
+}
+
 func TestGetReadCapacity(t *testing.T) {
 	readCapacity := collectionInfo.GetReadCapacity()
 
@@ -155,6 +171,56 @@ func TestGetGSI(t *testing.T) {
 	}
 }
 
+func TestGetGSIDefs(t *testing.T) {
+	defs, err := RepositoryDefinitionMap{
+		"GSI": map[string]interface{}{
+			"token": map[string]interface{}{
+				"readCapacity":  2,
+				"writeCapacity": float64(3),
+			},
+			"email": map[string]interface{}{
+				"readCapacity":  int64(1),
+				"writeCapacity": "1",
+				"rangeKey":      "created_at",
+				"rangeKeyType":  "N",
+				"projection":    "KEYS_ONLY",
+			},
+		},
+	}.GetGSIDefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []GSIDefinition{
+		{Name: "email-index", HashKey: "email", RangeKey: "created_at", HashKeyType: "S", RangeKeyType: "N", Projection: "KEYS_ONLY", ReadCapacity: 1, WriteCapacity: 1},
+		{Name: "token-index", HashKey: "token", HashKeyType: "S", RangeKeyType: "S", Projection: "ALL", ReadCapacity: 2, WriteCapacity: 3},
+	}
+	if !reflect.DeepEqual(defs, expected) {
+		t.Fatalf("Expected the GSI definitions %+v. Got: %+v", expected, defs)
+	}
+
+	if defs, err = (RepositoryDefinitionMap{}).GetGSIDefs(); err != nil || len(defs) != 0 {
+		t.Fatal("Expected no GSI definitions. Got: ", defs, err)
+	}
+}
+
+func TestGetGSIDefsMalformed(t *testing.T) {
+	malformed := []interface{}{
+		[]string{"token"},
+		map[string]interface{}{"token": 5},
+		map[string]interface{}{"token": map[string]interface{}{"readCapacity": 1}},
+		map[string]interface{}{"token": map[string]interface{}{"readCapacity": 1, "writeCapacity": 1.5}},
+		map[string]interface{}{"token": map[string]interface{}{"readCapacity": "many", "writeCapacity": 1}},
+		map[string]interface{}{"token": map[string]interface{}{"readCapacity": 1, "writeCapacity": 1, "rangeKey": 7}},
+	}
+	for _, gsi := range malformed {
+		_, err := RepositoryDefinitionMap{"GSI": gsi}.GetGSIDefs()
+		if !IsErrInvalidInput(err) {
+			t.Errorf("Expected an invalid input error for %v. Got: %v", gsi, err)
+		}
+	}
+}
+
 func TestDefineRepository(t *testing.T) {
 	r, err := repoBuilder.DefineRepository("test-repo", collectionInfo)
 	if r == nil {
@@ -166,6 +232,38 @@ func TestDefineRepository(t *testing.T) {
 	}
 }
 
+func TestDefineRepositoryWithUndeclaredIndexField(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	_, err := backend.DefineRepository("users", RepositoryDefinitionMap{
+		"name":    "users",
+		"fields":  []interface{}{"email", "name"},
+		"indexes": []Index{NewUniqueIndex("email", "nmae")},
+	})
+	if !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the index on the undeclared field. Got: ", err)
+	}
+
+	_, err = backend.DefineRepository("users", RepositoryDefinitionMap{
+		"name":    "users",
+		"fields":  []string{"email", "name"},
+		"indexes": []Index{NewUniqueIndex("email", "name"), NewNonUniqueIndex("id")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefineRepositoryWithMalformedIndexes(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	for _, indexes := range []interface{}{"email", []string{"email"}, []interface{}{NewUniqueIndex("email")}} {
+		if _, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users", "indexes": indexes}); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected the indexes %v to be invalid. Got: %v", indexes, err)
+		}
+	}
+}
+
 func TestGetRepository(t *testing.T) {
 	r, err := repoBuilder.GetRepository("test-repo")
 
@@ -218,6 +316,99 @@ func TestGetBackend(t *testing.T) {
 	}
 }
 
+func TestGetBackendFromBuilder(t *testing.T) {
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"store": &config.DBInfo{},
+		"cache": &config.DBInfo{},
+	})
+
+	var mutex sync.Mutex
+	builds := map[string]int{}
+	manager.SupportBackend("store", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		mutex.Lock()
+		builds["store"]++
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {}), nil
+	}, map[string]interface{}{})
+	manager.SupportBackend("cache", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		mutex.Lock()
+		builds["cache"]++
+		mutex.Unlock()
+		// the cache depends on the store backend
+		store, err := manager.GetBackend("store")
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}, map[string]interface{}{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if _, err := manager.GetBackend("cache"); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if _, err := manager.GetBackend("store"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the builder to get the other backend without a deadlock")
+	}
+
+	if builds["store"] != 1 || builds["cache"] != 1 {
+		t.Fatal("Expected each backend to be built once. Got: ", builds)
+	}
+}
+
+func TestPrewarmBackends(t *testing.T) {
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"store":  &config.DBInfo{},
+		"broken": &config.DBInfo{},
+	})
+	manager.SupportBackend("store", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {}), nil
+	}, map[string]interface{}{})
+	manager.SupportBackend("broken", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		return nil, fmt.Errorf("connection refused")
+	}, map[string]interface{}{})
+	// supported, but not configured
+	manager.SupportBackend("unused", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		t.Fatal("Expected the backend that is not configured not to be built")
+		return nil, nil
+	}, map[string]interface{}{})
+
+	if err := manager.PrewarmBackends(context.Background(), "store"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manager.(*DefaultBackendManager).backends["store"]; !ok {
+		t.Fatal("Expected the prewarmed backend to be cached")
+	}
+
+	err := manager.PrewarmBackends(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken: connection refused") {
+		t.Fatal("Expected the error of the builder. Got: ", err)
+	}
+	if strings.Contains(err.Error(), "store") {
+		t.Fatal("Expected only the failed backend to be reported. Got: ", err)
+	}
+}
+
 func TestGetSupportedBackends(t *testing.T) {
 	backends := backendManager.GetSupportedBackends()
 
@@ -237,3 +428,273 @@ func TestGetRequiredBackendProperties(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 }
+
+func TestDefineRepositoryDryRun(t *testing.T) {
+	built := 0
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, func(def RepositoryDefinition, backend Backend) (Repository, error) {
+		built++
+		return NewMemoryCollection(def), nil
+	}, func() {}, WithRepoPlanner(MemoryRepoPlanner))
+
+	def := RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewUniqueIndex("email"), NewNonUniqueIndex("last_name", "first_name")},
+	}
+
+	plan, err := backend.DefineRepositoryDryRun("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built != 0 {
+		t.Fatal("Expected the repository not to be built in dry run")
+	}
+	if _, err = backend.GetRepository("users"); err == nil {
+		t.Fatal("Expected the repository not to be defined in dry run")
+	}
+
+	expected := []string{
+		"create repository users",
+		"create index email on fields [email]",
+		"create index last_name_first_name on fields [last_name first_name]",
+	}
+	if len(plan.Operations) != len(expected) {
+		t.Fatal("Expected 3 planned operations. Got: ", plan.Operations)
+	}
+	for i, op := range plan.Operations {
+		if op.String() != expected[i] {
+			t.Errorf("Expected operation %q, got %q", expected[i], op.String())
+		}
+	}
+
+	if _, err = backend.DefineRepository("users", def); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = backend.DefineRepositoryDryRun("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Operations) != 0 {
+		t.Fatal("Expected no operations for already defined repository. Got: ", plan.Operations)
+	}
+}
+
+// indexTrackingBackend is a fake backend that keeps the names of the existing indexes and counts
+// the indexes it creates.
+type indexTrackingBackend struct {
+	existing map[string]bool
+	created  int
+}
+
+func (b *indexTrackingBackend) build(def RepositoryDefinition, backend Backend) (Repository, error) {
+	if def.GetIndexCreationMode() == IndexCreateIfMissing {
+		for _, index := range def.GetIndexes() {
+			if !b.existing[index.GetName()] {
+				b.existing[index.GetName()] = true
+				b.created++
+			}
+		}
+	}
+	return NewMemoryCollection(def), nil
+}
+
+func (b *indexTrackingBackend) plan(def RepositoryDefinition, backend Backend) (*RepositoryPlan, error) {
+	plan := &RepositoryPlan{Repository: def.GetName()}
+	for _, index := range def.GetIndexes() {
+		if !b.existing[index.GetName()] {
+			plan.Add(ActionCreateIndex, index.GetName(), index.GetFields()...)
+		}
+	}
+	return plan, nil
+}
+
+func TestIndexCreationMode(t *testing.T) {
+	fake := &indexTrackingBackend{existing: map[string]bool{"email": true}}
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, fake.build, func() {}, WithRepoPlanner(fake.plan))
+	definition := func(name string, mode interface{}, indexes ...Index) RepositoryDefinitionMap {
+		return RepositoryDefinitionMap{"name": name, "indexes": indexes, "indexCreation": mode}
+	}
+
+	if _, err := backend.DefineRepository("users", definition("users", nil, NewUniqueIndex("email"), NewNonUniqueIndex("name"))); err != nil {
+		t.Fatal(err)
+	}
+	if fake.created != 1 || !fake.existing["name"] {
+		t.Fatal("Expected the missing index to be created by default. Got: ", fake.created)
+	}
+
+	def := definition("events", IndexSkip, NewNonUniqueIndex("type"))
+	plan, err := backend.DefineRepositoryDryRun("events", def)
+	if err != nil || len(plan.Operations) != 0 {
+		t.Fatal("Expected no index creation planned when skipped. Got: ", plan, err)
+	}
+	if _, err = backend.DefineRepository("events", def); err != nil {
+		t.Fatal(err)
+	}
+	if fake.created != 1 || fake.existing["type"] {
+		t.Fatal("Expected the index not to be created when skipped. Got: ", fake.created)
+	}
+
+	_, err = backend.DefineRepository("orders", definition("orders", "failIfMissing", NewUniqueIndex("email"), NewNonUniqueIndex("status")))
+	var missingErr MissingIndexesError
+	if !IsErrMissingIndexes(err) || !errors.As(err, &missingErr) || !reflect.DeepEqual(missingErr.Indexes, []string{"status"}) {
+		t.Fatal("Expected the missing index to be reported. Got: ", err)
+	}
+	if _, err = backend.GetRepository("orders"); err == nil {
+		t.Fatal("Expected the repository not to be defined when an index is missing")
+	}
+
+	fake.existing["status"] = true
+	if _, err = backend.DefineRepository("orders", definition("orders", IndexFailIfMissing, NewNonUniqueIndex("status"))); err != nil {
+		t.Fatal("Expected the repository with the existing indexes to be defined. Got: ", err)
+	}
+	if fake.created != 1 {
+		t.Fatal("Expected no index to be created when failing if missing. Got: ", fake.created)
+	}
+
+	if _, err = backend.DefineRepository("jobs", definition("jobs", "sometimes")); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an unknown mode to be rejected. Got: ", err)
+	}
+}
+
+func TestGetBackendPropertySchema(t *testing.T) {
+	manager := NewBackendSupport(map[string]*config.DBInfo{})
+
+	schema, err := manager.GetBackendPropertySchema("mongodb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := map[string]PropertySpec{}
+	for _, spec := range schema {
+		specs[spec.Name] = spec
+	}
+	for name, required := range map[string]bool{"dbName": true, "host": true, "database": true, "user": false, "pass": false} {
+		spec, ok := specs[name]
+		if !ok {
+			t.Errorf("Expected property %s in the schema", name)
+			continue
+		}
+		if spec.Type != "string" || spec.Required != required {
+			t.Errorf("Expected %s to be a string with required=%t, got %s with required=%t", name, required, spec.Type, spec.Required)
+		}
+	}
+	if specs["collections"].Type != "map" || len(specs["collections"].Properties) != 1 {
+		t.Errorf("Expected collections to be a map with nested properties, got %v", specs["collections"])
+	}
+
+	required, err := manager.GetRequiredBackendProperties("mongodb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(required, props) {
+		t.Errorf("Expected the required properties derived from the schema to be %v, got %v", props, required)
+	}
+
+	if _, err = manager.GetBackendPropertySchema("unknown"); err == nil {
+		t.Errorf("Expected an error for unsupported backend")
+	}
+}
+
+func TestCollationFold(t *testing.T) {
+	tests := []struct {
+		collation *Collation
+		value     string
+		expected  string
+	}{
+		{&Collation{}, "Apple", "Apple"},
+		{&Collation{CaseInsensitive: true}, "Apple", "apple"},
+		{&Collation{CaseInsensitive: true}, "I", "i"},
+		{&Collation{CaseInsensitive: true, Locale: "tr-TR"}, "I", "ı"},
+		{&Collation{CaseInsensitive: true, Locale: "tr"}, "İ", "i"},
+		{&Collation{CaseInsensitive: true, Locale: "az_AZ"}, "I", "ı"},
+	}
+
+	for _, test := range tests {
+		if folded := test.collation.fold(test.value); folded != test.expected {
+			t.Errorf("Expected %q for %q with %+v, got %q", test.expected, test.value, *test.collation, folded)
+		}
+	}
+}
+
+// hintRecordingRepository records the index hints passed to the repository.
+type hintRecordingRepository struct {
+	Repository
+	hints *[]string
+}
+
+func (r *hintRecordingRepository) WithContext(ctx context.Context) Repository {
+	return &hintRecordingRepository{Repository: r.Repository.WithContext(ctx), hints: r.hints}
+}
+
+func (r *hintRecordingRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	*r.hints = append(*r.hints, indexName)
+	return r.Repository.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+}
+
+func TestGetAllWithHint(t *testing.T) {
+	hints := []string{}
+	builder := func(def RepositoryDefinition, backend Backend) (Repository, error) {
+		collection := NewMemoryCollection(def)
+		return &hintRecordingRepository{Repository: collection, hints: &hints}, nil
+	}
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, builder, func() {}, WithDefaultQueryTimeout(time.Second))
+
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewUniqueIndex("email")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := repo.GetAllWithHint(NewFilter().Match("email", "john@example.com"), "email", &memoryTestEntry{}, "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strArrEq(hints, []string{"email"}) {
+		t.Fatal("Expected the index hint to be passed to the backend. Got: ", hints)
+	}
+	if entries := *(results.(*[]*memoryTestEntry)); len(entries) != 1 || entries[0].Name != "John" {
+		t.Fatal("Expected the in-memory backend to ignore the hint. Got: ", entries)
+	}
+}
+
+func TestTimeWindowFilters(t *testing.T) {
+	before := time.Now().UTC()
+	filter := NewFilter().WithinLast("created_at", 24*time.Hour)
+	after := time.Now().UTC()
+
+	bound, ok := filter["created_at"].(map[string]interface{})["$gte"].(time.Time)
+	if !ok {
+		t.Fatal("Expected a $gte time bound. Got: ", filter)
+	}
+	if bound.Before(before.Add(-24*time.Hour)) || bound.After(after.Add(-24*time.Hour)) {
+		t.Fatalf("Expected the bound to be 24h before now. Got: %v", bound)
+	}
+	if bound.Location() != time.UTC {
+		t.Fatal("Expected the bound in UTC. Got: ", bound.Location())
+	}
+
+	cet := time.FixedZone("CET", 3600)
+	from := time.Date(2020, 1, 1, 1, 0, 0, 0, cet)
+	to := time.Date(2020, 2, 1, 1, 0, 0, 0, cet)
+	expected := Filter{"created_at": map[string]interface{}{
+		"$gt": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"$lt": time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	if filter = NewFilter().After("created_at", from).Before("created_at", to); !reflect.DeepEqual(filter, expected) {
+		t.Fatalf("Expected %v. Got: %v", expected, filter)
+	}
+
+	repo := NewMemoryCollection(RepositoryDefinitionMap{"name": "events"})
+	for _, age := range []time.Duration{time.Hour, 48 * time.Hour} {
+		if _, err := repo.Save(&map[string]interface{}{"created_at": time.Now().In(cet).Add(-age)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := repo.Count(NewFilter().WithinLast("created_at", 24*time.Hour)); err != nil || count != 1 {
+		t.Fatal("Expected only the event of the last 24h to match. Got: ", count, err)
+	}
+}
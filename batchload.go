@@ -0,0 +1,64 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+)
+
+// batchLoadParallelism is the maximum number of the loads of BatchLoad run at the same time.
+const batchLoadParallelism = 8
+
+// LoadRequest is a load of one record by BatchLoad: the record of the repository matching the
+// filter, read into the hint like with Repository.GetOne.
+type LoadRequest struct {
+	// Repo is the repository of the record.
+	Repo Repository
+	// Filter matches the record, usually by its ID.
+	Filter Filter
+	// Hint is the object the record is read into.
+	Hint interface{}
+}
+
+// LoadResult is the result of a LoadRequest of BatchLoad.
+type LoadResult struct {
+	// Result is the loaded record, nil if the load failed.
+	Result interface{}
+	// Err is the error of the load, like ErrNotFound if no record matches the filter.
+	Err error
+}
+
+// BatchLoad loads the records of the requests, from the same or different repositories, at the
+// same time, so the independent lookups of a request cost one round trip instead of one each:
+// 		results, err := backends.BatchLoad([]backends.LoadRequest{
+// 			{Repo: userRepo, Filter: backends.NewFilter().Match("id", order.UserID), Hint: &User{}},
+// 			{Repo: productRepo, Filter: backends.NewFilter().Match("id", order.ProductID), Hint: &Product{}},
+// 		})
+// The results are in the order of the requests, and the errors are per request, in
+// LoadResult.Err, so one failed load does not fail the others. At most 8 loads run at the same
+// time. It fails with ErrInvalidInput, loading nothing, if a request has no repository.
+func BatchLoad(requests []LoadRequest) ([]LoadResult, error) {
+	for i, request := range requests {
+		if request.Repo == nil {
+			return nil, ErrInvalidInput(fmt.Sprintf("the load request %d has no repository", i))
+		}
+	}
+
+	results := make([]LoadResult, len(requests))
+	slots := make(chan struct{}, batchLoadParallelism)
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, request LoadRequest) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := request.Repo.GetOne(request.Filter, request.Hint)
+			if err != nil {
+				result = nil
+			}
+			results[i] = LoadResult{Result: result, Err: err}
+		}(i, request)
+	}
+	wg.Wait()
+	return results, nil
+}
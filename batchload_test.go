@@ -0,0 +1,80 @@
+package backends
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrentLoadRepository blocks each GetOne until the expected number of the loads are running.
+type concurrentLoadRepository struct {
+	Repository
+	running *sync.WaitGroup
+}
+
+func (r *concurrentLoadRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	r.running.Done()
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		return nil, ErrBackendError("the loads did not run concurrently")
+	}
+	return r.Repository.GetOne(filter, result)
+}
+
+func TestBatchLoad(t *testing.T) {
+	running := &sync.WaitGroup{}
+	running.Add(3)
+
+	users := &concurrentLoadRepository{Repository: NewMemoryCollection(RepositoryDefinitionMap{"name": "users", "customId": true}), running: running}
+	products := &concurrentLoadRepository{Repository: NewMemoryCollection(RepositoryDefinitionMap{"name": "products", "customId": true}), running: running}
+
+	for _, user := range []*memoryTestEntry{{ID: "1", Name: "John"}, {ID: "2", Name: "Mary"}} {
+		if _, err := users.Save(user, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := products.Save(&memoryTestEntry{ID: "1", Name: "Lamp"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := BatchLoad([]LoadRequest{
+		{Repo: users, Filter: NewFilter().Match("id", "2"), Hint: &memoryTestEntry{}},
+		{Repo: products, Filter: NewFilter().Match("id", "1"), Hint: &memoryTestEntry{}},
+		{Repo: users, Filter: NewFilter().Match("id", "1"), Hint: &memoryTestEntry{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Mary", "Lamp", "John"}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results. Got: %v", len(expected), results)
+	}
+	for i, name := range expected {
+		if results[i].Err != nil {
+			t.Fatalf("Expected the load %d to succeed. Got: %v", i, results[i].Err)
+		}
+		if entry := results[i].Result.(*memoryTestEntry); entry.Name != name {
+			t.Fatalf("Expected the load %d to return %s. Got: %s", i, name, entry.Name)
+		}
+	}
+
+	collection := NewMemoryCollection(RepositoryDefinitionMap{"name": "users"})
+	results, err = BatchLoad([]LoadRequest{{Repo: collection, Filter: NewFilter().Match("name", "Nobody"), Hint: &memoryTestEntry{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsErrNotFound(results[0].Err) || results[0].Result != nil {
+		t.Fatalf("Expected a not found error of the load. Got: %+v", results[0])
+	}
+
+	if _, err = BatchLoad([]LoadRequest{{Filter: NewFilter().Match("id", "1"), Hint: &memoryTestEntry{}}}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the request without a repository. Got: ", err)
+	}
+}
@@ -0,0 +1,56 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyResults is the error wrapped by TooManyResultsError. It is of the ErrInvalidInput
+// class, as the query should be refined.
+var ErrTooManyResults = ErrInvalidInput("too many results")
+
+// TooManyResultsError is returned by GetAllBounded when more records than the maximum match the
+// filter.
+type TooManyResultsError struct {
+	// MaxRows is the maximum number of the results.
+	MaxRows int
+}
+
+// Error returns the error message.
+func (e TooManyResultsError) Error() string {
+	return fmt.Sprintf("too many results: more than %d records match the filter", e.MaxRows)
+}
+
+// Unwrap returns ErrTooManyResults, so errors.Is(err, ErrTooManyResults) and IsErrInvalidInput
+// report true for a TooManyResultsError.
+func (e TooManyResultsError) Unwrap() error {
+	return ErrTooManyResults
+}
+
+// IsErrTooManyResults checks if the error is a TooManyResultsError.
+func IsErrTooManyResults(err error) bool {
+	return errors.Is(err, ErrTooManyResults)
+}
+
+// GetAllBounded fetches all the records matching the filter, like GetAll without a limit, but
+// fails with TooManyResultsError if more than maxRows records match, instead of returning them
+// all or silently truncating them. It reads at most maxRows+1 records to detect the overflow, so
+// an API can ask the client to refine the query without reading the whole result:
+// 		users, err := backends.GetAllBounded(userRepo, filter, &User{}, "name", "asc", 500)
+// 		if backends.IsErrTooManyResults(err) {
+// 			// respond with 400 and ask for a narrower filter
+// 		}
+// The maxRows must be positive, otherwise it is ErrInvalidInput.
+func GetAllBounded(repo Repository, filter Filter, resultsTypeHint interface{}, order string, sorting string, maxRows int) (interface{}, error) {
+	if maxRows <= 0 {
+		return nil, ErrInvalidInput(fmt.Sprintf("maxRows must be positive, got %d", maxRows))
+	}
+	results, err := repo.GetAll(filter, resultsTypeHint, order, sorting, maxRows+1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resultsCount(results) > int64(maxRows) {
+		return nil, TooManyResultsError{MaxRows: maxRows}
+	}
+	return results, nil
+}
@@ -0,0 +1,32 @@
+package backends
+
+import "testing"
+
+func TestGetAllBounded(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for _, name := range []string{"Ana", "John", "Mary"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := GetAllBounded(repo, nil, &memoryTestEntry{}, "name", "asc", 3)
+	if err != nil {
+		t.Fatal("Expected exactly maxRows records to be returned. Got: ", err)
+	}
+	if users := *results.(*[]*memoryTestEntry); len(users) != 3 {
+		t.Fatal("Expected all the matching records. Got: ", users)
+	}
+
+	_, err = GetAllBounded(repo, nil, &memoryTestEntry{}, "name", "asc", 2)
+	if !IsErrTooManyResults(err) || !IsErrInvalidInput(err) {
+		t.Fatal("Expected maxRows+1 matching records to be too many. Got: ", err)
+	}
+	if tooMany, ok := err.(TooManyResultsError); !ok || tooMany.MaxRows != 2 {
+		t.Fatal("Expected the maximum in the error. Got: ", err)
+	}
+
+	if _, err = GetAllBounded(repo, nil, &memoryTestEntry{}, "name", "asc", 0); !IsErrInvalidInput(err) || IsErrTooManyResults(err) {
+		t.Fatal("Expected a maxRows that is not positive to be rejected. Got: ", err)
+	}
+}
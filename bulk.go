@@ -0,0 +1,161 @@
+package backends
+
+import "fmt"
+
+const (
+	// BulkInsert is the kind of the bulk operations added with BulkOp.Insert.
+	BulkInsert = "insert"
+	// BulkUpdate is the kind of the bulk operations added with BulkOp.Update.
+	BulkUpdate = "update"
+	// BulkDelete is the kind of the bulk operations added with BulkOp.Delete.
+	BulkDelete = "delete"
+)
+
+// BulkOp accumulates mixed inserts, updates and deletes and executes them together, in the order
+// they were added. Get one with Repository.Bulk:
+// 		result, err := repo.Bulk().
+// 			Insert(&User{Email: "john@example.com"}).
+// 			Update(backends.NewFilter().Match("email", "jane@example.com"), map[string]interface{}{"active": false}).
+// 			Delete(backends.NewFilter().Match("active", false)).
+// 			Execute()
+// A BulkOp is not safe for concurrent use.
+type BulkOp interface {
+	// Insert adds the insert of the object as a new record, like Save without a filter. The ID
+	// of the inserted record is set on the object.
+	Insert(object interface{}) BulkOp
+	// Update adds the update of the fields on all the records matching the filter, like
+	// UpdateFieldsReturning.
+	Update(filter Filter, fields map[string]interface{}) BulkOp
+	// Delete adds the delete of all the records matching the filter, like DeleteAll.
+	Delete(filter Filter) BulkOp
+	// Execute runs the operations. If an operation fails, BulkError with the index of the
+	// operation is returned, along with the counts of the operations before it, which are
+	// executed. The operations after it are not executed.
+	Execute() (BulkResult, error)
+}
+
+// BulkOperation is an operation added to a BulkOp.
+type BulkOperation struct {
+	// Kind is BulkInsert, BulkUpdate or BulkDelete.
+	Kind string
+	// Object is the object of an insert.
+	Object interface{}
+	// Filter is the filter of an update or a delete.
+	Filter Filter
+	// Fields are the fields set by an update.
+	Fields map[string]interface{}
+}
+
+// BulkResult reports the number of records affected by the operations of a BulkOp, per kind of
+// the operation.
+type BulkResult struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+}
+
+// add adds the counts of the other result.
+func (r *BulkResult) add(other BulkResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Deleted += other.Deleted
+}
+
+// BulkError is returned by BulkOp.Execute when an operation fails.
+type BulkError struct {
+	// Operation is the index of the failed operation, in the order the operations were added.
+	Operation int
+	Cause     error
+}
+
+// Error returns the error message.
+func (e BulkError) Error() string {
+	return fmt.Sprintf("bulk operation %d failed: %s", e.Operation, e.Cause.Error())
+}
+
+// Unwrap returns the cause of the failure, so errors.As can be used to get a DuplicateKeyError.
+func (e BulkError) Unwrap() error {
+	return e.Cause
+}
+
+// bulkOp is the BulkOp of the repositories, which accumulates the operations and passes them to
+// the execute function of the repository.
+type bulkOp struct {
+	operations []BulkOperation
+	execute    func(operations []BulkOperation) (BulkResult, error)
+}
+
+// newBulkOp creates a BulkOp that executes the operations with the function.
+func newBulkOp(execute func(operations []BulkOperation) (BulkResult, error)) BulkOp {
+	return &bulkOp{execute: execute}
+}
+
+func (b *bulkOp) Insert(object interface{}) BulkOp {
+	b.operations = append(b.operations, BulkOperation{Kind: BulkInsert, Object: object})
+	return b
+}
+
+func (b *bulkOp) Update(filter Filter, fields map[string]interface{}) BulkOp {
+	b.operations = append(b.operations, BulkOperation{Kind: BulkUpdate, Filter: filter, Fields: fields})
+	return b
+}
+
+func (b *bulkOp) Delete(filter Filter) BulkOp {
+	b.operations = append(b.operations, BulkOperation{Kind: BulkDelete, Filter: filter})
+	return b
+}
+
+func (b *bulkOp) Execute() (BulkResult, error) {
+	if len(b.operations) == 0 {
+		return BulkResult{}, nil
+	}
+	return b.execute(b.operations)
+}
+
+// runBulk adds the operations to the bulk of the repository and executes it. The wrappers of the
+// repositories use it to pass their operations on to the wrapped repository.
+func runBulk(repo Repository, operations []BulkOperation) (BulkResult, error) {
+	bulk := repo.Bulk()
+	for _, operation := range operations {
+		switch operation.Kind {
+		case BulkInsert:
+			bulk.Insert(operation.Object)
+		case BulkUpdate:
+			bulk.Update(operation.Filter, operation.Fields)
+		case BulkDelete:
+			bulk.Delete(operation.Filter)
+		}
+	}
+	return bulk.Execute()
+}
+
+// executeBulkOneByOne executes the operations one at a time with the methods of the repository,
+// for the backends that cannot send mixed operations in one request.
+func executeBulkOneByOne(repo Repository, operations []BulkOperation) (BulkResult, error) {
+	result := BulkResult{}
+	for i, operation := range operations {
+		var err error
+		switch operation.Kind {
+		case BulkInsert:
+			if _, err = repo.Save(operation.Object, nil); err == nil {
+				result.Inserted++
+			}
+		case BulkUpdate:
+			var ids []interface{}
+			if ids, err = repo.UpdateFieldsReturning(operation.Filter, operation.Fields); err == nil {
+				result.Updated += len(ids)
+			}
+		case BulkDelete:
+			var ids []interface{}
+			if ids, err = repo.DeleteAllReturning(operation.Filter); err == nil {
+				result.Deleted += len(ids)
+			}
+		default:
+			err = ErrInvalidInput(fmt.Sprintf("unknown bulk operation %q", operation.Kind))
+		}
+		if err != nil {
+			return result, BulkError{Operation: i, Cause: err}
+		}
+	}
+	return result, nil
+}
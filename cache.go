@@ -0,0 +1,353 @@
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// CachingRepository caches the results of GetOne and Exists of the wrapped repository for a
+// short time. It is meant for hot, read-mostly repositories.
+//
+// Every write through the repository (Save, SaveUpsert, SaveIf, FindAndModify, UpsertAll,
+// ReplaceOne, DeleteOne, DeleteAll etc.) flushes the whole cache, as the records changed by a write
+// cannot be matched to the cached filters in general. The writes made directly on the wrapped
+// repository, or by other instances of the service, are not seen until the cached entries expire,
+// so the results may be stale for up to the TTL.
+//
+// The reads with read options other than the defaults (like ReadOpts.Consistent) are not cached.
+// The other reads (GetAll etc.) are passed to the wrapped repository.
+type CachingRepository struct {
+	Repository
+	cache *repositoryCache
+	keyFn func(Filter) string
+}
+
+// CachedRepository wraps the repository with a cache of GetOne and Exists results. The results
+// are cached for the given TTL, keyed by the key function of the filter. If keyFn is nil, the
+// key is the JSON encoding of the filter. If keyFn returns an empty key, the result is not cached.
+// See CachingRepository for details.
+func CachedRepository(repo Repository, ttl time.Duration, keyFn func(Filter) string) Repository {
+	if keyFn == nil {
+		keyFn = filterCacheKey
+	}
+	return &CachingRepository{
+		Repository: repo,
+		cache:      newRepositoryCache(ttl),
+		keyFn:      keyFn,
+	}
+}
+
+// filterCacheKey returns the JSON encoding of the filter, which has the properties sorted.
+func filterCacheKey(filter Filter) string {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// WithClock sets the clock used to expire the cached results.
+func (r *CachingRepository) WithClock(clock Clock) *CachingRepository {
+	r.cache.mutex.Lock()
+	defer r.cache.mutex.Unlock()
+
+	r.cache.now = clock
+	return r
+}
+
+// Flush removes all cached results.
+func (r *CachingRepository) Flush() {
+	r.cache.flush()
+}
+
+// Migrate applies the migrations through the wrapper and flushes the cache.
+func (r *CachingRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	defer r.cache.flush()
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *CachingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context. The copy shares the cache.
+func (r *CachingRepository) WithContext(ctx context.Context) Repository {
+	return &CachingRepository{
+		Repository: r.Repository.WithContext(ctx),
+		cache:      r.cache,
+		keyFn:      r.keyFn,
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session. The copy shares the cache,
+// which is flushed by the writes of the session, so the reads of the session observe them.
+func (r *CachingRepository) WithSession(session *Session) Repository {
+	return &CachingRepository{
+		Repository: r.Repository.WithSession(session),
+		cache:      r.cache,
+		keyFn:      r.keyFn,
+	}
+}
+
+// GetOne fetches only one record for given filter. The record is served from the cache if the
+// same filter was looked up within the TTL.
+func (r *CachingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	key := r.keyFn(filter)
+	if key == "" {
+		return r.Repository.GetOne(filter, result)
+	}
+	key = "one:" + key
+
+	if record, ok := r.cache.get(key); ok {
+		if err := MapToInterface(record, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	generation := r.cache.currentGeneration()
+	found, err := r.Repository.GetOne(filter, result)
+	if err != nil {
+		return nil, err
+	}
+	// keep a copy, so the changes of the caller to the result do not change the cached record
+	record, err := normalizeValue(found)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(key, record, generation)
+
+	return found, nil
+}
+
+// GetOneWithOpts fetches only one record for given filter. Only the reads with the default
+// options are cached.
+func (r *CachingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	if opts == (ReadOpts{}) {
+		return r.GetOne(filter, result)
+	}
+	return r.Repository.GetOneWithOpts(filter, result, opts)
+}
+
+// Exists checks if there is at least one record matching the filter. The result is served
+// from the cache if the same filter was checked within the TTL.
+func (r *CachingRepository) Exists(filter Filter) (bool, error) {
+	key := r.keyFn(filter)
+	if key == "" {
+		return r.Repository.Exists(filter)
+	}
+	key = "exists:" + key
+
+	if exists, ok := r.cache.get(key); ok {
+		return exists.(bool), nil
+	}
+
+	generation := r.cache.currentGeneration()
+	exists, err := r.Repository.Exists(filter)
+	if err != nil {
+		return false, err
+	}
+	r.cache.put(key, exists, generation)
+
+	return exists, nil
+}
+
+// Save creates new record or updates the existing one and flushes the cache.
+func (r *CachingRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.Save(object, filter)
+}
+
+// SaveWithOpts creates new record or updates the existing one and flushes the cache.
+func (r *CachingRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.SaveWithOpts(object, filter, opts)
+}
+
+// SaveWithTimestamp creates new record or updates the existing one, returns the time of the
+// write and flushes the cache.
+func (r *CachingRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	defer r.cache.flush()
+	return r.Repository.SaveWithTimestamp(object, filter)
+}
+
+// SaveUpsert updates or inserts the record and flushes the cache.
+func (r *CachingRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	defer r.cache.flush()
+	return r.Repository.SaveUpsert(object, filter)
+}
+
+// SaveIf conditionally updates the record and flushes the cache.
+func (r *CachingRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	defer r.cache.flush()
+	return r.Repository.SaveIf(object, filter, condition)
+}
+
+// FindAndModify claims the matching records and flushes the cache.
+func (r *CachingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.FindAndModify(filter, update, limit, sort)
+}
+
+// UpsertAll inserts or updates the objects and flushes the cache.
+func (r *CachingRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.UpsertAll(objects, conflictKeys)
+}
+
+// Bulk returns a BulkOp that flushes the cache once executed.
+func (r *CachingRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		defer r.cache.flush()
+		return runBulk(r.Repository, operations)
+	})
+}
+
+// ReplaceOne replaces the record matching the filter and flushes the cache.
+func (r *CachingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.ReplaceOne(filter, object)
+}
+
+// DeleteOne deletes only one record for given filter and flushes the cache.
+func (r *CachingRepository) DeleteOne(filter Filter) error {
+	defer r.cache.flush()
+	return r.Repository.DeleteOne(filter)
+}
+
+// DeleteIf deletes the record if it matches the condition and flushes the cache.
+func (r *CachingRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	defer r.cache.flush()
+	return r.Repository.DeleteIf(filter, condition)
+}
+
+// DeleteAll deletes all matched records for given filter and flushes the cache.
+func (r *CachingRepository) DeleteAll(filter Filter) error {
+	defer r.cache.flush()
+	return r.Repository.DeleteAll(filter)
+}
+
+// DeleteAllReturning deletes all matched records, returns their IDs and flushes the cache.
+func (r *CachingRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.DeleteAllReturning(filter)
+}
+
+// UpdateFieldsReturning updates all matched records, returns their IDs and flushes the cache.
+func (r *CachingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	defer r.cache.flush()
+	return r.Repository.UpdateFieldsReturning(filter, fields)
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records and flushes
+// the cache.
+func (r *CachingRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	defer r.cache.flush()
+	return r.Repository.ArrayAppend(filter, field, values...)
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records and flushes
+// the cache.
+func (r *CachingRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	defer r.cache.flush()
+	return r.Repository.ArrayRemove(filter, field, values...)
+}
+
+// UpdatePath sets the value at the path of the matched records and flushes the cache.
+func (r *CachingRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	defer r.cache.flush()
+	return r.Repository.UpdatePath(filter, path, value)
+}
+
+// repositoryCache is a thread-safe map of cached results that expire after the TTL.
+//
+// The cache has a generation that is incremented on every flush. A result is cached only if
+// no flush happened since the read started, so a read that overlaps with a write does not
+// cache the result from before the write.
+type repositoryCache struct {
+	mutex      sync.Mutex
+	entries    map[string]cacheEntry
+	generation uint64
+	ttl        time.Duration
+	now        Clock
+	pruneAt    int
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// minCachePruneSize is the number of entries at which the expired entries are first pruned.
+const minCachePruneSize = 64
+
+func newRepositoryCache(ttl time.Duration) *repositoryCache {
+	return &repositoryCache{
+		entries: map[string]cacheEntry{},
+		ttl:     ttl,
+		now:     time.Now,
+		pruneAt: minCachePruneSize,
+	}
+}
+
+func (c *repositoryCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *repositoryCache) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.generation
+}
+
+// put caches the value, unless the cache was flushed since the given generation.
+func (c *repositoryCache) put(key string, value interface{}, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := c.now()
+	if len(c.entries) >= c.pruneAt {
+		// the expired entries are removed on read, the ones that are never read again here
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruneAt = 2 * len(c.entries)
+		if c.pruneAt < minCachePruneSize {
+			c.pruneAt = minCachePruneSize
+		}
+	}
+
+	c.entries[key] = cacheEntry{
+		value:   value,
+		expires: now.Add(c.ttl),
+	}
+}
+
+func (c *repositoryCache) flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = map[string]cacheEntry{}
+	c.generation++
+}
@@ -0,0 +1,153 @@
+package backends
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingRepository counts the lookups made on the wrapped repository.
+type countingRepository struct {
+	Repository
+	mutex   sync.Mutex
+	lookups int
+}
+
+func (r *countingRepository) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lookups
+}
+
+func (r *countingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	r.mutex.Lock()
+	r.lookups++
+	r.mutex.Unlock()
+	return r.Repository.GetOne(filter, result)
+}
+
+func (r *countingRepository) Exists(filter Filter) (bool, error) {
+	r.mutex.Lock()
+	r.lookups++
+	r.mutex.Unlock()
+	return r.Repository.Exists(filter)
+}
+
+func TestCachedRepository(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	counting := &countingRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})}
+	repo := CachedRepository(counting, time.Minute, nil).(*CachingRepository).WithClock(func() time.Time { return now })
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter().Match("id", result.(*memoryTestEntry).ID)
+
+	get := func() *memoryTestEntry {
+		entry := &memoryTestEntry{}
+		if _, err := repo.GetOne(filter, entry); err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+
+	// cache hits
+	get().Name = "changed by the caller"
+	if entry := get(); entry.Name != "John" {
+		t.Fatal("Expected the cached record not to be changed by the caller. Got: ", entry)
+	}
+	if exists, _ := repo.Exists(filter); !exists {
+		t.Fatal("Expected the record to exist")
+	}
+	if exists, _ := repo.Exists(filter); !exists {
+		t.Fatal("Expected the record to exist")
+	}
+	if lookups := counting.count(); lookups != 2 {
+		t.Fatalf("Expected one lookup per method, got %d", lookups)
+	}
+
+	// TTL expiry
+	now = now.Add(time.Minute)
+	get()
+	if lookups := counting.count(); lookups != 3 {
+		t.Fatalf("Expected the expired record to be looked up again, got %d lookups", lookups)
+	}
+
+	// invalidation after a write
+	if _, err = repo.Save(&map[string]interface{}{"name": "Johnny"}, filter); err != nil {
+		t.Fatal(err)
+	}
+	if entry := get(); entry.Name != "Johnny" {
+		t.Fatal("Expected the record to be read again after the write. Got: ", entry)
+	}
+	if err = repo.DeleteOne(filter); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := repo.Exists(filter); exists {
+		t.Fatal("Expected the record not to exist after the delete")
+	}
+}
+
+func TestCachedRepositoryKeyFn(t *testing.T) {
+	counting := &countingRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})}
+	repo := CachedRepository(counting, time.Minute, func(filter Filter) string {
+		if email, ok := filter["email"].(string); ok {
+			return email
+		}
+		return ""
+	})
+
+	if _, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		repo.Exists(NewFilter().Match("email", "john@example.com"))
+		repo.Exists(NewFilter().Match("name", "John"))
+	}
+	if lookups := counting.count(); lookups != 3 {
+		t.Fatalf("Expected the filters without a key not to be cached, got %d lookups", lookups)
+	}
+}
+
+func TestCachedRepositoryConcurrentAccess(t *testing.T) {
+	repo := CachedRepository(newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}), time.Minute, nil)
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John", Age: 0}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter().Match("id", result.(*memoryTestEntry).ID)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(2)
+		go func(age int) {
+			defer wg.Done()
+			if _, err := repo.Save(&map[string]interface{}{"age": age}, filter); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.GetOne(filter, &memoryTestEntry{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// after the last write, no stale record may be served from the cache
+	stored := &memoryTestEntry{}
+	if _, err = repo.(*CachingRepository).Repository.GetOne(filter, stored); err != nil {
+		t.Fatal(err)
+	}
+	cached := &memoryTestEntry{}
+	if _, err = repo.GetOne(filter, cached); err != nil {
+		t.Fatal(err)
+	}
+	if cached.Age != stored.Age {
+		t.Fatalf("Expected the cached age %d to be the stored age %d", cached.Age, stored.Age)
+	}
+}
@@ -0,0 +1,85 @@
+package backends
+
+// BackendCapabilities reports the features that the repositories of a backend support, so
+// generic code can choose a code path or reject a configuration early instead of handling
+// the errors of the unsupported operations. The capabilities are of the backend as used by
+// this package, not of the database server: MongoDB supports transactions since 4.0, but the
+// driver used here does not.
+type BackendCapabilities struct {
+	// Transactions is set if the records of more than one write can be changed atomically.
+	Transactions bool
+	// Aggregation is set if the backend runs aggregation pipelines (group, sum, ...).
+	Aggregation bool
+	// Regex is set if Filter.MatchPattern is matched as a regular expression. DynamoDB only
+	// matches the prefix and the substrings of the pattern.
+	Regex bool
+	// TextSearch is set if the backend supports Filter.TextSearch.
+	TextSearch bool
+	// PartialIndex is set if an index can cover only the records matching a filter.
+	PartialIndex bool
+	// TTL is set if the backend expires the records of the repositories with TTL enabled on its
+	// own. The backends without it need a TTLSweeper.
+	TTL bool
+	// CaseInsensitive is set if the results can be sorted regardless of case with
+	// ReadOpts.Collation.
+	CaseInsensitive bool
+	// Sorting is set if GetAll returns the results in the requested order. The DynamoDB scans
+	// are not ordered.
+	Sorting bool
+	// Geo is set if the backend supports the geospatial filters, Filter.Near and Filter.WithinBox.
+	Geo bool
+	// ServerTimestamps is set if Repository.SaveWithTimestamp returns the time of the clock of the
+	// backend, rather than of the client.
+	ServerTimestamps bool
+	// ParallelScan is set if Repository.ParallelScan reads the segments concurrently, instead of
+	// falling back to a single pass.
+	ParallelScan bool
+	// ChangeStreams is set if Repository.Watch pushes the changes of the records.
+	ChangeStreams bool
+}
+
+// mongoCapabilities are the capabilities of the MongoDB backend.
+var mongoCapabilities = BackendCapabilities{
+	Regex:            true,
+	TextSearch:       true,
+	TTL:              true,
+	CaseInsensitive:  true,
+	Sorting:          true,
+	Geo:              true,
+	ServerTimestamps: true,
+	ChangeStreams:    true,
+}
+
+// dynamoCapabilities are the capabilities of the DynamoDB backend.
+var dynamoCapabilities = BackendCapabilities{
+	TTL:          true,
+	ParallelScan: true,
+}
+
+// memoryCapabilities are the capabilities of the in-memory backend.
+var memoryCapabilities = BackendCapabilities{
+	Regex:            true,
+	TextSearch:       true,
+	CaseInsensitive:  true,
+	Sorting:          true,
+	Geo:              true,
+	ServerTimestamps: true,
+	ParallelScan:     true,
+}
+
+// WithCapabilities sets the capabilities reported by the backend. It is set by the builders of
+// the backends; the custom backends should set it to their own capabilities.
+func WithCapabilities(capabilities BackendCapabilities) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		backend.capabilities = capabilities
+	}
+}
+
+// Capabilities returns the capabilities of the backend, set with WithCapabilities. A backend
+// built without them reports no capabilities.
+func (m *RepositoriesBackend) Capabilities() BackendCapabilities {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.capabilities
+}
@@ -0,0 +1,87 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// checkCapped checks the capped entries of the definition: a capped repository needs a limit of
+// the documents or of the bytes, and the limits are only valid on a capped repository.
+func checkCapped(def RepositoryDefinition) error {
+	maxDocuments, maxBytes := def.GetMaxDocuments(), def.GetMaxBytes()
+	if maxDocuments < 0 || maxBytes < 0 {
+		return ErrInvalidInput(fmt.Sprintf("the limits of a capped repository cannot be negative, got %d documents and %d bytes", maxDocuments, maxBytes))
+	}
+	if !def.IsCapped() {
+		if maxDocuments > 0 || maxBytes > 0 {
+			return ErrInvalidInput("maxDocuments and maxBytes are only valid on a capped repository")
+		}
+		return nil
+	}
+	if maxDocuments == 0 && maxBytes == 0 {
+		return ErrInvalidInput("a capped repository needs maxDocuments or maxBytes")
+	}
+	return nil
+}
+
+// evictCapped deletes the oldest records of a capped collection until it is within its limits.
+// The size of a record is the size of its JSON. The caller must hold the write lock.
+func (c *MemoryCollection) evictCapped() {
+	if !c.repoDef.IsCapped() {
+		return
+	}
+	maxDocuments, maxBytes := c.repoDef.GetMaxDocuments(), c.repoDef.GetMaxBytes()
+
+	evicted := 0
+	if maxDocuments > 0 && len(c.records) > maxDocuments {
+		evicted = len(c.records) - maxDocuments
+	}
+	if maxBytes > 0 {
+		sizes := make([]int, len(c.records))
+		total := 0
+		for i, record := range c.records {
+			encoded, _ := json.Marshal(record)
+			sizes[i] = len(encoded)
+			total += sizes[i]
+		}
+		for i := 0; i < evicted; i++ {
+			total -= sizes[i]
+		}
+		// the newest record is kept even if it is larger than the limit
+		for total > maxBytes && evicted < len(c.records)-1 {
+			total -= sizes[evicted]
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		c.records = append([]map[string]interface{}{}, c.records[evicted:]...)
+	}
+}
+
+// ensureCappedCollection creates the capped collection of the definition, unless the collection
+// exists. MongoDB needs the size in bytes of a capped collection.
+func ensureCappedCollection(db *mgo.Database, collectionName string, def RepositoryDefinition) error {
+	if def.GetMaxBytes() == 0 {
+		return ErrInvalidInput("MongoDB needs maxBytes for a capped collection")
+	}
+	err := db.C(collectionName).Create(&mgo.CollectionInfo{
+		Capped:   true,
+		MaxBytes: def.GetMaxBytes(),
+		MaxDocs:  def.GetMaxDocuments(),
+	})
+	if err != nil && !isMongoNamespaceExistsErr(err) {
+		return err
+	}
+	return nil
+}
+
+// isMongoNamespaceExistsErr checks if the error is the one of creating a collection that exists.
+func isMongoNamespaceExistsErr(err error) bool {
+	if queryErr, ok := err.(*mgo.QueryError); ok && queryErr.Code == 48 {
+		return true
+	}
+	return strings.Contains(err.Error(), "already exists")
+}
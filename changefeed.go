@@ -0,0 +1,164 @@
+package backends
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ChangedSince fetches the records whose timestamp field, like "updatedAt", is after since, for
+// polling the changes of a repository without a change stream. The records are in the order of
+// the field, and the returned time is the latest timestamp among them, to be passed as since to
+// the next poll, or since itself if no record changed:
+// 		since := time.Time{}
+// 		for range time.Tick(time.Minute) {
+// 			changed, next, err := backends.ChangedSince(userRepo, "updatedAt", since, &User{}, 100)
+// 			if err != nil {
+// 				continue
+// 			}
+// 			process(changed)
+// 			since = next
+// 		}
+// The records with the same timestamp are returned by the same poll, so none of them is skipped
+// or returned twice when the limit cuts through them: the records with the timestamp of the last
+// record are left to the next poll if a record with that timestamp did not fit, and if all the
+// records of the poll have the same timestamp, all the records with it are returned, beyond the
+// limit. A record written later with a timestamp not after the returned time is not returned,
+// so the writers should set the field to the time of the write.
+//
+// The field must hold time.Time values. A limit of zero is no limit. The results must be sorted
+// by GetAll, so on the backends without BackendCapabilities.Sorting, like DynamoDB, use
+// ChangedSinceWithOpts with Unsorted.
+func ChangedSince(repo Repository, field string, since time.Time, resultsTypeHint interface{}, limit int) (interface{}, time.Time, error) {
+	return ChangedSinceWithOpts(repo, field, since, resultsTypeHint, ChangedSinceOpts{Limit: limit})
+}
+
+// ChangedSinceOpts are the options of ChangedSinceWithOpts.
+type ChangedSinceOpts struct {
+	// Limit is the number of records returned by a poll, see ChangedSince. Zero is no limit.
+	Limit int
+	// Unsorted must be set for the repositories whose GetAll does not return the records in the
+	// requested order, like the DynamoDB ones (see BackendCapabilities.Sorting). The poll then
+	// reads all the records changed since the time at once and sorts them itself.
+	Unsorted bool
+}
+
+// ChangedSinceWithOpts fetches the records changed since the time like ChangedSince, with the
+// given options.
+func ChangedSinceWithOpts(repo Repository, field string, since time.Time, resultsTypeHint interface{}, opts ChangedSinceOpts) (interface{}, time.Time, error) {
+	limit := opts.Limit
+	if field == "" {
+		return nil, since, ErrInvalidInput("the timestamp field is required")
+	}
+	if limit < 0 {
+		return nil, since, ErrInvalidInput(fmt.Sprintf("the limit must not be negative, got %d", limit))
+	}
+
+	fetch := limit
+	if opts.Unsorted {
+		// the first records by the timestamp can be anywhere among the results of GetAll
+		fetch = 0
+	} else if limit > 0 {
+		// one more record shows if the records of the last timestamp are cut by the limit
+		fetch = limit + 1
+	}
+	results, err := repo.GetAll(NewFilter().Gt(field, since), resultsTypeHint, field, Ascending.String(), fetch, 0)
+	if err != nil {
+		return nil, since, err
+	}
+	timestamps, err := recordTimes(results, field)
+	if err != nil {
+		return nil, since, err
+	}
+	if opts.Unsorted {
+		sortByTimes(results, timestamps)
+	}
+	if limit == 0 || len(timestamps) <= limit {
+		if len(timestamps) == 0 {
+			return results, since, nil
+		}
+		return results, timestamps[len(timestamps)-1], nil
+	}
+
+	last := timestamps[limit-1]
+	if timestamps[limit].Equal(last) {
+		kept := limit
+		for kept > 0 && timestamps[kept-1].Equal(last) {
+			kept--
+		}
+		if kept == 0 && opts.Unsorted {
+			// all the records were read, so all the records with the timestamp follow the first
+			for kept < len(timestamps) && timestamps[kept].Equal(last) {
+				kept++
+			}
+			return sliceResults(results, kept), last, nil
+		}
+		if kept == 0 {
+			// all the records of the poll have the same timestamp
+			results, err = repo.GetAll(NewFilter().Match(field, last), resultsTypeHint, "", "", 0, 0)
+			if err != nil {
+				return nil, since, err
+			}
+			return results, last, nil
+		}
+		return sliceResults(results, kept), timestamps[kept-1], nil
+	}
+	return sliceResults(results, limit), last, nil
+}
+
+// recordTimes returns the times of the field of the results: time.Time values of the structs, or
+// RFC3339 strings of the maps decoded from JSON.
+func recordTimes(results interface{}, field string) ([]time.Time, error) {
+	times := []time.Time{}
+	err := IterateOverSlice(results, func(i int, item interface{}) error {
+		record, err := InterfaceToMap(item)
+		if err != nil {
+			return err
+		}
+		var recordTime time.Time
+		switch value := (*record)[field].(type) {
+		case time.Time:
+			recordTime = value
+		case string:
+			if recordTime, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return ErrInvalidInput(fmt.Sprintf("the value %q of %s is not a time", value, field))
+			}
+		default:
+			return ErrInvalidInput(fmt.Sprintf("the value %v of %s is not a time", value, field))
+		}
+		times = append(times, recordTime)
+		return nil
+	})
+	return times, err
+}
+
+// sortByTimes sorts the results read by GetAll, and their times, by the times.
+func sortByTimes(results interface{}, times []time.Time) {
+	swap := reflect.Swapper(reflect.Indirect(reflect.ValueOf(results)).Interface())
+	sort.Stable(resultsByTime{times: times, swap: swap})
+}
+
+// resultsByTime sorts the results by their times.
+type resultsByTime struct {
+	times []time.Time
+	swap  func(i, j int)
+}
+
+func (r resultsByTime) Len() int           { return len(r.times) }
+func (r resultsByTime) Less(i, j int) bool { return r.times[i].Before(r.times[j]) }
+func (r resultsByTime) Swap(i, j int) {
+	r.swap(i, j)
+	r.times[i], r.times[j] = r.times[j], r.times[i]
+}
+
+// sliceResults returns the first n of the results read by GetAll.
+func sliceResults(results interface{}, n int) interface{} {
+	value := reflect.ValueOf(results)
+	if value.Kind() == reflect.Ptr {
+		sliced := reflect.New(value.Elem().Type())
+		sliced.Elem().Set(value.Elem().Slice(0, n))
+		return sliced.Interface()
+	}
+	return value.Slice(0, n).Interface()
+}
@@ -0,0 +1,93 @@
+package backends
+
+import (
+	"testing"
+	"time"
+)
+
+type changeFeedTestEntry struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func TestChangedSince(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "changes", "customId": true})
+
+	base := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	updates := map[string]time.Time{
+		"1": base.Add(time.Second),
+		"2": base.Add(2 * time.Second),
+		"3": base.Add(2 * time.Second),
+		"4": base.Add(2 * time.Second),
+		"5": base.Add(3 * time.Second),
+		"6": base.Add(3 * time.Second),
+		"7": base.Add(4 * time.Second),
+	}
+	for id, updatedAt := range updates {
+		if _, err := repo.Save(&changeFeedTestEntry{ID: id, UpdatedAt: updatedAt}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testPollChanges(t, base, base.Add(4*time.Second), len(updates), func(since time.Time) (interface{}, time.Time, error) {
+		return ChangedSince(repo, "updatedAt", since, &changeFeedTestEntry{}, 2)
+	})
+
+	// the repository returns the records in the reverse order, like an unsorted backend
+	unsorted := &descendingRepository{Repository: repo}
+	testPollChanges(t, base, base.Add(4*time.Second), len(updates), func(since time.Time) (interface{}, time.Time, error) {
+		return ChangedSinceWithOpts(unsorted, "updatedAt", since, &changeFeedTestEntry{}, ChangedSinceOpts{Limit: 2, Unsorted: true})
+	})
+
+	if _, _, err := ChangedSince(repo, "updatedAt", base, &changeFeedTestEntry{}, -1); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the negative limit. Got: ", err)
+	}
+}
+
+// descendingRepository returns the results of GetAll in the descending order.
+type descendingRepository struct {
+	Repository
+}
+
+func (r *descendingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.Repository.GetAll(filter, resultsTypeHint, order, string(Descending), limit, offset)
+}
+
+// testPollChanges polls the changes since base until a poll is empty, and checks that each of the
+// count records is returned once and that the last poll returns the last timestamp.
+func testPollChanges(t *testing.T, base time.Time, last time.Time, count int, poll func(since time.Time) (interface{}, time.Time, error)) {
+	seen := map[string]int{}
+	since := base
+	for i := 0; i < 10; i++ {
+		results, next, err := poll(since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := *(results.(*[]*changeFeedTestEntry))
+		if len(entries) == 0 {
+			if !next.Equal(since) {
+				t.Fatalf("Expected the empty poll to return the same time %v. Got: %v", since, next)
+			}
+			break
+		}
+		for _, entry := range entries {
+			seen[entry.ID]++
+			if entry.UpdatedAt.After(next) {
+				t.Fatalf("Expected the next time %v to be the latest timestamp. Got a record at %v", next, entry.UpdatedAt)
+			}
+		}
+		since = next
+	}
+
+	if len(seen) != count {
+		t.Fatalf("Expected all %d records to be polled. Got: %v", count, seen)
+	}
+	for id, polled := range seen {
+		if polled != 1 {
+			t.Fatalf("Expected the record %s to be polled once. Got %d times", id, polled)
+		}
+	}
+	if !since.Equal(last) {
+		t.Fatalf("Expected the last time to be the latest timestamp. Got: %v", since)
+	}
+}
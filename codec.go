@@ -0,0 +1,432 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Codec encodes the records before they are written and decodes them after they are read, like
+// to encrypt the values of some fields (see FieldEncryptor). The records are the top-level
+// properties of the objects. The updates of FindAndModify, UpdateFieldsReturning and the bulk
+// updates are encoded too, so a codec must handle the records with only some of the properties.
+// The filters are not encoded, so the encoded fields can be queried only if the encoding keeps
+// the values comparable.
+type Codec interface {
+	// Encode encodes the properties of the record in place, before it is written.
+	Encode(record map[string]interface{}) error
+	// Decode decodes the properties of the stored record in place, after it is read.
+	Decode(record map[string]interface{}) error
+}
+
+// CodecRepository encodes the records written to the wrapped repository and decodes the records
+// read from it with the codec. The results are decoded into the types of the results hints, also
+// into the objects of the saves.
+type CodecRepository struct {
+	Repository
+	codec Codec
+}
+
+// NewCodecRepository wraps the repository so the records are encoded and decoded with the codec.
+func NewCodecRepository(repo Repository, codec Codec) *CodecRepository {
+	return &CodecRepository{
+		Repository: repo,
+		codec:      codec,
+	}
+}
+
+// encode converts the object to a record and encodes it.
+func (r *CodecRepository) encode(object interface{}) (*map[string]interface{}, error) {
+	record, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.codec.Encode(*record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// encodeFields returns a copy of the updated fields, encoded.
+func (r *CodecRepository) encodeFields(fields map[string]interface{}) (map[string]interface{}, error) {
+	encoded := map[string]interface{}{}
+	for property, value := range fields {
+		encoded[property] = value
+	}
+	if err := r.codec.Encode(encoded); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// fromStored returns the decoded properties of the stored record.
+func (r *CodecRepository) fromStored(stored interface{}) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	if err := MapToInterface(stored, &record); err != nil {
+		return nil, err
+	}
+	if err := r.codec.Decode(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// decoder returns the decoder of the stored records.
+func (r *CodecRepository) decoder() storedDecoder {
+	return storedDecoder(r.fromStored)
+}
+
+// GetOne fetches only one record for given filter.
+func (r *CodecRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.GetOneWithOpts(filter, result, ReadOpts{})
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *CodecRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	strict := opts.StrictDecode
+	opts.StrictDecode = false
+	record, err := r.Repository.GetOneWithOpts(filter, &map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.decoder().decode(record, result, strict); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetAll fetches all matched records for given filter.
+func (r *CodecRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, ReadOpts{})
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *CodecRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(r.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+func (r *CodecRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if opts.SkipDecodeErrors {
+		return getAllSkippingDecodeErrors(r, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	strict := opts.StrictDecode
+	opts.StrictDecode = false
+	records, err := r.Repository.GetAllWithOpts(filter, &map[string]interface{}{}, order, sorting, limit, offset, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeAll(records, resultsTypeHint, strict)
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *CodecRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	records, err := r.Repository.GetAllByIndex(indexName, filter, &map[string]interface{}{}, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeAll(records, resultsTypeHint, false)
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition.
+func (r *CodecRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	records, err := r.Repository.QueryRange(hashValue, rangeOp, rangeValue, &map[string]interface{}{}, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeAll(records, resultsTypeHint, false)
+}
+
+// GetAllWithHint fetches all matched records, hinting the backend to use the named index.
+func (r *CodecRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	records, err := r.Repository.GetAllWithHint(filter, indexName, &map[string]interface{}{}, order, sorting, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeAll(records, resultsTypeHint, false)
+}
+
+// ParallelScan reads all matched records in segments.
+func (r *CodecRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	return r.Repository.ParallelScan(filter, segments, &map[string]interface{}{}, func(batch interface{}) error {
+		results, err := r.decoder().decodeAll(batch, resultsTypeHint, false)
+		if err != nil {
+			return err
+		}
+		return fn(reflect.Indirect(reflect.ValueOf(results)).Interface())
+	})
+}
+
+// Save creates new record or updates the existing one.
+func (r *CodecRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	return r.SaveWithOpts(object, filter, WriteOpts{})
+}
+
+// SaveWithOpts creates new record or updates the existing one using the given write options.
+func (r *CodecRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	record, err := r.encode(object)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := r.Repository.SaveWithOpts(record, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.decoder().decode(saved, object, false); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// SaveWithTimestamp creates new record or updates the existing one and returns the time of the write.
+func (r *CodecRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	record, err := r.encode(object)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	saved, committedAt, err := r.Repository.SaveWithTimestamp(record, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err = r.decoder().decode(saved, object, false); err != nil {
+		return nil, time.Time{}, err
+	}
+	return object, committedAt, nil
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record.
+func (r *CodecRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	record, err := r.encode(object)
+	if err != nil {
+		return nil, false, err
+	}
+	saved, created, err := r.Repository.SaveUpsert(record, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = r.decoder().decode(saved, object, false); err != nil {
+		return nil, false, err
+	}
+	return object, created, nil
+}
+
+// SaveIf updates the record matching the filter if it matches the condition.
+func (r *CodecRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	record, err := r.encode(object)
+	if err != nil {
+		return nil, false, err
+	}
+	saved, updated, err := r.Repository.SaveIf(record, filter, condition)
+	if err != nil || !updated {
+		return nil, updated, err
+	}
+	if err = r.decoder().decode(saved, object, false); err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// FindAndModify claims up to limit records matching the filter.
+func (r *CodecRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	update, err := r.encodeFields(update)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := r.Repository.FindAndModify(filter, update, limit, sort)
+	if err != nil {
+		return nil, err
+	}
+	records := []map[string]interface{}{}
+	err = IterateOverSlice(claimed, func(i int, item interface{}) error {
+		record, err := r.fromStored(item)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// UpsertAll inserts or updates each of the objects.
+func (r *CodecRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	records := make([]interface{}, len(objects))
+	for i, object := range objects {
+		record, err := r.encode(object)
+		if err != nil {
+			return nil, UpsertError{Row: i, Cause: err}
+		}
+		records[i] = record
+	}
+
+	written, err := r.Repository.UpsertAll(records, conflictKeys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(written))
+	for i, record := range written {
+		result, err := r.decoder().decodeLike(record, objects[i], false)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// ReplaceOne replaces the record matching the filter.
+func (r *CodecRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	record, err := r.encode(object)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.Repository.ReplaceOne(filter, record)
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeLike(previous, object, false)
+}
+
+// UpdateFieldsReturning sets the encoded fields on all matched records and returns their IDs.
+func (r *CodecRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	fields, err := r.encodeFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.UpdateFieldsReturning(filter, fields)
+}
+
+// Bulk returns a BulkOp that encodes the inserted objects and the updated fields. The IDs of the
+// inserted records are set on the objects.
+func (r *CodecRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		encoded := make([]BulkOperation, len(operations))
+		for i, operation := range operations {
+			var err error
+			switch operation.Kind {
+			case BulkInsert:
+				operation.Object, err = r.encode(operation.Object)
+			case BulkUpdate:
+				operation.Fields, err = r.encodeFields(operation.Fields)
+			}
+			if err != nil {
+				return BulkResult{}, BulkError{Operation: i, Cause: err}
+			}
+			encoded[i] = operation
+		}
+
+		result, err := runBulk(r.Repository, encoded)
+		// the IDs are set on the objects of the inserts that were executed
+		executed := len(encoded)
+		var bulkErr BulkError
+		if errors.As(err, &bulkErr) {
+			executed = bulkErr.Operation
+		} else if err != nil {
+			executed = 0
+		}
+		for i := 0; i < executed; i++ {
+			if encoded[i].Kind != BulkInsert {
+				continue
+			}
+			if decodeErr := r.decoder().decode(encoded[i].Object, operations[i].Object, false); decodeErr != nil {
+				return result, BulkError{Operation: i, Cause: decodeErr}
+			}
+		}
+		return result, err
+	})
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records. It fails with
+// ErrUnsupported if the codec encodes the field, as the stored array would not be an array of the
+// encoded values.
+func (r *CodecRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := r.checkNotEncoded(field, values); err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayAppend(filter, field, values...)
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records. It fails with
+// ErrUnsupported if the codec encodes the field.
+func (r *CodecRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := r.checkNotEncoded(field, values); err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayRemove(filter, field, values...)
+}
+
+// UpdatePath sets the value at the path of the matched records. It fails with ErrUnsupported if
+// the codec encodes the field the path is in, as the stored field is not a document.
+func (r *CodecRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	field := strings.Split(path, ".")[0]
+	encoded, err := r.encodeFields(map[string]interface{}{field: value})
+	if err != nil {
+		return 0, err
+	}
+	if !reflect.DeepEqual(encoded[field], value) {
+		return 0, ErrUnsupported(fmt.Sprintf("cannot update a path in the encoded field %s", field))
+	}
+	return r.Repository.UpdatePath(filter, path, value)
+}
+
+// checkNotEncoded checks that the codec leaves the values of the field as they are.
+func (r *CodecRepository) checkNotEncoded(field string, values []interface{}) error {
+	encoded, err := r.encodeFields(map[string]interface{}{field: values})
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(encoded[field], values) {
+		return ErrUnsupported(fmt.Sprintf("cannot update the array of the encoded field %s", field))
+	}
+	return nil
+}
+
+// GetByIDs fetches the records with the given IDs.
+func (r *CodecRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	records, err := r.Repository.GetByIDs(ids, &map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return r.decoder().decodeAll(records, resultHint, false)
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *CodecRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *CodecRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// Watch subscribes to the changes of the records, sent with the decoded documents. A document
+// that fails to decode is sent as nil, so the change is still reported.
+func (r *CodecRepository) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	events, err := r.Repository.Watch(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return mapChangeEvents(ctx, events, func(event ChangeEvent) ChangeEvent {
+		if event.Document != nil {
+			event.Document, _ = r.fromStored(event.Document)
+		}
+		return event
+	}), nil
+}
+
+// WithContext returns a copy of the repository bound to the context.
+func (r *CodecRepository) WithContext(ctx context.Context) Repository {
+	return NewCodecRepository(r.Repository.WithContext(ctx), r.codec)
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *CodecRepository) WithSession(session *Session) Repository {
+	return NewCodecRepository(r.Repository.WithSession(session), r.codec)
+}
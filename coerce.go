@@ -0,0 +1,530 @@
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// The field types of the repository definition ("fieldTypes"), used to coerce the filter values.
+const (
+	FieldTypeString = "string"
+	FieldTypeInt    = "int"
+	FieldTypeFloat  = "float"
+	FieldTypeBool   = "bool"
+	FieldTypeTime   = "time"
+)
+
+// CoerceFilter converts the filter values of the typed fields to the field type. The filters
+// built from JSON or from query parameters have float64 and string values, which do not match
+// the int and time values stored by the backends. The values are converted as:
+// 		"int"    - whole float64, json.Number and numeric strings to int
+// 		"float"  - integers, json.Number and numeric strings to float64
+// 		"bool"   - "true" and "false" to bool
+// 		"time"   - RFC3339 strings to time.Time
+// 		"string" - numbers and bools to their string form
+// The exact match values and the operands of the comparison, $in, $contains and $all operators
+// are converted, also in the negated filter of Filter.Not. The patterns and the field comparisons
+// are left as is. A value that cannot be converted is ErrInvalidInput. The properties without a
+// type and the filters of repositories without field types are returned unchanged.
+func CoerceFilter(filter Filter, fieldTypes map[string]string) (Filter, error) {
+	if filter == nil || len(fieldTypes) == 0 {
+		return filter, nil
+	}
+	converts := func(property string) bool {
+		_, ok := fieldTypes[property]
+		return ok
+	}
+	return convertFilter(filter, converts, func(property string, value interface{}) (interface{}, error) {
+		converted, err := coerceValue(value, fieldTypes[property])
+		if err != nil {
+			return nil, coercionError(property, value, fieldTypes[property], err)
+		}
+		return converted, nil
+	})
+}
+
+// convertFilter converts the filter values of the properties for which converts is true with the
+// convert function: the exact match values and the operands of the comparison, $in, $contains
+// and $all operators, also in the negated filter of Filter.Not and the alternatives of Filter.Or.
+// The other operands and properties are left as is.
+func convertFilter(filter Filter, converts func(property string) bool, convert func(property string, value interface{}) (interface{}, error)) (Filter, error) {
+	converted := Filter{}
+	for property, value := range filter {
+		if property == NotOperator {
+			negated, err := negatedFilter(value)
+			if err != nil {
+				return nil, ErrInvalidInput(err)
+			}
+			if negated, err = convertFilter(negated, converts, convert); err != nil {
+				return nil, err
+			}
+			converted[property] = negated
+			continue
+		}
+		if property == OrOperator {
+			alternatives, err := alternativeFilters(value)
+			if err != nil {
+				return nil, ErrInvalidInput(err)
+			}
+			convertedAlternatives := make([]Filter, len(alternatives))
+			for i, alternative := range alternatives {
+				if convertedAlternatives[i], err = convertFilter(alternative, converts, convert); err != nil {
+					return nil, err
+				}
+			}
+			converted[property] = convertedAlternatives
+			continue
+		}
+
+		if !converts(property) {
+			converted[property] = value
+			continue
+		}
+
+		specs, isOperator := operatorSpecs(value)
+		if !isOperator {
+			convertedValue, err := convert(property, value)
+			if err != nil {
+				return nil, err
+			}
+			converted[property] = convertedValue
+			continue
+		}
+
+		convertedSpecs := map[string]interface{}{}
+		for operator, operand := range specs {
+			switch operator {
+			case "$gt", "$gte", "$lt", "$lte", "$contains":
+				convertedOperand, err := convert(property, operand)
+				if err != nil {
+					return nil, err
+				}
+				convertedSpecs[operator] = convertedOperand
+			case "$in", "$all":
+				values, ok := inValues(operand)
+				if !ok {
+					convertedSpecs[operator] = operand
+					continue
+				}
+				convertedValues := make([]interface{}, len(values))
+				for i, value := range values {
+					var err error
+					if convertedValues[i], err = convert(property, value); err != nil {
+						return nil, err
+					}
+				}
+				convertedSpecs[operator] = convertedValues
+			default:
+				convertedSpecs[operator] = operand
+			}
+		}
+		converted[property] = convertedSpecs
+	}
+	return converted, nil
+}
+
+func coercionError(property string, value interface{}, fieldType string, err error) error {
+	return ErrInvalidInput(fmt.Sprintf("cannot convert the value %v of %s to %s: %s", value, property, fieldType, err.Error()))
+}
+
+// coerceValue converts the value to the field type.
+func coerceValue(value interface{}, fieldType string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if number, ok := value.(json.Number); ok {
+		value = number.String()
+		if fieldType == FieldTypeString {
+			return value, nil
+		}
+	}
+
+	switch fieldType {
+	case FieldTypeInt:
+		switch v := value.(type) {
+		case string:
+			return strconv.Atoi(v)
+		case float64:
+			if v != math.Trunc(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("not a whole number")
+			}
+			return int(v), nil
+		case float32:
+			return coerceValue(float64(v), fieldType)
+		}
+		if isInteger(value) {
+			return value, nil
+		}
+	case FieldTypeFloat:
+		switch v := value.(type) {
+		case string:
+			return strconv.ParseFloat(v, 64)
+		case float64, float32:
+			return value, nil
+		}
+		if isInteger(value) {
+			return float64(reflect.ValueOf(value).Convert(reflect.TypeOf(int64(0))).Int()), nil
+		}
+	case FieldTypeBool:
+		switch v := value.(type) {
+		case string:
+			return strconv.ParseBool(v)
+		case bool:
+			return v, nil
+		}
+	case FieldTypeTime:
+		switch v := value.(type) {
+		case string:
+			return time.Parse(time.RFC3339, v)
+		case time.Time:
+			return v, nil
+		}
+	case FieldTypeString:
+		switch value.(type) {
+		case string, bool, float64, float32:
+			return fmt.Sprint(value), nil
+		}
+		if isInteger(value) {
+			return fmt.Sprint(value), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown field type %s", fieldType)
+	}
+	return nil, fmt.Errorf("unexpected value type %T", value)
+}
+
+// isInteger checks if the value is of one of the integer types.
+func isInteger(value interface{}) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// CoercingRepository converts the filter values of the wrapped repository to the field types
+// with CoerceFilter, and the names of the enums to the stored values with EnumFilter, before
+// querying. The repositories defined with field types are wrapped with it by DefineRepository.
+type CoercingRepository struct {
+	Repository
+	fieldTypes map[string]string
+	enums      map[string]Enum
+}
+
+// NewCoercingRepository wraps the repository so the filter values are converted to the field types.
+func NewCoercingRepository(repo Repository, fieldTypes map[string]string) *CoercingRepository {
+	return &CoercingRepository{
+		Repository: repo,
+		fieldTypes: fieldTypes,
+	}
+}
+
+func (r *CoercingRepository) coerce(filter Filter) (Filter, error) {
+	coerced, err := CoerceFilter(filter, r.fieldTypes)
+	if err != nil {
+		return nil, err
+	}
+	return EnumFilter(coerced, r.enums)
+}
+
+// GetOne fetches only one record for given filter.
+func (r *CoercingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetOne(filter, result)
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *CoercingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetOneWithOpts(filter, result, opts)
+}
+
+// GetAll fetches all matched records for given filter.
+func (r *CoercingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *CoercingRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetFirst(filter, resultsTypeHint, order, sorting)
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+func (r *CoercingRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *CoercingRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+}
+
+// GetAllWithHint fetches all matched records, hinting the backend to use the named index.
+func (r *CoercingRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// ParallelScan reads all matched records in segments.
+func (r *CoercingRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.ParallelScan(filter, segments, resultsTypeHint, fn)
+}
+
+// Save creates new record or updates the existing one.
+func (r *CoercingRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.Save(object, filter)
+}
+
+// SaveWithOpts creates new record or updates the existing one using the given write options.
+func (r *CoercingRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.SaveWithOpts(object, filter, opts)
+}
+
+// SaveWithTimestamp creates new record or updates the existing one and returns the time of the write.
+func (r *CoercingRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return r.Repository.SaveWithTimestamp(object, filter)
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record.
+func (r *CoercingRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, false, err
+	}
+	return r.Repository.SaveUpsert(object, filter)
+}
+
+// SaveIf updates the record matching the filter if it matches the condition.
+func (r *CoercingRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if condition, err = r.coerce(condition); err != nil {
+		return nil, false, err
+	}
+	return r.Repository.SaveIf(object, filter, condition)
+}
+
+// FindAndModify claims up to limit records matching the filter.
+func (r *CoercingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.FindAndModify(filter, update, limit, sort)
+}
+
+// ReplaceOne replaces the record matching the filter.
+func (r *CoercingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.ReplaceOne(filter, object)
+}
+
+// DeleteOne deletes only one record for given filter.
+func (r *CoercingRepository) DeleteOne(filter Filter) error {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.DeleteOne(filter)
+}
+
+// DeleteIf deletes the record matching the filter if it matches the condition.
+func (r *CoercingRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return false, err
+	}
+	if condition, err = r.coerce(condition); err != nil {
+		return false, err
+	}
+	return r.Repository.DeleteIf(filter, condition)
+}
+
+// Bulk returns a BulkOp whose filters are coerced before the operations are executed.
+func (r *CoercingRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		coerced := make([]BulkOperation, len(operations))
+		for i, operation := range operations {
+			if operation.Filter != nil {
+				filter, err := r.coerce(operation.Filter)
+				if err != nil {
+					return BulkResult{}, BulkError{Operation: i, Cause: err}
+				}
+				operation.Filter = filter
+			}
+			coerced[i] = operation
+		}
+		return runBulk(r.Repository, coerced)
+	})
+}
+
+// DeleteAll deletes all matched records for given filter.
+func (r *CoercingRepository) DeleteAll(filter Filter) error {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.DeleteAll(filter)
+}
+
+// DeleteAllReturning deletes all matched records and returns their IDs.
+func (r *CoercingRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.DeleteAllReturning(filter)
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs.
+func (r *CoercingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.UpdateFieldsReturning(filter, fields)
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records.
+func (r *CoercingRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayAppend(filter, field, values...)
+}
+
+// UpdatePath sets the value at the path of the matched records.
+func (r *CoercingRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.UpdatePath(filter, path, value)
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records.
+func (r *CoercingRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayRemove(filter, field, values...)
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (r *CoercingRepository) Exists(filter Filter) (bool, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return false, err
+	}
+	return r.Repository.Exists(filter)
+}
+
+// Count returns the number of records matching the filter.
+func (r *CoercingRepository) Count(filter Filter) (int, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.Count(filter)
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records.
+func (r *CoercingRepository) CountBoth(filter Filter) (int64, int64, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.Repository.CountBoth(filter)
+}
+
+// Dump returns the query of the operation with the filter, with the values converted to the
+// types of the fields.
+func (r *CoercingRepository) Dump(op string, filter Filter) (string, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return "", err
+	}
+	return r.Repository.Dump(op, filter)
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *CoercingRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *CoercingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// Watch subscribes to the changes of the records matching the coerced filter.
+func (r *CoercingRepository) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	filter, err := r.coerce(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.Watch(ctx, filter)
+}
+
+// WithContext returns a copy of the repository bound to the context.
+func (r *CoercingRepository) WithContext(ctx context.Context) Repository {
+	return &CoercingRepository{Repository: r.Repository.WithContext(ctx), fieldTypes: r.fieldTypes, enums: r.enums}
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *CoercingRepository) WithSession(session *Session) Repository {
+	return &CoercingRepository{Repository: r.Repository.WithSession(session), fieldTypes: r.fieldTypes, enums: r.enums}
+}
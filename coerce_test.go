@@ -0,0 +1,88 @@
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestCoerceFilter(t *testing.T) {
+	fieldTypes := map[string]string{
+		"id":         FieldTypeInt,
+		"created_at": FieldTypeTime,
+		"score":      FieldTypeFloat,
+		"zip":        FieldTypeString,
+	}
+
+	// as decoded from JSON
+	filter := NewFilter().
+		Match("id", float64(42)).
+		Gte("created_at", "2020-01-02T15:04:05Z").
+		In("score", json.Number("1"), 2).
+		Match("zip", 1234).
+		Match("name", "John").
+		Not(NewFilter().Match("id", "7"))
+
+	coerced, err := CoerceFilter(filter, fieldTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Filter{
+		"id":         42,
+		"created_at": map[string]interface{}{"$gte": time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)},
+		"score":      map[string]interface{}{"$in": []interface{}{float64(1), float64(2)}},
+		"zip":        "1234",
+		"name":       "John",
+		NotOperator:  Filter{"id": 7},
+	}
+	if !reflect.DeepEqual(coerced, expected) {
+		t.Fatalf("Expected the coerced filter %v. Got: %v", expected, coerced)
+	}
+
+	invalid := []Filter{
+		NewFilter().Match("id", 4.2),
+		NewFilter().Match("id", "forty-two"),
+		NewFilter().Lt("created_at", "yesterday"),
+		NewFilter().Match("created_at", true),
+	}
+	for _, filter := range invalid {
+		if _, err := CoerceFilter(filter, fieldTypes); !IsErrInvalidInput(err) {
+			t.Errorf("Expected an invalid input error for %v. Got: %v", filter, err)
+		}
+	}
+}
+
+func TestDefineRepositoryWithFieldTypes(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	_, err := backend.DefineRepository("invalid", RepositoryDefinitionMap{
+		"name":       "invalid",
+		"fieldTypes": map[string]interface{}{"age": "integer"},
+	})
+	if !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the unknown field type. Got: ", err)
+	}
+
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{
+		"name":       "users",
+		"fieldTypes": map[string]interface{}{"age": FieldTypeInt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Save(&memoryTestEntry{Name: "John", Age: 30}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := repo.Count(NewFilter().Match("age", "30")); err != nil || count != 1 {
+		t.Fatal("Expected the string age to match the stored age. Got: ", count, err)
+	}
+	if _, err = repo.Exists(NewFilter().Match("age", "thirty")); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the age that is not a number. Got: ", err)
+	}
+}
@@ -0,0 +1,556 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CompositeMode is how a CompositeBackend handles the failed writes of the secondary backend.
+type CompositeMode string
+
+const (
+	// DualWriteBestEffort logs the failed writes of the secondary with Logger.Errorf and reports
+	// the write as successful, so the secondary cannot fail the service. The records of the
+	// failed writes are out of sync until they are written again or backfilled.
+	DualWriteBestEffort CompositeMode = "bestEffort"
+	// DualWriteRequired fails the write with SecondaryWriteError if the write of the secondary
+	// fails. The write of the primary is not rolled back.
+	DualWriteRequired CompositeMode = "required"
+)
+
+// SecondaryWriteError is returned by the writes of a CompositeRepository in the
+// DualWriteRequired mode when the write of the primary succeeded but the write of the secondary
+// failed. Use errors.As to get the failed operation:
+// 		var secondaryErr backends.SecondaryWriteError
+// 		if errors.As(err, &secondaryErr) {
+// 			fmt.Println(secondaryErr.Operation, secondaryErr.Cause)
+// 		}
+type SecondaryWriteError struct {
+	// Repository is the name of the repository.
+	Repository string
+	// Operation is the name of the Repository method, like "Save".
+	Operation string
+	// Cause is the error of the secondary.
+	Cause error
+}
+
+// Error returns the error message.
+func (e SecondaryWriteError) Error() string {
+	return fmt.Sprintf("%s of %s is applied to the primary, but failed on the secondary: %s", e.Operation, e.Repository, e.Cause.Error())
+}
+
+// Unwrap returns the error of the secondary.
+func (e SecondaryWriteError) Unwrap() error {
+	return e.Cause
+}
+
+// IsErrSecondaryWrite checks if the error is a SecondaryWriteError.
+func IsErrSecondaryWrite(err error) bool {
+	var secondaryErr SecondaryWriteError
+	return errors.As(err, &secondaryErr)
+}
+
+// CompositeBackend writes to two backends and reads from the first, for the migration of the
+// data from one database to another: the repositories write to the primary backend, then to the
+// secondary, and read from the primary, falling back to the secondary when the primary fails.
+// The secondary starts as the new database; once it is backfilled, swap the two, and finally
+// drop the composite.
+//
+// The writes of the two backends are not atomic. The primary and the secondary are in sync only
+// for the records written since the composite is in place, as long as no write of the secondary
+// fails (see CompositeMode); the older records must be backfilled. The reads that fall back to
+// the secondary may miss the records that are not backfilled yet, and the concurrent writes of
+// the same record may be applied to the two backends in a different order. The IDs generated by
+// the backends differ, so the repositories should have custom IDs (see IsCustomID): a new
+// record is written to the secondary with the ID of the primary.
+//
+// The other methods of Backend, like GetConfig or StartSession, are the ones of the primary.
+type CompositeBackend struct {
+	Backend
+	secondary    Backend
+	mode         CompositeMode
+	repositories map[string]*CompositeRepository
+	mutex        sync.Mutex
+}
+
+// NewCompositeBackend creates a backend that writes to both backends and reads from the primary
+// with a fallback to the secondary. The mode is DualWriteBestEffort or DualWriteRequired.
+func NewCompositeBackend(primary Backend, secondary Backend, mode CompositeMode) *CompositeBackend {
+	return &CompositeBackend{
+		Backend:      primary,
+		secondary:    secondary,
+		mode:         mode,
+		repositories: map[string]*CompositeRepository{},
+	}
+}
+
+// Primary returns the primary backend.
+func (b *CompositeBackend) Primary() Backend {
+	return b.Backend
+}
+
+// Secondary returns the secondary backend.
+func (b *CompositeBackend) Secondary() Backend {
+	return b.secondary
+}
+
+// DefineRepository defines the repository on both backends and returns the repository writing
+// to both. It fails with ErrInvalidInput if the mode of the backend is unknown.
+func (b *CompositeBackend) DefineRepository(name string, def RepositoryDefinition) (Repository, error) {
+	if b.mode != DualWriteBestEffort && b.mode != DualWriteRequired {
+		return nil, ErrInvalidInput(fmt.Sprintf("unknown composite mode %q", b.mode))
+	}
+	primary, err := b.Backend.DefineRepository(name, def)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := b.secondary.DefineRepository(name, def)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	repository := &CompositeRepository{
+		Repository: primary,
+		secondary:  secondary,
+		name:       name,
+		idField:    def.GetIDField(),
+		mode:       b.mode,
+		logger:     backendLogger{backend: b.Backend},
+	}
+	b.repositories[name] = repository
+	return repository, nil
+}
+
+// GetRepository returns the repository defined with DefineRepository.
+func (b *CompositeBackend) GetRepository(name string) (Repository, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if repository, ok := b.repositories[name]; ok {
+		return repository, nil
+	}
+	return nil, ErrNotFound(fmt.Sprintf("repository %s is not defined", name))
+}
+
+// DefineRepositoryDryRun returns the operations that DefineRepository would execute on the
+// primary backend.
+func (b *CompositeBackend) DefineRepositoryDryRun(name string, def RepositoryDefinition) (*RepositoryPlan, error) {
+	return b.Backend.DefineRepositoryDryRun(name, def)
+}
+
+// Shutdown shuts down both backends.
+func (b *CompositeBackend) Shutdown() {
+	b.Backend.Shutdown()
+	b.secondary.Shutdown()
+}
+
+// CompositeRepository is a repository of a CompositeBackend. The writes are applied to the
+// primary repository, then to the secondary; the reads of GetOne, GetAll, GetFirst, their
+// variants with options, GetByIDs, Exists and Count fall back to the secondary when the primary
+// fails with an error other than ErrNotFound or ErrInvalidInput. The other reads, like the
+// queries of an index, are of the primary only.
+type CompositeRepository struct {
+	Repository
+	secondary Repository
+	name      string
+	idField   string
+	mode      CompositeMode
+	logger    Logger
+}
+
+// Primary returns the repository of the primary backend.
+func (r *CompositeRepository) Primary() Repository {
+	return r.Repository
+}
+
+// Secondary returns the repository of the secondary backend.
+func (r *CompositeRepository) Secondary() Repository {
+	return r.secondary
+}
+
+// read runs the read on the primary and, if it fails, on the secondary.
+func (r *CompositeRepository) read(operation string, read func(repo Repository) (interface{}, error)) (interface{}, error) {
+	result, err := read(r.Repository)
+	if err == nil || IsErrNotFound(err) || IsErrInvalidInput(err) {
+		return result, err
+	}
+	r.logger.Errorf("composite %s: %s failed on the primary, reading from the secondary: %s", r.name, operation, err.Error())
+	return read(r.secondary)
+}
+
+// mirror applies the write to the secondary, after it was applied to the primary. The failure
+// is handled as set by the mode.
+func (r *CompositeRepository) mirror(operation string, write func(repo Repository) error) error {
+	err := write(r.secondary)
+	if err == nil {
+		return nil
+	}
+	if r.mode == DualWriteBestEffort {
+		r.logger.Errorf("composite %s: %s failed on the secondary: %s", r.name, operation, err.Error())
+		return nil
+	}
+	return SecondaryWriteError{Repository: r.name, Operation: operation, Cause: err}
+}
+
+// mirrorSave writes the record saved by the primary to the secondary: a new record with its ID,
+// or an update of the record matching the filter, inserted if the secondary does not have it.
+func (r *CompositeRepository) mirrorSave(operation string, saved interface{}, filter Filter) error {
+	return r.mirror(operation, func(repo Repository) error {
+		record, err := InterfaceToMap(saved)
+		if err != nil {
+			return err
+		}
+		if filter == nil {
+			_, err = repo.Save(record, nil)
+			return err
+		}
+		_, _, err = repo.SaveUpsert(record, filter)
+		return err
+	})
+}
+
+// GetOne looks up for a record on the primary, falling back to the secondary.
+func (r *CompositeRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.read("GetOne", func(repo Repository) (interface{}, error) {
+		return repo.GetOne(filter, result)
+	})
+}
+
+// GetOneWithOpts looks up for a record on the primary, falling back to the secondary.
+func (r *CompositeRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	return r.read("GetOneWithOpts", func(repo Repository) (interface{}, error) {
+		return repo.GetOneWithOpts(filter, result, opts)
+	})
+}
+
+// GetAll returns the matched records of the primary, falling back to the secondary.
+func (r *CompositeRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.read("GetAll", func(repo Repository) (interface{}, error) {
+		return repo.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+	})
+}
+
+// GetFirst returns the first of the matched records of the primary, falling back to the
+// secondary.
+func (r *CompositeRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return r.read("GetFirst", func(repo Repository) (interface{}, error) {
+		return repo.GetFirst(filter, resultsTypeHint, order, sorting)
+	})
+}
+
+// GetAllWithOpts returns the matched records of the primary, falling back to the secondary.
+func (r *CompositeRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	return r.read("GetAllWithOpts", func(repo Repository) (interface{}, error) {
+		return repo.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	})
+}
+
+// GetByIDs returns the records of the primary with the IDs, falling back to the secondary.
+func (r *CompositeRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	return r.read("GetByIDs", func(repo Repository) (interface{}, error) {
+		return repo.GetByIDs(ids, resultHint)
+	})
+}
+
+// Exists checks if the primary has a matching record, falling back to the secondary.
+func (r *CompositeRepository) Exists(filter Filter) (bool, error) {
+	exists, err := r.read("Exists", func(repo Repository) (interface{}, error) {
+		return repo.Exists(filter)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.(bool), nil
+}
+
+// Count counts the matching records of the primary, falling back to the secondary.
+func (r *CompositeRepository) Count(filter Filter) (int, error) {
+	count, err := r.read("Count", func(repo Repository) (interface{}, error) {
+		return repo.Count(filter)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.(int), nil
+}
+
+// CountBoth counts the matching and all the records of the primary, falling back to the
+// secondary.
+func (r *CompositeRepository) CountBoth(filter Filter) (int64, int64, error) {
+	counts, err := r.read("CountBoth", func(repo Repository) (interface{}, error) {
+		filtered, total, err := repo.CountBoth(filter)
+		return [2]int64{filtered, total}, err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts.([2]int64)[0], counts.([2]int64)[1], nil
+}
+
+// Save saves the object to the primary, then the saved record to the secondary.
+func (r *CompositeRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	saved, err := r.Repository.Save(object, filter)
+	if err != nil {
+		return nil, err
+	}
+	return saved, r.mirrorSave("Save", saved, filter)
+}
+
+// SaveWithOpts saves the object to the primary with the write options, then the saved record to
+// the secondary.
+func (r *CompositeRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	saved, err := r.Repository.SaveWithOpts(object, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return saved, r.mirrorSave("SaveWithOpts", saved, filter)
+}
+
+// SaveWithTimestamp saves the object to the primary, then the saved record to the secondary. The
+// time is the time of the write of the primary.
+func (r *CompositeRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	saved, committedAt, err := r.Repository.SaveWithTimestamp(object, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return saved, committedAt, r.mirrorSave("SaveWithTimestamp", saved, filter)
+}
+
+// SaveUpsert upserts the object to the primary, then the upserted record to the secondary.
+func (r *CompositeRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	saved, inserted, err := r.Repository.SaveUpsert(object, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	return saved, inserted, r.mirrorSave("SaveUpsert", saved, filter)
+}
+
+// SaveIf saves the object to the primary if the condition holds, then the saved record to the
+// secondary. The condition is not checked on the secondary.
+func (r *CompositeRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	saved, applied, err := r.Repository.SaveIf(object, filter, condition)
+	if err != nil || !applied {
+		return saved, applied, err
+	}
+	return saved, applied, r.mirrorSave("SaveIf", saved, filter)
+}
+
+// ReplaceOne replaces the record of the primary, then writes the object with the ID of the
+// primary record to the secondary, inserting it if the secondary does not have it.
+func (r *CompositeRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	replaced, err := r.Repository.ReplaceOne(filter, object)
+	if err != nil {
+		return nil, err
+	}
+	return replaced, r.mirror("ReplaceOne", func(repo Repository) error {
+		// the primary returns the previous record, the secondary gets the object with its ID
+		previous, err := InterfaceToMap(replaced)
+		if err != nil {
+			return err
+		}
+		record, err := InterfaceToMap(object)
+		if err != nil {
+			return err
+		}
+		(*record)[r.idField] = (*previous)[r.idField]
+		if _, err = repo.ReplaceOne(filter, record); err != nil && IsErrNotFound(err) {
+			_, err = repo.Save(record, nil)
+		}
+		return err
+	})
+}
+
+// UpsertAll upserts the objects to the primary, then the upserted records to the secondary.
+func (r *CompositeRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	upserted, err := r.Repository.UpsertAll(objects, conflictKeys)
+	if err != nil {
+		return nil, err
+	}
+	return upserted, r.mirror("UpsertAll", func(repo Repository) error {
+		records := make([]interface{}, len(upserted))
+		for i, object := range upserted {
+			record, err := InterfaceToMap(object)
+			if err != nil {
+				return err
+			}
+			records[i] = record
+		}
+		_, err := repo.UpsertAll(records, conflictKeys)
+		return err
+	})
+}
+
+// FindAndModify claims the records of the primary, then writes the claimed records to the
+// secondary.
+func (r *CompositeRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	claimed, err := r.Repository.FindAndModify(filter, update, limit, sort)
+	if err != nil {
+		return nil, err
+	}
+	return claimed, r.mirror("FindAndModify", func(repo Repository) error {
+		return IterateOverSlice(claimed, func(i int, item interface{}) error {
+			record, err := InterfaceToMap(item)
+			if err != nil {
+				return err
+			}
+			_, _, err = repo.SaveUpsert(record, NewFilter().Match(r.idField, (*record)[r.idField]))
+			return err
+		})
+	})
+}
+
+// DeleteOne deletes the record from the primary, then from the secondary, which may not have it.
+func (r *CompositeRepository) DeleteOne(filter Filter) error {
+	if err := r.Repository.DeleteOne(filter); err != nil {
+		return err
+	}
+	return r.mirror("DeleteOne", func(repo Repository) error {
+		if err := repo.DeleteOne(filter); err != nil && !IsErrNotFound(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// DeleteIf deletes the record from the primary if it matches the condition, then from the
+// secondary, regardless of its copy of the record.
+func (r *CompositeRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	deleted, err := r.Repository.DeleteIf(filter, condition)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return true, r.mirror("DeleteIf", func(repo Repository) error {
+		if err := repo.DeleteOne(filter); err != nil && !IsErrNotFound(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// DeleteAll deletes the matching records from the primary, then from the secondary.
+func (r *CompositeRepository) DeleteAll(filter Filter) error {
+	if err := r.Repository.DeleteAll(filter); err != nil {
+		return err
+	}
+	return r.mirror("DeleteAll", func(repo Repository) error {
+		return repo.DeleteAll(filter)
+	})
+}
+
+// DeleteAllReturning deletes the matching records from the primary, then from the secondary, and
+// returns the IDs of the records deleted from the primary.
+func (r *CompositeRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	ids, err := r.Repository.DeleteAllReturning(filter)
+	if err != nil {
+		return nil, err
+	}
+	return ids, r.mirror("DeleteAllReturning", func(repo Repository) error {
+		return repo.DeleteAll(filter)
+	})
+}
+
+// UpdateFieldsReturning updates the matching records of the primary, then of the secondary, and
+// returns the IDs of the records updated on the primary.
+func (r *CompositeRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	ids, err := r.Repository.UpdateFieldsReturning(filter, fields)
+	if err != nil {
+		return nil, err
+	}
+	return ids, r.mirror("UpdateFieldsReturning", func(repo Repository) error {
+		_, err := repo.UpdateFieldsReturning(filter, fields)
+		return err
+	})
+}
+
+// ArrayAppend appends the values on the primary, then on the secondary.
+func (r *CompositeRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	updated, err := r.Repository.ArrayAppend(filter, field, values...)
+	if err != nil {
+		return 0, err
+	}
+	return updated, r.mirror("ArrayAppend", func(repo Repository) error {
+		_, err := repo.ArrayAppend(filter, field, values...)
+		return err
+	})
+}
+
+// ArrayRemove removes the values on the primary, then on the secondary.
+func (r *CompositeRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	updated, err := r.Repository.ArrayRemove(filter, field, values...)
+	if err != nil {
+		return 0, err
+	}
+	return updated, r.mirror("ArrayRemove", func(repo Repository) error {
+		_, err := repo.ArrayRemove(filter, field, values...)
+		return err
+	})
+}
+
+// UpdatePath sets the nested field on the primary, then on the secondary.
+func (r *CompositeRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	updated, err := r.Repository.UpdatePath(filter, path, value)
+	if err != nil {
+		return 0, err
+	}
+	return updated, r.mirror("UpdatePath", func(repo Repository) error {
+		_, err := repo.UpdatePath(filter, path, value)
+		return err
+	})
+}
+
+// Bulk returns a BulkOp that executes the operations on the primary, then on the secondary. The
+// inserted objects have the IDs of the primary when they are inserted to the secondary.
+func (r *CompositeRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		result, err := runBulk(r.Repository, operations)
+		if err != nil {
+			return result, err
+		}
+		return result, r.mirror("Bulk", func(repo Repository) error {
+			_, err := runBulk(repo, operations)
+			return err
+		})
+	})
+}
+
+// EnsureIndexes creates the missing indexes on the primary and on the secondary.
+func (r *CompositeRepository) EnsureIndexes(indexes []Index) error {
+	if err := r.Repository.EnsureIndexes(indexes); err != nil {
+		return err
+	}
+	return r.secondary.EnsureIndexes(indexes)
+}
+
+// Migrate applies the migrations through the composite, so their writes go to both backends.
+// The applied versions are recorded in the primary. See Repository.Migrate.
+func (r *CompositeRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the composite, so it is written to both backends. The
+// idempotency keys are recorded in the primary. See Repository.SaveIdempotent.
+func (r *CompositeRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns the composite of the repositories bound to the context.
+func (r *CompositeRepository) WithContext(ctx context.Context) Repository {
+	bound := *r
+	bound.Repository = r.Repository.WithContext(ctx)
+	bound.secondary = r.secondary.WithContext(ctx)
+	return &bound
+}
+
+// WithSession returns the composite of the repositories bound to the session.
+func (r *CompositeRepository) WithSession(session *Session) Repository {
+	bound := *r
+	bound.Repository = r.Repository.WithSession(session)
+	bound.secondary = r.secondary.WithSession(session)
+	return &bound
+}
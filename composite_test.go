@@ -0,0 +1,159 @@
+package backends
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// unavailableReadsBackend defines the repositories whose reads fail, like on a primary that is
+// down for reads.
+type unavailableReadsBackend struct {
+	Backend
+}
+
+func (b *unavailableReadsBackend) DefineRepository(name string, def RepositoryDefinition) (Repository, error) {
+	repo, err := b.Backend.DefineRepository(name, def)
+	if err != nil {
+		return nil, err
+	}
+	return &unavailableReadsRepository{Repository: repo}, nil
+}
+
+type unavailableReadsRepository struct {
+	Repository
+}
+
+func (r *unavailableReadsRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return nil, ErrBackendError("connection refused")
+}
+
+func newCompositeTestBackends(t *testing.T) (Backend, Backend) {
+	backends := []Backend{}
+	for i := 0; i < 2; i++ {
+		backend, err := NewBackendSupport(map[string]*config.DBInfo{"memory": &config.DBInfo{}}).GetBackend("memory")
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, backend)
+	}
+	return backends[0], backends[1]
+}
+
+func TestCompositeBackend(t *testing.T) {
+	primary, secondary := newCompositeTestBackends(t)
+	def := RepositoryDefinitionMap{"name": "users", "customId": true}
+	repo, err := NewCompositeBackend(&unavailableReadsBackend{Backend: primary}, secondary, DualWriteRequired).DefineRepository("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := repo.Save(&map[string]interface{}{"name": "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := (*saved.(*map[string]interface{}))["id"]
+	for _, backend := range []Backend{primary, secondary} {
+		users, err := backend.GetRepository("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count, err := users.Count(NewFilter().Match("id", id)); err != nil || count != 1 {
+			t.Fatal("Expected the record written to both backends with the same ID. Got: ", count, err)
+		}
+	}
+
+	if _, err = repo.UpdateFieldsReturning(NewFilter().Match("id", id), map[string]interface{}{"name": "Johnny"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := repo.GetOne(NewFilter().Match("id", id), &map[string]interface{}{})
+	if err != nil {
+		t.Fatal("Expected the read to fall back to the secondary. Got: ", err)
+	}
+	if name := (*user.(*map[string]interface{}))["name"]; name != "Johnny" {
+		t.Fatal("Expected the update applied to the secondary. Got: ", name)
+	}
+}
+
+func TestCompositeBackendSecondaryFailure(t *testing.T) {
+	primary, secondary := newCompositeTestBackends(t)
+	def := RepositoryDefinitionMap{"name": "users", "customId": true, "indexes": []Index{NewIndex("email", true, "email")}}
+	secondaryUsers, err := secondary.DefineRepository("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"john@example.com", "mary@example.com"} {
+		if _, err = secondaryUsers.Save(&map[string]interface{}{"email": email}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	required, err := NewCompositeBackend(primary, secondary, DualWriteRequired).DefineRepository("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = required.Save(&map[string]interface{}{"email": "john@example.com"}, nil)
+	var secondaryErr SecondaryWriteError
+	if !errors.As(err, &secondaryErr) || secondaryErr.Operation != "Save" || !IsErrAlreadyExists(err) {
+		t.Fatal("Expected the failed write of the secondary to fail the save. Got: ", err)
+	}
+
+	bestEffort, err := NewCompositeBackend(primary, secondary, DualWriteBestEffort).DefineRepository("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bestEffort.Save(&map[string]interface{}{"email": "mary@example.com"}, nil); err != nil {
+		t.Fatal("Expected the failed write of the secondary to be ignored. Got: ", err)
+	}
+
+	if _, err = NewCompositeBackend(primary, secondary, "mirror").DefineRepository("users", def); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an unknown mode to be rejected. Got: ", err)
+	}
+}
+
+func TestCompositeBackendReplaceOne(t *testing.T) {
+	primary, secondary := newCompositeTestBackends(t)
+	def := RepositoryDefinitionMap{"name": "users", "customId": true}
+	repo, err := NewCompositeBackend(primary, secondary, DualWriteRequired).DefineRepository("users", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryUsers, err := primary.GetRepository("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondaryUsers, err := secondary.GetRepository("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := repo.Save(&map[string]interface{}{"name": "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the secondary does not have the record saved only to the primary
+	onlyPrimary, err := primaryUsers.Save(&map[string]interface{}{"name": "Mary"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range []interface{}{saved, onlyPrimary} {
+		id := (*record.(*map[string]interface{}))["id"]
+		previous, err := repo.ReplaceOne(NewFilter().Match("id", id), &map[string]interface{}{"name": "Jane"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (*previous.(*map[string]interface{}))["name"] != (*record.(*map[string]interface{}))["name"] {
+			t.Fatal("Expected the previous record of the primary. Got: ", previous)
+		}
+
+		mirrored, err := secondaryUsers.GetOne(NewFilter().Match("id", id), &map[string]interface{}{})
+		if err != nil {
+			t.Fatal("Expected the replacement mirrored to the secondary with the same ID. Got: ", err)
+		}
+		if name := (*mirrored.(*map[string]interface{}))["name"]; name != "Jane" {
+			t.Fatal("Expected the secondary to have the replacement, not the previous record. Got: ", name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package backends
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// ErrConnectionFailed is the error class for the failures to connect to the database. The
+// builders of the custom backends should return it when the database is not reachable, so the
+// build is retried by the connect retry policy of the manager.
+var ErrConnectionFailed = ErrorClass("connection failed")
+
+// IsErrConnectionFailed check of the error is of the ErrConnectionFailed class.
+func IsErrConnectionFailed(err error) bool {
+	return IsErrorOfType(err, ErrConnectionFailed(""))
+}
+
+// ConnectRetryPolicy is the policy of retrying the build of a backend that failed to connect to
+// the database, like when the database container is not ready yet when the service starts. Only
+// the connection errors are retried: the errors of the ErrConnectionFailed class, the network
+// errors and the "no reachable servers" error of the MongoDB driver. The configuration errors,
+// like a missing property, fail the build on the first attempt.
+type ConnectRetryPolicy struct {
+	// Attempts is the maximal number of builds, including the first one. Less than 2 means the
+	// build is not retried.
+	Attempts int
+	// Backoff is the wait before the first retry. The wait is doubled for each next retry.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between the retries. Zero means no cap.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the wait, between 0 and 1, that is random, so the instances of a
+	// service started together do not retry at the same time. A wait of 1s with a jitter of 0.2
+	// is between 0.8s and 1s.
+	Jitter float64
+}
+
+// wait returns the wait before the retry (counted from 1), with the jitter applied.
+func (p ConnectRetryPolicy) wait(retry int) time.Duration {
+	return backoffWait(p.Backoff, p.MaxBackoff, p.Jitter, retry)
+}
+
+// backoffWait returns the wait before the retry (counted from 1): the backoff doubled for each
+// next retry, capped by maxBackoff (if not zero), with the jitter fraction of it random.
+func backoffWait(backoff time.Duration, maxBackoff time.Duration, jitter float64, retry int) time.Duration {
+	wait := backoff
+	for i := 1; i < retry; i++ {
+		wait *= 2
+		if maxBackoff > 0 && wait >= maxBackoff {
+			break
+		}
+	}
+	if maxBackoff > 0 && wait > maxBackoff {
+		wait = maxBackoff
+	}
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		wait -= time.Duration(rand.Float64() * jitter * float64(wait))
+	}
+	return wait
+}
+
+// WithConnectRetry sets the policy of retrying the builds of the backends that fail to connect
+// to the database. By default the builds are not retried.
+func (m *DefaultBackendManager) WithConnectRetry(policy ConnectRetryPolicy) *DefaultBackendManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.connectRetry = policy
+	return m
+}
+
+// isConnectionError checks if the error of a backend builder is a failure to connect to the
+// database, which may succeed when retried.
+func isConnectionError(err error) bool {
+	if IsErrConnectionFailed(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "no reachable servers")
+}
+
+// buildWithRetry runs the builder, retrying it with the policy while it fails to connect. The
+// secrets of the database configuration are masked in the logged and the returned errors.
+func buildWithRetry(builder BackendBuilder, dbInfo *config.DBInfo, manager BackendManager, policy ConnectRetryPolicy, logger Logger) (Backend, error) {
+	for attempt := 1; ; attempt++ {
+		backend, err := builder(dbInfo, manager)
+		// the errors of the custom builders may have the password of the connection string
+		err = maskError(err, dbInfo)
+		if err == nil || attempt >= policy.Attempts || !isConnectionError(err) {
+			return backend, err
+		}
+		wait := policy.wait(attempt)
+		logger.Errorf("Failed to connect to the database (attempt %d of %d), retrying in %s: %s", attempt, policy.Attempts, wait, err.Error())
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestConnectRetry(t *testing.T) {
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"flaky":  &config.DBInfo{},
+		"broken": &config.DBInfo{},
+	}).(*DefaultBackendManager).WithConnectRetry(ConnectRetryPolicy{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+		Jitter:   0.5,
+	})
+
+	flakyBuilds, failures := 0, 2
+	manager.SupportBackend("flaky", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		flakyBuilds++
+		if flakyBuilds <= failures {
+			return nil, ErrConnectionFailed("connection refused")
+		}
+		return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {}), nil
+	}, map[string]interface{}{})
+	brokenBuilds := 0
+	manager.SupportBackend("broken", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		brokenBuilds++
+		return nil, fmt.Errorf("the host is missing")
+	}, map[string]interface{}{})
+
+	if _, err := manager.GetBackend("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if flakyBuilds != 3 {
+		t.Fatal("Expected the backend to be built on the third attempt. Got builds: ", flakyBuilds)
+	}
+
+	if _, err := manager.GetBackend("broken"); err == nil {
+		t.Fatal("Expected the error of the builder")
+	}
+	if brokenBuilds != 1 {
+		t.Fatal("Expected the configuration error not to be retried. Got builds: ", brokenBuilds)
+	}
+
+	flakyBuilds, failures = 0, 10
+	manager.ShutdownAll(context.Background())
+	if _, err := manager.GetBackend("flaky"); !IsErrConnectionFailed(err) {
+		t.Fatal("Expected the connection error after the last attempt. Got: ", err)
+	}
+	if flakyBuilds != 3 {
+		t.Fatal("Expected the build to be attempted 3 times. Got builds: ", flakyBuilds)
+	}
+}
+
+func TestConnectRetryWait(t *testing.T) {
+	policy := ConnectRetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for retry, expected := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 300 * time.Millisecond,
+		9: 300 * time.Millisecond,
+	} {
+		if wait := policy.wait(retry); wait != expected {
+			t.Errorf("Expected the wait %s before the retry %d. Got: %s", expected, retry, wait)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 20; i++ {
+		if wait := policy.wait(1); wait < 80*time.Millisecond || wait > 100*time.Millisecond {
+			t.Fatal("Expected the wait with the jitter between 80ms and 100ms. Got: ", wait)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+)
+
+// ContextKey is the type of the keys of the request-scoped values that the backends attach
+// to the backend calls of a repository derived with Repository.WithContext.
+type ContextKey string
+
+// RequestIDKey is the context key of the ID of the request that originated the repository operation.
+const RequestIDKey ContextKey = "request_id"
+
+// WithRequestID returns a copy of the context with the request ID set.
+// For example:
+// 		repo := userRepo.WithContext(backends.WithRequestID(ctx, requestID))
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, RequestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID set in the context, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	requestID, ok := ctx.Value(RequestIDKey).(string)
+	return requestID, ok && requestID != ""
+}
+
+// backendContext is the context of the operations of a repository defined on a backend. The
+// values of the context the repository is bound to take precedence; the other values are looked
+// up in the backend context when they are read, so the values set with Backend.SetInContext
+// after the repository was defined are visible to its operations.
+type backendContext struct {
+	context.Context
+	backend Backend
+}
+
+// Value returns the value of the bound context for the key, or else the current value of the
+// backend context.
+func (c backendContext) Value(key interface{}) interface{} {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	if name, ok := key.(string); ok {
+		return c.backend.GetFromContext(name)
+	}
+	return nil
+}
+
+// withBackendContext returns the context of the operations of a repository bound to the given
+// context (nil if not bound) and defined on the backend (nil if not defined on a backend).
+func withBackendContext(ctx context.Context, backend Backend) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if backend == nil {
+		return ctx
+	}
+	return backendContext{Context: ctx, backend: backend}
+}
+
+// queryComment returns the comment to attach to the queries executed with the context,
+// like "request_id:0001". Returns empty string if there are no values to attach.
+func queryComment(ctx context.Context) string {
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		return fmt.Sprintf("%s:%s", RequestIDKey, requestID)
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package backends
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+	"github.com/guregu/dynamo"
+)
+
+func TestQueryComment(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req-0001")
+
+	if comment := queryComment(ctx); comment != "request_id:req-0001" {
+		t.Errorf("Expected the request ID in the comment, got %q", comment)
+	}
+	if comment := queryComment(context.Background()); comment != "" {
+		t.Errorf("Expected no comment without request ID, got %q", comment)
+	}
+	if comment := queryComment(nil); comment != "" {
+		t.Errorf("Expected no comment without context, got %q", comment)
+	}
+}
+
+func TestDynamoWithContext(t *testing.T) {
+	table := &DynamoCollection{
+		Table:                &dynamo.Table{},
+		RepositoryDefinition: &collectionInfo,
+	}
+
+	ctx := WithRequestID(context.Background(), "req-0001")
+	scoped := table.WithContext(ctx).(*DynamoCollection)
+
+	if requestID, _ := RequestIDFromContext(scoped.requestContext()); requestID != "req-0001" {
+		t.Errorf("Expected the requests to be made with the request ID, got %q", requestID)
+	}
+	if _, ok := RequestIDFromContext(table.requestContext()); ok {
+		t.Errorf("Expected the original table not to be bound to the context")
+	}
+}
+
+func TestMemoryWithContext(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	scoped := repo.WithContext(WithRequestID(context.Background(), "req-0001"))
+	if requestID, _ := RequestIDFromContext(scoped.(*MemoryCollection).Context()); requestID != "req-0001" {
+		t.Errorf("Expected the collection to be bound to the context, got %q", requestID)
+	}
+
+	if _, err := scoped.Save(&memoryTestEntry{Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := repo.Count(NewFilter()); count != 1 {
+		t.Errorf("Expected the scoped collection to share the records, got count %d", count)
+	}
+}
+
+type recordingTestLogger struct {
+	messages []string
+}
+
+func (l *recordingTestLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, format)
+}
+
+func (l *recordingTestLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, format)
+}
+
+func TestBackendContextPropagation(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := repo.WithContext(WithRequestID(context.Background(), "req-0001")).(*MemoryCollection)
+
+	backend.SetInContext("tenant", "acme")
+	for _, collection := range []*MemoryCollection{repo.(*MemoryCollection), scoped} {
+		if tenant := collection.Context().Value("tenant"); tenant != "acme" {
+			t.Fatal("Expected the value set on the backend after the repository was defined. Got: ", tenant)
+		}
+	}
+	if requestID, _ := RequestIDFromContext(scoped.Context()); requestID != "req-0001" {
+		t.Fatal("Expected the values of the bound context to be kept. Got: ", requestID)
+	}
+
+	backend.SetInContext("tenant", "globex")
+	if tenant := repo.(*MemoryCollection).Context().Value("tenant"); tenant != "globex" {
+		t.Fatal("Expected the latest value of the backend. Got: ", tenant)
+	}
+
+	logger := &recordingTestLogger{}
+	backend.Configure(WithLogger(logger))
+	if _, err := repo.GetAll(NewFilter(), &memoryTestEntry{}, "", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.messages) == 0 {
+		t.Fatal("Expected the repository to log to the logger set after it was defined")
+	}
+
+	if keys := backend.ContextKeys(); !reflect.DeepEqual(keys, []string{"tenant", LOGGER_CTX_KEY}) {
+		t.Fatal("Expected the keys in the order they were set. Got: ", keys)
+	}
+}
@@ -0,0 +1,253 @@
+package backends
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// cursorTokenVersion is the version of the format of the resume tokens of the cursors.
+const cursorTokenVersion = 1
+
+// defaultCursorBatchSize is the number of records read per request by a cursor by default.
+const defaultCursorBatchSize = 100
+
+// CursorOpts are the options of a Cursor.
+type CursorOpts struct {
+	// Key is the property that the records are iterated in the order of. It must be unique and
+	// must not change, like the ID, and its values must be strings or numbers. Defaults to "id".
+	Key string
+	// BatchSize is the number of records read per request. Defaults to 100.
+	BatchSize int
+	// Unsorted must be set for the repositories whose GetAll does not return the records in the
+	// requested order, like the DynamoDB ones (see BackendCapabilities.Sorting). The cursor then
+	// reads all the remaining records at once and sorts them itself.
+	Unsorted bool
+}
+
+// Cursor iterates over the records matching a filter in the order of a unique key, reading them
+// in batches. After each record, the position of the cursor can be saved as a resume token, so a
+// worker restarted later continues where it stopped:
+// 		cursor := backends.NewCursor(jobRepo, filter, &Job{}, backends.CursorOpts{})
+// 		if err := cursor.ResumeFrom(savedToken); err != nil {
+// 			return err
+// 		}
+// 		for cursor.Next() {
+// 			job := cursor.Record().(*Job)
+// 			...
+// 			savedToken = cursor.Token()
+// 		}
+// 		if err := cursor.Err(); err != nil {
+// 			return err
+// 		}
+//
+// Each batch reads the records with a key greater than the key of the last record read, so the
+// records inserted or deleted during the iteration do not shift the position, and a resumed
+// cursor neither skips nor repeats records. The records inserted with a key lower than the
+// position are not seen.
+type Cursor struct {
+	repo            Repository
+	filter          Filter
+	resultsTypeHint interface{}
+	opts            CursorOpts
+
+	records   []interface{}
+	keys      []interface{}
+	position  int
+	exhausted bool
+
+	current interface{}
+	last    interface{}
+	started bool
+	err     error
+}
+
+// NewCursor creates a cursor over the records of the repository matching the filter, decoded into
+// the type of the results hint.
+func NewCursor(repo Repository, filter Filter, resultsTypeHint interface{}, opts CursorOpts) *Cursor {
+	if opts.Key == "" {
+		opts.Key = "id"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultCursorBatchSize
+	}
+	return &Cursor{
+		repo:            repo,
+		filter:          copyFilter(filter),
+		resultsTypeHint: resultsTypeHint,
+		opts:            opts,
+	}
+}
+
+// Next advances the cursor to the next record, reading the next batch when needed. It returns
+// false when there are no more records or the read failed, see Err.
+func (c *Cursor) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	if c.position >= len(c.records) {
+		if c.exhausted {
+			return false
+		}
+		if c.err = c.readBatch(); c.err != nil || len(c.records) == 0 {
+			return false
+		}
+	}
+	c.current = c.records[c.position]
+	c.last = c.keys[c.position]
+	c.started = true
+	c.position++
+	return true
+}
+
+// Record returns the current record, a pointer to the type of the results hint.
+func (c *Cursor) Record() interface{} {
+	return c.current
+}
+
+// Err returns the error of the last read, if it failed.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// readBatch reads the next batch of the records, after the key of the last record.
+func (c *Cursor) readBatch() error {
+	filter := copyFilter(c.filter)
+	if filter == nil {
+		filter = Filter{}
+	}
+	if c.started {
+		filter = filter.Gt(c.opts.Key, c.last)
+	}
+
+	limit := c.opts.BatchSize
+	if c.opts.Unsorted {
+		limit = 0
+	}
+	results, err := c.repo.GetAll(filter, c.resultsTypeHint, c.opts.Key, Ascending.String(), limit, 0)
+	if err != nil && !IsErrNotFound(err) {
+		return err
+	}
+
+	c.records, c.keys, c.position = []interface{}{}, []interface{}{}, 0
+	if results != nil {
+		slice := reflect.Indirect(reflect.ValueOf(results))
+		for i := 0; i < slice.Len(); i++ {
+			record := slice.Index(i).Interface()
+			key, err := c.recordKey(record)
+			if err != nil {
+				return err
+			}
+			c.records = append(c.records, record)
+			c.keys = append(c.keys, key)
+		}
+	}
+
+	if c.opts.Unsorted {
+		sort.Sort(cursorBatch{c})
+		c.exhausted = true
+	} else {
+		c.exhausted = len(c.records) < limit
+	}
+	return nil
+}
+
+// recordKey returns the value of the key of the record, as decoded from JSON.
+func (c *Cursor) recordKey(record interface{}) (interface{}, error) {
+	m, err := InterfaceToMap(record)
+	if err != nil {
+		return nil, err
+	}
+	switch key := (*m)[c.opts.Key].(type) {
+	case string, float64:
+		return key, nil
+	case nil:
+		return nil, ErrInvalidInput(fmt.Sprintf("the record has no value of the key %s of the cursor", c.opts.Key))
+	default:
+		return nil, ErrInvalidInput(fmt.Sprintf("the key %s of the cursor must be a string or a number, got %T", c.opts.Key, key))
+	}
+}
+
+// cursorBatch sorts the batch of a cursor by the keys of the records.
+type cursorBatch struct {
+	cursor *Cursor
+}
+
+func (b cursorBatch) Len() int {
+	return len(b.cursor.records)
+}
+
+func (b cursorBatch) Less(i, j int) bool {
+	return compareValues(b.cursor.keys[i], b.cursor.keys[j]) < 0
+}
+
+func (b cursorBatch) Swap(i, j int) {
+	b.cursor.records[i], b.cursor.records[j] = b.cursor.records[j], b.cursor.records[i]
+	b.cursor.keys[i], b.cursor.keys[j] = b.cursor.keys[j], b.cursor.keys[i]
+}
+
+// cursorToken is the content of a resume token.
+type cursorToken struct {
+	Version int         `json:"v"`
+	Key     string      `json:"k"`
+	Filter  string      `json:"f"`
+	Started bool        `json:"s,omitempty"`
+	After   interface{} `json:"a,omitempty"`
+}
+
+// Token returns the resume token of the position of the cursor, after the current record. The
+// token is an opaque string, safe to store and to use in URLs. It encodes the key of the cursor,
+// the value of the key of the current record and a fingerprint of the filter, so it stays valid
+// across the restarts of the process and the changes of the other fields of the records, but not
+// across a change of the key or of its values, nor of the format of the tokens of a future
+// version of this package.
+func (c *Cursor) Token() string {
+	data, _ := json.Marshal(cursorToken{
+		Version: cursorTokenVersion,
+		Key:     c.opts.Key,
+		Filter:  filterFingerprint(c.filter),
+		Started: c.started,
+		After:   c.last,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ResumeFrom moves the cursor to the position of the resume token, so Next returns the record
+// after the one the token was taken at. It must be called before the first Next. A token of a
+// cursor with a different key or filter is ErrInvalidInput.
+func (c *Cursor) ResumeFrom(token string) error {
+	if c.started || c.records != nil {
+		return ErrInvalidInput("the cursor can be resumed only before the iteration")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrInvalidInput("malformed resume token")
+	}
+	var decoded cursorToken
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return ErrInvalidInput("malformed resume token")
+	}
+	if decoded.Version != cursorTokenVersion {
+		return ErrInvalidInput(fmt.Sprintf("unsupported version %d of the resume token", decoded.Version))
+	}
+	if decoded.Key != c.opts.Key || decoded.Filter != filterFingerprint(c.filter) {
+		return ErrInvalidInput("the resume token is of a cursor with a different key or filter")
+	}
+	c.started = decoded.Started
+	c.last = decoded.After
+	return nil
+}
+
+// filterFingerprint returns a short hash of the JSON encoding of the filter.
+func filterFingerprint(filter Filter) string {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%v", filter))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
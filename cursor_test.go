@@ -0,0 +1,61 @@
+package backends
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCursorResumeFrom(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for i := 0; i < 25; i++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: fmt.Sprintf("user-%d", i), Age: 30}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, unsorted := range []bool{false, true} {
+		filter := NewFilter().Match("age", 30)
+		opts := CursorOpts{BatchSize: 4, Unsorted: unsorted}
+		seen := map[string]bool{}
+		ids := []string{}
+
+		cursor := NewCursor(repo, filter, &memoryTestEntry{}, opts)
+		for len(ids) < 10 && cursor.Next() {
+			ids = append(ids, cursor.Record().(*memoryTestEntry).ID)
+		}
+		token := cursor.Token()
+
+		// a fresh cursor, like the one of a restarted worker
+		resumed := NewCursor(repo, NewFilter().Match("age", 30), &memoryTestEntry{}, opts)
+		if err := resumed.ResumeFrom(token); err != nil {
+			t.Fatal(err)
+		}
+		for resumed.Next() {
+			ids = append(ids, resumed.Record().(*memoryTestEntry).ID)
+		}
+		if err := resumed.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("Expected no duplicates (unsorted: %v). Got %s twice", unsorted, id)
+			}
+			seen[id] = true
+			if i > 0 && ids[i-1] >= id {
+				t.Fatalf("Expected the records in the order of the IDs (unsorted: %v). Got: %v", unsorted, ids)
+			}
+		}
+		if len(ids) != 25 {
+			t.Fatalf("Expected all the 25 records without gaps (unsorted: %v). Got: %d", unsorted, len(ids))
+		}
+	}
+
+	cursor := NewCursor(repo, NewFilter().Match("age", 31), &memoryTestEntry{}, CursorOpts{})
+	if err := cursor.ResumeFrom(NewCursor(repo, NewFilter(), &memoryTestEntry{}, CursorOpts{}).Token()); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the token of a cursor with a different filter to be refused. Got: ", err)
+	}
+	if err := cursor.ResumeFrom("not a token"); !IsErrInvalidInput(err) {
+		t.Fatal("Expected a malformed token to be refused. Got: ", err)
+	}
+}
@@ -0,0 +1,129 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Decimal is an exact decimal number, like an amount of money, in its string form ("10.50").
+// Unlike float64 it keeps all the digits, and unlike a plain string it compares as a number,
+// so the comparison filters work on the decimal properties:
+// 		filter := backends.NewFilter().Between("price", backends.Decimal("9.90"), backends.Decimal("10.10"))
+// The backends store the Decimal values as numbers: MongoDB as Decimal128 and DynamoDB as a
+// number (N), so declare the decimal properties of the records as Decimal. The in-memory backend
+// keeps them in their JSON form, a string, and compares them as numbers with the Decimal values
+// of the exact matches and the comparisons (Gt, Gte, Lt, Lte, Between) of the filter.
+type Decimal string
+
+// rat parses the decimal. The fractions that big.Rat accepts, like "1/3", are not decimals.
+func (d Decimal) rat() (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(string(d))
+	if !ok || strings.Contains(string(d), "/") {
+		return nil, fmt.Errorf("%q is not a decimal number", string(d))
+	}
+	return value, nil
+}
+
+// Cmp compares the decimals as numbers. It returns -1 if d is less than other, 0 if they are
+// equal (like "10.5" and "10.50") and 1 if d is greater. An invalid decimal is ErrInvalidInput.
+func (d Decimal) Cmp(other Decimal) (int, error) {
+	a, err := d.rat()
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+	b, err := other.rat()
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+	return a.Cmp(b), nil
+}
+
+// GetBSON stores the decimal in MongoDB as Decimal128.
+func (d Decimal) GetBSON() (interface{}, error) {
+	return bson.ParseDecimal128(string(d))
+}
+
+// SetBSON reads the decimal from a Decimal128, a number or a string.
+func (d *Decimal) SetBSON(raw bson.Raw) error {
+	var value interface{}
+	if err := raw.Unmarshal(&value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bson.Decimal128:
+		*d = Decimal(v.String())
+	case string:
+		*d = Decimal(v)
+	case float64, int, int64:
+		*d = Decimal(fmt.Sprint(v))
+	default:
+		return fmt.Errorf("cannot read %T as a decimal", value)
+	}
+	return nil
+}
+
+// MarshalDynamo stores the decimal in DynamoDB as a number.
+func (d Decimal) MarshalDynamo() (*dynamodb.AttributeValue, error) {
+	if _, err := d.rat(); err != nil {
+		return nil, err
+	}
+	return &dynamodb.AttributeValue{N: aws.String(string(d))}, nil
+}
+
+// UnmarshalDynamo reads the decimal from a number or a string.
+func (d *Decimal) UnmarshalDynamo(av *dynamodb.AttributeValue) error {
+	switch {
+	case av.N != nil:
+		*d = Decimal(*av.N)
+	case av.S != nil:
+		*d = Decimal(*av.S)
+	default:
+		return fmt.Errorf("cannot read the attribute as a decimal")
+	}
+	return nil
+}
+
+// UnmarshalJSON reads the decimal from a JSON string or number. The decimals are written to
+// JSON as strings, so no digits are lost.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*d = Decimal(str)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*d = Decimal(number.String())
+	return nil
+}
+
+// compareDecimal compares the record value, a decimal string or a number, with the decimal.
+// It returns false if the record value is not a number.
+func compareDecimal(recordValue interface{}, d Decimal) (int, bool, error) {
+	expected, err := d.rat()
+	if err != nil {
+		return 0, false, err
+	}
+	var value Decimal
+	switch v := recordValue.(type) {
+	case string:
+		value = Decimal(v)
+	case float64:
+		value = Decimal(fmt.Sprint(v))
+	default:
+		return 0, false, nil
+	}
+	actual, err := value.rat()
+	if err != nil {
+		return 0, false, nil
+	}
+	return actual.Cmp(expected), true, nil
+}
@@ -0,0 +1,75 @@
+package backends
+
+import (
+	"testing"
+)
+
+type decimalTestProduct struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Price Decimal `json:"price"`
+}
+
+func TestDecimalCmp(t *testing.T) {
+	if cmp, err := Decimal("9.90").Cmp(Decimal("10.10")); err != nil || cmp != -1 {
+		t.Fatal("Expected 9.90 to be less than 10.10. Got: ", cmp, err)
+	}
+	if cmp, err := Decimal("10.5").Cmp(Decimal("10.50")); err != nil || cmp != 0 {
+		t.Fatal("Expected 10.5 to equal 10.50. Got: ", cmp, err)
+	}
+	for _, invalid := range []Decimal{"ten", "1/3", ""} {
+		if _, err := invalid.Cmp(Decimal("1")); !IsErrInvalidInput(err) {
+			t.Errorf("Expected an error for %q. Got: %v", invalid, err)
+		}
+	}
+
+	av, err := Decimal("10.50").MarshalDynamo()
+	if err != nil || av.N == nil || *av.N != "10.50" {
+		t.Fatal("Expected the decimal to be a DynamoDB number. Got: ", av, err)
+	}
+}
+
+func TestMemoryDecimalFilters(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "products"})
+
+	for name, price := range map[string]Decimal{"tea": "9.90", "coffee": "10.10", "cake": "100.00"} {
+		if _, err := repo.Save(&decimalTestProduct{Name: name, Price: price}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(filter Filter) []string {
+		results, err := repo.GetAll(filter, &decimalTestProduct{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, product := range *results.(*[]*decimalTestProduct) {
+			names = append(names, product.Name)
+		}
+		return names
+	}
+
+	// compared as strings, "9.90" and "100.00" are both greater than "10.10"
+	if found := names(NewFilter().Lt("price", Decimal("10.10"))); !strArrEq(found, []string{"tea"}) {
+		t.Fatal("Expected the prices to compare as numbers. Got: ", found)
+	}
+	if found := names(NewFilter().Gt("price", Decimal("9.95"))); !strArrEq(found, []string{"cake", "coffee"}) {
+		t.Fatal("Expected the prices to compare as numbers. Got: ", found)
+	}
+	if found := names(NewFilter().Between("price", Decimal("9.9"), Decimal("10.1"))); !strArrEq(found, []string{"coffee", "tea"}) {
+		t.Fatal("Expected the range to include the equal prices. Got: ", found)
+	}
+	if found := names(NewFilter().Match("price", Decimal("100"))); !strArrEq(found, []string{"cake"}) {
+		t.Fatal("Expected the exact match to compare as numbers. Got: ", found)
+	}
+
+	product := &decimalTestProduct{}
+	if _, err := repo.GetOne(NewFilter().Match("name", "coffee"), product); err != nil || product.Price != "10.10" {
+		t.Fatal("Expected the price to be read back with all the digits. Got: ", product.Price, err)
+	}
+
+	if _, err := repo.Count(NewFilter().Gt("price", Decimal("cheap"))); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the invalid decimal. Got: ", err)
+	}
+}
@@ -0,0 +1,285 @@
+package backends
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefinitionFromStruct builds the definition of the repository of the records of the struct (or
+// pointer to struct) from the "backends" tags of its fields, so the schema is kept next to the
+// model:
+// 		type User struct {
+// 			_         struct{}  `backends:"users"`
+// 			ID        string    `json:"id" backends:",id"`
+// 			Email     string    `json:"email" backends:",unique"`
+// 			FirstName string    `json:"firstName" backends:",index=byName"`
+// 			LastName  string    `json:"lastName" backends:",index=byName"`
+// 			CreatedAt time.Time `json:"createdAt" backends:",ttl=24h"`
+// 		}
+// 		def, err := backends.DefinitionFromStruct(User{})
+// 		userRepo, err := backend.DefineRepository("users", def)
+//
+// The tag of the blank field "_" names the repository, and may add the "customId" option for the
+// IDs set by the application. The tags of the other fields are the property name, like for
+// FilterFromStruct (empty for the name of the "json" tag or of the field), followed by the
+// options:
+//
+// - id: the ID field (and the DynamoDB hash key) of the records, "id" by default
+//
+// - index: an index of the field, named after it; index=<name> adds the field to the named
+// index, so the fields with the same index name make one compound index, in the order of the
+// fields
+//
+// - unique and unique=<name>: like index, for a unique index
+//
+// The index options can be repeated with different names, like `backends:",index=byName,unique=byEmail"`,
+// to add the field to more than one index.
+//
+// - ttl=<duration>: the TTL field, whose records expire after the duration, like "24h" or the
+// number of seconds; it must be a time.Time or a number
+//
+// The fields tagged with "-" and the unexported fields are skipped, and the fields of the embedded
+// structs are read as the fields of the outer struct. All the properties are declared as the
+// fields of the definition ("fields"). It fails with ErrInvalidInput if the repository is not
+// named, an option is unknown or repeated, more than one field is the ID or the TTL field, two
+// fields have the same property name, or an index is both unique and not.
+func DefinitionFromStruct(v interface{}) (RepositoryDefinition, error) {
+	valueType := reflect.TypeOf(v)
+	for valueType != nil && valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	if valueType == nil || valueType.Kind() != reflect.Struct {
+		return nil, ErrInvalidInput("a definition can be built only from a struct or pointer to struct")
+	}
+
+	builder := &structDefinition{def: RepositoryDefinitionMap{}, unique: map[string]bool{}, declared: map[string]bool{}}
+	if err := builder.addFields(valueType); err != nil {
+		return nil, err
+	}
+	return builder.build()
+}
+
+// structDefinition collects the definition of DefinitionFromStruct from the fields of the struct.
+type structDefinition struct {
+	def        RepositoryDefinitionMap
+	fields     []string
+	declared   map[string]bool
+	idField    string
+	ttlField   string
+	ttl        int
+	indexNames []string
+	indexes    map[string][]string
+	unique     map[string]bool
+}
+
+// addFields adds the fields of the struct type, and of its embedded structs.
+func (d *structDefinition) addFields(structType reflect.Type) error {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("backends")
+		if field.Name == "_" {
+			if err := d.setRepository(tag); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, tagged := structFieldTag(field)
+		if name == "-" || tag == "-" {
+			continue
+		}
+		if field.Anonymous && !tagged {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := d.addFields(embedded); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		if d.declared[name] {
+			return ErrInvalidInput(fmt.Sprintf("more than one field has the property name %s", name))
+		}
+		d.declared[name] = true
+		d.fields = append(d.fields, name)
+
+		if err := d.addOptions(field, name, tagOptions(tag)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagOptions returns the options of the "backends" tag, which follow the property name.
+func tagOptions(tag string) []string {
+	parts := strings.Split(tag, ",")
+	options := []string{}
+	for _, option := range parts[1:] {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// setRepository sets the name and the options of the repository from the tag of the blank field.
+func (d *structDefinition) setRepository(tag string) error {
+	if _, ok := d.def["name"]; ok {
+		return ErrInvalidInput("more than one field names the repository")
+	}
+	name := strings.TrimSpace(strings.Split(tag, ",")[0])
+	if name == "" {
+		return ErrInvalidInput("the repository name is empty")
+	}
+	d.def["name"] = name
+	for _, option := range tagOptions(tag) {
+		if option != "customId" {
+			return ErrInvalidInput(fmt.Sprintf("unknown repository option %q", option))
+		}
+		if _, ok := d.def["customId"]; ok {
+			return ErrInvalidInput("the repository option customId is repeated")
+		}
+		d.def["customId"] = true
+	}
+	return nil
+}
+
+// addOptions applies the options of the tag of the field with the property name.
+func (d *structDefinition) addOptions(field reflect.StructField, name string, options []string) error {
+	seen := map[string]bool{}
+	for _, option := range options {
+		key, value := option, ""
+		if separator := strings.Index(option, "="); separator >= 0 {
+			key, value = option[:separator], option[separator+1:]
+		}
+		seenKey := key
+		if key == "index" || key == "unique" {
+			if value == "" {
+				value = indexNameFromFields(name)
+			}
+			seenKey = key + "=" + value
+		}
+		if seen[seenKey] {
+			return ErrInvalidInput(fmt.Sprintf("the option %s of %s is repeated", seenKey, name))
+		}
+		seen[seenKey] = true
+
+		switch key {
+		case "id":
+			if value != "" {
+				return ErrInvalidInput(fmt.Sprintf("the option id of %s takes no value", name))
+			}
+			if d.idField != "" {
+				return ErrInvalidInput(fmt.Sprintf("both %s and %s are the ID field", d.idField, name))
+			}
+			d.idField = name
+		case "index", "unique":
+			if err := d.addIndex(value, key == "unique", name); err != nil {
+				return err
+			}
+		case "ttl":
+			if d.ttlField != "" {
+				return ErrInvalidInput(fmt.Sprintf("both %s and %s are the TTL field", d.ttlField, name))
+			}
+			if !isTTLType(field.Type) {
+				return ErrInvalidInput(fmt.Sprintf("the TTL field %s must be a time or a number, got %s", name, field.Type))
+			}
+			ttl, err := parseTTL(value)
+			if err != nil {
+				return ErrInvalidInput(fmt.Sprintf("invalid TTL %q of %s", value, name))
+			}
+			d.ttlField, d.ttl = name, ttl
+		default:
+			return ErrInvalidInput(fmt.Sprintf("unknown option %q of %s", option, name))
+		}
+	}
+	return nil
+}
+
+// addIndex adds the field to the index with the name.
+func (d *structDefinition) addIndex(indexName string, unique bool, field string) error {
+	if d.indexes == nil {
+		d.indexes = map[string][]string{}
+	}
+	fields, ok := d.indexes[indexName]
+	if !ok {
+		d.indexNames = append(d.indexNames, indexName)
+		d.unique[indexName] = unique
+	} else if d.unique[indexName] != unique {
+		return ErrInvalidInput(fmt.Sprintf("the index %s is both unique and not", indexName))
+	}
+	d.indexes[indexName] = append(fields, field)
+	return nil
+}
+
+// isTTLType checks if the type can hold the expiry of the records: a time or a number.
+func isTTLType(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseTTL parses the TTL as a duration, like "24h", or as the number of seconds.
+func parseTTL(value string) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("the TTL must be positive")
+		}
+		return seconds, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < time.Second {
+		return 0, fmt.Errorf("the TTL must be at least a second")
+	}
+	return int(duration / time.Second), nil
+}
+
+// build returns the definition of the collected fields.
+func (d *structDefinition) build() (RepositoryDefinition, error) {
+	if _, ok := d.def["name"]; !ok {
+		return nil, ErrInvalidInput("the repository is not named, tag the blank field _ with its name")
+	}
+
+	if d.idField != "" {
+		d.def["idField"] = d.idField
+		d.def["hashKey"] = d.idField
+	}
+	if d.ttlField != "" {
+		d.def["enableTtl"] = true
+		d.def["ttlAttribute"] = d.ttlField
+		d.def["ttl"] = d.ttl
+	}
+
+	indexes := []Index{}
+	for _, name := range d.indexNames {
+		indexes = append(indexes, NewIndex(name, d.unique[name], d.indexes[name]...))
+	}
+	d.def["indexes"] = indexes
+	d.def["fields"] = d.fields
+	return d.def, nil
+}
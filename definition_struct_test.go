@@ -0,0 +1,154 @@
+package backends
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+type structDefinitionBase struct {
+	CreatedAt time.Time `json:"createdAt" backends:",ttl=24h"`
+}
+
+type structDefinitionUser struct {
+	_ struct{} `backends:"users,customId"`
+	structDefinitionBase
+	UserID    string `json:"userId" backends:",id"`
+	Email     string `json:"email" backends:",unique"`
+	FirstName string `json:"firstName" backends:",index=byName"`
+	LastName  string `json:"lastName" backends:",index=byName"`
+	Role      string `backends:"role,index"`
+	Password  string `json:"-"`
+	internal  string
+}
+
+func TestDefinitionFromStruct(t *testing.T) {
+	def, err := DefinitionFromStruct(&structDefinitionUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.GetName() != "users" || !def.IsCustomID() {
+		t.Fatalf("Expected the users repository with custom IDs. Got: %v", def)
+	}
+	if def.GetIDField() != "userId" || def.GetHashKey() != "userId" {
+		t.Fatalf("Expected userId to be the ID field and the hash key. Got: %s, %s", def.GetIDField(), def.GetHashKey())
+	}
+	if !def.EnableTTL() || def.GetTTLAttribute() != "createdAt" || def.GetTTL() != 86400 {
+		t.Fatalf("Expected the TTL of a day on createdAt. Got: %v", def)
+	}
+
+	expected := []struct {
+		name   string
+		unique bool
+		fields []string
+	}{
+		{"email", true, []string{"email"}},
+		{"byName", false, []string{"firstName", "lastName"}},
+		{"role", false, []string{"role"}},
+	}
+	indexes := def.GetIndexes()
+	if len(indexes) != len(expected) {
+		t.Fatalf("Expected %d indexes. Got: %v", len(expected), indexes)
+	}
+	for i, index := range indexes {
+		if index.GetName() != expected[i].name || index.Unique() != expected[i].unique || !reflect.DeepEqual(index.GetFields(), expected[i].fields) {
+			t.Fatalf("Expected the index %+v. Got: %s %v %v", expected[i], index.GetName(), index.Unique(), index.GetFields())
+		}
+	}
+
+	fields := []string{"createdAt", "userId", "email", "firstName", "lastName", "role"}
+	if !reflect.DeepEqual(def.GetFields(), fields) {
+		t.Fatalf("Expected the fields %v. Got: %v", fields, def.GetFields())
+	}
+
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	if _, err = backend.DefineRepository("users", def); err != nil {
+		t.Fatal("Expected the definition to be valid. Got: ", err)
+	}
+}
+
+type structDefinitionAccount struct {
+	_        struct{} `backends:"accounts"`
+	Email    string   `json:"email" backends:",index=byName,index=byEmail"`
+	Name     string   `json:"name" backends:",index=byName,unique=byOwner"`
+	TenantID string   `json:"tenantId" backends:",unique=byOwner"`
+}
+
+func TestDefinitionFromStructMultipleIndexes(t *testing.T) {
+	def, err := DefinitionFromStruct(&structDefinitionAccount{})
+	if err != nil {
+		t.Fatal("Expected a field to be allowed in more than one index. Got: ", err)
+	}
+
+	expected := []struct {
+		name   string
+		unique bool
+		fields []string
+	}{
+		{"byName", false, []string{"email", "name"}},
+		{"byEmail", false, []string{"email"}},
+		{"byOwner", true, []string{"name", "tenantId"}},
+	}
+	indexes := def.GetIndexes()
+	if len(indexes) != len(expected) {
+		t.Fatalf("Expected %d indexes. Got: %v", len(expected), indexes)
+	}
+	for i, index := range indexes {
+		if index.GetName() != expected[i].name || index.Unique() != expected[i].unique || !reflect.DeepEqual(index.GetFields(), expected[i].fields) {
+			t.Fatalf("Expected the index %+v. Got: %s %v %v", expected[i], index.GetName(), index.Unique(), index.GetFields())
+		}
+	}
+}
+
+func TestDefinitionFromStructInvalid(t *testing.T) {
+	invalid := map[string]interface{}{
+		"unnamed": struct {
+			ID string `json:"id" backends:",id"`
+		}{},
+		"two IDs": struct {
+			_   struct{} `backends:"users"`
+			ID  string   `json:"id" backends:",id"`
+			Key string   `json:"key" backends:",id"`
+		}{},
+		"repeated option": struct {
+			_     struct{} `backends:"users"`
+			Email string   `json:"email" backends:",index,index"`
+		}{},
+		"unknown option": struct {
+			_     struct{} `backends:"users"`
+			Email string   `json:"email" backends:",sparse"`
+		}{},
+		"repeated index": struct {
+			_     struct{} `backends:"users"`
+			Email string   `json:"email" backends:",unique=byEmail,unique=byEmail"`
+		}{},
+		"ID with a value": struct {
+			_  struct{} `backends:"users"`
+			ID string   `json:"id" backends:",id=key"`
+		}{},
+		"unique and not": struct {
+			_     struct{} `backends:"users"`
+			First string   `json:"first" backends:",index=byName"`
+			Last  string   `json:"last" backends:",unique=byName"`
+		}{},
+		"TTL of a string": struct {
+			_       struct{} `backends:"users"`
+			Expires string   `json:"expires" backends:",ttl=60"`
+		}{},
+		"duplicate property": struct {
+			_     struct{} `backends:"users"`
+			Email string   `json:"email"`
+			Mail  string   `backends:"email"`
+		}{},
+		"not a struct": "users",
+	}
+	for name, v := range invalid {
+		if _, err := DefinitionFromStruct(v); !IsErrInvalidInput(err) {
+			t.Errorf("Expected an error for the %s. Got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeleteLimitExceeded is the error wrapped by DeleteLimitExceededError. It is of the
+// ErrInvalidInput class.
+var ErrDeleteLimitExceeded = ErrInvalidInput("delete limit exceeded")
+
+// DeleteLimitExceededError is returned by the deletes of a DeleteLimitRepository that would delete
+// more records than the limit. Nothing is deleted. Use errors.As to get the count:
+// 		var limitErr backends.DeleteLimitExceededError
+// 		if errors.As(err, &limitErr) {
+// 			fmt.Println(limitErr.Count, limitErr.Limit)
+// 		}
+type DeleteLimitExceededError struct {
+	// Limit is the maximum number of records that a delete may delete.
+	Limit int
+	// Count is the number of records that the delete would delete.
+	Count int
+}
+
+// Error returns the error message.
+func (e DeleteLimitExceededError) Error() string {
+	return fmt.Sprintf("delete limit exceeded: the delete would delete %d records, the limit is %d", e.Count, e.Limit)
+}
+
+// Unwrap returns ErrDeleteLimitExceeded, so errors.Is(err, ErrDeleteLimitExceeded) and
+// IsErrInvalidInput report true for a DeleteLimitExceededError.
+func (e DeleteLimitExceededError) Unwrap() error {
+	return ErrDeleteLimitExceeded
+}
+
+// IsErrDeleteLimitExceeded checks if the error is a DeleteLimitExceededError.
+func IsErrDeleteLimitExceeded(err error) bool {
+	return errors.Is(err, ErrDeleteLimitExceeded)
+}
+
+// unlimitedDeletesKey is the context key of the override of the delete limit.
+type unlimitedDeletesKey struct{}
+
+// AllowUnlimitedDeletes returns a copy of the context that overrides the delete limit of the
+// repositories bound to it with Repository.WithContext:
+// 		err := userRepo.WithContext(backends.AllowUnlimitedDeletes(ctx)).DeleteAll(filter)
+func AllowUnlimitedDeletes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unlimitedDeletesKey{}, true)
+}
+
+// unlimitedDeletes checks if the context overrides the delete limit.
+func unlimitedDeletes(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	unlimited, _ := ctx.Value(unlimitedDeletesKey{}).(bool)
+	return unlimited
+}
+
+// DeleteLimitRepository is a safety cap on the deletes of the wrapped repository: DeleteAll,
+// DeleteAllReturning and the deletes of Bulk refuse to delete more records than the limit with
+// DeleteLimitExceededError, so a buggy filter cannot wipe the whole collection/table. The
+// records matching the filter are counted before the delete, so a delete racing with the inserts
+// of the matching records may still delete a few more records than the limit.
+//
+// The repositories of a backend are wrapped with it when the "deleteLimit" entry of the
+// repository definition is set. Override the limit for a delete with AllowUnlimitedDeletes.
+type DeleteLimitRepository struct {
+	Repository
+	limit     int
+	unlimited bool
+}
+
+// NewDeleteLimitRepository wraps the repository so its deletes delete at most limit records.
+func NewDeleteLimitRepository(repo Repository, limit int) *DeleteLimitRepository {
+	return &DeleteLimitRepository{
+		Repository: repo,
+		limit:      limit,
+	}
+}
+
+// checkLimit counts the records matching the filter of a delete and checks them against the limit.
+func (r *DeleteLimitRepository) checkLimit(filter Filter) error {
+	if r.unlimited {
+		return nil
+	}
+	count, err := r.Repository.Count(filter)
+	if err != nil {
+		return err
+	}
+	if count > r.limit {
+		return DeleteLimitExceededError{Limit: r.limit, Count: count}
+	}
+	return nil
+}
+
+// DeleteAll deletes all the records matching the filter, if they are not more than the limit.
+func (r *DeleteLimitRepository) DeleteAll(filter Filter) error {
+	if err := r.checkLimit(filter); err != nil {
+		return err
+	}
+	return r.Repository.DeleteAll(filter)
+}
+
+// DeleteAllReturning deletes all the records matching the filter, if they are not more than the
+// limit, and returns their IDs.
+func (r *DeleteLimitRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	if err := r.checkLimit(filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.DeleteAllReturning(filter)
+}
+
+// Bulk returns a BulkOp that checks each of the deletes against the limit before any of the
+// operations is executed. None of the operations is executed if a delete exceeds the limit.
+func (r *DeleteLimitRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		for _, operation := range operations {
+			if operation.Kind != BulkDelete {
+				continue
+			}
+			if err := r.checkLimit(operation.Filter); err != nil {
+				return BulkResult{}, err
+			}
+		}
+		return runBulk(r.Repository, operations)
+	})
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *DeleteLimitRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *DeleteLimitRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context. The limit is overridden if
+// the context is derived with AllowUnlimitedDeletes.
+func (r *DeleteLimitRepository) WithContext(ctx context.Context) Repository {
+	return &DeleteLimitRepository{
+		Repository: r.Repository.WithContext(ctx),
+		limit:      r.limit,
+		unlimited:  unlimitedDeletes(ctx),
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *DeleteLimitRepository) WithSession(session *Session) Repository {
+	return &DeleteLimitRepository{
+		Repository: r.Repository.WithSession(session),
+		limit:      r.limit,
+		unlimited:  r.unlimited,
+	}
+}
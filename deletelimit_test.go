@@ -0,0 +1,73 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestDeleteLimit(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users", "deleteLimit": 2})
+	for _, name := range []string{"John", "Jane", "Jack"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name, Age: 30}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := repo.DeleteAll(NewFilter().Match("age", 30))
+	var limitErr DeleteLimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Count != 3 || limitErr.Limit != 2 {
+		t.Fatal("Expected the delete of 3 records to exceed the limit of 2. Got: ", err)
+	}
+	if !IsErrDeleteLimitExceeded(err) {
+		t.Fatal("Expected IsErrDeleteLimitExceeded to report the error.")
+	}
+	if count, err := repo.Count(NewFilter()); err != nil || count != 3 {
+		t.Fatal("Expected all the records to remain after the blocked delete. Got: ", count, err)
+	}
+
+	if _, err = repo.Bulk().Delete(NewFilter().Match("name", "John")).Delete(NewFilter()).Execute(); !IsErrDeleteLimitExceeded(err) {
+		t.Fatal("Expected the bulk with a delete exceeding the limit to be blocked. Got: ", err)
+	}
+	if count, _ := repo.Count(NewFilter()); count != 3 {
+		t.Fatal("Expected none of the deletes of the blocked bulk to be executed. Got: ", count)
+	}
+
+	ids, err := repo.DeleteAllReturning(NewFilter().Match("name", "John"))
+	if err != nil || len(ids) != 1 {
+		t.Fatal("Expected the delete within the limit to delete the record. Got: ", ids, err)
+	}
+
+	if err = repo.WithContext(AllowUnlimitedDeletes(context.Background())).DeleteAll(NewFilter()); err != nil {
+		t.Fatal("Expected the overridden limit to allow the delete. Got: ", err)
+	}
+	if count, _ := repo.Count(NewFilter()); count != 0 {
+		t.Fatal("Expected all the records deleted. Got: ", count)
+	}
+}
+
+func TestDeleteLimitDefinition(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	for _, deleteLimit := range []interface{}{-1, 2.5, "two"} {
+		if _, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users", "deleteLimit": deleteLimit}); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected an error for the delete limit %v. Got: %v", deleteLimit, err)
+		}
+	}
+
+	// a whole float64, like in a definition decoded from JSON
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users", "deleteLimit": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"John", "Jane"} {
+		if _, err = repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = repo.DeleteAll(NewFilter()); !IsErrDeleteLimitExceeded(err) {
+		t.Fatal("Expected the delete limit of the definition to apply. Got: ", err)
+	}
+}
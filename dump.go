@@ -0,0 +1,58 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The operations rendered by Repository.Dump.
+const (
+	// DumpFind is the read of the matching records, like GetAll.
+	DumpFind = "find"
+	// DumpCount is the count of the matching records.
+	DumpCount = "count"
+	// DumpDelete is the delete of the matching records, like DeleteAll.
+	DumpDelete = "delete"
+)
+
+// checkDumpOperation checks that the operation is one that Repository.Dump renders.
+func checkDumpOperation(op string) error {
+	switch op {
+	case DumpFind, DumpCount, DumpDelete:
+		return nil
+	}
+	return ErrInvalidInput(fmt.Sprintf("cannot dump the operation %q, expected %q, %q or %q", op, DumpFind, DumpCount, DumpDelete))
+}
+
+// dumpValue renders a value of a query as JSON, or with the default format if it cannot be
+// encoded.
+func dumpValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// dumpDynamoExpression renders a DynamoDB expression with the names of the attributes in place of
+// the $ placeholders, and the values of the ? placeholders listed separately, in order.
+func dumpDynamoExpression(expression string, args []interface{}) string {
+	var rendered strings.Builder
+	values := []string{}
+	next := 0
+	for _, c := range expression {
+		if (c != '$' && c != '?') || next >= len(args) {
+			rendered.WriteRune(c)
+			continue
+		}
+		if c == '$' {
+			rendered.WriteString(dumpValue(fmt.Sprintf("%v", args[next])))
+		} else {
+			rendered.WriteRune(c)
+			values = append(values, dumpValue(args[next]))
+		}
+		next++
+	}
+	return fmt.Sprintf("%s ARGS [%s]", rendered.String(), strings.Join(values, ", "))
+}
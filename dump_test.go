@@ -0,0 +1,47 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestDumpPattern(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := dynamo.New(sess).Table("users")
+	dynamoRepo := &DynamoCollection{
+		Table:                &table,
+		RepositoryDefinition: RepositoryDefinitionMap{"name": "users"},
+	}
+	mongoRepo := &MongoCollection{
+		Collection: &mgo.Collection{Name: "users"},
+		repoDef:    RepositoryDefinitionMap{"name": "users"},
+	}
+	filter := NewFilter().MatchPattern("name", "John%")
+
+	query, err := dynamoRepo.Dump(DumpFind, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `Scan users FILTER "name" BEGINS_WITH ? ARGS ["John"]`; query != expected {
+		t.Fatalf("Expected the DynamoDB query %s. Got: %s", expected, query)
+	}
+
+	query, err = mongoRepo.Dump(DumpDelete, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `db.users.remove({"name":{"$regex":"^John.*"}})`; query != expected {
+		t.Fatalf("Expected the MongoDB command %s. Got: %s", expected, query)
+	}
+
+	if _, err = mongoRepo.Dump("update", filter); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an unknown operation to be rejected. Got: ", err)
+	}
+}
@@ -3,14 +3,16 @@ package backends
 import (
 	"context"
 	"fmt"
-	"log"
+	"math"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/Microkubes/microservice-tools/config"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -25,6 +27,12 @@ var DYNAMO_CTX_KEY = "DYNAMO_SESSION"
 type DynamoCollection struct {
 	*dynamo.Table
 	RepositoryDefinition
+	ctx     context.Context
+	backend Backend
+	// consistent makes all the reads strongly consistent, see WithSession
+	consistent bool
+	stats      *queryStatsRecorder
+	logger     Logger
 }
 
 type patternCondition struct {
@@ -35,6 +43,9 @@ type patternCondition struct {
 // DynamoDBRepoBuilder builds new dynamo table.
 // If it does not exist builder will create it
 func DynamoDBRepoBuilder(repoDef RepositoryDefinition, backend Backend) (Repository, error) {
+	if repoDef.IsCapped() {
+		return nil, ErrUnsupported("DynamoDB does not support capped tables, expire the old items with a TTL instead")
+	}
 
 	sessionObj := backend.GetFromContext(DYNAMO_CTX_KEY)
 	if sessionObj == nil {
@@ -51,31 +62,103 @@ func DynamoDBRepoBuilder(repoDef RepositoryDefinition, backend Backend) (Reposit
 		return nil, ErrBackendError("database name is missing and required")
 	}
 
-	tableName := repoDef.GetName()
-	if tableName == "" {
+	if repoDef.GetName() == "" {
 		return nil, ErrBackendError("table name is missing and required")
 	}
+	tableName := backend.PhysicalName(repoDef.GetName())
 
-	svc := dynamodb.New(sessionAWS)
-	err := createTable(svc, repoDef)
-	if err != nil {
-		return nil, err
-	}
+	logger := BackendLogger(backend)
 
-	err = setTTL(svc, repoDef)
-	if err != nil {
-		return nil, err
+	// the table is created on the primary
+	if !IsReadReplica(backend) {
+		svc := dynamodb.New(sessionAWS)
+		err := createTable(svc, tableName, repoDef, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		err = setTTL(svc, tableName, repoDef)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	db := dynamo.New(sessionAWS)
 	table := db.Table(tableName)
 
 	return &DynamoCollection{
-		&table,
-		repoDef,
+		Table:                &table,
+		RepositoryDefinition: repoDef,
+		backend:              backend,
+		stats:                newQueryStatsRecorder(),
+		logger:               backendLogger{backend},
 	}, nil
 }
 
+// DynamoDBRepoPlanner plans the creation of the dynamo table with its GSIs and the TTL setting.
+// The table is planned only if it does not already exist.
+func DynamoDBRepoPlanner(repoDef RepositoryDefinition, backend Backend) (*RepositoryPlan, error) {
+	if repoDef.IsCapped() {
+		return nil, ErrUnsupported("DynamoDB does not support capped tables, expire the old items with a TTL instead")
+	}
+
+	sessionObj := backend.GetFromContext(DYNAMO_CTX_KEY)
+	if sessionObj == nil {
+		return nil, ErrBackendError("dynamo session not configured")
+	}
+
+	sessionAWS, ok := sessionObj.(*session.Session)
+	if !ok {
+		return nil, ErrBackendError("unknown session type")
+	}
+
+	if repoDef.GetName() == "" {
+		return nil, ErrBackendError("table name is missing and required")
+	}
+	tableName := backend.PhysicalName(repoDef.GetName())
+
+	hashKey := repoDef.GetHashKey()
+	if hashKey == "" {
+		return nil, ErrBackendError(fmt.Sprintf("Hash key is missing for table %s", tableName))
+	}
+
+	svc := dynamodb.New(sessionAWS)
+	result, err := svc.ListTables(&dynamodb.ListTablesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RepositoryPlan{
+		Repository: tableName,
+	}
+
+	if !contains(result.TableNames, tableName) {
+		keys := []string{hashKey}
+		if repoDef.GetRangeKey() != "" {
+			keys = append(keys, repoDef.GetRangeKey())
+		}
+		plan.Add(ActionCreateRepository, tableName, keys...)
+
+		gsiDefs, err := repoDef.GetGSIDefs()
+		if err != nil {
+			return nil, err
+		}
+		for _, gsi := range gsiDefs {
+			keys := []string{gsi.HashKey}
+			if gsi.RangeKey != "" {
+				keys = append(keys, gsi.RangeKey)
+			}
+			plan.Add(ActionCreateIndex, gsi.Name, keys...)
+		}
+	}
+
+	if repoDef.EnableTTL() {
+		plan.Add(ActionEnableTTL, repoDef.GetTTLAttribute(), repoDef.GetTTLAttribute())
+	}
+
+	return plan, nil
+}
+
 // DynamoDBBackendBuilder returns RepositoriesBackend
 func DynamoDBBackendBuilder(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
 
@@ -96,38 +179,41 @@ func DynamoDBBackendBuilder(dbInfo *config.DBInfo, manager BackendManager) (Back
 		return nil, ErrBackendError("AWS region is missing from config")
 	}
 
+	logger := managerLogger(manager)
 	configAWS := &aws.Config{
 		Region: aws.String(dbInfo.AWSRegion),
 	}
 
 	if dbInfo.AWSEndpoint != "" {
 		configAWS.Endpoint = aws.String(dbInfo.AWSEndpoint)
-		log.Println("Using AWS Endpoint: ", dbInfo.AWSEndpoint)
+		logger.Debugf("Using AWS Endpoint: %s", MaskConnectionString(dbInfo.AWSEndpoint))
 	}
 
 	if staticCredentials {
-		log.Println("Using static AWS Credentials.")
+		logger.Debugf("Using static AWS Credentials.")
 		configAWS.Credentials = credentials.NewStaticCredentials(dbInfo.AWSSecretKeyID, dbInfo.AWSSecretAccessKey, dbInfo.AWSSessionToken)
 	}
 
 	if dbInfo.AWSCredentials != "" {
-		log.Println("Using Shared AWS Credentials from file.")
+		logger.Debugf("Using Shared AWS Credentials from file.")
 		configAWS.Credentials = credentials.NewSharedCredentials(dbInfo.AWSCredentials, "")
 	}
 	sess, err := session.NewSession(configAWS)
 	if err != nil {
-		return nil, err
+		return nil, maskError(err, dbInfo)
 	}
+	sess.Handlers.Build.PushBackNamed(dynamoRequestIDHandler)
 
-	ctx := context.WithValue(context.Background(), DYNAMO_CTX_KEY, sess)
 	cleanup := func() {}
 
-	return NewRepositoriesBackend(ctx, dbInfo, DynamoDBRepoBuilder, cleanup), nil
+	backend := NewRepositoriesBackend(context.Background(), dbInfo, DynamoDBRepoBuilder, cleanup, WithRepoPlanner(DynamoDBRepoPlanner), WithCapabilities(dynamoCapabilities))
+	backend.SetInContext(DYNAMO_CTX_KEY, sess)
+	return backend, nil
 
 }
 
 // createTable creates table if it does not exist
-func createTable(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
+func createTable(svc *dynamodb.DynamoDB, tableName string, repoDef RepositoryDefinition, logger Logger) error {
 	result, err := svc.ListTables(&dynamodb.ListTablesInput{})
 	if err != nil {
 		return err
@@ -137,7 +223,6 @@ func createTable(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
 	var keySchemaElements []*dynamodb.KeySchemaElement
 	var globalSecondaryIndexes []*dynamodb.GlobalSecondaryIndex
 
-	tableName := repoDef.GetName()
 	tableNames := result.TableNames
 	hashKey := repoDef.GetHashKey()
 	rangeKey := repoDef.GetRangeKey()
@@ -181,38 +266,55 @@ func createTable(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
 		})
 	}
 
-	gsi := repoDef.GetGSI()
-	if gsi != nil {
-		for index, value := range gsi {
+	gsiDefs, err := repoDef.GetGSIDefs()
+	if err != nil {
+		return err
+	}
+	if repoDef.GetIndexCreationMode() != IndexCreateIfMissing {
+		// the GSIs are managed elsewhere
+		gsiDefs = nil
+	}
+	for _, gsi := range gsiDefs {
 
-			var keySchemaGSI []*dynamodb.KeySchemaElement
-			if index == hashKey {
-				keySchemaGSI = append(keySchemaGSI, &dynamodb.KeySchemaElement{
-					AttributeName: aws.String(index),
-					KeyType:       aws.String("HASH"),
-				})
-			} else if index == rangeKey {
-				keySchemaGSI = append(keySchemaGSI, &dynamodb.KeySchemaElement{
-					AttributeName: aws.String(index),
-					KeyType:       aws.String("RANGE"),
-				})
-			} else {
-				return ErrBackendError("GSI must be hash or range key")
-			}
+		var keySchemaGSI []*dynamodb.KeySchemaElement
+		if gsi.HashKey == hashKey {
+			keySchemaGSI = append(keySchemaGSI, &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(gsi.HashKey),
+				KeyType:       aws.String("HASH"),
+			})
+		} else if gsi.HashKey == rangeKey {
+			keySchemaGSI = append(keySchemaGSI, &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(gsi.HashKey),
+				KeyType:       aws.String("RANGE"),
+			})
+		} else {
+			return ErrBackendError("GSI must be hash or range key")
+		}
 
-			v := value.(map[string]interface{})
-			globalSecondaryIndexes = append(globalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
-				IndexName: aws.String(fmt.Sprintf("%s-index", index)),
-				KeySchema: keySchemaGSI,
-				Projection: &dynamodb.Projection{
-					ProjectionType: aws.String("ALL"),
-				},
-				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-					ReadCapacityUnits:  aws.Int64(int64(v["readCapacity"].(int))),
-					WriteCapacityUnits: aws.Int64(int64(v["writeCapacity"].(int))),
-				},
+		if gsi.RangeKey != "" {
+			keySchemaGSI = append(keySchemaGSI, &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(gsi.RangeKey),
+				KeyType:       aws.String("RANGE"),
 			})
+			if !hasAttributeDefinition(attributes, gsi.RangeKey) {
+				attributes = append(attributes, &dynamodb.AttributeDefinition{
+					AttributeName: aws.String(gsi.RangeKey),
+					AttributeType: aws.String(gsi.RangeKeyType),
+				})
+			}
 		}
+
+		globalSecondaryIndexes = append(globalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName: aws.String(gsi.Name),
+			KeySchema: keySchemaGSI,
+			Projection: &dynamodb.Projection{
+				ProjectionType: aws.String(gsi.Projection),
+			},
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(gsi.ReadCapacity),
+				WriteCapacityUnits: aws.Int64(gsi.WriteCapacity),
+			},
+		})
 	}
 
 	input := &dynamodb.CreateTableInput{
@@ -232,18 +334,27 @@ func createTable(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
 		return err
 	}
 
-	log.Printf("Table created: %v\n", cto)
+	logger.Debugf("Table created: %v", cto)
 
 	return nil
 }
 
+// hasAttributeDefinition checks if the attribute is already defined for the table.
+func hasAttributeDefinition(attributes []*dynamodb.AttributeDefinition, name string) bool {
+	for _, attribute := range attributes {
+		if aws.StringValue(attribute.AttributeName) == name {
+			return true
+		}
+	}
+	return false
+}
+
 // setTTL sets TimeToLive to the table
-func setTTL(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
+func setTTL(svc *dynamodb.DynamoDB, tableName string, repoDef RepositoryDefinition) error {
 
 	if repoDef.EnableTTL() {
 		enabled := repoDef.EnableTTL()
 		attribute := repoDef.GetTTLAttribute()
-		tableName := repoDef.GetName()
 		TTL := repoDef.GetTTL()
 
 		if attribute == "" {
@@ -279,29 +390,46 @@ func setTTL(svc *dynamodb.DynamoDB, repoDef RepositoryDefinition) error {
 // 		"id":    "54acb6c5-baeb-4213-b10f-e707a6055e64",
 // }
 func (c *DynamoCollection) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return c.GetOneWithOpts(filter, result, ReadOpts{})
+}
 
-	var record map[string]interface{}
-	var records []map[string]interface{}
-
-	var query []string
-	var args []interface{}
-	for k, v := range filter {
-		query = append(query, "$ = ?")
-		args = append(args, k)
-		args = append(args, v)
+// GetOneWithOpts looks up for an item by given filter using the given read options.
+// ReadOpts.Consistent maps to a strongly consistent scan. The read is from the table of the region of
+// the client, so ReadOpts.ReadPreference has no effect.
+func (c *DynamoCollection) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
 	}
-
-	if c.RepositoryDefinition.EnableTTL() {
-		query = append(query, "$ > ?")
-		args = append(args, c.RepositoryDefinition.GetTTLAttribute())
-		args = append(args, time.Now())
+	if opts.StrictDecode {
+		return getOneStrict(c, filter, result, opts)
 	}
 
-	err := c.Table.Scan().Filter(strings.Join(query, " AND "), args...).Limit(int64(1)).All(&records)
+	var record map[string]interface{}
+	var records []map[string]interface{}
+
+	indexed, err := c.indexedQuery(filter, opts.Consistent || c.consistent)
 	if err != nil {
 		return nil, err
 	}
-	if records == nil {
+	if indexed != nil {
+		c.logger.Debugf("dynamodb %s: query one %v", c.Table.Name(), filter)
+		err = c.throttled(indexed.Limit(1).AllWithContext(c.requestContext(), &records))
+		if err != nil && err != dynamo.ErrNotFound {
+			return nil, err
+		}
+	} else {
+		query, args, err := c.filterExpression(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		c.logger.Debugf("dynamodb %s: scan one %q %v", c.Table.Name(), query, args)
+		err = c.throttled(c.Table.Scan().Filter(query, args...).Consistent(opts.Consistent || c.consistent).Limit(int64(1)).AllWithContext(c.requestContext(), &records))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
 		return nil, ErrNotFound("Record not found")
 	}
 
@@ -315,34 +443,49 @@ func (c *DynamoCollection) GetOne(filter Filter, result interface{}) (interface{
 
 // GetAll returns all matched records. You can specify limit and offset as well.
 func (c *DynamoCollection) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
-	var results reflect.Value
+	return c.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, ReadOpts{})
+}
 
-	resultHint := AsPtr(resultsTypeHint)
+// GetAllWithOpts returns all matched records using the given read options.
+// ReadOpts.Consistent maps to a strongly consistent scan. The scan results are not sorted, so
+// ReadOpts.Collation and the default sort of the definition have no effect. The scan is of the table of
+// the region of the client, so ReadOpts.ReadPreference has no effect either.
+func (c *DynamoCollection) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if err := checkBatchSize(opts.BatchSize); err != nil {
+		return nil, err
+	}
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
+	}
+	if opts.SkipDecodeErrors {
+		return getAllSkippingDecodeErrors(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	if opts.StrictDecode {
+		return getAllStrict(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
 
-	results = NewSliceOfType(resultHint)
+	start := time.Now()
+	limit, err := pageBounds(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSorting(sorting); err != nil {
+		return nil, err
+	}
 
-	var query []string
-	var args []interface{}
-	for k, v := range filter {
-		if specs, ok := v.(map[string]interface{}); ok {
-			if pattern, ok := specs["$pattern"]; ok {
-				for _, cond := range patternToDynamodbCondition(pattern.(string)) {
-					query = append(query, fmt.Sprintf("$ %s ?", cond.condition))
-					args = append(args, k)
-					args = append(args, cond.value)
-				}
-			}
-			continue
-		}
-		query = append(query, "$ = ?")
-		args = append(args, k)
-		args = append(args, v)
+	var results reflect.Value
+	var consumed dynamo.ConsumedCapacity
+
+	resultHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.RepositoryDefinition.EnableTTL() {
-		query = append(query, "$ > ?")
-		args = append(args, c.RepositoryDefinition.GetTTLAttribute())
-		args = append(args, time.Now())
+	results = NewSliceOfType(resultHint)
+
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return nil, err
 	}
 
 	startFrom := 1
@@ -350,7 +493,8 @@ func (c *DynamoCollection) GetAll(filter Filter, resultsTypeHint interface{}, or
 		startFrom = offset + 1
 	}
 
-	itr := c.Table.Scan().Filter(strings.Join(query, " AND "), args...).SearchLimit(int64(startFrom)).Iter()
+	c.logger.Debugf("dynamodb %s: scan %q %v, offset %d, limit %d", c.Table.Name(), query, args, offset, limit)
+	itr := c.Table.Scan().Filter(query, args...).Consistent(opts.Consistent || c.consistent).SearchLimit(int64(startFrom)).ConsumedCapacity(&consumed).IterWithContext(c.requestContext())
 	for i := 0; ; i++ {
 		record, err := CreateNewAsExample(resultHint)
 		if err != nil {
@@ -358,7 +502,7 @@ func (c *DynamoCollection) GetAll(filter Filter, resultsTypeHint interface{}, or
 		}
 		more := itr.Next(record)
 		if itr.Err() != nil {
-			return nil, itr.Err()
+			return nil, c.throttled(itr.Err())
 		}
 		if !more {
 			break
@@ -368,128 +512,1077 @@ func (c *DynamoCollection) GetAll(filter Filter, resultsTypeHint interface{}, or
 		}
 		results = reflect.ValueOf(reflect.Append(results, reflect.ValueOf(record)).Interface())
 
-		itr = c.Table.Scan().StartFrom(itr.LastEvaluatedKey()).SearchLimit(1).Iter()
+		itr = c.Table.Scan().StartFrom(itr.LastEvaluatedKey()).Consistent(opts.Consistent || c.consistent).SearchLimit(1).ConsumedCapacity(&consumed).IterWithContext(c.requestContext())
 	}
 
+	c.stats.record(QueryStats{
+		ItemsReturned:    int64(results.Len()),
+		ConsumedCapacity: consumed.Total,
+	}, start)
 	return results.Interface(), nil
 }
 
-// Save creates new item or updates the existing one
-func (c *DynamoCollection) Save(object interface{}, filter Filter) (interface{}, error) {
-
-	var result interface{}
+// GetFirst returns the first of the matched records. The scan results are not sorted, so the
+// order is not applied and any matched record may be returned.
+func (c *DynamoCollection) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(c.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
 
-	payload, err := InterfaceToMap(object)
+// GetAllByIndex queries the named global secondary index. DynamoDB does not pick an index on its own,
+// so this is the way to look up items by a GSI key. The index can be given by its DynamoDB name
+// ("email-index") or by its key attribute as in the GSI definition ("email"). The filter must have
+// an exact match on the key attribute, the other properties of the filter are applied as a filter
+// expression on the query results.
+func (c *DynamoCollection) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	start := time.Now()
+	limit, err := pageBounds(limit, offset)
 	if err != nil {
 		return nil, err
 	}
 
-	hashKey := c.RepositoryDefinition.GetHashKey()
-	rangeKey := c.RepositoryDefinition.GetRangeKey()
+	indexQuery, err := newDynamoIndexQuery(c.RepositoryDefinition, indexName, filter)
+	if err != nil {
+		return nil, err
+	}
 
-	if filter == nil {
-		// Create item
-		if _, ok := (*payload)["id"]; !ok {
-			id, err := uuid.NewV4()
-			if err != nil {
-				return nil, err
-			}
+	var consumed dynamo.ConsumedCapacity
+	query := c.Table.Get(indexQuery.attribute, indexQuery.value).Index(indexQuery.index).ConsumedCapacity(&consumed)
 
-			(*payload)["id"] = id.String()
-		}
+	expr, args, err := c.filterExpression(indexQuery.filter)
+	if err != nil {
+		return nil, err
+	}
+	if expr != "" {
+		query = query.Filter(expr, args...)
+	}
 
-		if c.RepositoryDefinition.EnableTTL() {
-			attribute := c.RepositoryDefinition.GetTTLAttribute()
-			TTL := c.RepositoryDefinition.GetTTL()
+	c.logger.Debugf("dynamodb %s: query %s %s = %v, filter %q %v", c.Table.Name(), indexQuery.index, indexQuery.attribute, indexQuery.value, expr, args)
+	var records []map[string]interface{}
+	err = c.throttled(query.AllWithContext(c.requestContext(), &records))
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
 
-			(*payload)[attribute] = time.Now().Add(time.Second * time.Duration(TTL))
-		}
+	if offset > len(records) {
+		offset = len(records)
+	}
+	records = records[offset:]
+	if limit != 0 && limit < len(records) {
+		records = records[:limit]
+	}
 
-		av, err := dynamodbattribute.MarshalMap(payload)
+	resultHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultHint)
+	for _, record := range records {
+		item, err := CreateNewAsExample(resultHint)
 		if err != nil {
 			return nil, err
 		}
-
-		err = c.Table.Put(av).If("attribute_not_exists($)", hashKey).Run()
-		if err != nil {
-			if IsConditionalCheckErr(err) {
-				return nil, ErrAlreadyExists("record already exists!")
-			}
+		if err = MapToInterface(&record, item); err != nil {
 			return nil, err
 		}
-	} else {
-		// Update item
+		results = reflect.Append(results, reflect.ValueOf(item))
+	}
 
-		var item interface{}
-		_, err = c.GetOne(filter, &item)
-		if err != nil {
-			return nil, err
-		}
-		res := item.(map[string]interface{})
+	c.stats.record(QueryStats{
+		ItemsReturned:    int64(results.Len()),
+		ConsumedCapacity: consumed.Total,
+	}, start)
+	return results.Interface(), nil
+}
 
-		query := c.Table.Update(hashKey, res[hashKey])
-		if rangeKey != "" {
-			query = query.Range(rangeKey, res[rangeKey])
-		}
+// dynamoArrayRetries is the number of times ArrayRemove retries the update of an item whose list
+// was changed since it was read.
+const dynamoArrayRetries = 5
+
+// ArrayAppend appends the values to the list of the field of all the matched items. The matching
+// items are scanned first, then each of them is updated with an UpdateItem that sets the list to
+// list_append of the list (or an empty list, if missing) and the values, conditioned on the
+// filter, so the concurrent appends are not lost. The items changed to no longer match the filter
+// since the scan are skipped. None of the items is updated if the field of any of them holds a
+// value that is not a list.
+func (c *DynamoCollection) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	candidates, conditions, conditionArgs, err := c.arrayCandidates(filter, field, values)
+	if err != nil {
+		return 0, err
+	}
 
-		for k, v := range *payload {
-			if k != hashKey && k != rangeKey {
-				query = query.Set(k, v)
-			}
+	var updated int64
+	for _, candidate := range candidates {
+		itemUpdate := c.itemUpdate(candidate).SetExpr("$ = list_append(if_not_exists($, ?), ?)", field, field, dynamoList{}, dynamoList(values))
+		if len(conditions) > 0 {
+			itemUpdate = itemUpdate.If(strings.Join(conditions, " AND "), conditionArgs...)
 		}
-
-		var updatedItem map[string]interface{}
-		err = query.Value(&updatedItem)
-		if err != nil {
-			return nil, err
+		if err = c.throttled(itemUpdate.RunWithContext(c.requestContext())); err != nil {
+			if IsConditionalCheckErr(err) {
+				continue
+			}
+			return updated, err
 		}
-
-		payload = &updatedItem
+		updated++
 	}
+	return updated, nil
+}
 
-	err = MapToInterface(payload, &result)
+// ArrayRemove removes the values from the list of the field of all the matched items. DynamoDB
+// cannot remove the list elements by value, so the matching items are scanned first, then the list
+// of each of them is set to the remaining elements with an UpdateItem conditioned on the filter,
+// and on the list still having the same size and the removed values at the same positions. If the
+// list was changed since it was read, the item is read again and the update is retried, up to
+// dynamoArrayRetries times. None of the items is updated if the field of any of them holds a value
+// that is not a list.
+func (c *DynamoCollection) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	candidates, conditions, conditionArgs, err := c.arrayCandidates(filter, field, values)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return result, nil
-}
+	var updated int64
+	for _, candidate := range candidates {
+		item := candidate
+		for attempt := 0; item != nil; attempt++ {
+			array, err := storedArray(item, field)
+			if err != nil {
+				return updated, err
+			}
+			remaining, removed, err := removeArrayValues(array, values)
+			if err != nil {
+				return updated, err
+			}
+			if len(removed) == 0 {
+				break
+			}
 
-// DeleteOne deletes only one item at the time
-// Example filter:
-//	filter := map[string]interface{}{
-// 		"email": "keitaro-user1@keitaro.com",
-// }
-func (c *DynamoCollection) DeleteOne(filter Filter) error {
+			itemConditions := append([]string{"size($) = ?"}, conditions...)
+			itemArgs := append([]interface{}{field, len(array)}, conditionArgs...)
+			for _, i := range removed {
+				itemConditions = append(itemConditions, fmt.Sprintf("$[%d] = ?", i))
+				itemArgs = append(itemArgs, field, array[i])
+			}
+			err = c.itemUpdate(item).
+				SetExpr("$ = ?", field, dynamoList(remaining)).
+				If(strings.Join(itemConditions, " AND "), itemArgs...).
+				RunWithContext(c.requestContext())
+			err = c.throttled(err)
+			if err == nil {
+				updated++
+				break
+			}
+			if !IsConditionalCheckErr(err) || attempt == dynamoArrayRetries {
+				return updated, err
+			}
+			// the item was changed since it was read
+			if item, err = c.rereadItem(item, filter); err != nil {
+				return updated, err
+			}
+		}
+	}
+	return updated, nil
+}
 
+// UpdatePath sets the value at the path of all the matched items. DynamoDB cannot set a path whose
+// parent map is missing, so the matching items are scanned first, then each of them is updated
+// with an UpdateItem that sets the first missing map of the path (or the value itself, if the
+// whole path exists) to the value nested in the maps of the rest of the path, conditioned on the
+// filter. The items changed to no longer match the filter since the scan are skipped. None of the
+// items is updated if the path is invalid in any of them.
+func (c *DynamoCollection) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
 	hashKey := c.RepositoryDefinition.GetHashKey()
 	rangeKey := c.RepositoryDefinition.GetRangeKey()
-
-	var item interface{}
-	_, err := c.GetOne(filter, &item)
+	segments, err := splitPath(path, hashKey, rangeKey, c.RepositoryDefinition.GetIDField())
 	if err != nil {
-		return err
+		return 0, err
 	}
-	result := item.(map[string]interface{})
 
-	query := c.Table.Delete(hashKey, result[hashKey])
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return 0, err
+	}
+	conditions, conditionArgs, err := conditionExpression(filter)
+	if err != nil {
+		return 0, err
+	}
 
-	if rangeKey != "" {
-		query = query.Range(rangeKey, result[rangeKey])
+	candidates := []map[string]interface{}{}
+	if err = c.throttled(c.Table.Scan().Filter(query, args...).Consistent(true).AllWithContext(c.requestContext(), &candidates)); err != nil {
+		return 0, err
+	}
+	for _, candidate := range candidates {
+		if _, _, err = pathUpdate(candidate, segments, value); err != nil {
+			return 0, err
+		}
 	}
 
-	var old map[string]interface{}
-	err = query.OldValue(&old)
-	if err != nil {
-		if err == dynamo.ErrNotFound {
-			return ErrNotFound(err)
+	var updated int64
+	for _, candidate := range candidates {
+		setSegments, setValue, _ := pathUpdate(candidate, segments, value)
+		itemUpdate := c.itemUpdate(candidate).Set(documentPath(candidate, setSegments), setValue)
+		if len(conditions) > 0 {
+			itemUpdate = itemUpdate.If(strings.Join(conditions, " AND "), conditionArgs...)
 		}
-		return err
+		if err = c.throttled(itemUpdate.RunWithContext(c.requestContext())); err != nil {
+			if IsConditionalCheckErr(err) {
+				continue
+			}
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// arrayCandidates scans the items matching the filter for ArrayAppend and ArrayRemove, and returns
+// them with the condition expression of the filter. It fails with ErrInvalidInput if the field of
+// any of the items holds a value that is not a list.
+func (c *DynamoCollection) arrayCandidates(filter Filter, field string, values []interface{}) ([]map[string]interface{}, []string, []interface{}, error) {
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+	if err := checkArrayUpdate(field, values, hashKey, rangeKey, c.RepositoryDefinition.GetIDField()); err != nil {
+		return nil, nil, nil, err
+	}
+
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	conditions, conditionArgs, err := conditionExpression(filter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	candidates := []map[string]interface{}{}
+	if err = c.throttled(c.Table.Scan().Filter(query, args...).Consistent(true).AllWithContext(c.requestContext(), &candidates)); err != nil {
+		return nil, nil, nil, err
+	}
+	for _, candidate := range candidates {
+		if _, err = storedArray(candidate, field); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return candidates, conditions, conditionArgs, nil
+}
+
+// itemUpdate returns the UpdateItem of the item, by its primary key.
+func (c *DynamoCollection) itemUpdate(item map[string]interface{}) *dynamo.Update {
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	itemUpdate := c.Table.Update(hashKey, item[hashKey])
+	if rangeKey != "" {
+		itemUpdate = itemUpdate.Range(rangeKey, item[rangeKey])
+	}
+	return itemUpdate
+}
+
+// rereadItem reads the item again by its primary key with a consistent read. It returns nil if
+// the item was deleted or no longer matches the filter.
+func (c *DynamoCollection) rereadItem(item map[string]interface{}, filter Filter) (map[string]interface{}, error) {
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return nil, err
+	}
+	get := c.Table.Get(hashKey, item[hashKey]).Consistent(true)
+	if rangeKey != "" {
+		get = get.Range(rangeKey, dynamo.Equal, item[rangeKey])
+	}
+	if query != "" {
+		get = get.Filter(query, args...)
+	}
+
+	var items []map[string]interface{}
+	if err = c.throttled(get.AllWithContext(c.requestContext(), &items)); err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return items[0], nil
+}
+
+// dynamoList is stored in DynamoDB as a list, also when it is empty, which guregu/dynamo would
+// otherwise omit.
+type dynamoList []interface{}
+
+// MarshalDynamo stores the elements as a list.
+func (l dynamoList) MarshalDynamo() (*dynamodb.AttributeValue, error) {
+	list := &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+	for _, element := range l {
+		av, err := dynamodbattribute.Marshal(element)
+		if err != nil {
+			return nil, err
+		}
+		list.L = append(list.L, av)
+	}
+	return list, nil
+}
+
+// UsesIndex returns the key of the table ("primary") or the GSI matched exactly by the filter. The
+// key is unique if the filter matches both the hash key and the range key (if any).
+func (c *DynamoCollection) UsesIndex(filter Filter) (Index, bool) {
+	hashKey, rangeKey := c.RepositoryDefinition.GetHashKey(), c.RepositoryDefinition.GetRangeKey()
+	indexes := []Index{}
+	if hashKey != "" {
+		if rangeKey != "" {
+			indexes = append(indexes, NewIndex(dynamoPrimaryIndex, true, hashKey, rangeKey), NewIndex(dynamoPrimaryIndex, false, hashKey))
+		} else {
+			indexes = append(indexes, NewIndex(dynamoPrimaryIndex, true, hashKey))
+		}
+	}
+	if gsiDefs, err := c.RepositoryDefinition.GetGSIDefs(); err == nil {
+		for _, gsi := range gsiDefs {
+			indexes = append(indexes, NewIndex(fmt.Sprintf("%s-index", gsi.HashKey), false, gsi.HashKey))
+		}
+	}
+	return usesIndex(indexes, filter)
+}
+
+// Migrate applies the migrations of the table and records the applied version in the
+// SchemaVersionsRepository of the backend. See Repository.Migrate.
+func (c *DynamoCollection) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return runMigrations(ctx, c, c.backend, c.RepositoryDefinition.GetName(), from, to, migrations)
+}
+
+// SaveIdempotent saves a new item unless the idempotency key was processed, recording the keys in
+// the IdempotencyKeysRepository of the backend. See Repository.SaveIdempotent.
+func (c *DynamoCollection) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return saveIdempotent(c, c.backend, c.RepositoryDefinition.GetName(), c.RepositoryDefinition.GetIDField(), object, idempotencyKey, ttl)
+}
+
+// Watch is not supported: the DynamoDB streams are read with a separate client, which the
+// DynamoDB backend does not have. Poll the changes with ChangedSinceWithOpts instead, with
+// Unsorted set, as the scans are not sorted.
+func (c *DynamoCollection) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	return nil, ErrUnsupported("the DynamoDB backend does not support change streams")
+}
+
+// dynamoPrimaryIndex is the name of the index of the table key reported by UsesIndex.
+const dynamoPrimaryIndex = "primary"
+
+// indexedQuery returns the query of the key of the table or of the GSI matched exactly by the
+// filter, with the other properties of the filter as the filter expression. It returns nil if
+// the filter matches no index, or only a GSI while the read must be consistent.
+func (c *DynamoCollection) indexedQuery(filter Filter, consistent bool) (*dynamo.Query, error) {
+	index, ok := c.UsesIndex(filter)
+	if !ok || (index.GetName() != dynamoPrimaryIndex && consistent) {
+		return nil, nil
+	}
+
+	fields := index.GetFields()
+	query := c.Table.Get(fields[0], filter[fields[0]])
+	if index.GetName() == dynamoPrimaryIndex {
+		query = query.Consistent(consistent)
+		if len(fields) > 1 {
+			query = query.Range(fields[1], dynamo.Equal, filter[fields[1]])
+		}
+	} else {
+		query = query.Index(index.GetName())
+	}
+
+	remaining := Filter{}
+	for property, value := range filter {
+		remaining[property] = value
+	}
+	for _, field := range fields {
+		delete(remaining, field)
+	}
+	expr, args, err := c.filterExpression(remaining)
+	if err != nil {
+		return nil, err
+	}
+	if expr != "" {
+		query = query.Filter(expr, args...)
+	}
+	return query, nil
+}
+
+// dynamoRangeOperators maps the range operators of QueryRange to the key condition operators.
+var dynamoRangeOperators = map[string]dynamo.Operator{
+	"$eq":         dynamo.Equal,
+	"$gt":         dynamo.Greater,
+	"$gte":        dynamo.GreaterOrEqual,
+	"$lt":         dynamo.Less,
+	"$lte":        dynamo.LessOrEqual,
+	"$startsWith": dynamo.BeginsWith,
+	"$between":    dynamo.Between,
+}
+
+// rangeKeyCondition returns the key condition operator and its values for the range operator.
+func rangeKeyCondition(rangeOp string, rangeValue interface{}) (dynamo.Operator, []interface{}, error) {
+	operator, ok := dynamoRangeOperators[rangeOp]
+	if !ok {
+		return "", nil, ErrInvalidInput(fmt.Sprintf("unsupported range operator %q", rangeOp))
+	}
+	switch rangeOp {
+	case "$between":
+		bounds, ok := rangeValue.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return "", nil, ErrInvalidInput("the value of $between must be []interface{}{from, to}")
+		}
+		return operator, bounds, nil
+	case "$startsWith":
+		if _, ok := rangeValue.(string); !ok {
+			return "", nil, ErrInvalidInput("the prefix of $startsWith must be a string")
+		}
+	}
+	return operator, []interface{}{rangeValue}, nil
+}
+
+// QueryRange queries the table with the key condition on the hash key and the range key, so
+// only the items of the partition are read. The items are ordered by the range key. The offset
+// is applied on the client, so the skipped items are read too.
+func (c *DynamoCollection) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	start := time.Now()
+	limit, err := pageBounds(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	hashKey, rangeKey := c.RepositoryDefinition.GetHashKey(), c.RepositoryDefinition.GetRangeKey()
+	if hashKey == "" || rangeKey == "" {
+		return nil, ErrInvalidInput("the table has no hash key and range key")
+	}
+	operator, values, err := rangeKeyCondition(rangeOp, rangeValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var consumed dynamo.ConsumedCapacity
+	query := c.Table.Get(hashKey, hashValue).Range(rangeKey, operator, values...).Consistent(c.consistent).ConsumedCapacity(&consumed)
+	// the expired items are filtered out if TTL is enabled
+	expr, args, err := c.filterExpression(NewFilter())
+	if err != nil {
+		return nil, err
+	}
+	if expr != "" {
+		query = query.Filter(expr, args...)
+	}
+	if limit != 0 {
+		query = query.Limit(int64(offset + limit))
+	}
+
+	c.logger.Debugf("dynamodb %s: query %s = %v, %s %s %v", c.Table.Name(), hashKey, hashValue, rangeKey, operator, values)
+	var records []map[string]interface{}
+	err = c.throttled(query.AllWithContext(c.requestContext(), &records))
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+
+	if offset > len(records) {
+		offset = len(records)
+	}
+	records = records[offset:]
+
+	resultHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultHint)
+	for _, record := range records {
+		item, err := CreateNewAsExample(resultHint)
+		if err != nil {
+			return nil, err
+		}
+		if err = MapToInterface(&record, item); err != nil {
+			return nil, err
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+	}
+
+	c.stats.record(QueryStats{
+		ItemsReturned:    int64(results.Len()),
+		ConsumedCapacity: consumed.Total,
+	}, start)
+	return results.Interface(), nil
+}
+
+// GetAllWithHint queries the named global secondary index if the filter has an exact match on
+// its key, like GetAllByIndex. Otherwise the hint is ignored and the table is scanned.
+func (c *DynamoCollection) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	if _, err := newDynamoIndexQuery(c.RepositoryDefinition, indexName, filter); err != nil {
+		return c.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+	}
+	return c.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+}
+
+// dynamoIndexQuery holds the key condition of a query on a global secondary index.
+type dynamoIndexQuery struct {
+	// index is the DynamoDB name of the index
+	index string
+	// attribute is the key attribute of the index
+	attribute string
+	// value is the value of the key attribute to look up
+	value interface{}
+	// filter holds the remaining properties of the filter
+	filter Filter
+}
+
+// ParallelScan runs a parallel scan of the table: each segment is scanned with its own scan
+// request, concurrently, and the filter is applied as a filter expression. The scans of the
+// other segments are cancelled when fn fails. See Repository.ParallelScan.
+func (c *DynamoCollection) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return err
+	}
+	resultHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debugf("dynamodb %s: parallel scan %q %v, segments %d", c.Table.Name(), query, args, segments)
+	batchSize := batchSizeOr(c.RepositoryDefinition, scanBatchSize)
+	return scanSegments(c.requestContext(), segments, func(ctx context.Context, segment int) error {
+		itr := c.Table.Scan().Filter(query, args...).Segment(int64(segment), int64(segments)).Consistent(c.consistent).IterWithContext(ctx)
+		batch := NewSliceOfType(resultHint)
+		for {
+			record, err := CreateNewAsExample(resultHint)
+			if err != nil {
+				return err
+			}
+			if !itr.Next(record) {
+				break
+			}
+			batch = reflect.Append(batch, reflect.ValueOf(record))
+			if batch.Len() == batchSize {
+				if err := fn(batch.Interface()); err != nil {
+					return err
+				}
+				batch = NewSliceOfType(resultHint)
+			}
+		}
+		if err := itr.Err(); err != nil {
+			return c.throttled(err)
+		}
+		if batch.Len() == 0 {
+			return nil
+		}
+		return fn(batch.Interface())
+	})
+}
+
+// newDynamoIndexQuery builds the key condition of the query on the named index from the filter.
+func newDynamoIndexQuery(repoDef RepositoryDefinition, indexName string, filter Filter) (*dynamoIndexQuery, error) {
+	attribute := strings.TrimSuffix(indexName, "-index")
+	if _, ok := repoDef.GetGSI()[attribute]; !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("unknown index %s", indexName))
+	}
+
+	value, ok := filter[attribute]
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("the filter must match the key attribute %s of the index %s", attribute, indexName))
+	}
+	if _, isOperator := operatorSpecs(value); isOperator {
+		return nil, ErrInvalidInput(fmt.Sprintf("the key attribute %s must be matched exactly", attribute))
+	}
+
+	remaining := Filter{}
+	for property, propertyValue := range filter {
+		if property != attribute {
+			remaining[property] = propertyValue
+		}
+	}
+
+	return &dynamoIndexQuery{
+		index:     fmt.Sprintf("%s-index", attribute),
+		attribute: attribute,
+		value:     value,
+		filter:    remaining,
+	}, nil
+}
+
+// Save creates new item or updates the existing one. The item as stored is decoded into the
+// object, which is returned, see Repository.Save.
+func (c *DynamoCollection) Save(object interface{}, filter Filter) (interface{}, error) {
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	if filter == nil {
+		// Create item
+		idField := c.RepositoryDefinition.GetIDField()
+		if _, ok := (*payload)[idField]; !ok {
+			id, err := uuid.NewV4()
+			if err != nil {
+				return nil, err
+			}
+
+			(*payload)[idField] = id.String()
+		}
+
+		if c.RepositoryDefinition.EnableTTL() {
+			attribute := c.RepositoryDefinition.GetTTLAttribute()
+			TTL := c.RepositoryDefinition.GetTTL()
+
+			(*payload)[attribute] = time.Now().Add(time.Second * time.Duration(TTL))
+		}
+
+		av, err := dynamodbattribute.MarshalMap(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.throttled(c.Table.Put(av).If("attribute_not_exists($)", hashKey).RunWithContext(c.requestContext()))
+		if err != nil {
+			return nil, WrapDuplicateKeyError(err, c.RepositoryDefinition, c.detectDuplicateKey)
+		}
+	} else {
+		// Update item
+
+		var item interface{}
+		_, err = c.GetOne(filter, &item)
+		if err != nil {
+			return nil, err
+		}
+		res := item.(map[string]interface{})
+
+		query := c.Table.Update(hashKey, res[hashKey])
+		if rangeKey != "" {
+			query = query.Range(rangeKey, res[rangeKey])
+		}
+
+		for k, v := range *payload {
+			if k != hashKey && k != rangeKey {
+				query = query.Set(k, v)
+			}
+		}
+
+		var updatedItem map[string]interface{}
+		err = c.throttled(query.ValueWithContext(c.requestContext(), &updatedItem))
+		if err != nil {
+			return nil, err
+		}
+
+		payload = &updatedItem
+	}
+
+	// decode the item as stored into the object, so the generated fields are populated
+	err = MapToInterface(payload, object)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// SaveUpsert updates the item matching the filter or puts a new item. See Repository.SaveUpsert.
+// The item is looked up with a scan first, so two concurrent upserts of the same new item may
+// both report it as created. The put of the new item is conditioned on the hash key, so the
+// second put fails if the item has the same primary key.
+func (c *DynamoCollection) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	if len(filter) == 0 {
+		return nil, false, ErrInvalidInput("filter is required for upsert")
+	}
+
+	exists, err := c.Exists(filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists {
+		saved, err := c.Save(object, filter)
+		if err != nil {
+			return nil, false, err
+		}
+		if err = MapToInterface(saved, &object); err != nil {
+			return nil, false, err
+		}
+		return object, false, nil
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+	item := map[string]interface{}{}
+	for property, value := range upsertProperties(filter) {
+		item[property] = value
+	}
+	for property, value := range *payload {
+		item[property] = value
+	}
+
+	saved, err := c.Save(&item, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = MapToInterface(saved, &object); err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// SaveWithOpts creates new item or updates the existing one using the given write options.
+// DynamoDB acknowledges the writes only after they are durably stored, so WriteOpts.Durable
+// does not change the behaviour.
+func (c *DynamoCollection) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	return c.Save(object, filter)
+}
+
+// SaveWithTimestamp saves the object like Save. DynamoDB does not report the time of its writes,
+// so the returned time is of the client clock when the write returned, an approximation of the
+// time of the write.
+func (c *DynamoCollection) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	saved, err := c.Save(object, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return saved, time.Now(), nil
+}
+
+// SaveIf updates the item matching the filter only if the item also matches the condition.
+// The condition, the filter and the existence of the item are set as the ConditionExpression of
+// the update, so the check and the update are atomic, and an item deleted after it was read is
+// not created again. The bool result reports whether the update was applied. The result is the
+// object with the updated item decoded into it.
+func (c *DynamoCollection) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	if filter == nil {
+		return nil, false, ErrInvalidInput("filter is required for conditional save")
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	var item interface{}
+	_, err = c.GetOne(filter, &item)
+	if err != nil {
+		return nil, false, err
+	}
+	res := item.(map[string]interface{})
+
+	query := c.Table.Update(hashKey, res[hashKey])
+	if rangeKey != "" {
+		query = query.Range(rangeKey, res[rangeKey])
+	}
+
+	for k, v := range *payload {
+		if k != hashKey && k != rangeKey {
+			query = query.Set(k, v)
+		}
+	}
+
+	// the item must still exist and match the filter when it is updated, so an item deleted or
+	// changed after it was read is not created or updated by the UpdateItem
+	conditions, args := []string{"attribute_exists($)"}, []interface{}{hashKey}
+	for _, conditionFilter := range []Filter{filter, condition} {
+		filterConditions, filterArgs, err := conditionExpression(conditionFilter)
+		if err != nil {
+			return nil, false, err
+		}
+		conditions = append(conditions, filterConditions...)
+		args = append(args, filterArgs...)
+	}
+	query = query.If(strings.Join(conditions, " AND "), args...)
+
+	var updatedItem map[string]interface{}
+	err = c.throttled(query.ValueWithContext(c.requestContext(), &updatedItem))
+	if err != nil {
+		if IsConditionalCheckErr(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if err = MapToInterface(&updatedItem, object); err != nil {
+		return nil, false, err
+	}
+
+	return object, true, nil
+}
+
+// FindAndModify claims up to limit items matching the filter, in the order of the sort keys.
+// DynamoDB has no multi-item find-and-modify, so the matching items are scanned and sorted
+// first, then each of them is updated with an UpdateItem conditioned on the filter, returning
+// the updated item. The items changed by a concurrent claim in between fail the condition and
+// are skipped, so they are not claimed twice. See Repository.FindAndModify.
+func (c *DynamoCollection) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	if err := checkFindAndModify(update, limit, hashKey, rangeKey); err != nil {
+		return nil, err
+	}
+
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return nil, err
+	}
+	conditions, conditionArgs, err := conditionExpression(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []map[string]interface{}{}
+	if err = c.throttled(c.Table.Scan().Filter(query, args...).Consistent(true).AllWithContext(c.requestContext(), &candidates)); err != nil {
+		return nil, err
+	}
+	sortRecordsByKeys(candidates, sort)
+
+	claimed := []map[string]interface{}{}
+	for _, candidate := range candidates {
+		if len(claimed) == limit {
+			break
+		}
+
+		itemUpdate := c.Table.Update(hashKey, candidate[hashKey])
+		if rangeKey != "" {
+			itemUpdate = itemUpdate.Range(rangeKey, candidate[rangeKey])
+		}
+		for k, v := range update {
+			itemUpdate = itemUpdate.Set(k, v)
+		}
+		if len(conditions) > 0 {
+			itemUpdate = itemUpdate.If(strings.Join(conditions, " AND "), conditionArgs...)
+		}
+
+		var updatedItem map[string]interface{}
+		if err = c.throttled(itemUpdate.ValueWithContext(c.requestContext(), &updatedItem)); err != nil {
+			if IsConditionalCheckErr(err) {
+				// claimed or changed by someone else since the scan
+				continue
+			}
+			return nil, err
+		}
+		claimed = append(claimed, updatedItem)
+	}
+	return claimed, nil
+}
+
+// UpdateFieldsReturning sets the fields on all the items matching the filter and returns their IDs.
+// The items are updated one at a time, like the claims of FindAndModify: the matching items are
+// scanned first, then each of them is updated with an UpdateItem conditioned on the filter. The
+// items changed to no longer match the filter since the scan are skipped.
+func (c *DynamoCollection) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+	if err := checkUpdateFields(fields, hashKey, rangeKey, c.RepositoryDefinition.GetIDField()); err != nil {
+		return nil, err
+	}
+
+	// FindAndModify with no limit on the number of items
+	updated, err := c.FindAndModify(filter, fields, math.MaxInt32, nil)
+	if err != nil {
+		return nil, err
+	}
+	ids := []interface{}{}
+	for _, item := range updated.([]map[string]interface{}) {
+		ids = append(ids, item[c.RepositoryDefinition.GetIDField()])
+	}
+	return ids, nil
+}
+
+// ReplaceOne replaces the item matching the filter with the object and returns the previous item.
+// The item is looked up with the filter first, then replaced with a PutItem that returns the old
+// item (ReturnValues=ALL_OLD) and is conditioned on the item still existing.
+func (c *DynamoCollection) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	var item interface{}
+	_, err = c.GetOne(filter, &item)
+	if err != nil {
+		return nil, err
+	}
+	res := item.(map[string]interface{})
+
+	// the replacement keeps the primary key of the replaced item
+	(*payload)[hashKey] = res[hashKey]
+	if rangeKey != "" {
+		(*payload)[rangeKey] = res[rangeKey]
+	}
+
+	var old map[string]interface{}
+	err = c.throttled(c.Table.Put(payload).If("attribute_exists($)", hashKey).OldValueWithContext(c.requestContext(), &old))
+	if err != nil {
+		if IsConditionalCheckErr(err) {
+			return nil, ErrNotFound("record not found")
+		}
+		return nil, err
+	}
+
+	previous, err := CreateNewAsExample(object)
+	if err != nil {
+		return nil, err
+	}
+	if err = MapToInterface(&old, &previous); err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// UpsertAll inserts or updates the objects, matching the existing items by the values of the
+// conflict keys. See Repository.UpsertAll for details.
+// DynamoDB has no batch update, so the objects are written one by one. When the conflict keys are
+// the primary key of the table, each object is written with a single UpdateItem, which creates the
+// item if it does not exist. Otherwise the existing item is looked up with a scan and the new items
+// are created with a conditional put. Two concurrent upserts of the same new object on a
+// non-primary key may then both insert it.
+func (c *DynamoCollection) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	upserted := []map[string]interface{}{}
+
+	for i, object := range objects {
+		record, err := c.upsert(object, conflictKeys)
+		if err != nil {
+			return nil, UpsertError{Row: i, Cause: err}
+		}
+		upserted = append(upserted, record)
+	}
+
+	return upsertResults(objects, upserted, conflictKeys)
+}
+
+// upsert writes one object for UpsertAll and returns the written item.
+func (c *DynamoCollection) upsert(object interface{}, conflictKeys []string) (map[string]interface{}, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := conflictFilter(*payload, conflictKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+	primaryKey := []string{hashKey}
+	if rangeKey != "" {
+		primaryKey = append(primaryKey, rangeKey)
+	}
+
+	if len(conflictKeys) == len(primaryKey) && containsString(conflictKeys, hashKey) && (rangeKey == "" || containsString(conflictKeys, rangeKey)) {
+		query := c.Table.Update(hashKey, (*payload)[hashKey])
+		if rangeKey != "" {
+			query = query.Range(rangeKey, (*payload)[rangeKey])
+		}
+		for k, v := range *payload {
+			if k != hashKey && k != rangeKey {
+				query = query.Set(k, v)
+			}
+		}
+
+		var updatedItem map[string]interface{}
+		if err = c.throttled(query.ValueWithContext(c.requestContext(), &updatedItem)); err != nil {
+			return nil, err
+		}
+		return updatedItem, nil
+	}
+
+	exists, err := c.Exists(filter)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		filter = nil // create new item
+	}
+
+	// save the copy of the object, so the item is decoded into the map, not into the object
+	if _, err = c.Save(payload, filter); err != nil {
+		return nil, err
+	}
+	return *payload, nil
+}
+
+// DeleteOne deletes only one item at the time
+// Example filter:
+//	filter := map[string]interface{}{
+// 		"email": "keitaro-user1@keitaro.com",
+// }
+func (c *DynamoCollection) DeleteOne(filter Filter) error {
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	var item interface{}
+	_, err := c.GetOne(filter, &item)
+	if err != nil {
+		return err
+	}
+	result := item.(map[string]interface{})
+
+	query := c.Table.Delete(hashKey, result[hashKey])
+
+	if rangeKey != "" {
+		query = query.Range(rangeKey, result[rangeKey])
+	}
+
+	var old map[string]interface{}
+	err = c.throttled(query.OldValueWithContext(c.requestContext(), &old))
+	if err != nil {
+		if err == dynamo.ErrNotFound {
+			return ErrNotFound(err)
+		}
+		return err
 	}
 
 	return nil
 }
 
+// DeleteIf deletes the item matching the filter only if the item also matches the condition.
+// The item is looked up by the filter, then the existence of the item, the filter and the
+// condition are set as the ConditionExpression of the delete, so the check and the delete are
+// atomic: an item changed or deleted after the lookup is not deleted and false is returned.
+func (c *DynamoCollection) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	if filter == nil {
+		return false, ErrInvalidInput("filter is required for conditional delete")
+	}
+
+	hashKey := c.RepositoryDefinition.GetHashKey()
+	rangeKey := c.RepositoryDefinition.GetRangeKey()
+
+	var item interface{}
+	_, err := c.GetOne(filter, &item)
+	if err != nil {
+		return false, err
+	}
+	result := item.(map[string]interface{})
+
+	query := c.Table.Delete(hashKey, result[hashKey])
+	if rangeKey != "" {
+		query = query.Range(rangeKey, result[rangeKey])
+	}
+
+	// the item must still exist and match the filter when it is deleted, so an item deleted or
+	// changed after it was read is not reported as deleted
+	conditions, args := []string{"attribute_exists($)"}, []interface{}{hashKey}
+	for _, conditionFilter := range []Filter{filter, condition} {
+		filterConditions, filterArgs, err := conditionExpression(conditionFilter)
+		if err != nil {
+			return false, err
+		}
+		conditions = append(conditions, filterConditions...)
+		args = append(args, filterArgs...)
+	}
+	query = query.If(strings.Join(conditions, " AND "), args...)
+
+	err = c.throttled(query.RunWithContext(c.requestContext()))
+	if err != nil {
+		if IsConditionalCheckErr(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // DeleteAll deletes batch of items
 // Example filter:
 // filter := map[string]interface{}{
@@ -498,20 +1591,28 @@ func (c *DynamoCollection) DeleteOne(filter Filter) error {
 // 		}
 // email is the hash key, id is the range key
 func (c *DynamoCollection) DeleteAll(filter Filter) error {
+	_, err := c.DeleteAllReturning(filter)
+	return err
+}
+
+// DeleteAllReturning deletes the items like DeleteAll and returns their IDs, the values of the ID
+// field of the items read before they are deleted.
+func (c *DynamoCollection) DeleteAllReturning(filter Filter) ([]interface{}, error) {
 	hashKey := c.RepositoryDefinition.GetHashKey()
 	rangeKey := c.RepositoryDefinition.GetRangeKey()
 
 	if _, ok := filter[hashKey]; !ok {
-		return ErrInvalidInput("range hash key must be provided")
+		return nil, ErrInvalidInput("range hash key must be provided")
 	}
 
+	deleted := []interface{}{}
 	batchSize := 128
 	offset := 0
 
 	for {
-		resultsIntf, err := c.GetAll(filter, &map[string]interface{}{}, hashKey, "ascending", batchSize, offset)
+		resultsIntf, err := c.GetAll(filter, &map[string]interface{}{}, hashKey, Ascending.String(), batchSize, offset)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		results := resultsIntf.([]*map[string]interface{})
 
@@ -525,15 +1626,563 @@ func (c *DynamoCollection) DeleteAll(filter Filter) error {
 				delFilter = delFilter.Match(rangeKey, (*result)[rangeKey])
 			}
 			if err = c.DeleteOne(delFilter); err != nil {
-				return err
+				return nil, err
 			}
+			deleted = append(deleted, (*result)[c.RepositoryDefinition.GetIDField()])
 		}
 		offset += len(results)
 	}
 
+	return deleted, nil
+}
+
+// Exists checks if there is at least one item matching the filter.
+// Only the hash key of the first matched item is projected, the item itself is not fetched.
+func (c *DynamoCollection) Exists(filter Filter) (bool, error) {
+	var records []map[string]interface{}
+
+	indexed, err := c.indexedQuery(filter, c.consistent)
+	if err != nil {
+		return false, err
+	}
+	if indexed != nil {
+		err = c.throttled(indexed.Project(c.RepositoryDefinition.GetHashKey()).Limit(1).AllWithContext(c.requestContext(), &records))
+		if err != nil && err != dynamo.ErrNotFound {
+			return false, err
+		}
+		return len(records) > 0, nil
+	}
+
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.throttled(c.Table.Scan().Filter(query, args...).Project(c.RepositoryDefinition.GetHashKey()).Consistent(c.consistent).Limit(int64(1)).AllWithContext(c.requestContext(), &records))
+	if err != nil {
+		return false, err
+	}
+
+	return len(records) > 0, nil
+}
+
+// Count returns the number of items matching the filter.
+// The count requires a full scan of the table.
+func (c *DynamoCollection) Count(filter Filter) (int, error) {
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := c.Table.Scan().Filter(query, args...).Consistent(c.consistent).CountWithContext(c.requestContext())
+	if err != nil {
+		return 0, c.throttled(err)
+	}
+
+	return int(count), nil
+}
+
+// CountBoth returns the number of items matching the filter and the number of all the items. The
+// items are counted with a scan of the table for each count, or with one scan for an empty
+// filter.
+func (c *DynamoCollection) CountBoth(filter Filter) (int64, int64, error) {
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total, err := c.Table.Scan().Consistent(c.consistent).CountWithContext(c.requestContext())
+	if err != nil {
+		return 0, 0, c.throttled(err)
+	}
+	if query == "" {
+		return total, total, nil
+	}
+
+	filtered, err := c.Table.Scan().Filter(query, args...).Consistent(c.consistent).CountWithContext(c.requestContext())
+	if err != nil {
+		return 0, 0, c.throttled(err)
+	}
+	return filtered, total, nil
+}
+
+// Dump returns the scan of the operation with the filter expression. DynamoDB cannot delete by a
+// filter, so the delete is the scan of the items deleted one at a time.
+func (c *DynamoCollection) Dump(op string, filter Filter) (string, error) {
+	if err := checkDumpOperation(op); err != nil {
+		return "", err
+	}
+	query, args, err := c.filterExpression(filter)
+	if err != nil {
+		return "", err
+	}
+
+	scan := map[string]string{DumpFind: "Scan", DumpCount: "Scan COUNT", DumpDelete: "Scan (DeleteItem each)"}[op]
+	if query == "" {
+		return fmt.Sprintf("%s %s", scan, c.Table.Name()), nil
+	}
+	return fmt.Sprintf("%s %s FILTER %s", scan, c.Table.Name(), dumpDynamoExpression(query, args)), nil
+}
+
+// dynamoBatchGetLimit is the maximal number of keys in one BatchGetItem request.
+const dynamoBatchGetLimit = 100
+
+// GetByIDs fetches the items with the given hash keys with BatchGetItem and returns them in
+// the same order as the IDs. The keys are requested in chunks of 100, or of the batch size of
+// the definition if it is smaller, and the duplicated keys are requested only once. The result is a pointer to a slice with nil for each ID that was
+// not found. Tables with a range key are not supported, as the items cannot be identified
+// by the hash key only.
+func (c *DynamoCollection) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	if c.RepositoryDefinition.GetRangeKey() != "" {
+		return nil, ErrInvalidInput("GetByIDs is not supported on tables with a range key")
+	}
+	hashKey := c.RepositoryDefinition.GetHashKey()
+
+	keys := []dynamo.Keyed{}
+	requested := map[string]bool{}
+	for _, id := range ids {
+		if requested[idKey(id)] {
+			continue
+		}
+		requested[idKey(id)] = true
+		keys = append(keys, dynamo.Keys{id})
+	}
+
+	chunkSize := batchSizeOr(c.RepositoryDefinition, dynamoBatchGetLimit)
+	if chunkSize > dynamoBatchGetLimit {
+		chunkSize = dynamoBatchGetLimit
+	}
+
+	records := map[string]map[string]interface{}{}
+	for start := 0; start < len(keys); start += chunkSize {
+		end := start + chunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var found []map[string]interface{}
+		err := c.throttled(c.Table.Batch(hashKey).Get(keys[start:end]...).Consistent(c.consistent).AllWithContext(c.requestContext(), &found))
+		if err != nil && err != dynamo.ErrNotFound {
+			return nil, err
+		}
+		for _, record := range found {
+			records[idKey(record[hashKey])] = record
+		}
+	}
+
+	return orderByIDs(ids, records, resultHint)
+}
+
+// LastQueryStats returns the stats of the most recent GetAll or GetAllByIndex of the collection
+// instance. The DynamoDB client does not expose the scanned count, so ItemsScanned is not
+// reported. ConsumedCapacity is the total capacity consumed by the scan or query requests, which
+// grows with the data read, including the items dropped by the filter expression.
+func (c *DynamoCollection) LastQueryStats() QueryStats {
+	return c.stats.get()
+}
+
+// DescribeRepository returns the item count, the size and the provisioned capacity of the live
+// table from DescribeTable. DynamoDB does not report the consumed capacity of the table there, it
+// is available in the CloudWatch metrics of the table.
+func (c *DynamoCollection) DescribeRepository() (RepositoryStats, error) {
+	description, err := c.Table.Describe().RunWithContext(c.requestContext())
+	if err != nil {
+		return RepositoryStats{}, err
+	}
+	return RepositoryStats{
+		ItemCount:     description.Items,
+		SizeBytes:     description.Size,
+		ReadCapacity:  description.Throughput.Read,
+		WriteCapacity: description.Throughput.Write,
+	}, nil
+}
+
+// EnsureIndexes creates the global secondary indexes that the table does not have. The first
+// field of an index is the hash key of the GSI and the optional second field is its range key.
+// The GSI is named after the hash key ("email-index"), like the GSIs of the definition, and
+// DynamoDB has no unique constraints other than the primary key, so the index names and the
+// unique flags are ignored. The key types, the projection and the capacity are taken from the
+// GSI definition with the same hash key if there is one; otherwise the keys are strings, all the
+// attributes are projected and the capacity is the capacity of the table.
+// DynamoDB creates one index at a time, so the table is waited on to become active after each
+// created index. The GSIs of the table that are not in the list are logged and kept.
+func (c *DynamoCollection) EnsureIndexes(indexes []Index) error {
+	description, err := c.Table.Describe().RunWithContext(c.requestContext())
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, gsi := range description.GSI {
+		existing[gsi.Name] = true
+	}
+
+	gsiDefs, err := c.RepositoryDefinition.GetGSIDefs()
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, index := range indexes {
+		gsi, err := c.gsiForIndex(index, gsiDefs)
+		if err != nil {
+			return err
+		}
+		wanted[gsi.Name] = true
+		if existing[gsi.Name] {
+			continue
+		}
+
+		_, err = c.Table.UpdateTable().CreateIndex(dynamo.Index{
+			Name:           gsi.Name,
+			HashKey:        gsi.HashKey,
+			HashKeyType:    dynamo.KeyType(gsi.HashKeyType),
+			RangeKey:       gsi.RangeKey,
+			RangeKeyType:   dynamo.KeyType(gsi.RangeKeyType),
+			ProjectionType: dynamo.IndexProjection(gsi.Projection),
+			Throughput: dynamo.Throughput{
+				Read:  gsi.ReadCapacity,
+				Write: gsi.WriteCapacity,
+			},
+		}).RunWithContext(c.requestContext())
+		if err != nil {
+			return err
+		}
+		existing[gsi.Name] = true
+
+		if err = c.waitUntilActive(); err != nil {
+			return err
+		}
+	}
+
+	for name := range existing {
+		if !wanted[name] {
+			c.logger.Debugf("The index %s of %s is not in the ensured indexes and is kept", name, c.Table.Name())
+		}
+	}
 	return nil
 }
 
+// gsiForIndex builds the GSI definition for the index.
+func (c *DynamoCollection) gsiForIndex(index Index, gsiDefs []GSIDefinition) (GSIDefinition, error) {
+	fields := index.GetFields()
+	if len(fields) == 0 || len(fields) > 2 {
+		return GSIDefinition{}, ErrInvalidInput(fmt.Sprintf("the index %s must have a hash key and an optional range key", index.GetName()))
+	}
+
+	for _, gsi := range gsiDefs {
+		if gsi.HashKey == fields[0] {
+			if len(fields) == 2 {
+				gsi.RangeKey = fields[1]
+			}
+			return gsi, nil
+		}
+	}
+
+	gsi := GSIDefinition{
+		Name:          fmt.Sprintf("%s-index", fields[0]),
+		HashKey:       fields[0],
+		HashKeyType:   "S",
+		RangeKeyType:  "S",
+		Projection:    "ALL",
+		ReadCapacity:  c.RepositoryDefinition.GetReadCapacity(),
+		WriteCapacity: c.RepositoryDefinition.GetWriteCapacity(),
+	}
+	if len(fields) == 2 {
+		gsi.RangeKey = fields[1]
+	}
+	return gsi, nil
+}
+
+// dynamoIndexPollInterval is the interval of checking if the table is active.
+var dynamoIndexPollInterval = 5 * time.Second
+
+// waitUntilActive waits until the table and all of its indexes are active, or until the
+// request context is done.
+func (c *DynamoCollection) waitUntilActive() error {
+	ctx := c.requestContext()
+	for {
+		description, err := c.Table.Describe().RunWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		active := description.Status == dynamo.ActiveStatus
+		for _, gsi := range description.GSI {
+			active = active && gsi.Status == dynamo.ActiveStatus
+		}
+		if active {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dynamoIndexPollInterval):
+		}
+	}
+}
+
+// RequestIDHeader is the HTTP header of the DynamoDB requests with the request ID of their
+// context (see WithRequestID), so the request can be traced in the logs of the proxies.
+const RequestIDHeader = "X-Request-Id"
+
+// dynamoRequestIDHandler sets the request ID of the context of a DynamoDB request as its
+// RequestIDHeader. It is added to the session of the backends built by DynamoDBBackendBuilder.
+var dynamoRequestIDHandler = request.NamedHandler{
+	Name: "backends.RequestIDHandler",
+	Fn: func(r *request.Request) {
+		if requestID, ok := RequestIDFromContext(r.Context()); ok {
+			r.HTTPRequest.Header.Set(RequestIDHeader, requestID)
+		}
+	},
+}
+
+// WithContext returns a copy of the table whose requests to DynamoDB are made with the
+// given context. The request ID of the context (see WithRequestID) is sent as the
+// RequestIDHeader of the requests, and the other request-scoped values are available to the AWS
+// SDK request handlers through the request context, so they can be logged.
+func (c *DynamoCollection) WithContext(ctx context.Context) Repository {
+	return &DynamoCollection{
+		Table:                c.Table,
+		RepositoryDefinition: c.RepositoryDefinition,
+		ctx:                  ctx,
+		backend:              c.backend,
+		consistent:           c.consistent,
+		stats:                newQueryStatsRecorder(),
+		logger:               c.logger,
+	}
+}
+
+// Bulk returns a BulkOp that executes the operations one at a time: the inserts are puts, the
+// updates and deletes scan for the matching items first, like UpdateFieldsReturning and
+// DeleteAllReturning.
+func (c *DynamoCollection) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		return executeBulkOneByOne(c, operations)
+	})
+}
+
+// WithSession returns a copy of the table whose reads are strongly consistent. The queries of a
+// global secondary index (GetAllByIndex) cannot be strongly consistent, so they may not observe
+// the latest writes of the session.
+func (c *DynamoCollection) WithSession(session *Session) Repository {
+	return &DynamoCollection{
+		Table:                c.Table,
+		RepositoryDefinition: c.RepositoryDefinition,
+		ctx:                  c.ctx,
+		backend:              c.backend,
+		consistent:           true,
+		stats:                newQueryStatsRecorder(),
+		logger:               c.logger,
+	}
+}
+
+// requestContext returns the context for the requests to DynamoDB, with the values of the
+// backend context.
+func (c *DynamoCollection) requestContext() context.Context {
+	return withBackendContext(c.ctx, c.backend)
+}
+
+// dynamoComparisonOperators maps the filter comparison operators to dynamoDB operators.
+var dynamoComparisonOperators = map[string]string{
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// filterExpression builds the scan filter expression and its arguments for the given filter.
+// If TTL is enabled on the table, the expired items are filtered out as well.
+func (c *DynamoCollection) filterExpression(filter Filter) (string, []interface{}, error) {
+	query, args, err := conditionExpression(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if c.RepositoryDefinition.EnableTTL() {
+		query = append(query, "$ > ?")
+		args = append(args, c.RepositoryDefinition.GetTTLAttribute())
+		args = append(args, time.Now())
+	}
+
+	return strings.Join(query, " AND "), args, nil
+}
+
+// conditionExpression builds the conditions and their arguments for the given filter.
+// The conditions should be joined with AND.
+func conditionExpression(filter Filter) ([]string, []interface{}, error) {
+	var query []string
+	var args []interface{}
+	for k, v := range filter {
+		if k == TextOperator {
+			return nil, nil, ErrUnsupported("DynamoDB does not support text search")
+		}
+		if k == NotOperator {
+			negated, err := negatedFilter(v)
+			if err != nil {
+				return nil, nil, ErrInvalidInput(err)
+			}
+			negatedQuery, negatedArgs, err := conditionExpression(negated)
+			if err != nil {
+				return nil, nil, err
+			}
+			query = append(query, fmt.Sprintf("NOT (%s)", strings.Join(negatedQuery, " AND ")))
+			args = append(args, negatedArgs...)
+			continue
+		}
+		if k == OrOperator {
+			alternatives, err := alternativeFilters(v)
+			if err != nil {
+				return nil, nil, ErrInvalidInput(err)
+			}
+			alternativeQueries := make([]string, len(alternatives))
+			for i, alternative := range alternatives {
+				alternativeQuery, alternativeArgs, err := conditionExpression(alternative)
+				if err != nil {
+					return nil, nil, err
+				}
+				alternativeQueries[i] = fmt.Sprintf("(%s)", strings.Join(alternativeQuery, " AND "))
+				args = append(args, alternativeArgs...)
+			}
+			query = append(query, fmt.Sprintf("(%s)", strings.Join(alternativeQueries, " OR ")))
+			continue
+		}
+		if specs, ok := operatorSpecs(v); ok {
+			for operator, operand := range specs {
+				if operator == "$any" {
+					// no constraint on the property
+					continue
+				}
+				if operator == "$pattern" {
+					pattern, ok := operand.(string)
+					if !ok {
+						return nil, nil, ErrInvalidInput(fmt.Sprintf("pattern for %s must be a string", k))
+					}
+					for _, cond := range patternToDynamodbCondition(pattern) {
+						query = append(query, fmt.Sprintf("$ %s ?", cond.condition))
+						args = append(args, k)
+						args = append(args, cond.value)
+					}
+					continue
+				}
+				if operator == "$startsWith" || operator == "$endsWith" {
+					affix, ok := operand.(string)
+					if !ok {
+						return nil, nil, ErrInvalidInput(fmt.Sprintf("%s for %s must be a string", operator, k))
+					}
+					function := "begins_with"
+					if operator == "$endsWith" {
+						function = "contains"
+					}
+					query = append(query, fmt.Sprintf("%s($, ?)", function))
+					args = append(args, k, affix)
+					continue
+				}
+				if operator == "$in" {
+					values, ok := inValues(operand)
+					if !ok || len(values) == 0 {
+						return nil, nil, ErrInvalidInput(fmt.Sprintf("values for %s must be a non-empty list", k))
+					}
+					placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+					query = append(query, fmt.Sprintf("$ IN (%s)", placeholders))
+					args = append(args, k)
+					args = append(args, values...)
+					continue
+				}
+				if operator == "$fieldCmp" {
+					fieldOperator, otherKey, err := fieldComparison(operand)
+					if err != nil {
+						return nil, nil, ErrInvalidInput(err)
+					}
+					if fieldOperator == "!=" {
+						fieldOperator = "<>"
+					}
+					// comparisons with a missing attribute are false, but <> is not, so the
+					// attributes are checked to exist
+					query = append(query, fmt.Sprintf("attribute_exists($) AND attribute_exists($) AND $ %s $", fieldOperator))
+					args = append(args, k, otherKey, k, otherKey)
+					continue
+				}
+				if operator == "$mod" {
+					return nil, nil, ErrUnsupported("DynamoDB does not support modulo conditions")
+				}
+				if operator == "$near" || operator == "$withinBox" {
+					return nil, nil, ErrUnsupported("DynamoDB does not support geospatial queries")
+				}
+				if operator == "$contains" || operator == "$all" {
+					values := []interface{}{operand}
+					if operator == "$all" {
+						var ok bool
+						if values, ok = inValues(operand); !ok || len(values) == 0 {
+							return nil, nil, ErrInvalidInput(fmt.Sprintf("values for %s must be a non-empty list", k))
+						}
+					}
+					// contains() on a string attribute matches a substring, so the strings are excluded
+					query = append(query, "NOT attribute_type($, ?)")
+					args = append(args, k, "S")
+					for _, value := range values {
+						query = append(query, "contains($, ?)")
+						args = append(args, k, value)
+					}
+					continue
+				}
+				dynamoOperator, ok := dynamoComparisonOperators[operator]
+				if !ok {
+					return nil, nil, ErrInvalidInput(fmt.Sprintf("unknown filter operator %s", operator))
+				}
+				query = append(query, fmt.Sprintf("$ %s ?", dynamoOperator))
+				args = append(args, k)
+				args = append(args, operand)
+			}
+			continue
+		}
+		if v == nil {
+			// IS NULL matches the NULL attributes and the missing ones, like on the other backends
+			query = append(query, "(attribute_not_exists($) OR attribute_type($, ?))")
+			args = append(args, k, k, "NULL")
+			continue
+		}
+		query = append(query, "$ = ?")
+		args = append(args, k)
+		args = append(args, v)
+	}
+
+	return query, args, nil
+}
+
+// detectDuplicateKey is the DuplicateKeyDetector for DynamoDB. The only unique constraint in
+// DynamoDB is the primary key, which is checked with a condition on the hash key when putting new items.
+func (c *DynamoCollection) detectDuplicateKey(err error) (string, bool) {
+	if !IsConditionalCheckErr(err) {
+		return "", false
+	}
+	return c.RepositoryDefinition.GetHashKey(), true
+}
+
+// dynamoThrottlingCodes are the codes of the errors of DynamoDB throttling the requests: the
+// provisioned throughput of the table or an index exceeded, and the request rate of the account
+// exceeded.
+var dynamoThrottlingCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"RequestLimitExceeded":                   true,
+	"ThrottlingException":                    true,
+}
+
+// detectThrottling is the ThrottlingDetector for DynamoDB. DynamoDB does not suggest a delay
+// before the retry.
+func detectThrottling(err error) (time.Duration, bool) {
+	if ae, ok := err.(awserr.Error); ok {
+		return 0, dynamoThrottlingCodes[ae.Code()]
+	}
+	return 0, false
+}
+
+// throttled converts the throttling errors of DynamoDB to ThrottledError. The AWS SDK retries the
+// throttled requests on its own first, so these are the requests still throttled after the
+// retries of the SDK.
+func (c *DynamoCollection) throttled(err error) error {
+	return WrapThrottlingError(err, detectThrottling)
+}
+
 func patternToDynamodbCondition(pattern string) []*patternCondition {
 	conditions := []*patternCondition{}
 
@@ -1,7 +1,19 @@
 package backends
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
 )
 
 func TestTokenize(t *testing.T) {
@@ -194,3 +206,563 @@ func TestPatternToDynamoDBCondition(t *testing.T) {
 		t.Fatal("Invalid conditions. Got: ", conds)
 	}
 }
+
+func TestDynamoIndexQuery(t *testing.T) {
+	def := RepositoryDefinitionMap{
+		"name":     "tokens",
+		"hashKey":  "id",
+		"rangeKey": "token",
+		"GSI": map[string]interface{}{
+			"token": map[string]interface{}{
+				"readCapacity":  2,
+				"writeCapacity": 2,
+			},
+		},
+	}
+
+	for _, indexName := range []string{"token", "token-index"} {
+		query, err := newDynamoIndexQuery(def, indexName, NewFilter().Match("token", "abc").Match("active", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.index != "token-index" {
+			t.Errorf("Expected to query token-index, got %s", query.index)
+		}
+		if query.attribute != "token" || query.value != "abc" {
+			t.Errorf("Expected key condition token = abc, got %s = %v", query.attribute, query.value)
+		}
+		if len(query.filter) != 1 || query.filter["active"] != true {
+			t.Errorf("Expected the remaining filter to hold only the non-key properties, got %v", query.filter)
+		}
+	}
+
+	if _, err := newDynamoIndexQuery(def, "email-index", NewFilter().Match("email", "abc")); !IsErrInvalidInput(err) {
+		t.Error("Expected an error for unknown index. Got: ", err)
+	}
+	if _, err := newDynamoIndexQuery(def, "token-index", NewFilter().Match("active", true)); !IsErrInvalidInput(err) {
+		t.Error("Expected an error when the key attribute is not in the filter. Got: ", err)
+	}
+	if _, err := newDynamoIndexQuery(def, "token-index", NewFilter().MatchPattern("token", "ab%")); !IsErrInvalidInput(err) {
+		t.Error("Expected an error when the key attribute is not matched exactly. Got: ", err)
+	}
+}
+
+// fakeDynamoDB serves the DescribeTable requests of the DynamoDB API with the given table description.
+func fakeDynamoDB(t *testing.T, table map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "DynamoDB_20120810.DescribeTable" {
+			t.Errorf("Unexpected DynamoDB operation %s", target)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": table})
+	}))
+}
+
+func TestDynamoDescribeRepository(t *testing.T) {
+	server := fakeDynamoDB(t, map[string]interface{}{
+		"TableName":      "users",
+		"TableStatus":    "ACTIVE",
+		"ItemCount":      42,
+		"TableSizeBytes": 2048,
+		"KeySchema": []map[string]interface{}{
+			{"AttributeName": "id", "KeyType": "HASH"},
+		},
+		"AttributeDefinitions": []map[string]interface{}{
+			{"AttributeName": "id", "AttributeType": "S"},
+		},
+		"ProvisionedThroughput": map[string]interface{}{
+			"ReadCapacityUnits":  5,
+			"WriteCapacityUnits": 10,
+		},
+	})
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := dynamo.New(sess).Table("users")
+	repo := &DynamoCollection{
+		Table:                &table,
+		RepositoryDefinition: RepositoryDefinitionMap{"name": "users"},
+	}
+
+	stats, err := repo.DescribeRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RepositoryStats{ItemCount: 42, SizeBytes: 2048, ReadCapacity: 5, WriteCapacity: 10}
+	if stats != expected {
+		t.Fatalf("Expected the stats of the live table %+v. Got: %+v", expected, stats)
+	}
+}
+
+func TestDynamoRequestIDHeader(t *testing.T) {
+	var requestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(RequestIDHeader)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": map[string]interface{}{"TableName": "users"}})
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Handlers.Build.PushBackNamed(dynamoRequestIDHandler)
+	table := dynamo.New(sess).Table("users")
+	repo := &DynamoCollection{
+		Table:                &table,
+		RepositoryDefinition: RepositoryDefinitionMap{"name": "users"},
+	}
+
+	scoped := repo.WithContext(WithRequestID(context.Background(), "req-0001")).(*DynamoCollection)
+	if _, err = scoped.DescribeRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "req-0001" {
+		t.Fatalf("Expected the request ID in the %s header. Got: %q", RequestIDHeader, requestID)
+	}
+
+	if _, err = repo.DescribeRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "" {
+		t.Fatal("Expected no request ID without one in the context. Got: ", requestID)
+	}
+}
+
+func TestConditionExpressionNull(t *testing.T) {
+	query, args, err := conditionExpression(NewFilter().Match("email", nil).MatchAny("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(query, []string{"(attribute_not_exists($) OR attribute_type($, ?))"}) {
+		t.Fatal("Expected only the IS NULL condition. Got: ", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"email", "email", "NULL"}) {
+		t.Fatal("Unexpected arguments: ", args)
+	}
+}
+
+func TestConditionExpressionAnyFieldMatches(t *testing.T) {
+	query, args, err := conditionExpression(NewFilter().AnyFieldMatches("john", "name", "email"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(query, []string{"(($ = ?) OR ($ = ?))"}) {
+		t.Fatal("Expected the alternatives joined with OR. Got: ", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"name", "john", "email", "john"}) {
+		t.Fatal("Unexpected arguments: ", args)
+	}
+}
+
+func TestConditionExpressionTextSearch(t *testing.T) {
+	if _, _, err := conditionExpression(NewFilter().TextSearch("coffee", "title")); !IsErrUnsupported(err) {
+		t.Fatal("Expected the text search to be unsupported. Got: ", err)
+	}
+}
+
+func TestRangeKeyCondition(t *testing.T) {
+	operator, values, err := rangeKeyCondition("$between", []interface{}{"2020-01-01", "2020-12-31"})
+	if err != nil || operator != dynamo.Between || len(values) != 2 {
+		t.Fatal("Expected the BETWEEN condition with two values. Got: ", operator, values, err)
+	}
+	if operator, values, err = rangeKeyCondition("$gte", 10); err != nil || operator != dynamo.GreaterOrEqual || len(values) != 1 {
+		t.Fatal("Expected the GE condition with one value. Got: ", operator, values, err)
+	}
+	if _, _, err = rangeKeyCondition("$startsWith", 10); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for a prefix that is not a string. Got: ", err)
+	}
+	if _, _, err = rangeKeyCondition("$ne", 10); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for an unsupported operator. Got: ", err)
+	}
+}
+
+func TestDynamoQueryRange(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "DynamoDB_20120810.Query" {
+			t.Errorf("Expected a query instead of %s", target)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Count":        2,
+			"ScannedCount": 2,
+			"Items": []map[string]interface{}{
+				{"user": map[string]string{"S": "john"}, "day": map[string]string{"S": "2020-03-01"}},
+				{"user": map[string]string{"S": "john"}, "day": map[string]string{"S": "2020-06-01"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := dynamo.New(sess).Table("visits")
+	repo := &DynamoCollection{
+		Table:                &table,
+		RepositoryDefinition: RepositoryDefinitionMap{"name": "visits", "hashKey": "user", "rangeKey": "day"},
+		stats:                newQueryStatsRecorder(),
+		logger:               nopLogger{},
+	}
+
+	results, err := repo.QueryRange("john", "$between", []interface{}{"2020-01-01", "2020-12-31"}, &map[string]interface{}{}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition, _ := request["KeyConditionExpression"].(string)
+	if !strings.Contains(condition, "BETWEEN") {
+		t.Fatal("Expected the range condition in the key condition expression. Got: ", condition)
+	}
+	if _, scan := request["ScanFilter"]; scan {
+		t.Fatal("Expected a query instead of a scan")
+	}
+	visits := *results.(*[]*map[string]interface{})
+	if len(visits) != 1 || (*visits[0])["day"] != "2020-06-01" {
+		t.Fatal("Expected the items after the offset. Got: ", visits)
+	}
+}
+
+// fakeDynamoTable serves the item requests of the DynamoDB API (GetItem aside) on the items of
+// one table with the given hash key, in the order they were put. The conditions, the key
+// conditions and the filters are evaluated for their equalities and attribute_exists and
+// attribute_not_exists only, and the updates for their SET assignments.
+type fakeDynamoTable struct {
+	t       *testing.T
+	hashKey string
+	items   []map[string]interface{}
+	// requests are the bodies of the requests by their operation, like "UpdateItem"
+	requests map[string][]map[string]interface{}
+	// deleteAfterQuery deletes the items read by a query, like a concurrent delete
+	deleteAfterQuery bool
+	// afterQuery is called after a query, like a concurrent write
+	afterQuery func()
+}
+
+func newFakeDynamoTable(t *testing.T, hashKey string, items ...map[string]interface{}) *fakeDynamoTable {
+	return &fakeDynamoTable{t: t, hashKey: hashKey, items: items, requests: map[string][]map[string]interface{}{}}
+}
+
+// dynamoString is the attribute value of the string.
+func dynamoString(value string) map[string]interface{} {
+	return map[string]interface{}{"S": value}
+}
+
+// repository returns the repository of the table, served by a fake DynamoDB server.
+func (f *fakeDynamoTable) repository(def RepositoryDefinitionMap) (*DynamoCollection, func()) {
+	server := httptest.NewServer(f)
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	table := dynamo.New(sess).Table(def.GetName())
+	return &DynamoCollection{
+		Table:                &table,
+		RepositoryDefinition: def,
+		stats:                newQueryStatsRecorder(),
+		logger:               nopLogger{},
+	}, server.Close
+}
+
+var (
+	fakeDynamoEquality  = regexp.MustCompile(`(#\w+)\s*=\s*(:\w+)`)
+	fakeDynamoExists    = regexp.MustCompile(`attribute_exists\s*\(\s*(#\w+)\s*\)`)
+	fakeDynamoNotExists = regexp.MustCompile(`attribute_not_exists\s*\(\s*(#\w+)\s*\)`)
+)
+
+// matches evaluates the expression of the request on the item.
+func (f *fakeDynamoTable) matches(item map[string]interface{}, request map[string]interface{}, expressionKey string) bool {
+	expression, _ := request[expressionKey].(string)
+	names, _ := request["ExpressionAttributeNames"].(map[string]interface{})
+	values, _ := request["ExpressionAttributeValues"].(map[string]interface{})
+	name := func(placeholder string) string {
+		name, _ := names[placeholder].(string)
+		return name
+	}
+	for _, equality := range fakeDynamoEquality.FindAllStringSubmatch(expression, -1) {
+		if !reflect.DeepEqual(item[name(equality[1])], values[equality[2]]) {
+			return false
+		}
+	}
+	for _, exists := range fakeDynamoExists.FindAllStringSubmatch(expression, -1) {
+		if _, ok := item[name(exists[1])]; !ok {
+			return false
+		}
+	}
+	for _, notExists := range fakeDynamoNotExists.FindAllStringSubmatch(expression, -1) {
+		if _, ok := item[name(notExists[1])]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the index of the item with the key, or -1.
+func (f *fakeDynamoTable) find(key map[string]interface{}) int {
+	for i, item := range f.items {
+		if reflect.DeepEqual(item[f.hashKey], key[f.hashKey]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeDynamoTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	request := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		f.t.Error(err)
+	}
+	f.requests[operation] = append(f.requests[operation], request)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	conditionFailed := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		})
+	}
+	empty := map[string]interface{}{}
+
+	switch operation {
+	case "Scan", "Query":
+		start := 0
+		if startKey, ok := request["ExclusiveStartKey"].(map[string]interface{}); ok {
+			start = f.find(startKey) + 1
+		}
+		limit := len(f.items)
+		if requestLimit, ok := request["Limit"].(float64); ok {
+			limit = int(requestLimit)
+		}
+		items, evaluated := []map[string]interface{}{}, 0
+		var lastKey map[string]interface{}
+		for _, item := range f.items[start:] {
+			if evaluated == limit {
+				break
+			}
+			evaluated++
+			if evaluated == limit {
+				lastKey = map[string]interface{}{f.hashKey: item[f.hashKey]}
+			}
+			if f.matches(item, request, "KeyConditionExpression") && f.matches(item, request, "FilterExpression") {
+				items = append(items, item)
+			}
+		}
+		response := map[string]interface{}{"Count": len(items), "ScannedCount": evaluated}
+		if request["Select"] != "COUNT" {
+			response["Items"] = items
+		}
+		if lastKey != nil {
+			response["LastEvaluatedKey"] = lastKey
+		}
+		if operation == "Query" && f.deleteAfterQuery {
+			for _, item := range items {
+				f.items = append(f.items[:f.find(item)], f.items[f.find(item)+1:]...)
+			}
+		}
+		if operation == "Query" && f.afterQuery != nil {
+			f.afterQuery()
+		}
+		json.NewEncoder(w).Encode(response)
+	case "PutItem":
+		item, _ := request["Item"].(map[string]interface{})
+		i := f.find(item)
+		existing := empty
+		if i >= 0 {
+			existing = f.items[i]
+		}
+		if !f.matches(existing, request, "ConditionExpression") {
+			conditionFailed()
+			return
+		}
+		if i >= 0 {
+			f.items[i] = item
+		} else {
+			f.items = append(f.items, item)
+		}
+		json.NewEncoder(w).Encode(empty)
+	case "UpdateItem":
+		key, _ := request["Key"].(map[string]interface{})
+		i := f.find(key)
+		existing := empty
+		if i >= 0 {
+			existing = f.items[i]
+		}
+		if !f.matches(existing, request, "ConditionExpression") {
+			conditionFailed()
+			return
+		}
+		item := map[string]interface{}{}
+		for name, value := range key {
+			item[name] = value
+		}
+		for name, value := range existing {
+			item[name] = value
+		}
+		update, _ := request["UpdateExpression"].(string)
+		names, _ := request["ExpressionAttributeNames"].(map[string]interface{})
+		values, _ := request["ExpressionAttributeValues"].(map[string]interface{})
+		for _, assignment := range fakeDynamoEquality.FindAllStringSubmatch(update, -1) {
+			name, _ := names[assignment[1]].(string)
+			item[name] = values[assignment[2]]
+		}
+		if i >= 0 {
+			f.items[i] = item
+		} else {
+			f.items = append(f.items, item)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Attributes": item})
+	case "DeleteItem":
+		key, _ := request["Key"].(map[string]interface{})
+		i := f.find(key)
+		existing := empty
+		if i >= 0 {
+			existing = f.items[i]
+		}
+		if !f.matches(existing, request, "ConditionExpression") {
+			conditionFailed()
+			return
+		}
+		if i < 0 {
+			json.NewEncoder(w).Encode(empty)
+			return
+		}
+		deleted := f.items[i]
+		f.items = append(f.items[:i], f.items[i+1:]...)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Attributes": deleted})
+	default:
+		f.t.Errorf("Unexpected DynamoDB operation %s", operation)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestDynamoSaveIf(t *testing.T) {
+	fake := newFakeDynamoTable(t, "id", map[string]interface{}{"id": dynamoString("1"), "name": dynamoString("John")})
+	repo, closeServer := fake.repository(RepositoryDefinitionMap{"name": "users", "hashKey": "id"})
+	defer closeServer()
+
+	entry := &memoryTestEntry{ID: "1", Name: "Johnny"}
+	result, applied, err := repo.SaveIf(entry, NewFilter().Match("id", "1"), NewFilter().Match("name", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied {
+		t.Fatal("Expected the update to be applied")
+	}
+	if saved, ok := result.(*memoryTestEntry); !ok || saved != entry || saved.Name != "Johnny" {
+		t.Fatalf("Expected the updated object itself. Got: %#v", result)
+	}
+	updates := fake.requests["UpdateItem"]
+	if condition, _ := updates[0]["ConditionExpression"].(string); !strings.Contains(condition, "attribute_exists") {
+		t.Fatal("Expected the update to be conditioned on the existence of the item. Got: ", condition)
+	}
+
+	if _, applied, err = repo.SaveIf(&memoryTestEntry{ID: "1", Name: "Jack"}, NewFilter().Match("id", "1"), NewFilter().Match("name", "John")); err != nil || applied {
+		t.Fatalf("Expected the update not to be applied when the condition fails. Got: %v %v", applied, err)
+	}
+
+	fake.deleteAfterQuery = true
+	if _, applied, err = repo.SaveIf(&memoryTestEntry{ID: "1", Name: "Jack"}, NewFilter().Match("id", "1"), nil); err != nil || applied {
+		t.Fatalf("Expected the update of the deleted item not to be applied. Got: %v %v", applied, err)
+	}
+	if len(fake.items) != 0 {
+		t.Fatal("Expected the deleted item not to be created by the update. Got: ", fake.items)
+	}
+}
+
+func TestDynamoDeleteAll(t *testing.T) {
+	fake := newFakeDynamoTable(t, "id",
+		map[string]interface{}{"id": dynamoString("1"), "name": dynamoString("John")},
+		map[string]interface{}{"id": dynamoString("2"), "name": dynamoString("Mary")},
+	)
+	repo, closeServer := fake.repository(RepositoryDefinitionMap{"name": "users", "hashKey": "id"})
+	defer closeServer()
+
+	deleted, err := repo.DeleteAllReturning(NewFilter().Match("id", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "1" {
+		t.Fatal("Expected the ID of the deleted item. Got: ", deleted)
+	}
+	if len(fake.requests["DeleteItem"]) != 1 {
+		t.Fatal("Expected one item to be deleted. Got: ", fake.requests["DeleteItem"])
+	}
+	if len(fake.items) != 1 || !reflect.DeepEqual(fake.items[0]["id"], dynamoString("2")) {
+		t.Fatal("Expected only the matched item to be deleted. Got: ", fake.items)
+	}
+
+	if err = repo.DeleteAll(NewFilter().Match("id", "3")); err != nil {
+		t.Fatal("Expected no error when nothing matches. Got: ", err)
+	}
+	if _, err = repo.DeleteAllReturning(NewFilter().Match("name", "Mary")); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the filter without the hash key to be rejected. Got: ", err)
+	}
+}
+
+func TestDynamoDeleteIf(t *testing.T) {
+	fake := newFakeDynamoTable(t, "id",
+		map[string]interface{}{"id": dynamoString("1"), "status": dynamoString("active")},
+		map[string]interface{}{"id": dynamoString("2"), "status": dynamoString("active")},
+	)
+	repo, closeServer := fake.repository(RepositoryDefinitionMap{"name": "users", "hashKey": "id"})
+	defer closeServer()
+
+	// the item stops matching the filter between the lookup and the delete
+	fake.afterQuery = func() {
+		fake.items[0]["status"] = dynamoString("suspended")
+	}
+	deleted, err := repo.DeleteIf(NewFilter().Match("id", "1").Match("status", "active"), nil)
+	if err != nil || deleted {
+		t.Fatalf("Expected the changed item not to be deleted. Got: %v %v", deleted, err)
+	}
+	if len(fake.items) != 2 {
+		t.Fatal("Expected the changed item to remain. Got: ", fake.items)
+	}
+	condition, _ := fake.requests["DeleteItem"][0]["ConditionExpression"].(string)
+	if !strings.Contains(condition, "attribute_exists") {
+		t.Fatal("Expected the delete to be conditioned on the existence of the item. Got: ", condition)
+	}
+
+	// the item is deleted between the lookup and the delete
+	fake.afterQuery = nil
+	fake.deleteAfterQuery = true
+	if deleted, err = repo.DeleteIf(NewFilter().Match("id", "2"), nil); err != nil || deleted {
+		t.Fatalf("Expected the delete of the deleted item not to be reported. Got: %v %v", deleted, err)
+	}
+
+	fake.deleteAfterQuery = false
+	if deleted, err = repo.DeleteIf(NewFilter().Match("id", "1"), NewFilter().Match("status", "suspended")); err != nil || !deleted {
+		t.Fatalf("Expected the item matching the condition to be deleted. Got: %v %v", deleted, err)
+	}
+	if len(fake.items) != 0 {
+		t.Fatal("Expected no items left. Got: ", fake.items)
+	}
+}
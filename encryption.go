@@ -0,0 +1,157 @@
+package backends
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// encryptedPrefix marks the encrypted values stored by FieldEncryptor.
+const encryptedPrefix = "enc:"
+
+// FieldEncryptor is a Codec that encrypts the values of the named fields with AES-GCM before the
+// records are written and decrypts them after they are read. Wrap a repository with it using
+// NewCodecRepository or CodecMiddleware:
+// 		encryptor, err := backends.NewFieldEncryptor(key, "ssn", "cardNumber")
+// 		repo := backends.Chain(userRepo, backends.CodecMiddleware(encryptor))
+//
+// An encrypted value is stored as the string "enc:<key ID>:<base64 of the nonce and the
+// ciphertext>", where the key ID is derived from the key. To rotate the key, create the encryptor
+// with the new key and add the previous keys with AddDecryptionKey: the records are written with
+// the new key and the records written with the previous keys are still read.
+//
+// The values of the other fields are left untouched, so they remain queryable. The encrypted
+// fields cannot be queried, as the same value is encrypted differently each time. The values
+// that are not encrypted, like the ones written before the field was encrypted, are read as they
+// are.
+type FieldEncryptor struct {
+	mutex  sync.RWMutex
+	keyID  string
+	aeads  map[string]cipher.AEAD
+	fields []string
+}
+
+// NewFieldEncryptor creates a FieldEncryptor that encrypts the fields with the key. The key must
+// be 16, 24 or 32 bytes long, to select AES-128, AES-192 or AES-256.
+func NewFieldEncryptor(key []byte, fields ...string) (*FieldEncryptor, error) {
+	keyID, aead, err := newFieldCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldEncryptor{
+		keyID:  keyID,
+		aeads:  map[string]cipher.AEAD{keyID: aead},
+		fields: fields,
+	}, nil
+}
+
+// newFieldCipher creates the AES-GCM cipher of the key and returns it with the ID of the key.
+func newFieldCipher(key []byte) (string, cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, ErrInvalidInput(fmt.Sprintf("invalid encryption key: %s", err.Error()))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4]), aead, nil
+}
+
+// AddDecryptionKey adds a previous key, so the values encrypted with it can still be decrypted
+// after the key is rotated. The values are always encrypted with the key of NewFieldEncryptor.
+func (e *FieldEncryptor) AddDecryptionKey(key []byte) error {
+	keyID, aead, err := newFieldCipher(key)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.aeads[keyID] = aead
+	return nil
+}
+
+// Encode encrypts the values of the encrypted fields of the record. The missing fields and the
+// nil values are not encrypted.
+func (e *FieldEncryptor) Encode(record map[string]interface{}) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	aead := e.aeads[e.keyID]
+	for _, field := range e.fields {
+		value, ok := record[field]
+		if !ok || value == nil {
+			continue
+		}
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			return ErrInvalidInput(fmt.Sprintf("cannot encrypt the field %s: %s", field, err.Error()))
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		// the field name is authenticated, so a value cannot be moved to another field
+		sealed := aead.Seal(nonce, nonce, plaintext, []byte(field))
+		record[field] = encryptedPrefix + e.keyID + ":" + base64.StdEncoding.EncodeToString(sealed)
+	}
+	return nil
+}
+
+// Decode decrypts the encrypted values of the encrypted fields of the record.
+func (e *FieldEncryptor) Decode(record map[string]interface{}) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for _, field := range e.fields {
+		encrypted, ok := record[field].(string)
+		if !ok || !strings.HasPrefix(encrypted, encryptedPrefix) {
+			continue
+		}
+		value, err := e.decrypt(field, strings.TrimPrefix(encrypted, encryptedPrefix))
+		if err != nil {
+			return ErrInvalidInput(fmt.Sprintf("cannot decrypt the field %s: %s", field, err.Error()))
+		}
+		record[field] = value
+	}
+	return nil
+}
+
+// decrypt decrypts the value stored as "<key ID>:<base64 of the nonce and the ciphertext>".
+func (e *FieldEncryptor) decrypt(field string, encrypted string) (interface{}, error) {
+	separator := strings.Index(encrypted, ":")
+	if separator < 0 {
+		return nil, fmt.Errorf("the key ID is missing")
+	}
+	aead, ok := e.aeads[encrypted[:separator]]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", encrypted[:separator])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted[separator+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("the ciphertext is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(plaintext, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
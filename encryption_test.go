@@ -0,0 +1,84 @@
+package backends
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type encryptionTestUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	SSN  string `json:"ssn"`
+	Age  int    `json:"age"`
+}
+
+func TestFieldEncryptor(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	oldEncryptor, err := NewFieldEncryptor(oldKey, "ssn", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	repo := Chain(stored, CodecMiddleware(oldEncryptor))
+
+	john := &encryptionTestUser{Name: "John", SSN: "123-45-6789", Age: 30}
+	if _, err := repo.Save(john, nil); err != nil {
+		t.Fatal(err)
+	}
+	if john.SSN != "123-45-6789" || john.ID == "" {
+		t.Fatal("Expected the saved user with the decrypted fields and the ID. Got: ", john)
+	}
+
+	raw, err := GetOneRaw(stored, NewFilter().Match("name", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssn, _ := raw["ssn"].(string)
+	if !strings.HasPrefix(ssn, "enc:") || strings.Contains(ssn, "6789") {
+		t.Fatal("Expected the SSN to be stored as ciphertext. Got: ", raw["ssn"])
+	}
+	if age, _ := raw["age"].(string); !strings.HasPrefix(age, "enc:") {
+		t.Fatal("Expected the age to be stored as ciphertext. Got: ", raw["age"])
+	}
+
+	// the fields that are not encrypted remain queryable
+	user := &encryptionTestUser{}
+	if _, err := repo.GetOne(NewFilter().Match("name", "John"), user); err != nil {
+		t.Fatal(err)
+	}
+	if *user != *john {
+		t.Fatal("Expected the decrypted user to match the saved one. Got: ", user)
+	}
+
+	// rotate the key: the new records use the new key, the old records are still read
+	newEncryptor, err := NewFieldEncryptor(bytes.Repeat([]byte{2}, 32), "ssn", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := Chain(stored, CodecMiddleware(newEncryptor))
+	if _, err := rotated.GetOne(NewFilter().Match("name", "John"), &encryptionTestUser{}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the value encrypted with an unknown key not to be decrypted. Got: ", err)
+	}
+	if err := newEncryptor.AddDecryptionKey(oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Save(&encryptionTestUser{Name: "Jane", SSN: "987-65-4321", Age: 25}, nil); err != nil {
+		t.Fatal(err)
+	}
+	results, err := rotated.GetAll(NewFilter(), &encryptionTestUser{}, "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := *results.(*[]*encryptionTestUser)
+	if len(users) != 2 || users[0].SSN != "987-65-4321" || users[1].SSN != "123-45-6789" || users[1].Age != 30 {
+		t.Fatal("Expected the records of both keys to be decrypted. Got: ", users)
+	}
+	if _, err := repo.GetOne(NewFilter().Match("name", "Jane"), &encryptionTestUser{}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the old encryptor not to decrypt the values of the new key. Got: ", err)
+	}
+
+	if _, err := NewFieldEncryptor([]byte("short"), "ssn"); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the invalid key size. Got: ", err)
+	}
+}
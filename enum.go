@@ -0,0 +1,156 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Enum maps the names of the values of an enum field to the values stored for them, like
+// {"active": 1, "suspended": 2}. The names are used by the code and the API, the values by the
+// stored records. See NewEnumRepository.
+type Enum map[string]interface{}
+
+// enumValueKey returns the key of a stored value of an enum, in its JSON form, so the values
+// read back as float64 match the int values of the enum.
+func enumValueKey(value interface{}) (string, error) {
+	key, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// checkEnums checks that the enums have names, and that the names of an enum have distinct values,
+// so the values can be read back as the names.
+func checkEnums(enums map[string]Enum) error {
+	for field, enum := range enums {
+		if len(enum) == 0 {
+			return ErrInvalidInput(fmt.Sprintf("the enum of %s has no names", field))
+		}
+		names := map[string]string{}
+		for name, value := range enum {
+			key, err := enumValueKey(value)
+			if err != nil || value == nil {
+				return ErrInvalidInput(fmt.Sprintf("the value %v of %s.%s cannot be stored", value, field, name))
+			}
+			if other, ok := names[key]; ok {
+				first, second := sortedPair(name, other)
+				return ErrInvalidInput(fmt.Sprintf("the names %s and %s of the enum of %s have the same value %v", first, second, field, value))
+			}
+			names[key] = name
+		}
+	}
+	return nil
+}
+
+// sortedPair returns the two strings in order, so the messages do not depend on the map order.
+func sortedPair(a, b string) (string, string) {
+	pair := []string{a, b}
+	sort.Strings(pair)
+	return pair[0], pair[1]
+}
+
+// EnumCodec is the Codec of the enum fields: it encodes the names of the values to the stored
+// values and decodes the stored values back to the names. An unknown name or stored value is
+// ErrInvalidInput, so the writes of a name that is not in the enum fail, and so do the reads of
+// a record with a stored value that is not in the enum, like one written before the value was
+// added. A nil value is kept as nil.
+type EnumCodec struct {
+	enums  map[string]Enum
+	names  map[string]map[string]string
+	fields []string
+}
+
+// NewEnumCodec creates the codec of the enum fields.
+func NewEnumCodec(enums map[string]Enum) *EnumCodec {
+	codec := &EnumCodec{enums: enums, names: map[string]map[string]string{}}
+	for field, enum := range enums {
+		codec.fields = append(codec.fields, field)
+		codec.names[field] = map[string]string{}
+		for name, value := range enum {
+			if key, err := enumValueKey(value); err == nil {
+				codec.names[field][key] = name
+			}
+		}
+	}
+	sort.Strings(codec.fields)
+	return codec
+}
+
+// value returns the stored value of the name of the enum field.
+func (c *EnumCodec) value(field string, name interface{}) (interface{}, error) {
+	if name == nil {
+		return nil, nil
+	}
+	nameStr, ok := name.(string)
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("the value of the enum field %s must be one of its names, got %v", field, name))
+	}
+	value, ok := c.enums[field][nameStr]
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("unknown name %q of the enum field %s", nameStr, field))
+	}
+	return value, nil
+}
+
+// Encode replaces the names of the enum fields of the record with the stored values.
+func (c *EnumCodec) Encode(record map[string]interface{}) error {
+	for _, field := range c.fields {
+		name, ok := record[field]
+		if !ok {
+			continue
+		}
+		value, err := c.value(field, name)
+		if err != nil {
+			return err
+		}
+		record[field] = value
+	}
+	return nil
+}
+
+// Decode replaces the stored values of the enum fields of the record with their names.
+func (c *EnumCodec) Decode(record map[string]interface{}) error {
+	for _, field := range c.fields {
+		value, ok := record[field]
+		if !ok || value == nil {
+			continue
+		}
+		key, err := enumValueKey(value)
+		if err != nil {
+			return ErrInvalidInput(err)
+		}
+		name, ok := c.names[field][key]
+		if !ok {
+			return ErrInvalidInput(fmt.Sprintf("unknown stored value %v of the enum field %s", value, field))
+		}
+		record[field] = name
+	}
+	return nil
+}
+
+// EnumFilter converts the names in the filter values of the enum fields to the stored values,
+// like CoerceFilter does for the field types, so Match("status", "active") matches the records
+// stored with the value of "active". An unknown name is ErrInvalidInput.
+func EnumFilter(filter Filter, enums map[string]Enum) (Filter, error) {
+	if filter == nil || len(enums) == 0 {
+		return filter, nil
+	}
+	codec := NewEnumCodec(enums)
+	converts := func(property string) bool {
+		_, ok := enums[property]
+		return ok
+	}
+	return convertFilter(filter, converts, codec.value)
+}
+
+// NewEnumRepository wraps the repository so the enum fields are stored as the values of their
+// names, read back as the names, and queried by the names. The repositories defined with enums
+// ("enums") are wrapped with it by DefineRepository.
+func NewEnumRepository(repo Repository, enums map[string]Enum) *CoercingRepository {
+	return &CoercingRepository{
+		Repository: NewCodecRepository(repo, NewEnumCodec(enums)),
+		enums:      enums,
+	}
+}
@@ -0,0 +1,130 @@
+package backends
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+type enumTestEntry struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func TestEnumRepository(t *testing.T) {
+	collection := NewMemoryCollection(RepositoryDefinitionMap{"name": "accounts", "customId": true})
+	repo := NewEnumRepository(collection, map[string]Enum{
+		"status": {"active": 1, "suspended": 2},
+	})
+
+	for _, entry := range []*enumTestEntry{
+		{ID: "1", Name: "John", Status: "active"},
+		{ID: "2", Name: "Mary", Status: "suspended"},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := GetOneRaw(collection, NewFilter().Match("id", "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw["status"] != float64(2) {
+		t.Fatalf("Expected the status to be stored as 2. Got: %v", raw["status"])
+	}
+
+	result, err := repo.GetOne(NewFilter().Match("status", "suspended"), &enumTestEntry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := result.(*enumTestEntry); entry.Name != "Mary" || entry.Status != "suspended" {
+		t.Fatalf("Expected Mary read back as suspended. Got: %+v", entry)
+	}
+	if count, err := repo.Count(NewFilter().In("status", "active", "suspended")); err != nil || count != 2 {
+		t.Fatal("Expected both records to match the names. Got: ", count, err)
+	}
+
+	if _, err = repo.GetOne(NewFilter().Match("status", "deleted"), &enumTestEntry{}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the unknown name in the filter. Got: ", err)
+	}
+	if _, err = repo.Save(&enumTestEntry{ID: "3", Status: "deleted"}, nil); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the unknown name in the record. Got: ", err)
+	}
+
+	if _, err = collection.Save(&map[string]interface{}{"id": "4", "status": 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetOne(NewFilter().Match("id", "4"), &enumTestEntry{}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the unknown stored value. Got: ", err)
+	}
+}
+
+func TestDefineRepositoryWithEnums(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	invalid := map[string]RepositoryDefinitionMap{
+		"empty":     {"enums": map[string]interface{}{"status": map[string]interface{}{}}},
+		"duplicate": {"enums": map[string]interface{}{"status": map[string]interface{}{"active": 1, "enabled": 1}}},
+		"typed": {
+			"enums":      map[string]interface{}{"status": map[string]interface{}{"active": 1}},
+			"fieldTypes": map[string]interface{}{"status": FieldTypeInt},
+		},
+	}
+	for name, def := range invalid {
+		def["name"] = name
+		if _, err := backend.DefineRepository(name, def); !IsErrInvalidInput(err) {
+			t.Errorf("Expected an error for the %s enum. Got: %v", name, err)
+		}
+	}
+
+	repo, err := backend.DefineRepository("accounts", RepositoryDefinitionMap{
+		"name":     "accounts",
+		"customId": true,
+		"enums":    map[string]interface{}{"status": map[string]interface{}{"active": 1, "suspended": 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Save(&enumTestEntry{ID: "1", Name: "John", Status: "active"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := repo.Exists(NewFilter().Match("status", "active")); err != nil || !exists {
+		t.Fatal("Expected the record to match its status name. Got: ", exists, err)
+	}
+}
+
+func TestDefineRepositoryWithEnumsReadReplica(t *testing.T) {
+	replica := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {}, WithReadReplica(replica))
+
+	def := RepositoryDefinitionMap{
+		"name":     "accounts",
+		"customId": true,
+		"enums":    map[string]interface{}{"status": map[string]interface{}{"active": 1, "suspended": 2}},
+	}
+	repo, err := backend.DefineRepository("accounts", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaRepo, err := replica.GetRepository("accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replicaRepo.Save(&enumTestEntry{ID: "2", Name: "Mary", Status: "active"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reads from the replica translate the stored values once
+	for _, filter := range []Filter{NewFilter().Match("id", "2"), NewFilter().Match("status", "active")} {
+		result, err := repo.GetOne(filter, &enumTestEntry{})
+		if err != nil {
+			t.Fatalf("Expected the record of the replica for %v. Got: %v", filter, err)
+		}
+		if entry := result.(*enumTestEntry); entry.Status != "active" {
+			t.Fatalf("Expected the status name. Got: %+v", entry)
+		}
+	}
+}
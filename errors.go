@@ -1,6 +1,10 @@
 package backends
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // BackendErrorInfo holds the info for an error that occurred in the backend.
 // It contains the error message - this is usually a code string - like "not found" or "duplicate".
@@ -71,6 +75,9 @@ var ErrAlreadyExists = ErrorClass("already exists")
 // ErrInvalidInput is a generic error class related to invalid input parameters specified on a backend function.
 var ErrInvalidInput = ErrorClass("invalid input")
 
+// ErrUnsupported is the error class for the operations and the filters that the backend does not support.
+var ErrUnsupported = ErrorClass("unsupported")
+
 // ErrBackendError is a genering error class capturing errors that happened during processing in the backend.
 var ErrBackendError = func(args ...interface{}) error {
 	return &BackendErrorInfo{
@@ -78,8 +85,77 @@ var ErrBackendError = func(args ...interface{}) error {
 	}
 }
 
+// ErrDuplicateKey is the error wrapped by DuplicateKeyError. It is of the ErrAlreadyExists class.
+var ErrDuplicateKey = ErrAlreadyExists("duplicate key")
+
+// DuplicateKeyError is returned when a write violates a unique index.
+// It carries the name and the fields of the violated index when the backend reports them.
+// Use errors.As to get the details:
+// 		var dupErr backends.DuplicateKeyError
+// 		if errors.As(err, &dupErr) {
+// 			fmt.Println(dupErr.Index, dupErr.Fields)
+// 		}
+type DuplicateKeyError struct {
+	Index  string
+	Fields []string
+	Cause  error
+}
+
+// Error returns the error message.
+func (e DuplicateKeyError) Error() string {
+	msg := "duplicate key"
+	if e.Index != "" {
+		msg += fmt.Sprintf(" on index %s", e.Index)
+	}
+	if len(e.Fields) > 0 {
+		msg += fmt.Sprintf(" (%s)", strings.Join(e.Fields, ", "))
+	}
+	return msg
+}
+
+// Unwrap returns ErrDuplicateKey, so errors.Is(err, ErrDuplicateKey) and IsErrAlreadyExists
+// report true for a DuplicateKeyError.
+func (e DuplicateKeyError) Unwrap() error {
+	return ErrDuplicateKey
+}
+
+// DuplicateKeyDetector recognizes the native duplicate-key errors of a backend.
+// It returns the name of the violated index (empty if the backend does not report it) and
+// true if err is a duplicate-key error. Each backend provides its own detector.
+type DuplicateKeyDetector func(err error) (index string, ok bool)
+
+// WrapDuplicateKeyError uses the detector to check if err is a duplicate-key error and if so,
+// converts it to DuplicateKeyError with the fields of the violated index looked up in the
+// repository definition. Any other error is returned unchanged.
+func WrapDuplicateKeyError(err error, repoDef RepositoryDefinition, detect DuplicateKeyDetector) error {
+	if err == nil {
+		return nil
+	}
+	index, ok := detect(err)
+	if !ok {
+		return err
+	}
+
+	dupErr := DuplicateKeyError{
+		Index: index,
+		Cause: err,
+	}
+	for _, idx := range repoDef.GetIndexes() {
+		if idx.GetName() == index {
+			dupErr.Fields = idx.GetFields()
+			break
+		}
+	}
+	return dupErr
+}
+
 // IsErrorOfType checks if the suplied err is of the same type (backend error class) as some backend error.
+// Wrapped backend errors are checked as well.
 func IsErrorOfType(err error, backendErr error) bool {
+	var errInfo *BackendErrorInfo
+	if errors.As(err, &errInfo) {
+		return errInfo.Error() == backendErr.Error()
+	}
 	return err.Error() == backendErr.Error()
 }
 
@@ -97,3 +173,40 @@ func IsErrAlreadyExists(err error) bool {
 func IsErrInvalidInput(err error) bool {
 	return IsErrorOfType(err, ErrInvalidInput(""))
 }
+
+// IsErrUnsupported check of the error is of the ErrUnsupported class.
+func IsErrUnsupported(err error) bool {
+	return IsErrorOfType(err, ErrUnsupported(""))
+}
+
+// DecodeErrors is returned by the reads with ReadOpts.SkipDecodeErrors along with the records
+// that were decoded. It holds the errors of the records that could not be decoded into the
+// results type, which are left out of the results.
+type DecodeErrors []error
+
+// Error returns the error message.
+func (e DecodeErrors) Error() string {
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("failed to decode %d records: %s", len(e), strings.Join(messages, "; "))
+}
+
+// UpsertError is returned by Repository.UpsertAll when a row cannot be written.
+// The rows before the failed row are written and the rows after it are not.
+type UpsertError struct {
+	// Row is the index of the failed row in the input.
+	Row   int
+	Cause error
+}
+
+// Error returns the error message.
+func (e UpsertError) Error() string {
+	return fmt.Sprintf("upsert failed on row %d: %s", e.Row, e.Cause.Error())
+}
+
+// Unwrap returns the cause of the failure, so errors.As can be used to get a DuplicateKeyError.
+func (e UpsertError) Unwrap() error {
+	return e.Cause
+}
@@ -0,0 +1,171 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WithClock sets the clock used to track when the cached backends were last accessed.
+func (m *DefaultBackendManager) WithClock(clock Clock) *DefaultBackendManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.now = clock
+	return m
+}
+
+// touch records the access to the cached backend. The caller must hold the lock.
+func (m *DefaultBackendManager) touch(backendType string) {
+	if m.lastAccess == nil {
+		m.lastAccess = map[string]time.Time{}
+	}
+	m.lastAccess[backendType] = m.clock()()
+}
+
+// clock returns the clock of the manager. The caller must hold the lock.
+func (m *DefaultBackendManager) clock() Clock {
+	if m.now == nil {
+		return time.Now
+	}
+	return m.now
+}
+
+// EvictIdle shuts down and removes the cached backends that were not accessed with GetBackend
+// for longer than maxIdle. The next GetBackend builds the backend again. Returns the types of the
+// evicted backends.
+//
+// A backend is accessed only when it is returned by GetBackend, so the eviction is meant for
+// services that get the backend when they use it. The repositories of an evicted backend must not
+// be used anymore, as the backend connections are closed.
+func (m *DefaultBackendManager) EvictIdle(maxIdle time.Duration) []string {
+	m.mutex.Lock()
+	idleSince := m.clock()().Add(-maxIdle)
+
+	evicted := []string{}
+	idle := []Backend{}
+	for backendType, backend := range m.backends {
+		if lastAccess, ok := m.lastAccess[backendType]; ok && !lastAccess.Before(idleSince) {
+			continue
+		}
+		delete(m.backends, backendType)
+		delete(m.lastAccess, backendType)
+		evicted = append(evicted, backendType)
+		idle = append(idle, backend)
+	}
+	m.mutex.Unlock()
+
+	// shut down outside of the lock, so GetBackend is not blocked on closing the connections
+	for _, backend := range idle {
+		backend.Shutdown()
+	}
+	return evicted
+}
+
+// ShutdownAll shuts down all the backends built by the manager and removes them from the cache,
+// so the next GetBackend builds the backend again. The backends are shut down concurrently and
+// ShutdownAll waits for them until the context is done. The backends that panic on Shutdown or
+// are not shut down in time are listed in the returned ErrBackendError.
+//
+// The backends still being built are shut down once their build is done, instead of being
+// cached, and the GetBackend calls after ShutdownAll start a new build.
+func (m *DefaultBackendManager) ShutdownAll(ctx context.Context) error {
+	m.mutex.Lock()
+	built := m.backends
+	building := m.building
+	for _, build := range building {
+		build.shutdown = true
+	}
+	m.backends = map[string]Backend{}
+	m.building = map[string]*backendBuild{}
+	m.lastAccess = map[string]time.Time{}
+	m.mutex.Unlock()
+
+	type shutdownResult struct {
+		backendType string
+		err         error
+	}
+	results := make(chan shutdownResult, len(built)+len(building))
+	shutdown := func(backendType string, backend func() Backend) {
+		defer func() {
+			if r := recover(); r != nil {
+				results <- shutdownResult{backendType, fmt.Errorf("%v", r)}
+			}
+		}()
+		if backend := backend(); backend != nil {
+			backend.Shutdown()
+		}
+		results <- shutdownResult{backendType, nil}
+	}
+	for backendType, backend := range built {
+		backend := backend
+		go shutdown(backendType, func() Backend { return backend })
+	}
+	for backendType, build := range building {
+		build := build
+		go shutdown(backendType, func() Backend {
+			<-build.done
+			if build.err != nil {
+				return nil
+			}
+			return build.backend
+		})
+	}
+
+	failed := []string{}
+	pending := map[string]bool{}
+	for backendType := range built {
+		pending[backendType] = true
+	}
+	for backendType := range building {
+		pending[backendType] = true
+	}
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.backendType)
+			if result.err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", result.backendType, result.err.Error()))
+			}
+		case <-ctx.Done():
+			for backendType := range pending {
+				failed = append(failed, fmt.Sprintf("%s: %s", backendType, ctx.Err().Error()))
+			}
+			pending = nil
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return ErrBackendError(fmt.Sprintf("failed to shut down the backends: %s", strings.Join(failed, "; ")))
+	}
+	return nil
+}
+
+// StartIdleEviction evicts the backends idle for longer than maxIdle every interval in the
+// background, until the context is cancelled. The returned channel is closed once the eviction
+// stops. See EvictIdle for details.
+func (m *DefaultBackendManager) StartIdleEviction(ctx context.Context, maxIdle, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logger := m.Logger()
+				for _, backendType := range m.EvictIdle(maxIdle) {
+					logger.Debugf("Evicted idle backend %s", backendType)
+				}
+			}
+		}
+	}()
+	return done
+}
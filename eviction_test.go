@@ -0,0 +1,216 @@
+package backends
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestEvictIdle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	shutdowns := map[string]int{}
+
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"used":   &config.DBInfo{},
+		"unused": &config.DBInfo{},
+	}).(*DefaultBackendManager).WithClock(func() time.Time { return now })
+
+	for _, backendType := range []string{"used", "unused"} {
+		backendType := backendType
+		manager.SupportBackend(backendType, func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+			return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {
+				shutdowns[backendType]++
+			}), nil
+		}, map[string]interface{}{})
+	}
+
+	unused, err := manager.GetBackend("unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.GetBackend("used"); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if _, err = manager.GetBackend("used"); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Minute)
+	evicted := manager.EvictIdle(5 * time.Minute)
+	if !strArrEq(evicted, []string{"unused"}) {
+		t.Fatal("Expected only the idle backend to be evicted. Got: ", evicted)
+	}
+	if shutdowns["unused"] != 1 || shutdowns["used"] != 0 {
+		t.Fatal("Expected the evicted backend to be shut down. Got: ", shutdowns)
+	}
+
+	rebuilt, err := manager.GetBackend("unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == unused {
+		t.Fatal("Expected the evicted backend to be built again")
+	}
+	if evicted = manager.EvictIdle(5 * time.Minute); len(evicted) != 0 {
+		t.Fatal("Expected the rebuilt backend not to be idle. Got: ", evicted)
+	}
+}
+
+func TestStartIdleEviction(t *testing.T) {
+	shutdown := make(chan struct{}, 1)
+
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"idle": &config.DBInfo{},
+	}).(*DefaultBackendManager)
+	manager.SupportBackend("idle", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {
+			shutdown <- struct{}{}
+		}), nil
+	}, map[string]interface{}{})
+
+	if _, err := manager.GetBackend("idle"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := manager.StartIdleEviction(ctx, time.Millisecond, 5*time.Millisecond)
+
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the idle backend to be shut down")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestShutdownAll(t *testing.T) {
+	shutdowns := map[string]int{}
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"first":    &config.DBInfo{},
+		"second":   &config.DBInfo{},
+		"panicky":  &config.DBInfo{},
+		"not-used": &config.DBInfo{},
+	}).(*DefaultBackendManager)
+
+	var mutex sync.Mutex
+	for _, backendType := range []string{"first", "second", "panicky", "not-used"} {
+		backendType := backendType
+		manager.SupportBackend(backendType, func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+			return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {
+				mutex.Lock()
+				shutdowns[backendType]++
+				mutex.Unlock()
+				if backendType == "panicky" {
+					panic("connection lost")
+				}
+			}), nil
+		}, map[string]interface{}{})
+	}
+
+	for _, backendType := range []string{"first", "second", "panicky"} {
+		if _, err := manager.GetBackend(backendType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := manager.ShutdownAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "panicky: connection lost") {
+		t.Fatal("Expected an error for the backend that panicked. Got: ", err)
+	}
+	if shutdowns["first"] != 1 || shutdowns["second"] != 1 || shutdowns["panicky"] != 1 || shutdowns["not-used"] != 0 {
+		t.Fatal("Expected all the built backends to be shut down once. Got: ", shutdowns)
+	}
+
+	if err = manager.ShutdownAll(context.Background()); err != nil {
+		t.Fatal("Expected nothing to shut down the second time. Got: ", err)
+	}
+	if _, err = manager.GetBackend("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.ShutdownAll(context.Background()); err != nil || shutdowns["first"] != 2 {
+		t.Fatal("Expected the rebuilt backend to be shut down. Got: ", shutdowns, err)
+	}
+}
+
+func TestShutdownAllInProgressBuild(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	builds, shutdowns := 0, 0
+
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"slow": &config.DBInfo{},
+	}).(*DefaultBackendManager)
+	manager.SupportBackend("slow", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		mutex.Lock()
+		builds++
+		first := builds == 1
+		mutex.Unlock()
+		if first {
+			close(started)
+			<-release
+		}
+		return NewRepositoriesBackend(context.Background(), dbInfo, MemoryRepoBuilder, func() {
+			mutex.Lock()
+			shutdowns++
+			mutex.Unlock()
+		}), nil
+	}, map[string]interface{}{})
+
+	built := make(chan Backend)
+	go func() {
+		backend, _ := manager.GetBackend("slow")
+		built <- backend
+	}()
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- manager.ShutdownAll(context.Background())
+	}()
+	for {
+		manager.mutex.Lock()
+		_, building := manager.building["slow"]
+		manager.mutex.Unlock()
+		if !building {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a GetBackend after ShutdownAll does not get the backend that is shut down
+	rebuilt, err := manager.GetBackend("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	backend := <-built
+	if err = <-shutdownErr; err != nil {
+		t.Fatal(err)
+	}
+	if backend == nil || backend == rebuilt {
+		t.Fatal("Expected the GetBackend after ShutdownAll to build the backend again")
+	}
+	mutex.Lock()
+	if shutdowns != 1 {
+		t.Fatal("Expected the backend built during ShutdownAll to be shut down. Got: ", shutdowns)
+	}
+	mutex.Unlock()
+
+	cached, err := manager.GetBackend("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != rebuilt {
+		t.Fatal("Expected the rebuilt backend to be cached, not the one that was shut down")
+	}
+}
@@ -0,0 +1,108 @@
+package backends
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// MarshalJSON encodes the filter as a JSON object. The operators are kept in their
+// "$"-prefixed form, so:
+// 		backends.NewFilter().Match("role", "admin").Between("age", 18, 65)
+// is encoded as:
+// 		{"age":{"$gte":18,"$lte":65},"role":"admin"}
+func (f Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}(f))
+}
+
+// UnmarshalJSON decodes a filter encoded with MarshalJSON.
+//
+// JSON does not distinguish between integers and floating point numbers, so the numbers
+// are restored by their textual form: a number without a fraction or an exponent is
+// decoded as int (or int64 if it does not fit in int), any other number as float64.
+// This means that a float64 with an integral value, like 30.0, is restored as int 30.
+// The backends compare numbers by value, so the restored filter matches the same entries.
+//
+// The pattern match is restored in the same form as set by Filter.MatchPattern, the other
+// operators are restored as map[string]interface{} and the "$in" values as []interface{}.
+// The filter negated with Filter.Not is restored as Filter, and the alternatives of
+// Filter.AnyFieldMatches as []Filter.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	raw := map[string]interface{}{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	*f = restoreFilter(raw)
+	return nil
+}
+
+// restoreFilter builds the filter from the decoded JSON object.
+func restoreFilter(raw map[string]interface{}) Filter {
+	filter := Filter{}
+	for property, value := range raw {
+		value = restoreJSONNumbers(value)
+		if alternatives, ok := value.([]interface{}); ok && property == OrOperator {
+			restored := make([]Filter, 0, len(alternatives))
+			for _, alternative := range alternatives {
+				if specs, ok := alternative.(map[string]interface{}); ok {
+					restored = append(restored, restoreFilter(specs))
+				}
+			}
+			if len(restored) == len(alternatives) {
+				filter[property] = restored
+				continue
+			}
+		}
+		if specs, ok := value.(map[string]interface{}); ok {
+			if property == NotOperator {
+				filter.Not(restoreFilter(specs))
+				continue
+			}
+			if isPatternSpec(specs) {
+				filter.MatchPattern(property, specs["$pattern"].(string))
+				continue
+			}
+		}
+		filter[property] = value
+	}
+	return filter
+}
+
+// isPatternSpec checks if the operators are the ones set by Filter.MatchPattern.
+func isPatternSpec(specs map[string]interface{}) bool {
+	if len(specs) != 1 {
+		return false
+	}
+	_, ok := specs["$pattern"].(string)
+	return ok
+}
+
+// restoreJSONNumbers converts the json.Number values to int or float64.
+func restoreJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				if int64(int(i)) == i {
+					return int(i)
+				}
+				return i
+			}
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = restoreJSONNumbers(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = restoreJSONNumbers(val)
+		}
+	}
+	return value
+}
@@ -0,0 +1,41 @@
+package backends
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFilterJSONRoundTrip(t *testing.T) {
+	filter := NewFilter().
+		Match("role", "admin").
+		Match("active", true).
+		Match("score", 4.5).
+		MatchPattern("name", "John%").
+		Between("age", 18, 65).
+		In("country", "MK", "DE").
+		AnyFieldMatches("johnny", "nickname", "email").
+		Not(NewFilter().Match("banned", true).MatchPattern("email", "%@example.com"))
+
+	data, err := json.Marshal(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := Filter{}
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(filter, restored) {
+		t.Fatalf("Expected %v, got %v", filter, restored)
+	}
+
+	age := restored["age"].(map[string]interface{})
+	if _, ok := age["$gte"].(int); !ok {
+		t.Fatalf("Expected the integer operand to be restored as int. Got: %T", age["$gte"])
+	}
+	if _, ok := restored["score"].(float64); !ok {
+		t.Fatalf("Expected the fractional value to be restored as float64. Got: %T", restored["score"])
+	}
+}
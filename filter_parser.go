@@ -0,0 +1,209 @@
+package backends
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseFilter parses a compact filter expression, usually received as a query parameter,
+// into a Filter. For example:
+// 		filter, err := backends.ParseFilter("age>=18;name~John%;role:admin")
+// is the same as:
+// 		filter := backends.NewFilter().Gte("age", 18).MatchPattern("name", "John%").Match("role", "admin")
+//
+// The grammar of the expression is:
+// 		expression = condition *( ";" condition )
+// 		condition  = property operator value
+// 		property   = 1*( ALPHA / DIGIT / "_" / "." / "-" )
+// 		operator   = ":" / "~" / ">=" / "<=" / ">" / "<"
+//
+// The operators are:
+// 		":"  - exact match (Filter.Match)
+// 		"~"  - pattern match (Filter.MatchPattern), the value is always a string
+// 		">=" - greater than or equal (Filter.Gte)
+// 		"<=" - less than or equal (Filter.Lte)
+// 		">"  - greater than (Filter.Gt)
+// 		"<"  - less than (Filter.Lt)
+//
+// The values of the exact match and the comparison operators are typed: "true" and "false"
+// are parsed as bool, integers as int, other numbers as float64 and anything else as string.
+// To force a string value, wrap it in double quotes - zip:"01234".
+// A backslash escapes the next character, so "\;" is a literal ";", "\"" is a literal
+// double quote and "\\" is a literal backslash. Whitespace is not allowed in the property
+// names and around the operators, but it is kept as-is in the values.
+//
+// Multiple comparison conditions on the same property are combined (age>=18;age<65),
+// but any other repeated condition on a property is an error.
+// The returned error is of the ErrInvalidInput class and its details describe the problem.
+func ParseFilter(query string) (Filter, error) {
+	filter := NewFilter()
+
+	conditions, err := splitConditions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, condition := range conditions {
+		if strings.TrimSpace(condition) == "" {
+			if len(conditions) == 1 {
+				// empty expression - match everything
+				return filter, nil
+			}
+			return nil, ErrInvalidInput(fmt.Sprintf("condition %d is empty", i+1))
+		}
+
+		property, operator, rawValue, err := splitCondition(condition)
+		if err != nil {
+			return nil, ErrInvalidInput(fmt.Sprintf("condition %d (%q): %s", i+1, condition, err.Error()))
+		}
+
+		value, err := parseFilterValue(rawValue, operator == "~")
+		if err != nil {
+			return nil, ErrInvalidInput(fmt.Sprintf("condition %d (%q): %s", i+1, condition, err.Error()))
+		}
+
+		existing, exists := filter[property]
+		if exists {
+			specs, isOperator := operatorSpecs(existing)
+			_, isComparison := comparisonOperators[operator]
+			_, patternSet := specs["$pattern"]
+			if !isOperator || !isComparison || patternSet {
+				return nil, ErrInvalidInput(fmt.Sprintf("condition %d (%q): duplicate condition for property %s", i+1, condition, property))
+			}
+			if _, ok := specs[comparisonOperators[operator]]; ok {
+				return nil, ErrInvalidInput(fmt.Sprintf("condition %d (%q): duplicate %s condition for property %s", i+1, condition, operator, property))
+			}
+		}
+
+		switch operator {
+		case ":":
+			filter.Match(property, value)
+		case "~":
+			filter.MatchPattern(property, value.(string))
+		default:
+			filter.withOperator(property, comparisonOperators[operator], value)
+		}
+	}
+
+	return filter, nil
+}
+
+// comparisonOperators maps the expression comparison operators to filter operators.
+var comparisonOperators = map[string]string{
+	">=": "$gte",
+	"<=": "$lte",
+	">":  "$gt",
+	"<":  "$lt",
+}
+
+// splitConditions splits the expression on the unescaped ";". The escape sequences are
+// kept in the conditions, the values are unescaped later.
+func splitConditions(query string) ([]string, error) {
+	conditions := []string{}
+	current := ""
+	escaped := false
+
+	for _, r := range query {
+		if escaped {
+			current += string(r)
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			current += string(r)
+			escaped = true
+			continue
+		}
+		if r == ';' {
+			conditions = append(conditions, current)
+			current = ""
+			continue
+		}
+		current += string(r)
+	}
+	if escaped {
+		return nil, ErrInvalidInput("unterminated escape sequence at the end of the expression")
+	}
+
+	return append(conditions, current), nil
+}
+
+// splitCondition splits the condition to property, operator and the raw value.
+func splitCondition(condition string) (string, string, string, error) {
+	i := 0
+	for i < len(condition) && isPropertyChar(condition[i]) {
+		i++
+	}
+	property := condition[:i]
+	if property == "" {
+		return "", "", "", fmt.Errorf("missing property name")
+	}
+	if i == len(condition) {
+		return "", "", "", fmt.Errorf("missing operator after property %s", property)
+	}
+
+	rest := condition[i:]
+	for _, operator := range []string{">=", "<=", ">", "<", ":", "~"} {
+		if strings.HasPrefix(rest, operator) {
+			return property, operator, rest[len(operator):], nil
+		}
+	}
+
+	return "", "", "", fmt.Errorf("invalid character %q in property name or unknown operator", rest[0])
+}
+
+func isPropertyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+}
+
+// parseFilterValue unescapes the raw value and converts it to the inferred type.
+func parseFilterValue(raw string, asString bool) (interface{}, error) {
+	value := ""
+	quoted := false
+	closed := false
+	escaped := false
+
+	for i, r := range raw {
+		if closed {
+			return nil, fmt.Errorf("unexpected characters after the closing double quote")
+		}
+		switch {
+		case escaped:
+			value += string(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"' && i == 0:
+			quoted = true
+		case r == '"' && quoted:
+			closed = true
+		case r == '"':
+			return nil, fmt.Errorf("unescaped double quote in value")
+		default:
+			value += string(r)
+		}
+	}
+	if quoted && !closed {
+		return nil, fmt.Errorf("unterminated quoted value")
+	}
+
+	if quoted || asString {
+		return value, nil
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	if value == "true" || value == "false" {
+		return value == "true", nil
+	}
+	if i, err := strconv.Atoi(value); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, nil
+	}
+
+	return value, nil
+}
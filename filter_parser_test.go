@@ -0,0 +1,85 @@
+package backends
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected Filter
+	}{
+		{"", Filter{}},
+		{"role:admin", Filter{"role": "admin"}},
+		{"active:true", Filter{"active": true}},
+		{"age:30", Filter{"age": 30}},
+		{"score:4.5", Filter{"score": 4.5}},
+		{`zip:"01234"`, Filter{"zip": "01234"}},
+		{"name~John%", Filter{"name": map[string]string{"$pattern": "John%"}}},
+		{"name~123", Filter{"name": map[string]string{"$pattern": "123"}}},
+		{"age>18", Filter{"age": map[string]interface{}{"$gt": 18}}},
+		{"age>=18", Filter{"age": map[string]interface{}{"$gte": 18}}},
+		{"age<65", Filter{"age": map[string]interface{}{"$lt": 65}}},
+		{"age<=65", Filter{"age": map[string]interface{}{"$lte": 65}}},
+		{"age>=18;age<65", Filter{"age": map[string]interface{}{"$gte": 18, "$lt": 65}}},
+		{"age>=18;name~J%;role:admin", Filter{
+			"age":  map[string]interface{}{"$gte": 18},
+			"name": map[string]string{"$pattern": "J%"},
+			"role": "admin",
+		}},
+		{`title:a\;b`, Filter{"title": "a;b"}},
+		{`title:say \"hi\"`, Filter{"title": `say "hi"`}},
+		{`path:c:\\tmp`, Filter{"path": `c:\tmp`}},
+		{"address.city:Skopje", Filter{"address.city": "Skopje"}},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.query, test.expected, filter)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		details string
+	}{
+		{":admin", "missing property name"},
+		{"role", "missing operator"},
+		{"role=admin", "unknown operator"},
+		{"age>=", "missing value"},
+		{"role:admin;", "condition 2 is empty"},
+		{"role:admin;;age:3", "condition 2 is empty"},
+		{"role:admin;role:user", "duplicate condition"},
+		{"name~J%;name>3", "duplicate condition"},
+		{"age>=18;age>=21", "duplicate >= condition"},
+		{`role:admin\`, "unterminated escape sequence"},
+		{`zip:"01234`, "unterminated quoted value"},
+		{`zip:"012"34`, "after the closing double quote"},
+		{`title:say "hi"`, "unescaped double quote"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFilter(test.query)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.query)
+			continue
+		}
+		if !IsErrInvalidInput(err) {
+			t.Errorf("%q: expected invalid input error, got %s", test.query, err)
+			continue
+		}
+		details := err.(*BackendErrorInfo).Details()
+		if !strings.Contains(details, test.details) {
+			t.Errorf("%q: expected the details to contain %q, got %q", test.query, test.details, details)
+		}
+	}
+}
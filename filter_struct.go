@@ -0,0 +1,124 @@
+package backends
+
+import (
+	"reflect"
+	"strings"
+)
+
+// StructFilterOption configures how FilterFromStruct builds the filter.
+type StructFilterOption func(opts *structFilterOpts)
+
+type structFilterOpts struct {
+	includeZero bool
+}
+
+// IncludeZeroValues makes FilterFromStruct match the fields with zero values as well.
+// The nil pointers are skipped regardless.
+func IncludeZeroValues() StructFilterOption {
+	return func(opts *structFilterOpts) {
+		opts.includeZero = true
+	}
+}
+
+// FilterFromStruct builds a filter that exactly matches the fields of the struct (or pointer
+// to struct). For example:
+// 		type UserQuery struct {
+// 			Email  string `backends:"email"`
+// 			Role   string `backends:"role"`
+// 			Active *bool  `backends:"active"`
+// 			Page   int    `backends:"-"`
+// 		}
+// 		filter, err := backends.FilterFromStruct(UserQuery{Role: "admin"})
+// gives the filter {"role": "admin"}.
+//
+// The property name is taken from the "backends" tag, then from the "json" tag, and is the field
+// name if neither is set. The fields tagged with "-" and the unexported fields are skipped. The
+// fields of embedded structs are matched as if they were fields of the outer struct.
+//
+// The fields with zero values are skipped, unless IncludeZeroValues is given. The pointers are
+// matched by the value they point to and the nil pointers are always skipped, so a pointer field
+// can match a zero value, like an Active field set to false.
+func FilterFromStruct(v interface{}, opts ...StructFilterOption) (Filter, error) {
+	options := &structFilterOpts{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, ErrInvalidInput("cannot build a filter from a nil pointer")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, ErrInvalidInput("a filter can be built only from a struct or pointer to struct")
+	}
+
+	filter := NewFilter()
+	addStructFields(filter, value, options)
+	return filter, nil
+}
+
+// addStructFields adds the fields of the struct value to the filter.
+func addStructFields(filter Filter, value reflect.Value, opts *structFilterOpts) {
+	valueType := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		tag, tagged := structFieldTag(field)
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && !tagged {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addStructFields(filter, embedded, opts)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		} else if !opts.includeZero && fieldValue.IsZero() {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		filter.Match(name, fieldValue.Interface())
+	}
+}
+
+// structFieldTag returns the property name from the "backends" or "json" tag of the field.
+// The second return value is false if neither tag sets a name.
+func structFieldTag(field reflect.StructField) (string, bool) {
+	for _, key := range []string{"backends", "json"} {
+		tag, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package backends
+
+import (
+	"reflect"
+	"testing"
+)
+
+type auditQuery struct {
+	CreatedBy string `backends:"createdBy"`
+}
+
+type tenantQuery struct {
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type userQuery struct {
+	auditQuery
+	*tenantQuery
+	Email    string  `backends:"email" json:"mail"`
+	Role     string  `json:"role"`
+	Age      int     `backends:"age"`
+	Active   *bool   `backends:"active"`
+	Nickname *string `backends:"nickname"`
+	Page     int     `backends:"-"`
+	Country  string
+	internal string
+}
+
+func TestFilterFromStruct(t *testing.T) {
+	inactive := false
+
+	tests := []struct {
+		name     string
+		query    interface{}
+		opts     []StructFilterOption
+		expected Filter
+	}{
+		{
+			name:     "non-zero fields",
+			query:    userQuery{Email: "john@example.com", Role: "admin", Page: 2, internal: "x"},
+			expected: Filter{"email": "john@example.com", "role": "admin"},
+		},
+		{
+			name:     "pointer to struct",
+			query:    &userQuery{Country: "MK"},
+			expected: Filter{"Country": "MK"},
+		},
+		{
+			name:     "pointer fields",
+			query:    userQuery{Active: &inactive},
+			expected: Filter{"active": false},
+		},
+		{
+			name:     "embedded structs",
+			query:    userQuery{auditQuery: auditQuery{CreatedBy: "admin"}, tenantQuery: &tenantQuery{Tenant: "acme"}},
+			expected: Filter{"createdBy": "admin", "tenant": "acme"},
+		},
+		{
+			name:  "zero values",
+			query: userQuery{Role: "admin"},
+			opts:  []StructFilterOption{IncludeZeroValues()},
+			expected: Filter{
+				"createdBy": "",
+				"email":     "",
+				"role":      "admin",
+				"age":       0,
+				"Country":   "",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		filter, err := FilterFromStruct(test.query, test.opts...)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(filter, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, filter)
+		}
+	}
+}
+
+func TestFilterFromStructInvalid(t *testing.T) {
+	var query *userQuery
+
+	for _, v := range []interface{}{query, "role", map[string]interface{}{"role": "admin"}} {
+		if _, err := FilterFromStruct(v); !IsErrInvalidInput(err) {
+			t.Errorf("Expected ErrInvalidInput for %#v, got %v", v, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// earthRadiusMeters is the mean radius of the Earth, used to compute the distances between the
+// points, like MongoDB does for the spherical queries.
+const earthRadiusMeters = 6378100.0
+
+// GeoPoint is a location stored as a GeoJSON point, the format of the locations that the
+// geospatial filters (Filter.Near, Filter.WithinBox) match. Note that GeoJSON lists the longitude
+// first. Create it with NewGeoPoint:
+// 		store := Store{Name: "Downtown", Location: backends.NewGeoPoint(41.9981, 21.4254)}
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// NewGeoPoint creates the GeoJSON point of the latitude and the longitude.
+func NewGeoPoint(lat, lng float64) GeoPoint {
+	return GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// Near matches the entries with a location (a GeoPoint) within the radius, in meters, of the
+// point at the latitude and the longitude. For example:
+// 		filter := backends.NewFilter().Near("location", 41.9981, 21.4254, 500)
+// matches the stores within 500m. The distance is measured on a sphere, like the "$near" queries
+// of MongoDB.
+//
+// MongoDB matches it with $geoWithin and $centerSphere, which, unlike $near, does not sort the
+// results by the distance, so it can be counted and sorted like any other filter. Create a
+// 2dsphere index on the property, like NewNonUniqueIndex("$2dsphere:location"), so the query
+// does not scan the whole collection. The in-memory backend computes the haversine distance.
+// DynamoDB has no geospatial queries, so the filter is ErrUnsupported there (see
+// BackendCapabilities.Geo).
+func (f Filter) Near(property string, lat, lng, radiusMeters float64) Filter {
+	return f.withOperator(property, "$near", map[string]interface{}{
+		"lat":    lat,
+		"lng":    lng,
+		"radius": radiusMeters,
+	})
+}
+
+// WithinBox matches the entries with a location (a GeoPoint) within the box of the latitudes
+// and the longitudes, bounds included. For example:
+// 		filter := backends.NewFilter().WithinBox("location", 41.9, 21.3, 42.1, 21.6)
+// The box cannot cross the antimeridian, so minLng must not be greater than maxLng.
+//
+// MongoDB matches it with $geoWithin and the polygon of the box, whose edges are geodesics, so
+// for the large boxes the matched area slightly differs from the box of the coordinates that the
+// in-memory backend matches. Like Near, it is ErrUnsupported on DynamoDB.
+func (f Filter) WithinBox(property string, minLat, minLng, maxLat, maxLng float64) Filter {
+	return f.withOperator(property, "$withinBox", map[string]interface{}{
+		"minLat": minLat,
+		"minLng": minLng,
+		"maxLat": maxLat,
+		"maxLng": maxLng,
+	})
+}
+
+// geoCircle is the operand of the $near operator.
+type geoCircle struct {
+	lat, lng, radius float64
+}
+
+// geoBox is the operand of the $withinBox operator.
+type geoBox struct {
+	minLat, minLng, maxLat, maxLng float64
+}
+
+// nearOperand parses the operand of the $near operator, also as restored from JSON.
+func nearOperand(operand interface{}) (geoCircle, error) {
+	values, err := geoValues(operand, "$near", "lat", "lng", "radius")
+	if err != nil {
+		return geoCircle{}, err
+	}
+	circle := geoCircle{lat: values[0], lng: values[1], radius: values[2]}
+	if err = checkCoordinates(circle.lat, circle.lng); err != nil {
+		return geoCircle{}, err
+	}
+	if circle.radius < 0 {
+		return geoCircle{}, fmt.Errorf("the radius of $near must not be negative")
+	}
+	return circle, nil
+}
+
+// boxOperand parses the operand of the $withinBox operator, also as restored from JSON.
+func boxOperand(operand interface{}) (geoBox, error) {
+	values, err := geoValues(operand, "$withinBox", "minLat", "minLng", "maxLat", "maxLng")
+	if err != nil {
+		return geoBox{}, err
+	}
+	box := geoBox{minLat: values[0], minLng: values[1], maxLat: values[2], maxLng: values[3]}
+	if err = checkCoordinates(box.minLat, box.minLng); err != nil {
+		return geoBox{}, err
+	}
+	if err = checkCoordinates(box.maxLat, box.maxLng); err != nil {
+		return geoBox{}, err
+	}
+	if box.minLat > box.maxLat || box.minLng > box.maxLng {
+		return geoBox{}, fmt.Errorf("the minimal coordinates of $withinBox must not be greater than the maximal ones")
+	}
+	return box, nil
+}
+
+// geoValues returns the numbers of the keys of the operand of a geospatial operator.
+func geoValues(operand interface{}, operator string, keys ...string) ([]float64, error) {
+	specs, ok := operand.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the operand of %s must be a map", operator)
+	}
+	values := make([]float64, len(keys))
+	for i, key := range keys {
+		if values[i], ok = geoNumber(specs[key]); !ok {
+			return nil, fmt.Errorf("%s of %s must be a number", key, operator)
+		}
+	}
+	return values, nil
+}
+
+// geoNumber converts the number to float64.
+func geoNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// checkCoordinates checks that the latitude and the longitude are in range.
+func checkCoordinates(lat, lng float64) error {
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return fmt.Errorf("invalid coordinates (%v, %v)", lat, lng)
+	}
+	return nil
+}
+
+// recordPoint returns the latitude and the longitude of the GeoJSON point of a stored record, as
+// decoded from JSON. It returns false if the value is not a point.
+func recordPoint(value interface{}) (float64, float64, bool) {
+	point, ok := value.(map[string]interface{})
+	if !ok || point["type"] != "Point" {
+		return 0, 0, false
+	}
+	coordinates, ok := point["coordinates"].([]interface{})
+	if !ok || len(coordinates) != 2 {
+		return 0, 0, false
+	}
+	lng, lngOk := geoNumber(coordinates[0])
+	lat, latOk := geoNumber(coordinates[1])
+	return lat, lng, lngOk && latOk
+}
+
+// haversineDistance returns the distance in meters between the two points on a sphere.
+func haversineDistance(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// matchGeo checks if the stored value is a point matching the $near or the $withinBox operator.
+func matchGeo(recordValue interface{}, operator string, operand interface{}) (bool, error) {
+	if operator == "$near" {
+		circle, err := nearOperand(operand)
+		if err != nil {
+			return false, err
+		}
+		lat, lng, ok := recordPoint(recordValue)
+		return ok && haversineDistance(circle.lat, circle.lng, lat, lng) <= circle.radius, nil
+	}
+	box, err := boxOperand(operand)
+	if err != nil {
+		return false, err
+	}
+	lat, lng, ok := recordPoint(recordValue)
+	return ok && lat >= box.minLat && lat <= box.maxLat && lng >= box.minLng && lng <= box.maxLng, nil
+}
+
+// toMongoGeo returns the $geoWithin condition of the $near or the $withinBox operator.
+func toMongoGeo(operator string, operand interface{}) (map[string]interface{}, error) {
+	if operator == "$near" {
+		circle, err := nearOperand(operand)
+		if err != nil {
+			return nil, err
+		}
+		// the radius of $centerSphere is in radians
+		return map[string]interface{}{
+			"$centerSphere": []interface{}{[]float64{circle.lng, circle.lat}, circle.radius / earthRadiusMeters},
+		}, nil
+	}
+	box, err := boxOperand(operand)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"$geometry": map[string]interface{}{
+			"type": "Polygon",
+			"coordinates": [][][]float64{{
+				{box.minLng, box.minLat},
+				{box.maxLng, box.minLat},
+				{box.maxLng, box.maxLat},
+				{box.minLng, box.maxLat},
+				{box.minLng, box.minLat},
+			}},
+		},
+	}, nil
+}
@@ -0,0 +1,86 @@
+package backends
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type geoTestStore struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Location GeoPoint `json:"location"`
+}
+
+func TestGeoFilters(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "stores"})
+	for _, store := range []*geoTestStore{
+		{Name: "Square", Location: NewGeoPoint(41.9961, 21.4316)},
+		{Name: "Fortress", Location: NewGeoPoint(42.0003, 21.4331)},
+		{Name: "Airport", Location: NewGeoPoint(41.9616, 21.6214)},
+	} {
+		if _, err := repo.Save(store, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fortress is about 480m from the square, the airport about 16km
+	near := NewFilter().Near("location", 41.9961, 21.4316, 1000)
+	data, err := json.Marshal(near)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := Filter{}
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, filter := range []Filter{near, restored} {
+		results, err := repo.GetAll(filter, &geoTestStore{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores := *results.(*[]*geoTestStore)
+		if len(stores) != 2 || stores[0].Name != "Fortress" || stores[1].Name != "Square" {
+			t.Fatal("Expected the stores within 1km of the square. Got: ", stores)
+		}
+	}
+
+	box := NewFilter().WithinBox("location", 41.9, 21.5, 42.0, 21.7)
+	if data, err = json.Marshal(box); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	results, err := repo.GetAll(restored, &geoTestStore{}, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stores := *results.(*[]*geoTestStore); len(stores) != 1 || stores[0].Name != "Airport" {
+		t.Fatal("Expected only the airport within the box. Got: ", stores)
+	}
+
+	if _, err = repo.GetAll(NewFilter().Near("location", 91, 0, 10), &geoTestStore{}, "", "", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for a latitude out of range. Got: ", err)
+	}
+	if _, _, err = conditionExpression(near); !IsErrUnsupported(err) {
+		t.Fatal("Expected the geospatial filter to be unsupported on DynamoDB. Got: ", err)
+	}
+}
+
+func TestToMongoGeoFilter(t *testing.T) {
+	query, err := toMongoFilter(NewFilter().Near("location", 41.9961, 21.4316, 6378.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	centerSphere := query["location"].(bson.M)["$geoWithin"].(map[string]interface{})["$centerSphere"].([]interface{})
+	center := centerSphere[0].([]float64)
+	if center[0] != 21.4316 || center[1] != 41.9961 || centerSphere[1].(float64) != 0.001 {
+		t.Fatal("Expected the $centerSphere with the longitude first and the radius in radians. Got: ", centerSphere)
+	}
+
+	if _, err = toMongoFilter(NewFilter().Near("location", 0, 0, 1).WithinBox("location", 0, 0, 1, 1)); err == nil {
+		t.Fatal("Expected an error for $near combined with $withinBox.")
+	}
+}
@@ -1,9 +1,11 @@
 package backends
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	"gopkg.in/mgo.v2/bson"
@@ -30,21 +32,13 @@ func InterfaceToMap(object interface{}) (*map[string]interface{}, error) {
 			f := rValue.Field(i)
 			tag := typeOfObject.Field(i).Tag
 			key := typeOfObject.Field(i).Name
-			log.Println("InterfaceToMap:key")
-			log.Println(key)
 			if bsonName, ok := tag.Lookup("bson"); ok {
 				key = bsonName
-				log.Println("InterfaceToMap:key bson")
-				log.Println(key)
 			} else if jsonName, ok := tag.Lookup("json"); ok {
 				key = jsonName
-				log.Println("InterfaceToMap:key json")
-				log.Println(key)
 			}
 			if strings.Contains(key, ",") {
 				key = key[0:strings.Index(key, ",")]
-				log.Println("InterfaceToMap:key contains")
-				log.Println(key)
 			}
 			value := f.Interface()
 			(*result)[key] = value
@@ -77,6 +71,276 @@ func MapToInterface(object interface{}, result interface{}) error {
 	return nil
 }
 
+// strictDecode decodes the record into the result like MapToInterface, but it fails with
+// ErrInvalidInput if the record has a property that the result type does not have.
+func strictDecode(record interface{}, result interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(result); err != nil {
+		return ErrInvalidInput(fmt.Sprintf("the record does not match the result type: %s", err.Error()))
+	}
+	return nil
+}
+
+// decodeRecord decodes the record into the result like MapToInterface, but it fails with
+// ErrInvalidInput if a value of the record does not fit the field of the result, like a string
+// in an int field.
+func decodeRecord(record interface{}, result interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		return ErrInvalidInput(fmt.Sprintf("the record does not match the result type: %s", err.Error()))
+	}
+	return nil
+}
+
+// rangeFilter returns the filter matching the value of the hash key and the range condition on
+// the range key, for QueryRange on the backends without key conditions.
+func rangeFilter(def RepositoryDefinition, hashValue interface{}, rangeOp string, rangeValue interface{}) (Filter, error) {
+	hashKey, rangeKey := def.GetHashKey(), def.GetRangeKey()
+	if hashKey == "" || rangeKey == "" {
+		return nil, ErrInvalidInput("the repository has no hash key and range key")
+	}
+
+	filter := NewFilter().Match(hashKey, hashValue)
+	switch rangeOp {
+	case "$eq":
+		return filter.Match(rangeKey, rangeValue), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return filter.withOperator(rangeKey, rangeOp, rangeValue), nil
+	case "$startsWith":
+		prefix, ok := rangeValue.(string)
+		if !ok {
+			return nil, ErrInvalidInput("the prefix of $startsWith must be a string")
+		}
+		return filter.StartsWith(rangeKey, prefix), nil
+	case "$between":
+		bounds, ok := rangeValue.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, ErrInvalidInput("the value of $between must be []interface{}{from, to}")
+		}
+		return filter.Between(rangeKey, bounds[0], bounds[1]), nil
+	}
+	return nil, ErrInvalidInput(fmt.Sprintf("unsupported range operator %q", rangeOp))
+}
+
+// queryRangeWithFilter runs QueryRange as GetAll with the filter of rangeFilter, ordered by the
+// range key.
+func queryRangeWithFilter(repo Repository, def RepositoryDefinition, hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	filter, err := rangeFilter(def, hashValue, rangeOp, rangeValue)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAll(filter, resultsTypeHint, def.GetRangeKey(), Ascending.String(), limit, offset)
+}
+
+// usesIndex returns the most selective of the indexes whose fields are all matched exactly by the
+// filter: a unique index first, then the index with the most fields, then the first one listed.
+func usesIndex(indexes []Index, filter Filter) (Index, bool) {
+	var used Index
+	for _, index := range indexes {
+		fields := index.GetFields()
+		if len(fields) == 0 || !matchesExactly(filter, fields) {
+			continue
+		}
+		if used == nil ||
+			(index.Unique() && !used.Unique()) ||
+			(index.Unique() == used.Unique() && len(fields) > len(used.GetFields())) {
+			used = index
+		}
+	}
+	return used, used != nil
+}
+
+// matchesExactly checks if the filter matches all the fields with an exact value.
+func matchesExactly(filter Filter, fields []string) bool {
+	for _, field := range fields {
+		value, ok := filter[field]
+		if !ok {
+			return false
+		}
+		if _, isOperator := operatorSpecs(value); isOperator {
+			return false
+		}
+	}
+	return true
+}
+
+// storedDecoder converts a record read as a map by a wrapped repository, like the record with the
+// stored names of FieldMappingRepository, to the record to decode into the results.
+type storedDecoder func(stored interface{}) (map[string]interface{}, error)
+
+// decode decodes the stored record into the result. The strict decode fails for the properties
+// that the result does not have, like strictDecode.
+func (d storedDecoder) decode(stored interface{}, result interface{}, strict bool) error {
+	record, err := d(stored)
+	if err != nil {
+		return err
+	}
+	if strict {
+		return strictDecode(record, result)
+	}
+	return decodeRecord(record, result)
+}
+
+// decodeLike decodes the stored record into a new value of the type of the example.
+func (d storedDecoder) decodeLike(stored interface{}, example interface{}, strict bool) (interface{}, error) {
+	result, err := CreateNewAsExample(example)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.decode(stored, result, strict); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// decodeAll decodes the stored records into a pointer to a slice of the type of the results hint.
+// The nil records, like the missing records of GetByIDs, stay nil.
+func (d storedDecoder) decodeAll(records interface{}, resultsTypeHint interface{}, strict bool) (interface{}, error) {
+	resultsTypeHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultsTypeHint)
+	err = IterateOverSlice(records, func(i int, record interface{}) error {
+		if value := reflect.ValueOf(record); !value.IsValid() || (value.Kind() == reflect.Ptr && value.IsNil()) {
+			results = reflect.Append(results, reflect.Zero(results.Type().Elem()))
+			return nil
+		}
+		item, err := d.decodeLike(record, resultsTypeHint, strict)
+		if err != nil {
+			return err
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+
+	return slicePointer.Interface(), nil
+}
+
+// getOneStrict fetches the record as a map with the read options and decodes it into the
+// result with strictDecode.
+func getOneStrict(repo Repository, filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	opts.StrictDecode = false
+	record, err := repo.GetOneWithOpts(filter, &map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = strictDecode(record, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getAllStrict fetches the records as maps with the read options and decodes them into a
+// pointer to a slice of the type of the results hint with strictDecode.
+func getAllStrict(repo Repository, filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	opts.StrictDecode = false
+	records, err := repo.GetAllWithOpts(filter, &map[string]interface{}{}, order, sorting, limit, offset, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsTypeHint, err = resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultsTypeHint)
+	err = IterateOverSlice(records, func(i int, record interface{}) error {
+		item, err := CreateNewAsExample(resultsTypeHint)
+		if err != nil {
+			return err
+		}
+		if err = strictDecode(record, item); err != nil {
+			return err
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+
+	return slicePointer.Interface(), nil
+}
+
+// getAllSkippingDecodeErrors fetches the records as maps with the read options and decodes them
+// into a pointer to a slice of the type of the results hint. The records that cannot be decoded
+// are skipped and their errors are returned as DecodeErrors, along with the decoded results.
+func getAllSkippingDecodeErrors(repo Repository, filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	strict := opts.StrictDecode
+	opts.SkipDecodeErrors = false
+	opts.StrictDecode = false
+	records, err := repo.GetAllWithOpts(filter, &map[string]interface{}{}, order, sorting, limit, offset, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsTypeHint, err = resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultsTypeHint)
+	decodeErrs := DecodeErrors{}
+	err = IterateOverSlice(records, func(i int, record interface{}) error {
+		item, err := CreateNewAsExample(resultsTypeHint)
+		if err != nil {
+			return err
+		}
+		if strict {
+			err = strictDecode(record, item)
+		} else {
+			err = decodeRecord(record, item)
+		}
+		if err != nil {
+			decodeErrs = append(decodeErrs, recordDecodeError(record, err))
+			return nil
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+
+	if len(decodeErrs) > 0 {
+		return slicePointer.Interface(), decodeErrs
+	}
+	return slicePointer.Interface(), nil
+}
+
+// recordDecodeError adds the ID of the record, if it has one, to the error of its decoding.
+func recordDecodeError(record interface{}, err error) error {
+	if properties, ok := record.(*map[string]interface{}); ok && properties != nil {
+		record = *properties
+	}
+	if properties, ok := record.(map[string]interface{}); ok {
+		if id, ok := properties["id"]; ok {
+			return fmt.Errorf("record %v: %s", id, err.Error())
+		}
+	}
+	return err
+}
+
 // IterateOverSlice iterates over a slice viewed as generic itnerface{}. A callback function is called for
 // every item in the slice. If the callback returns an error, the iteration will break and the function will
 // return that error.
@@ -108,13 +372,27 @@ func IterateOverSlice(slice interface{}, callback func(i int, item interface{})
 func stringToObjectID(object map[string]interface{}) error {
 	if id, ok := object["id"]; ok {
 		delete(object, "id")
-		if !bson.IsObjectIdHex(id.(string)) {
+		if specs, isOperator := operatorSpecs(id); isOperator {
+			// the ranges of the IDs, like the ones of a Cursor, compare the ObjectIds
+			for _, operator := range []string{"$gt", "$gte", "$lt", "$lte", "$ne"} {
+				if hex, ok := specs[operator].(string); ok && bson.IsObjectIdHex(hex) {
+					specs[operator] = bson.ObjectIdHex(hex)
+				}
+			}
+			object["_id"] = specs
+			return nil
+		}
+		hex, isString := id.(string)
+		if !isString {
+			// nil (IS NULL), an ObjectId and the other operators (like MatchAny) apply to the ID as they are
+			object["_id"] = id
+			return nil
+		}
+		if !bson.IsObjectIdHex(hex) {
 			return ErrInvalidInput("id is a invalid hex representation of an ObjectId")
 		}
 
-		if reflect.TypeOf(id).String() != "bson.ObjectId" {
-			object["_id"] = bson.ObjectIdHex(id.(string))
-		}
+		object["_id"] = bson.ObjectIdHex(hex)
 	}
 
 	return nil
@@ -138,6 +416,16 @@ func contains(s []*string, item string) bool {
 	return false
 }
 
+// containsString checks if item is in s array
+func containsString(s []string, item string) bool {
+	for _, a := range s {
+		if a == item {
+			return true
+		}
+	}
+	return false
+}
+
 // CreateNewAsExample creates a new value of the same type as the "example" passed to the function.
 // The function always returns a pointer to the created value.
 func CreateNewAsExample(example interface{}) (interface{}, error) {
@@ -196,3 +484,348 @@ func valueOrError(val reflect.Value) (reflect.Value, error) {
 	}
 	return val, nil
 }
+
+// idKey returns the key used to match the requested IDs to the fetched records.
+// The numbers decoded from JSON are float64, so the IDs are compared by their string representation.
+func idKey(id interface{}) string {
+	return fmt.Sprintf("%v", id)
+}
+
+// orderByIDs returns a pointer to a slice of results, one for each of the requested IDs, in the same order.
+// The records are mapped by idKey of their ID. The results for the missing records are nil.
+func orderByIDs(ids []interface{}, records map[string]map[string]interface{}, resultHint interface{}) (interface{}, error) {
+	resultHint, err := resultsHintPtr(resultHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultHint)
+
+	for _, id := range ids {
+		record, ok := records[idKey(id)]
+		if !ok {
+			results = reflect.Append(results, reflect.Zero(results.Type().Elem()))
+			continue
+		}
+		item, err := CreateNewAsExample(resultHint)
+		if err != nil {
+			return nil, err
+		}
+		if err = MapToInterface(&record, item); err != nil {
+			return nil, err
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+
+	return slicePointer.Interface(), nil
+}
+
+// upsertProperties returns the exact match properties of the filter, which are set on the record
+// inserted by an upsert together with the properties of the object.
+func upsertProperties(filter Filter) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for property, value := range filter {
+		if property == NotOperator || property == TextOperator || property == OrOperator {
+			continue
+		}
+		if _, isOperator := operatorSpecs(value); isOperator {
+			continue
+		}
+		properties[property] = value
+	}
+	return properties
+}
+
+// conflictFilter builds the filter that matches the record with the same values of the conflict keys.
+func conflictFilter(record map[string]interface{}, conflictKeys []string) (Filter, error) {
+	if len(conflictKeys) == 0 {
+		return nil, ErrInvalidInput("at least one conflict key is required")
+	}
+	filter := NewFilter()
+	for _, key := range conflictKeys {
+		value, ok := record[key]
+		if !ok {
+			return nil, ErrInvalidInput(fmt.Sprintf("the conflict key %s is missing", key))
+		}
+		filter.Match(key, value)
+	}
+	return filter, nil
+}
+
+// conflictKey returns the key identifying the record by the values of the conflict keys.
+func conflictKey(record map[string]interface{}, conflictKeys []string) string {
+	values := []string{}
+	for _, key := range conflictKeys {
+		values = append(values, idKey(record[key]))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// upsertResults decodes the upserted records, each to a value of the same type as its object.
+// The rows colliding on the conflict keys all get the record written by the last of them.
+func upsertResults(objects []interface{}, records []map[string]interface{}, conflictKeys []string) ([]interface{}, error) {
+	latest := map[string]map[string]interface{}{}
+	for _, record := range records {
+		latest[conflictKey(record, conflictKeys)] = record
+	}
+
+	results := []interface{}{}
+	for i, record := range records {
+		record = latest[conflictKey(record, conflictKeys)]
+		result, err := CreateNewAsExample(objects[i])
+		if err != nil {
+			return nil, err
+		}
+		if err = MapToInterface(&record, result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// sortRecords sorts the records by the value of the order property. The strings are compared
+// by the collation, if set.
+func sortRecords(records []map[string]interface{}, order, sorting string, collation *Collation) {
+	if order == "" {
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		cmp := compareCollated(records[i][order], records[j][order], collation)
+		if isDescending(sorting) {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
+// sortRecordsByKeys sorts the records by the sort keys, the first key first.
+func sortRecordsByKeys(records []map[string]interface{}, sortKeys []SortKey) {
+	if len(sortKeys) == 0 {
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range sortKeys {
+			cmp := compareValues(records[i][key.Property], records[j][key.Property])
+			if cmp == 0 {
+				continue
+			}
+			if isDescending(key.Sorting) {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+// sortPage sorts the matched records of GetAll by the order, or by the default sort of the
+// definition without one. A sorted page of the records (with a limit or an offset) is sorted by
+// the ID field last, as the tiebreaker of the records with equal sort values, so the pages are
+// disjoint and together return every record once. The unsorted records are kept in the order
+// they are read.
+func sortPage(records []map[string]interface{}, repoDef RepositoryDefinition, idField string, order string, sorting string, limit int, offset int, collation *Collation) {
+	defaultSort := repoDef.GetDefaultSort()
+	if order == "" && len(defaultSort) == 0 {
+		return
+	}
+	if limit > 0 || offset > 0 {
+		// the sorts are stable, so the records sorted by ID stay sorted by ID within the ties
+		sortRecordsByKeys(records, []SortKey{{Property: idField}})
+	}
+	if order == "" {
+		sortRecordsByKeys(records, defaultSort)
+	}
+	sortRecords(records, order, sorting, collation)
+}
+
+// checkFindAndModify validates the arguments of FindAndModify. The update must not set any of
+// the key properties, as the keys of a record are immutable.
+func checkFindAndModify(update map[string]interface{}, limit int, keys ...string) error {
+	if limit <= 0 {
+		return ErrInvalidInput(fmt.Sprintf("limit must be positive, got %d", limit))
+	}
+	return checkUpdateFields(update, keys...)
+}
+
+// checkUpdateFields checks that the update sets some fields, and none of the key properties.
+func checkUpdateFields(update map[string]interface{}, keys ...string) error {
+	if len(update) == 0 {
+		return ErrInvalidInput("update is required")
+	}
+	for _, key := range keys {
+		if _, ok := update[key]; ok && key != "" {
+			return ErrInvalidInput(fmt.Sprintf("cannot update the key property %s", key))
+		}
+	}
+	return nil
+}
+
+// checkArrayUpdate checks the field and the values of ArrayAppend and ArrayRemove. The field
+// cannot be one of the key properties.
+func checkArrayUpdate(field string, values []interface{}, keys ...string) error {
+	if field == "" {
+		return ErrInvalidInput("field is required")
+	}
+	if len(values) == 0 {
+		return ErrInvalidInput("values are required")
+	}
+	return checkUpdateFields(map[string]interface{}{field: values}, keys...)
+}
+
+// storedArray returns the array of the field of the stored record, or nil if the field is missing
+// or nil. It fails with ErrInvalidInput if the field holds a value that is not an array.
+func storedArray(record map[string]interface{}, field string) ([]interface{}, error) {
+	value, ok := record[field]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("the field %s is not an array", field))
+	}
+	return array, nil
+}
+
+// removeArrayValues returns the elements of the array that are equal to none of the values, and
+// the indexes of the removed elements. The values are compared by their JSON representation.
+func removeArrayValues(array []interface{}, values []interface{}) ([]interface{}, []int, error) {
+	normalized, err := normalizeValue(values)
+	if err != nil {
+		return nil, nil, ErrInvalidInput(err)
+	}
+	remaining := []interface{}{}
+	removed := []int{}
+	for i, element := range array {
+		value, err := normalizeValue(element)
+		if err != nil {
+			return nil, nil, err
+		}
+		if containsValue(normalized.([]interface{}), value) {
+			removed = append(removed, i)
+			continue
+		}
+		remaining = append(remaining, element)
+	}
+	return remaining, removed, nil
+}
+
+// containsValue checks if any of the values is deeply equal to the value.
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareCollated compares the values like compareValues, with the strings compared by the
+// collation first. The strings equal by the collation are ordered by compareValues.
+func compareCollated(a, b interface{}, collation *Collation) int {
+	if collation != nil {
+		as, aOk := a.(string)
+		bs, bOk := b.(string)
+		if aOk && bOk {
+			if cmp := strings.Compare(collation.fold(as), collation.fold(bs)); cmp != 0 {
+				return cmp
+			}
+		}
+	}
+	return compareValues(a, b)
+}
+
+// pageBounds validates the limit and the offset of a page of results. A limit of zero or less
+// means no limit, so it is returned as zero. A negative offset is ErrInvalidInput.
+func pageBounds(limit, offset int) (int, error) {
+	if offset < 0 {
+		return 0, ErrInvalidInput(fmt.Sprintf("offset must not be negative, got %d", offset))
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	return limit, nil
+}
+
+// firstResult returns the first of the results of GetAll, or ErrNotFound if there are none.
+func firstResult(results interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	slice := reflect.Indirect(reflect.ValueOf(results))
+	if slice.Kind() != reflect.Slice {
+		return nil, ErrBackendError(fmt.Sprintf("expected a slice of results, got %T", results))
+	}
+	if slice.Len() == 0 {
+		return nil, ErrNotFound("record not found")
+	}
+	return slice.Index(0).Interface(), nil
+}
+
+// recordsPage decodes the records in the range given by the offset and limit into a pointer
+// to a slice of the type of the results hint.
+func recordsPage(records []map[string]interface{}, resultsTypeHint interface{}, limit, offset int) (interface{}, error) {
+	resultsTypeHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultsTypeHint)
+
+	if offset > len(records) {
+		offset = len(records)
+	}
+	records = records[offset:]
+	if limit != 0 && limit < len(records) {
+		records = records[:limit]
+	}
+
+	for _, record := range records {
+		item, err := CreateNewAsExample(resultsTypeHint)
+		if err != nil {
+			return nil, err
+		}
+		if err = decodeRecord(&record, item); err != nil {
+			return nil, err
+		}
+		results = reflect.Append(results, reflect.ValueOf(item))
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+
+	return slicePointer.Interface(), nil
+}
+
+// resultsHintPtr checks the results type hint of a read of many records and returns it as a
+// pointer, see AsPtr. The hint is an example of one record: a struct or a map with string keys, or
+// a pointer to one, like &User{} or &map[string]interface{}{}. The results are a pointer to a
+// slice of pointers to its type, like *[]*User. Any other hint, like nil or a slice, is
+// ErrInvalidInput.
+func resultsHintPtr(resultsTypeHint interface{}) (interface{}, error) {
+	if resultsTypeHint == nil {
+		return nil, ErrInvalidInput("the results type hint is required, like &User{}")
+	}
+	hintType := reflect.TypeOf(resultsTypeHint)
+	elemType := hintType
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	switch elemType.Kind() {
+	case reflect.Struct:
+	case reflect.Map:
+		if elemType.Key().Kind() != reflect.String {
+			return nil, ErrInvalidInput(fmt.Sprintf("the results type hint %s is a map without string keys", hintType))
+		}
+	case reflect.Slice, reflect.Array:
+		recordType := elemType.Elem()
+		for recordType.Kind() == reflect.Ptr {
+			recordType = recordType.Elem()
+		}
+		return nil, ErrInvalidInput(fmt.Sprintf("the results type hint %s is a slice, pass an example of one record like &%s{} instead", hintType, recordType))
+	default:
+		return nil, ErrInvalidInput(fmt.Sprintf("the results type hint %s is not a struct or a map, or a pointer to one", hintType))
+	}
+	return AsPtr(resultsTypeHint), nil
+}
@@ -3,6 +3,8 @@ package backends
 import (
 	"fmt"
 	"testing"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestInterfaceToMap(t *testing.T) {
@@ -49,6 +51,14 @@ func TestStringToObjectID(t *testing.T) {
 	if _, ok := testMap["_id"]; !ok {
 		t.Errorf("ID not transformed")
 	}
+
+	rangeMap := map[string]interface{}(NewFilter().Gt("id", "5975c461f9f8eb02aae053f3"))
+	if err = stringToObjectID(rangeMap); err != nil {
+		t.Fatal(err)
+	}
+	if gt := rangeMap["_id"].(map[string]interface{})["$gt"]; gt != bson.ObjectIdHex("5975c461f9f8eb02aae053f3") {
+		t.Errorf("Expected the operand of the range converted to an ObjectId. Got: %v", gt)
+	}
 }
 
 func TestIsConditionalCheckErr(t *testing.T) {
@@ -0,0 +1,142 @@
+package backends
+
+import (
+	"fmt"
+	"time"
+)
+
+// IdempotencyKeysRepository is the name of the metadata repository in which
+// Repository.SaveIdempotent records the processed idempotency keys of the repositories of a
+// backend, one record per key.
+const IdempotencyKeysRepository = "backends_idempotency_keys"
+
+// MaxIdempotencyTTL is the longest time an idempotency key can be kept. It is the TTL of the
+// records of IdempotencyKeysRepository, after which the backends that expire the records
+// natively delete them.
+const MaxIdempotencyTTL = 7 * 24 * time.Hour
+
+// idempotencyKeyRecord is the record of a processed idempotency key of a repository.
+type idempotencyKeyRecord struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repository"`
+	RecordID   string    `json:"recordId"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// idempotencyKeysDefinition is the definition of the metadata repository of the idempotency
+// keys, keyed by the name of the repository and the key. The key is unique, so a concurrent
+// claim of a key fails with ErrAlreadyExists.
+func idempotencyKeysDefinition() RepositoryDefinitionMap {
+	return RepositoryDefinitionMap{
+		"name":          IdempotencyKeysRepository,
+		"customId":      true,
+		"hashKey":       "id",
+		"indexes":       []Index{NewIndex("id", true, "id")},
+		"readCapacity":  int64(1),
+		"writeCapacity": int64(1),
+		"enableTtl":     true,
+		"ttlAttribute":  "createdAt",
+		"ttl":           int(MaxIdempotencyTTL / time.Second),
+	}
+}
+
+// boundSave is an object of SaveIdempotent bound to the repository that saves it.
+type boundSave struct {
+	object interface{}
+	repo   Repository
+}
+
+// bindSave returns the object of SaveIdempotent saved with the repository instead of the one
+// SaveIdempotent is called on. The repository wrappers bind the object to themselves, so the
+// object is saved through all the wrappers of the repository SaveIdempotent was called on. An
+// object already bound by an outer wrapper is returned unchanged.
+func bindSave(object interface{}, repo Repository) interface{} {
+	if _, ok := object.(boundSave); ok {
+		return object
+	}
+	return boundSave{object: object, repo: repo}
+}
+
+// saveIdempotent saves the object to the repository of the backend unless the idempotency key
+// was processed within its TTL, see Repository.SaveIdempotent. The key is claimed before the
+// object is saved, so of the concurrent saves with the same key only one saves the object. If
+// the save fails, the claim is released so the key can be retried.
+func saveIdempotent(repo Repository, backend Backend, name, idField string, object interface{}, key string, ttl time.Duration) (interface{}, bool, error) {
+	if bound, ok := object.(boundSave); ok {
+		object, repo = bound.object, bound.repo
+	}
+	if key == "" {
+		return nil, false, ErrInvalidInput("idempotency key is required")
+	}
+	if ttl <= 0 || ttl > MaxIdempotencyTTL {
+		return nil, false, ErrInvalidInput(fmt.Sprintf("the TTL of the idempotency key must be positive and at most %s, got %s", MaxIdempotencyTTL, ttl))
+	}
+	if backend == nil {
+		return nil, false, ErrBackendError("the repository is not defined on a backend")
+	}
+
+	keys, err := backend.DefineRepository(IdempotencyKeysRepository, idempotencyKeysDefinition())
+	if err != nil {
+		return nil, false, err
+	}
+	keyFilter := NewFilter().Match("id", name+"/"+key)
+
+	processed := &idempotencyKeyRecord{}
+	_, err = keys.GetOne(keyFilter, processed)
+	switch {
+	case err == nil && time.Now().Before(processed.ExpiresAt):
+		return processedRecord(repo, idField, object, processed.RecordID)
+	case err == nil:
+		if err = keys.DeleteOne(keyFilter); err != nil && !IsErrNotFound(err) {
+			return nil, false, err
+		}
+	case !IsErrNotFound(err):
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	claim := &idempotencyKeyRecord{ID: name + "/" + key, Repository: name, ExpiresAt: now.Add(ttl), CreatedAt: now}
+	if _, err = keys.Save(claim, nil); err != nil {
+		if IsErrAlreadyExists(err) {
+			// claimed by a concurrent save with the same key
+			return object, true, nil
+		}
+		return nil, false, err
+	}
+
+	saved, err := repo.Save(object, nil)
+	if err != nil {
+		if releaseErr := keys.DeleteOne(keyFilter); releaseErr != nil && !IsErrNotFound(releaseErr) {
+			return nil, false, fmt.Errorf("%s (releasing the idempotency key failed: %s)", err.Error(), releaseErr.Error())
+		}
+		return nil, false, err
+	}
+
+	record, err := InterfaceToMap(saved)
+	if err != nil {
+		return nil, false, err
+	}
+	claim.RecordID = fmt.Sprint((*record)[idField])
+	if _, _, err = keys.SaveUpsert(claim, keyFilter); err != nil {
+		return nil, false, err
+	}
+	return saved, false, nil
+}
+
+// processedRecord returns the record saved by the first save with an idempotency key, read into
+// the object. If the record was deleted since, or the first save has not finished, the object is
+// returned unchanged.
+func processedRecord(repo Repository, idField string, object interface{}, recordID string) (interface{}, bool, error) {
+	if recordID == "" {
+		return object, true, nil
+	}
+	result, err := repo.GetOne(NewFilter().Match(idField, recordID), object)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return object, true, nil
+		}
+		return nil, false, err
+	}
+	return result, true, nil
+}
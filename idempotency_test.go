@@ -0,0 +1,34 @@
+package backends
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveIdempotent(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "payments"})
+
+	first, deduped, err := repo.SaveIdempotent(&map[string]interface{}{"amount": 10}, "message-1", time.Hour)
+	if err != nil || deduped {
+		t.Fatal("Expected the first save with the key to be applied. Got: ", deduped, err)
+	}
+	second, deduped, err := repo.SaveIdempotent(&map[string]interface{}{"amount": 10}, "message-1", time.Hour)
+	if err != nil || !deduped {
+		t.Fatal("Expected the repeated key to be deduped. Got: ", deduped, err)
+	}
+	if id := (*second.(*map[string]interface{}))["id"]; id != (*first.(*map[string]interface{}))["id"] {
+		t.Fatal("Expected the record of the first save. Got: ", second)
+	}
+	if count, err := repo.Count(nil); err != nil || count != 1 {
+		t.Fatal("Expected one record for the two saves with the same key. Got: ", count, err)
+	}
+
+	if _, deduped, err = repo.SaveIdempotent(&map[string]interface{}{"amount": 20}, "message-2", time.Hour); err != nil || deduped {
+		t.Fatal("Expected a save with another key to be applied. Got: ", deduped, err)
+	}
+	for _, ttl := range []time.Duration{0, MaxIdempotencyTTL + time.Hour} {
+		if _, _, err = repo.SaveIdempotent(&map[string]interface{}{"amount": 30}, "message-3", ttl); !IsErrInvalidInput(err) {
+			t.Fatal("Expected a TTL out of range to be rejected. Got: ", err)
+		}
+	}
+}
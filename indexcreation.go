@@ -0,0 +1,104 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IndexCreationMode is how DefineRepository handles the declared indexes of a repository (the
+// GSIs for DynamoDB), set with the "indexCreation" entry of the definition.
+type IndexCreationMode string
+
+const (
+	// IndexCreateIfMissing creates the indexes that the collection/table does not have. This is
+	// the default.
+	IndexCreateIfMissing IndexCreationMode = "createIfMissing"
+	// IndexSkip does not create the indexes, for when they are managed by a separate migration.
+	// A missing index is not reported, the queries just don't use it.
+	IndexSkip IndexCreationMode = "skip"
+	// IndexFailIfMissing does not create the indexes, and fails DefineRepository with
+	// MissingIndexesError if any of them is missing.
+	IndexFailIfMissing IndexCreationMode = "failIfMissing"
+)
+
+// ErrMissingIndexes is the error wrapped by MissingIndexesError. It is of the ErrNotFound class.
+var ErrMissingIndexes = ErrNotFound("missing indexes")
+
+// MissingIndexesError is returned by DefineRepository with IndexFailIfMissing when the
+// collection/table does not have some of the declared indexes. Use errors.As to get the names of
+// the missing indexes:
+// 		var missingErr backends.MissingIndexesError
+// 		if errors.As(err, &missingErr) {
+// 			fmt.Println(missingErr.Repository, missingErr.Indexes)
+// 		}
+type MissingIndexesError struct {
+	// Repository is the name of the collection/table.
+	Repository string
+	// Indexes are the names of the missing indexes.
+	Indexes []string
+}
+
+// Error returns the error message.
+func (e MissingIndexesError) Error() string {
+	return fmt.Sprintf("missing indexes: %s does not have the indexes [%s]", e.Repository, strings.Join(e.Indexes, ", "))
+}
+
+// Unwrap returns ErrMissingIndexes, so errors.Is(err, ErrMissingIndexes) and IsErrNotFound report
+// true for a MissingIndexesError.
+func (e MissingIndexesError) Unwrap() error {
+	return ErrMissingIndexes
+}
+
+// IsErrMissingIndexes checks if the error is a MissingIndexesError.
+func IsErrMissingIndexes(err error) bool {
+	return errors.Is(err, ErrMissingIndexes)
+}
+
+// checkIndexCreationMode checks the index creation mode of the definition and, with
+// IndexFailIfMissing, that the indexes of the definition exist. The missing indexes are the ones
+// that the planner of the backend would create.
+func (m *RepositoriesBackend) checkIndexCreationMode(def RepositoryDefinition) error {
+	mode := def.GetIndexCreationMode()
+	switch mode {
+	case IndexCreateIfMissing, IndexSkip:
+		return nil
+	case IndexFailIfMissing:
+	default:
+		return ErrInvalidInput(fmt.Sprintf("unknown index creation mode %q", mode))
+	}
+
+	if m.repositoryPlanner == nil {
+		return ErrUnsupported("the backend cannot check the indexes of the repositories")
+	}
+	plan, err := m.repositoryPlanner(def, m)
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, operation := range plan.Operations {
+		if operation.Action == ActionCreateIndex {
+			missing = append(missing, operation.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return MissingIndexesError{Repository: plan.Repository, Indexes: missing}
+	}
+	return nil
+}
+
+// withoutIndexCreation removes the creation of the indexes from the plan of a definition whose
+// indexes are not created by DefineRepository.
+func withoutIndexCreation(plan *RepositoryPlan, def RepositoryDefinition) *RepositoryPlan {
+	if plan == nil || def.GetIndexCreationMode() == IndexCreateIfMissing {
+		return plan
+	}
+	operations := []PlannedOperation{}
+	for _, operation := range plan.Operations {
+		if operation.Action != ActionCreateIndex {
+			operations = append(operations, operation)
+		}
+	}
+	plan.Operations = operations
+	return plan
+}
@@ -0,0 +1,186 @@
+package backends
+
+import (
+	"encoding/json"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// JoinLeft is the key of the record of the left repository in the rows of Join.
+	JoinLeft = "left"
+	// JoinRight is the key of the record of the right repository in the rows of Join.
+	JoinRight = "right"
+
+	// joinBatchSize is the number of the values of the left field looked up in the right
+	// repository with one query of the app-side join.
+	joinBatchSize = 100
+)
+
+// Join returns the records of the left repository matching the filter joined with the records of
+// the right repository whose rightField equals their leftField. Each row has the left record under
+// JoinLeft and a matched right record under JoinRight, in the form described in GetOneRaw:
+// 		rows, err := backends.Join(orders, customers, "customerId", "id", backends.NewFilter().Match("status", "open"))
+// 		for _, row := range rows {
+// 			fmt.Println(row[backends.JoinLeft]["total"], row[backends.JoinRight]["name"])
+// 		}
+// It is an inner join: a left record matching several right records is in several rows, and one
+// matching none is in no row. The rows are in the order of the left records.
+//
+// For two MongoDB collections of the same database the join is done by the database with a
+// $lookup, unless a join field is the ObjectId of a collection without a custom ID. Otherwise the
+// join is done in the application: all the matched left records are read, then the right records
+// with their values of the join field, in batches of 100 values, and the rows are built with a
+// hash table of the right records. The matched left records and their right records are all held
+// in memory, so narrow the filter for the large repositories.
+func Join(left Repository, right Repository, leftField, rightField string, filter Filter) ([]map[string]interface{}, error) {
+	if leftField == "" || rightField == "" {
+		return nil, ErrInvalidInput("the join fields are required")
+	}
+	if leftCollection, ok := left.(*MongoCollection); ok {
+		if rightCollection, ok := right.(*MongoCollection); ok && leftCollection.canLookup(rightCollection, leftField, rightField) {
+			return leftCollection.lookup(rightCollection, leftField, rightField, filter)
+		}
+	}
+	return hashJoin(left, right, leftField, rightField, filter)
+}
+
+// hashJoin joins the records of the repositories in the application, see Join.
+func hashJoin(left Repository, right Repository, leftField, rightField string, filter Filter) ([]map[string]interface{}, error) {
+	leftRecords, err := GetAllRaw(left, filter, "", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{}
+	seen := map[string]bool{}
+	for _, record := range leftRecords {
+		value, ok := record[leftField]
+		if !ok || value == nil {
+			continue
+		}
+		key, err := joinKey(value)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, value)
+		}
+	}
+
+	matches := map[string][]map[string]interface{}{}
+	for start := 0; start < len(values); start += joinBatchSize {
+		end := start + joinBatchSize
+		if end > len(values) {
+			end = len(values)
+		}
+		rightRecords, err := GetAllRaw(right, NewFilter().In(rightField, values[start:end]...), "", "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range rightRecords {
+			key, err := joinKey(record[rightField])
+			if err != nil {
+				return nil, err
+			}
+			matches[key] = append(matches[key], record)
+		}
+	}
+
+	rows := []map[string]interface{}{}
+	for _, record := range leftRecords {
+		value, ok := record[leftField]
+		if !ok || value == nil {
+			continue
+		}
+		key, err := joinKey(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches[key] {
+			rows = append(rows, map[string]interface{}{JoinLeft: record, JoinRight: match})
+		}
+	}
+	return rows, nil
+}
+
+// joinKey returns the key of a value of a join field in the hash table of the right records.
+// The values are in their JSON form, so equal values have the same JSON.
+func joinKey(value interface{}) (string, error) {
+	key, err := json.Marshal(value)
+	if err != nil {
+		return "", ErrInvalidInput(err)
+	}
+	return string(key), nil
+}
+
+// canLookup checks if the join with the right collection can be done with a $lookup: both
+// collections are in the same database and neither join field is an ObjectId, which is
+// compared as its hex string by the app-side join.
+func (c *MongoCollection) canLookup(right *MongoCollection, leftField, rightField string) bool {
+	if c.Database == nil || right.Database == nil || c.Database.Name != right.Database.Name {
+		return false
+	}
+	isObjectID := func(repoDef RepositoryDefinition, field string) bool {
+		return !repoDef.IsCustomID() && (field == "id" || field == "_id")
+	}
+	return !isObjectID(c.repoDef, leftField) && !isObjectID(right.repoDef, rightField)
+}
+
+// lookup joins the documents of the collection matching the filter with the documents of the
+// right collection with a $lookup, see Join.
+func (c *MongoCollection) lookup(right *MongoCollection, leftField, rightField string, filter Filter) ([]map[string]interface{}, error) {
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	const joined = "__joined"
+	pipeline := []bson.M{
+		{"$match": mongoFilter},
+		{"$lookup": bson.M{"from": right.Name, "localField": leftField, "foreignField": rightField, "as": joined}},
+		{"$unwind": "$" + joined},
+	}
+	documents := []map[string]interface{}{}
+	if err = c.Pipe(pipeline).All(&documents); err != nil {
+		return nil, err
+	}
+
+	rows := []map[string]interface{}{}
+	for _, document := range documents {
+		var rightDocument map[string]interface{}
+		switch nested := document[joined].(type) {
+		case bson.M:
+			rightDocument = nested
+		case map[string]interface{}:
+			rightDocument = nested
+		}
+		delete(document, joined)
+
+		leftRecord, err := c.rawDocument(document)
+		if err != nil {
+			return nil, err
+		}
+		rightRecord, err := right.rawDocument(rightDocument)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, map[string]interface{}{JoinLeft: leftRecord, JoinRight: rightRecord})
+	}
+	return rows, nil
+}
+
+// rawDocument converts a document of the collection to the form described in GetOneRaw.
+func (c *MongoCollection) rawDocument(document map[string]interface{}) (map[string]interface{}, error) {
+	if objectID, ok := document["_id"].(bson.ObjectId); ok {
+		if c.repoDef.IsCustomID() {
+			document["_id"] = objectID.Hex()
+		} else {
+			document["id"] = objectID.Hex()
+			delete(document, "_id")
+		}
+	}
+	mongoDecimals(document)
+	return rawRecord(document)
+}
@@ -0,0 +1,49 @@
+package backends
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinOneToMany(t *testing.T) {
+	customers := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "customers"})
+	orders := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "orders"})
+
+	ids := map[string]interface{}{}
+	for _, name := range []string{"John", "Mary", "Ana"} {
+		saved, err := customers.Save(&map[string]interface{}{"name": name}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = (*saved.(*map[string]interface{}))["id"]
+	}
+	for _, order := range []map[string]interface{}{
+		{"customerId": ids["John"], "total": 10},
+		{"customerId": ids["Mary"], "total": 20},
+		{"customerId": ids["John"], "total": 30},
+	} {
+		if _, err := orders.Save(&order, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := Join(customers, orders, "id", "customerId", NewFilter().In("name", "John", "Ana"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := []interface{}{}
+	for _, row := range rows {
+		customer, order := row[JoinLeft].(map[string]interface{}), row[JoinRight].(map[string]interface{})
+		if customer["name"] != "John" || order["customerId"] != customer["id"] {
+			t.Fatal("Expected the orders of John joined with John. Got: ", row)
+		}
+		totals = append(totals, order["total"])
+	}
+	if !reflect.DeepEqual(totals, []interface{}{float64(10), float64(30)}) {
+		t.Fatal("Expected a row for each order of John, and none for Ana without orders. Got: ", totals)
+	}
+
+	if _, err = Join(customers, orders, "", "customerId", nil); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the join fields to be required. Got: ", err)
+	}
+}
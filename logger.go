@@ -0,0 +1,85 @@
+package backends
+
+// Logger receives the log messages of the backends and the repositories, so they can be
+// routed to the logging library of the service, like zap or logrus. The queries are logged
+// with Debugf; the failures that do not fail an operation, like a failed TTL sweep, with Errorf.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger is the default Logger, which discards all the messages.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// LOGGER_CTX_KEY is set in the context of the backends configured with WithLogger
+var LOGGER_CTX_KEY = "LOGGER"
+
+// WithLogger sets the logger of the backend and of the repositories defined on it, before or
+// after the option is applied. The read replica of the backend logs to the same logger.
+func WithLogger(logger Logger) BackendOption {
+	return func(backend *RepositoriesBackend) {
+		backend.setInContext(LOGGER_CTX_KEY, logger)
+	}
+}
+
+// BackendLogger returns the logger set on the backend with WithLogger. If there is none, it
+// returns a logger that discards the messages, so the backends are silent by default.
+func BackendLogger(backend Backend) Logger {
+	if logger, ok := backend.GetFromContext(LOGGER_CTX_KEY).(Logger); ok && logger != nil {
+		return logger
+	}
+	return nopLogger{}
+}
+
+// backendLogger logs to the current logger of the backend, so the repositories log to a logger
+// set on the backend after they were defined.
+type backendLogger struct {
+	backend Backend
+}
+
+func (l backendLogger) Debugf(format string, args ...interface{}) {
+	BackendLogger(l.backend).Debugf(format, args...)
+}
+
+func (l backendLogger) Errorf(format string, args ...interface{}) {
+	BackendLogger(l.backend).Errorf(format, args...)
+}
+
+// WithLogger sets the logger of the manager. The backends built by the manager after it is set
+// log to the same logger, see the WithLogger backend option.
+func (m *DefaultBackendManager) WithLogger(logger Logger) *DefaultBackendManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.logger = logger
+	return m
+}
+
+// Logger returns the logger of the manager, or a logger that discards the messages if none is set.
+func (m *DefaultBackendManager) Logger() Logger {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.getLogger()
+}
+
+// getLogger returns the logger of the manager. The caller must hold the lock.
+func (m *DefaultBackendManager) getLogger() Logger {
+	if m.logger == nil {
+		return nopLogger{}
+	}
+	return m.logger
+}
+
+// managerLogger returns the logger of the manager set with DefaultBackendManager.WithLogger,
+// for the messages of the backend builders logged before the backend is built.
+func managerLogger(manager BackendManager) Logger {
+	if m, ok := manager.(*DefaultBackendManager); ok {
+		return m.Logger()
+	}
+	return nopLogger{}
+}
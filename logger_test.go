@@ -0,0 +1,104 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// recordingLogger keeps the logged messages.
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.record("DEBUG " + fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record("ERROR " + fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) record(message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.messages = append(l.messages, message)
+}
+
+func (l *recordingLogger) contains(prefix string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, message := range l.messages {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestManagerLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"memory": &config.DBInfo{},
+	}).(*DefaultBackendManager).WithLogger(logger)
+	manager.SupportBackend("memory", MemoryBackendBuilder, map[string]interface{}{})
+
+	backend, err := manager.GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetAll(NewFilter().Match("name", "John"), &memoryTestEntry{}, "", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if !logger.contains("DEBUG memory users: find map[name:John]") {
+		t.Fatal("Expected the query to be logged. Got: ", logger.messages)
+	}
+}
+
+func TestDefaultLoggerIsSilent(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	if _, ok := BackendLogger(backend).(nopLogger); !ok {
+		t.Fatal("Expected the no-op logger by default. Got: ", BackendLogger(backend))
+	}
+
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewNonUniqueIndex("email")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Save(&memoryTestEntry{Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetAll(NewFilter(), &memoryTestEntry{}, "", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the email index is not ensured, so it is logged as kept
+	if err = repo.EnsureIndexes([]Index{NewNonUniqueIndex("name")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatal("Expected nothing to be logged by default. Got: ", buf.String())
+	}
+}
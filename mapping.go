@@ -0,0 +1,689 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// FieldMappingRepository renames the properties of the records between the names of the Go types
+// and the names of the fields/attributes stored by the wrapped repository, so the structs do not
+// need backend specific tags to follow the naming convention of the database. With the mapping
+// {"userName": "user_name"} a record read into a struct with the property "userName" has it from
+// the stored field "user_name", and Filter.Match("userName", ...) matches the "user_name" field.
+//
+// The objects are written with the mapped names and the records are read back with the Go names,
+// also into the objects of the saves.
+// The properties of the filters (also in Filter.Not, Filter.TextSearch and Filter.MatchField),
+// the order properties, the sort keys, the updates of FindAndModify and UpdateFieldsReturning and
+// the conflict keys of UpsertAll are mapped too. Only the top-level properties are renamed; the
+// properties without a mapping keep their names. The indexes, the keys and the TTL attribute of
+// the repository definition are the stored names. The repositories defined with a field mapping
+// are wrapped with it by DefineRepository.
+type FieldMappingRepository struct {
+	Repository
+	stored map[string]string
+	names  map[string]string
+}
+
+// NewFieldMappingRepository wraps the repository so the properties are renamed with the mapping
+// of the Go names to the stored names.
+func NewFieldMappingRepository(repo Repository, mapping map[string]string) *FieldMappingRepository {
+	names := map[string]string{}
+	for name, stored := range mapping {
+		names[stored] = name
+	}
+	return &FieldMappingRepository{
+		Repository: repo,
+		stored:     mapping,
+		names:      names,
+	}
+}
+
+// WithFieldMapping returns a copy of the definition with the mapping of the Go names of the
+// properties to the stored names of the fields. See FieldMappingRepository.
+// 		def := backends.RepositoryDefinitionMap{"name": "users"}.WithFieldMapping(map[string]string{
+// 			"userName": "user_name",
+// 		})
+func (m RepositoryDefinitionMap) WithFieldMapping(mapping map[string]string) RepositoryDefinitionMap {
+	def := RepositoryDefinitionMap{}
+	for key, value := range m {
+		def[key] = value
+	}
+	def["fieldMapping"] = mapping
+	return def
+}
+
+// storedName returns the stored name of the property.
+func (r *FieldMappingRepository) storedName(property string) string {
+	if stored, ok := r.stored[property]; ok {
+		return stored
+	}
+	return property
+}
+
+// mapFilter returns a copy of the filter with the stored names of the properties.
+func (r *FieldMappingRepository) mapFilter(filter Filter) (Filter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	mapped := Filter{}
+	for property, value := range filter {
+		switch property {
+		case NotOperator:
+			negated, err := negatedFilter(value)
+			if err != nil {
+				return nil, ErrInvalidInput(err)
+			}
+			if negated, err = r.mapFilter(negated); err != nil {
+				return nil, err
+			}
+			mapped[property] = negated
+			continue
+		case OrOperator:
+			alternatives, err := alternativeFilters(value)
+			if err != nil {
+				return nil, ErrInvalidInput(err)
+			}
+			mappedAlternatives := make([]Filter, len(alternatives))
+			for i, alternative := range alternatives {
+				if mappedAlternatives[i], err = r.mapFilter(alternative); err != nil {
+					return nil, err
+				}
+			}
+			mapped[property] = mappedAlternatives
+			continue
+		case TextOperator:
+			query, fields, err := textSearch(value)
+			if err != nil {
+				return nil, ErrInvalidInput(err)
+			}
+			for i, field := range fields {
+				fields[i] = r.storedName(field)
+			}
+			mapped[property] = map[string]interface{}{
+				"query":  query,
+				"fields": fields,
+			}
+			continue
+		}
+
+		specs, isOperator := operatorSpecs(value)
+		if isOperator {
+			if comparison, ok := specs["$fieldCmp"]; ok {
+				operator, field, err := fieldComparison(comparison)
+				if err != nil {
+					return nil, ErrInvalidInput(err)
+				}
+				specs["$fieldCmp"] = map[string]interface{}{
+					"op":    operator,
+					"field": r.storedName(field),
+				}
+				value = specs
+			}
+		}
+		mapped[r.storedName(property)] = value
+	}
+	return mapped, nil
+}
+
+// toStored converts the object to a record with the stored names of the properties.
+func (r *FieldMappingRepository) toStored(object interface{}) (*map[string]interface{}, error) {
+	properties, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+	record := map[string]interface{}{}
+	for property, value := range *properties {
+		record[r.storedName(property)] = value
+	}
+	return &record, nil
+}
+
+// fromStored returns the properties of the stored record with the Go names.
+func (r *FieldMappingRepository) fromStored(stored interface{}) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	if err := MapToInterface(stored, &properties); err != nil {
+		return nil, err
+	}
+	record := map[string]interface{}{}
+	for property, value := range properties {
+		if name, ok := r.names[property]; ok {
+			property = name
+		}
+		record[property] = value
+	}
+	return record, nil
+}
+
+// decode decodes the stored record into the result, with the Go names of the properties. The
+// strict decode fails for the properties that the result does not have, like strictDecode.
+func (r *FieldMappingRepository) decode(stored interface{}, result interface{}, strict bool) error {
+	return storedDecoder(r.fromStored).decode(stored, result, strict)
+}
+
+// decodeLike decodes the stored record into a new value of the type of the example.
+func (r *FieldMappingRepository) decodeLike(stored interface{}, example interface{}, strict bool) (interface{}, error) {
+	return storedDecoder(r.fromStored).decodeLike(stored, example, strict)
+}
+
+// decodeAll decodes the stored records into a pointer to a slice of the type of the results hint.
+func (r *FieldMappingRepository) decodeAll(records interface{}, resultsTypeHint interface{}, strict bool) (interface{}, error) {
+	return storedDecoder(r.fromStored).decodeAll(records, resultsTypeHint, strict)
+}
+
+// GetOne fetches only one record for given filter.
+func (r *FieldMappingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.GetOneWithOpts(filter, result, ReadOpts{})
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *FieldMappingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	strict := opts.StrictDecode
+	opts.StrictDecode = false
+	record, err := r.Repository.GetOneWithOpts(filter, &map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.decode(record, result, strict); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetAll fetches all matched records for given filter.
+func (r *FieldMappingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, ReadOpts{})
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *FieldMappingRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(r.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+func (r *FieldMappingRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if opts.SkipDecodeErrors {
+		return getAllSkippingDecodeErrors(r, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	strict := opts.StrictDecode
+	opts.StrictDecode = false
+	records, err := r.Repository.GetAllWithOpts(filter, &map[string]interface{}{}, r.storedName(order), sorting, limit, offset, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(records, resultsTypeHint, strict)
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *FieldMappingRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.Repository.GetAllByIndex(indexName, filter, &map[string]interface{}{}, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(records, resultsTypeHint, false)
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition. The keys
+// are the stored names of the definition.
+func (r *FieldMappingRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	records, err := r.Repository.QueryRange(hashValue, rangeOp, rangeValue, &map[string]interface{}{}, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(records, resultsTypeHint, false)
+}
+
+// GetAllWithHint fetches all matched records, hinting the backend to use the named index.
+func (r *FieldMappingRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.Repository.GetAllWithHint(filter, indexName, &map[string]interface{}{}, r.storedName(order), sorting, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(records, resultsTypeHint, false)
+}
+
+// ParallelScan reads all matched records in segments.
+func (r *FieldMappingRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.ParallelScan(filter, segments, &map[string]interface{}{}, func(batch interface{}) error {
+		results, err := r.decodeAll(batch, resultsTypeHint, false)
+		if err != nil {
+			return err
+		}
+		return fn(reflect.Indirect(reflect.ValueOf(results)).Interface())
+	})
+}
+
+// Save creates new record or updates the existing one.
+func (r *FieldMappingRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	return r.SaveWithOpts(object, filter, WriteOpts{})
+}
+
+// SaveWithOpts creates new record or updates the existing one using the given write options.
+func (r *FieldMappingRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	record, err := r.toStored(object)
+	if err != nil {
+		return nil, err
+	}
+	if filter, err = r.mapFilter(filter); err != nil {
+		return nil, err
+	}
+	saved, err := r.Repository.SaveWithOpts(record, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.decode(saved, object, false); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// SaveWithTimestamp creates new record or updates the existing one and returns the time of the write.
+func (r *FieldMappingRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	record, err := r.toStored(object)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if filter, err = r.mapFilter(filter); err != nil {
+		return nil, time.Time{}, err
+	}
+	saved, committedAt, err := r.Repository.SaveWithTimestamp(record, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err = r.decode(saved, object, false); err != nil {
+		return nil, time.Time{}, err
+	}
+	return object, committedAt, nil
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record.
+func (r *FieldMappingRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	record, err := r.toStored(object)
+	if err != nil {
+		return nil, false, err
+	}
+	if filter, err = r.mapFilter(filter); err != nil {
+		return nil, false, err
+	}
+	saved, created, err := r.Repository.SaveUpsert(record, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = r.decode(saved, object, false); err != nil {
+		return nil, false, err
+	}
+	return object, created, nil
+}
+
+// SaveIf updates the record matching the filter if it matches the condition.
+func (r *FieldMappingRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	record, err := r.toStored(object)
+	if err != nil {
+		return nil, false, err
+	}
+	if filter, err = r.mapFilter(filter); err != nil {
+		return nil, false, err
+	}
+	if condition, err = r.mapFilter(condition); err != nil {
+		return nil, false, err
+	}
+	saved, updated, err := r.Repository.SaveIf(record, filter, condition)
+	if err != nil || !updated {
+		return nil, updated, err
+	}
+	if err = r.decode(saved, object, false); err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// FindAndModify claims up to limit records matching the filter.
+func (r *FieldMappingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	storedUpdate := map[string]interface{}{}
+	for property, value := range update {
+		storedUpdate[r.storedName(property)] = value
+	}
+	storedSort := make([]SortKey, len(sort))
+	for i, key := range sort {
+		storedSort[i] = SortKey{Property: r.storedName(key.Property), Sorting: key.Sorting}
+	}
+
+	claimed, err := r.Repository.FindAndModify(filter, storedUpdate, limit, storedSort)
+	if err != nil {
+		return nil, err
+	}
+	records := []map[string]interface{}{}
+	err = IterateOverSlice(claimed, func(i int, item interface{}) error {
+		record, err := r.fromStored(item)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// UpsertAll inserts or updates each of the objects.
+func (r *FieldMappingRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	records := make([]interface{}, len(objects))
+	for i, object := range objects {
+		record, err := r.toStored(object)
+		if err != nil {
+			return nil, UpsertError{Row: i, Cause: err}
+		}
+		records[i] = record
+	}
+	storedKeys := make([]string, len(conflictKeys))
+	for i, key := range conflictKeys {
+		storedKeys[i] = r.storedName(key)
+	}
+
+	written, err := r.Repository.UpsertAll(records, storedKeys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(written))
+	for i, record := range written {
+		result, err := r.decodeLike(record, objects[i], false)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// ReplaceOne replaces the record matching the filter.
+func (r *FieldMappingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	record, err := r.toStored(object)
+	if err != nil {
+		return nil, err
+	}
+	if filter, err = r.mapFilter(filter); err != nil {
+		return nil, err
+	}
+	previous, err := r.Repository.ReplaceOne(filter, record)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeLike(previous, object, false)
+}
+
+// DeleteOne deletes only one record for given filter.
+func (r *FieldMappingRepository) DeleteOne(filter Filter) error {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.DeleteOne(filter)
+}
+
+// DeleteIf deletes the record matching the filter if it matches the condition.
+func (r *FieldMappingRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	if condition, err = r.mapFilter(condition); err != nil {
+		return false, err
+	}
+	return r.Repository.DeleteIf(filter, condition)
+}
+
+// DeleteAll deletes all matched records for given filter.
+func (r *FieldMappingRepository) DeleteAll(filter Filter) error {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return err
+	}
+	return r.Repository.DeleteAll(filter)
+}
+
+// Bulk returns a BulkOp that writes the inserted objects with the mapped names and translates
+// the filters and the updated fields. The IDs of the inserted records are set on the objects.
+func (r *FieldMappingRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		mapped := make([]BulkOperation, len(operations))
+		for i, operation := range operations {
+			var err error
+			switch operation.Kind {
+			case BulkInsert:
+				operation.Object, err = r.toStored(operation.Object)
+			case BulkUpdate:
+				operation.Fields = r.storedFields(operation.Fields)
+				operation.Filter, err = r.mapFilter(operation.Filter)
+			default:
+				operation.Filter, err = r.mapFilter(operation.Filter)
+			}
+			if err != nil {
+				return BulkResult{}, BulkError{Operation: i, Cause: err}
+			}
+			mapped[i] = operation
+		}
+
+		result, err := runBulk(r.Repository, mapped)
+		// the IDs are set on the objects of the inserts that were executed
+		executed := len(mapped)
+		var bulkErr BulkError
+		if errors.As(err, &bulkErr) {
+			executed = bulkErr.Operation
+		} else if err != nil {
+			executed = 0
+		}
+		for i := 0; i < executed; i++ {
+			if mapped[i].Kind != BulkInsert {
+				continue
+			}
+			if decodeErr := r.decode(mapped[i].Object, operations[i].Object, false); decodeErr != nil {
+				return result, BulkError{Operation: i, Cause: decodeErr}
+			}
+		}
+		return result, err
+	})
+}
+
+// storedFields returns the fields with the mapped names.
+func (r *FieldMappingRepository) storedFields(fields map[string]interface{}) map[string]interface{} {
+	storedFields := map[string]interface{}{}
+	for property, value := range fields {
+		storedFields[r.storedName(property)] = value
+	}
+	return storedFields
+}
+
+// DeleteAllReturning deletes all matched records and returns their IDs.
+func (r *FieldMappingRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.DeleteAllReturning(filter)
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs.
+func (r *FieldMappingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Repository.UpdateFieldsReturning(filter, r.storedFields(fields))
+}
+
+// ArrayAppend appends the values to the array of the stored field of the matched records.
+func (r *FieldMappingRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayAppend(filter, r.storedName(field), values...)
+}
+
+// UpdatePath sets the value at the path of the matched records, in the stored field of the first
+// segment of the path.
+func (r *FieldMappingRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	segments := strings.SplitN(path, ".", 2)
+	segments[0] = r.storedName(segments[0])
+	return r.Repository.UpdatePath(filter, strings.Join(segments, "."), value)
+}
+
+// ArrayRemove removes the values from the array of the stored field of the matched records.
+func (r *FieldMappingRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayRemove(filter, r.storedName(field), values...)
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (r *FieldMappingRepository) Exists(filter Filter) (bool, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	return r.Repository.Exists(filter)
+}
+
+// Count returns the number of records matching the filter.
+func (r *FieldMappingRepository) Count(filter Filter) (int, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.Repository.Count(filter)
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records.
+func (r *FieldMappingRepository) CountBoth(filter Filter) (int64, int64, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.Repository.CountBoth(filter)
+}
+
+// UsesIndex returns the index used by the reads with the filter, with the mapped names.
+func (r *FieldMappingRepository) UsesIndex(filter Filter) (Index, bool) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, false
+	}
+	return r.Repository.UsesIndex(filter)
+}
+
+// Dump returns the query of the operation with the filter, with the mapped names.
+func (r *FieldMappingRepository) Dump(op string, filter Filter) (string, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	return r.Repository.Dump(op, filter)
+}
+
+// GetByIDs fetches the records with the given IDs.
+func (r *FieldMappingRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	records, err := r.Repository.GetByIDs(ids, &map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(records, resultHint, false)
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *FieldMappingRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *FieldMappingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// Watch subscribes to the changes of the records matching the filter, with the mapped names, and
+// sends the documents with the Go names.
+func (r *FieldMappingRepository) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	filter, err := r.mapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	events, err := r.Repository.Watch(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return mapChangeEvents(ctx, events, func(event ChangeEvent) ChangeEvent {
+		if event.Document != nil {
+			event.Document, _ = r.fromStored(event.Document)
+		}
+		return event
+	}), nil
+}
+
+// WithContext returns a copy of the repository bound to the context.
+func (r *FieldMappingRepository) WithContext(ctx context.Context) Repository {
+	return &FieldMappingRepository{
+		Repository: r.Repository.WithContext(ctx),
+		stored:     r.stored,
+		names:      r.names,
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *FieldMappingRepository) WithSession(session *Session) Repository {
+	return &FieldMappingRepository{
+		Repository: r.Repository.WithSession(session),
+		stored:     r.stored,
+		names:      r.names,
+	}
+}
+
+// checkFieldMapping checks that no two properties are mapped to the same stored field.
+func checkFieldMapping(mapping map[string]string) error {
+	mapped := map[string]string{}
+	for name, stored := range mapping {
+		if other, ok := mapped[stored]; ok {
+			if other > name {
+				other, name = name, other
+			}
+			return ErrInvalidInput(fmt.Sprintf("the properties %s and %s are mapped to the same field %s", other, name, stored))
+		}
+		mapped[stored] = name
+	}
+	return nil
+}
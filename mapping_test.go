@@ -0,0 +1,86 @@
+package backends
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+type mappingTestUser struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Age      int    `json:"age"`
+}
+
+func TestFieldMappingRepository(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}.WithFieldMapping(map[string]string{
+		"userName": "user_name",
+		"age":      "user_age",
+	}))
+	mapping, ok := repo.(*FieldMappingRepository)
+	if !ok {
+		t.Fatalf("Expected the repository to be wrapped with the field mapping. Got: %T", repo)
+	}
+
+	for _, user := range []*mappingTestUser{{UserName: "john", Age: 30}, {UserName: "jane", Age: 25}} {
+		saved, err := repo.Save(user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.(*mappingTestUser).UserName != user.UserName {
+			t.Fatal("Expected the saved user with the Go names. Got: ", saved)
+		}
+	}
+
+	// stored with the mapped names
+	stored, err := GetOneRaw(mapping.Repository, NewFilter().Match("user_name", "john"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored["userName"]; ok || stored["user_age"] != float64(30) {
+		t.Fatal("Expected the record to be stored with the mapped names. Got: ", stored)
+	}
+
+	user := &mappingTestUser{}
+	if _, err := repo.GetOne(NewFilter().Match("userName", "john"), user); err != nil {
+		t.Fatal(err)
+	}
+	if user.UserName != "john" || user.Age != 30 {
+		t.Fatal("Expected the user to be decoded with the Go names. Got: ", user)
+	}
+
+	results, err := repo.GetAll(NewFilter().Lt("age", 40).Not(NewFilter().Match("userName", "nobody")), &mappingTestUser{}, "age", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := *results.(*[]*mappingTestUser)
+	if len(users) != 2 || users[0].UserName != "jane" || users[1].UserName != "john" {
+		t.Fatal("Expected the users ordered by the mapped age. Got: ", users)
+	}
+
+	if count, err := repo.Count(NewFilter().Gte("age", 30)); err != nil || count != 1 {
+		t.Fatal("Expected one user of age 30 or more. Got: ", count, err)
+	}
+
+	bob := &mappingTestUser{UserName: "bob", Age: 40}
+	result, err := repo.Bulk().Insert(bob).Update(NewFilter().Match("userName", "jane"), map[string]interface{}{"age": 26}).Execute()
+	if err != nil || result != (BulkResult{Inserted: 1, Updated: 1}) {
+		t.Fatal("Expected one inserted and one updated user. Got: ", result, err)
+	}
+	if bob.ID == "" {
+		t.Fatal("Expected the ID of the inserted user to be set")
+	}
+	if count, err := repo.Count(NewFilter().Match("age", 26)); err != nil || count != 1 {
+		t.Fatal("Expected the update with the mapped names. Got: ", count, err)
+	}
+
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	_, err = backend.DefineRepository("accounts", RepositoryDefinitionMap{"name": "accounts"}.WithFieldMapping(map[string]string{
+		"userName": "name",
+		"fullName": "name",
+	}))
+	if !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the properties mapped to the same field. Got: ", err)
+	}
+}
@@ -0,0 +1,65 @@
+package backends
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestMaskConnectionString(t *testing.T) {
+	for connection, expected := range map[string]string{
+		"mongodb://admin:s3cret@db:27017/users":          "mongodb://admin:***@db:27017/users",
+		"mongodb://admin@db:27017/users":                 "mongodb://admin@db:27017/users",
+		"postgres://db/users?user=admin&password=s3cret": "postgres://db/users?user=admin&password=***",
+		"host=db user=admin password=s3cret":             "host=db user=admin password=***",
+		"db:27017":                                       "db:27017",
+	} {
+		if masked := MaskConnectionString(connection); masked != expected {
+			t.Errorf("Expected %s masked as %s. Got: %s", connection, expected, masked)
+		}
+	}
+
+	info := DBInfoString(&config.DBInfo{Host: "db:27017", DatabaseName: "users", Username: "admin", Password: "s3cret"})
+	if info != "host=db:27017 database=users user=admin pass=***" {
+		t.Fatal("Expected the configuration with the password masked. Got: ", info)
+	}
+}
+
+func TestBuildErrorMasksPassword(t *testing.T) {
+	logger := &recordingLogger{}
+	manager := NewBackendManager(map[string]*config.DBInfo{
+		"mongodb": &config.DBInfo{Host: "db:27017", Username: "admin", Password: "s3cret"},
+	}).(*DefaultBackendManager).WithConnectRetry(ConnectRetryPolicy{Attempts: 2, Backoff: time.Millisecond}).WithLogger(logger)
+
+	manager.SupportBackend("mongodb", func(dbInfo *config.DBInfo, manager BackendManager) (Backend, error) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("auth failed for admin:%s@%s", dbInfo.Password, dbInfo.Host)}
+	}, map[string]interface{}{})
+
+	_, err := manager.GetBackend("mongodb")
+	if err == nil {
+		t.Fatal("Expected the connection error")
+	}
+	if strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "admin:***@db:27017") {
+		t.Fatal("Expected the error with the password masked. Got: ", err)
+	}
+	if !isConnectionError(err) {
+		t.Fatal("Expected the masked error to still be a connection error. Got: ", err)
+	}
+	if !logger.contains("ERROR Failed to connect") {
+		t.Fatal("Expected the retry to be logged. Got: ", logger.messages)
+	}
+	for _, message := range logger.messages {
+		if strings.Contains(message, "s3cret") {
+			t.Fatal("Expected the logs without the password. Got: ", message)
+		}
+	}
+
+	classErr := maskError(ErrConnectionFailed("dial mongodb://admin:s3cret@db"), nil)
+	if !IsErrConnectionFailed(classErr) || strings.Contains(classErr.(*BackendErrorInfo).Details(), "s3cret") {
+		t.Fatal("Expected the class error kept with the details masked. Got: ", classErr)
+	}
+}
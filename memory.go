@@ -0,0 +1,1490 @@
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+	"github.com/satori/go.uuid"
+)
+
+// MemoryCollection is an in-memory implementation of the Repository interface.
+// The records are kept as generic maps in insertion order. It is meant to be used
+// for tests and local development, the data is lost once the process exits.
+type MemoryCollection struct {
+	*memoryStore
+	name    string
+	repoDef RepositoryDefinition
+	ctx     context.Context
+	backend Backend
+	stats   *queryStatsRecorder
+	logger  Logger
+}
+
+// memoryStore holds the records of one in-memory collection.
+type memoryStore struct {
+	mutex   *sync.RWMutex
+	records []map[string]interface{}
+	// indexes are the indexes of the definition and the ones added with EnsureIndexes
+	indexes []Index
+}
+
+// MemoryRepoBuilder builds new in-memory collection.
+func MemoryRepoBuilder(repoDef RepositoryDefinition, backend Backend) (Repository, error) {
+	if repoDef.GetName() == "" {
+		return nil, ErrBackendError("collection name is missing and required")
+	}
+
+	collection := NewMemoryCollection(repoDef)
+	collection.name = backend.PhysicalName(repoDef.GetName())
+	collection.backend = backend
+	collection.logger = backendLogger{backend}
+
+	return collection, nil
+}
+
+// MemoryRepoPlanner plans the creation of in-memory collection. The collection and its
+// indexes are created only if the repository is not already defined on the backend.
+func MemoryRepoPlanner(repoDef RepositoryDefinition, backend Backend) (*RepositoryPlan, error) {
+	if repoDef.GetName() == "" {
+		return nil, ErrBackendError("collection name is missing and required")
+	}
+
+	name := backend.PhysicalName(repoDef.GetName())
+	plan := &RepositoryPlan{
+		Repository: name,
+	}
+	plan.Add(ActionCreateRepository, name)
+	if repoDef.GetIndexCreationMode() != IndexCreateIfMissing {
+		// the in-memory indexes exist only with the collection, so they are always created with
+		// it and are never missing
+		return plan, nil
+	}
+	for _, index := range repoDef.GetIndexes() {
+		plan.Add(ActionCreateIndex, index.GetName(), index.GetFields()...)
+	}
+
+	return plan, nil
+}
+
+// MemoryBackendBuilder returns RepositoriesBackend that keeps all data in memory.
+func MemoryBackendBuilder(conf *config.DBInfo, manager BackendManager) (Backend, error) {
+	return NewRepositoriesBackend(context.Background(), conf, MemoryRepoBuilder, func() {}, WithRepoPlanner(MemoryRepoPlanner), WithCapabilities(memoryCapabilities)), nil
+}
+
+// NewMemoryCollection creates new empty in-memory collection for the given definition.
+func NewMemoryCollection(repoDef RepositoryDefinition) *MemoryCollection {
+	return &MemoryCollection{
+		memoryStore: &memoryStore{
+			mutex:   &sync.RWMutex{},
+			records: []map[string]interface{}{},
+			indexes: append([]Index{}, repoDef.GetIndexes()...),
+		},
+		name:    repoDef.GetName(),
+		repoDef: repoDef,
+		stats:   newQueryStatsRecorder(),
+		logger:  nopLogger{},
+	}
+}
+
+// WithContext returns a copy of the collection bound to the given context. The copy shares
+// the records with the collection. The in-memory backend has no backend calls to attach the
+// request-scoped values to, but they are available through Context.
+func (c *MemoryCollection) WithContext(ctx context.Context) Repository {
+	return &MemoryCollection{
+		memoryStore: c.memoryStore,
+		name:        c.name,
+		repoDef:     c.repoDef,
+		ctx:         ctx,
+		backend:     c.backend,
+		stats:       newQueryStatsRecorder(),
+		logger:      c.logger,
+	}
+}
+
+// ArrayAppend appends the values to the array of the field of all the matched records. The
+// records are matched and updated under the write lock, and none of them is updated if the field
+// of any of them is not an array.
+func (c *MemoryCollection) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := checkArrayUpdate(field, values, c.repoDef.GetIDField()); err != nil {
+		return 0, err
+	}
+	appended, err := normalizeValue(values)
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+	return c.updateArrays(filter, field, func(array []interface{}) ([]interface{}, bool, error) {
+		return append(append([]interface{}{}, array...), appended.([]interface{})...), true, nil
+	})
+}
+
+// ArrayRemove removes the values from the array of the field of all the matched records. The
+// records are matched and updated under the write lock, and none of them is updated if the field
+// of any of them is not an array.
+func (c *MemoryCollection) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := checkArrayUpdate(field, values, c.repoDef.GetIDField()); err != nil {
+		return 0, err
+	}
+	return c.updateArrays(filter, field, func(array []interface{}) ([]interface{}, bool, error) {
+		remaining, removed, err := removeArrayValues(array, values)
+		return remaining, len(removed) > 0, err
+	})
+}
+
+// updateArrays sets the array of the field of the matched records to the array returned by the
+// update, if it reports a change. It returns the number of the changed records.
+func (c *MemoryCollection) updateArrays(filter Filter, field string, update func(array []interface{}) ([]interface{}, bool, error)) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	matched := []map[string]interface{}{}
+	updates := [][]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		array, err := storedArray(record, field)
+		if err != nil {
+			return 0, err
+		}
+		updated, changed, err := update(array)
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			matched = append(matched, record)
+			updates = append(updates, updated)
+		}
+	}
+	for i, record := range matched {
+		record[field] = updates[i]
+	}
+	return int64(len(matched)), nil
+}
+
+// UpdatePath sets the value at the path of all the matched records. The records are matched and
+// updated under the write lock, and none of them is updated if the path is invalid in any of them.
+func (c *MemoryCollection) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	segments, err := splitPath(path, c.repoDef.GetIDField())
+	if err != nil {
+		return 0, err
+	}
+	normalized, err := normalizeValue(value)
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	matched := []map[string]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		if _, _, err = pathUpdate(record, segments, normalized); err != nil {
+			return 0, err
+		}
+		matched = append(matched, record)
+	}
+	for _, record := range matched {
+		// the value is copied per record, so the records don't share the documents of the value
+		copied, _ := normalizeValue(normalized)
+		setPath(record, segments, copied)
+	}
+	return int64(len(matched)), nil
+}
+
+// UsesIndex returns the index of the collection matched exactly by the filter, including the
+// ones added with EnsureIndexes and the unique index of the IDs. The in-memory collection scans
+// the records regardless; GetOne and Exists stop at the first match.
+func (c *MemoryCollection) UsesIndex(filter Filter) (Index, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	indexes := append([]Index{NewIndex(c.repoDef.GetIDField(), true, c.repoDef.GetIDField())}, c.indexes...)
+	return usesIndex(indexes, filter)
+}
+
+// Dump returns the operation with the filter. The in-memory collection has no query language, so
+// the filter is rendered as JSON.
+func (c *MemoryCollection) Dump(op string, filter Filter) (string, error) {
+	if err := checkDumpOperation(op); err != nil {
+		return "", err
+	}
+	if filter == nil {
+		filter = Filter{}
+	}
+	return fmt.Sprintf("%s %s %s", op, c.name, dumpValue(filter)), nil
+}
+
+// Migrate applies the migrations of the collection and records the applied version in the
+// SchemaVersionsRepository of the backend. See Repository.Migrate.
+func (c *MemoryCollection) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return runMigrations(ctx, c, c.backend, c.repoDef.GetName(), from, to, migrations)
+}
+
+// SaveIdempotent saves a new record unless the idempotency key was processed, recording the keys
+// in the IdempotencyKeysRepository of the backend. See Repository.SaveIdempotent.
+func (c *MemoryCollection) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return saveIdempotent(c, c.backend, c.repoDef.GetName(), c.repoDef.GetIDField(), object, idempotencyKey, ttl)
+}
+
+// Watch is not supported by the in-memory backend, which has no change stream. Poll the changes
+// with ChangedSince instead.
+func (c *MemoryCollection) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	return nil, ErrUnsupported("the in-memory backend does not support change streams")
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition.
+func (c *MemoryCollection) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	return queryRangeWithFilter(c, c.repoDef, hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+}
+
+// Bulk returns a BulkOp that executes the operations one at a time.
+func (c *MemoryCollection) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		return executeBulkOneByOne(c, operations)
+	})
+}
+
+// WithSession returns the collection itself, as the reads of an in-memory collection always
+// observe the writes.
+func (c *MemoryCollection) WithSession(session *Session) Repository {
+	return c
+}
+
+// Context returns the context the collection is bound to with WithContext, based on
+// context.Background() if the collection is not bound to a context. The values set in the
+// context of the backend are available through it as well, including the ones set after the
+// collection was defined.
+func (c *MemoryCollection) Context() context.Context {
+	return withBackendContext(c.ctx, c.backend)
+}
+
+// Name returns the name of the collection.
+func (c *MemoryCollection) Name() string {
+	return c.name
+}
+
+// GetOne fetches only one record for given filter
+func (c *MemoryCollection) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if ok {
+			if err = MapToInterface(&record, &result); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+	}
+
+	return nil, ErrNotFound("record not found")
+}
+
+// GetAll fetches all matched records for given filter
+func (c *MemoryCollection) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return c.getAll(filter, resultsTypeHint, order, sorting, limit, offset, nil)
+}
+
+// getAll fetches all matched records, sorted with the collation if set.
+func (c *MemoryCollection) getAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, collation *Collation) (interface{}, error) {
+	limit, err := pageBounds(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSorting(sorting); err != nil {
+		return nil, err
+	}
+
+	c.logger.Debugf("memory %s: find %v, sort %q %q, skip %d, limit %d", c.name, filter, order, sorting, offset, limit)
+	start := time.Now()
+	c.mutex.RLock()
+	scanned := len(c.records)
+	matched := []map[string]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			c.mutex.RUnlock()
+			return nil, ErrInvalidInput(err)
+		}
+		if ok {
+			matched = append(matched, record)
+		}
+	}
+	c.mutex.RUnlock()
+
+	sortPage(matched, c.repoDef, c.repoDef.GetIDField(), order, sorting, limit, offset, collation)
+
+	results, err := recordsPage(matched, resultsTypeHint, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	c.stats.record(QueryStats{
+		ItemsScanned:  int64(scanned),
+		ItemsReturned: resultsCount(results),
+	}, start)
+	return results, nil
+}
+
+// Save creates new record unless it does not exist, otherwise it updates the record.
+// A new record gets a generated UUID as ID, unless the object has one. The stored record is
+// decoded back into the object, which is returned. See Repository.Save.
+func (c *MemoryCollection) Save(object interface{}, filter Filter) (interface{}, error) {
+	result, _, err := c.SaveWithTimestamp(object, filter)
+	return result, err
+}
+
+// SaveWithTimestamp saves the object like Save and returns the time of the write, taken while
+// the collection is locked, so the times of the writes are in the order of the writes.
+func (c *MemoryCollection) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	record, err := toMemoryRecord(*payload)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if filter == nil {
+		if err = c.generateID(record); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if err = c.checkUniqueIndexes(record, nil); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		c.records = append(c.records, record)
+		c.evictCapped()
+		committedAt := time.Now()
+
+		if err = MapToInterface(&record, &object); err != nil {
+			return nil, time.Time{}, err
+		}
+		return object, committedAt, nil
+	}
+
+	for _, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, time.Time{}, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		result, err := c.updateRecord(existing, record, object)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return result, time.Now(), nil
+	}
+
+	return nil, time.Time{}, ErrNotFound("record not found")
+}
+
+// ReplaceOne replaces the record matching the filter with the object and returns the
+// previous record. The check and the replace are atomic.
+func (c *MemoryCollection) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := toMemoryRecord(*payload)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+
+		// the ID is immutable once the record is created
+		idField := c.repoDef.GetIDField()
+		record[idField] = existing[idField]
+		if err = c.checkUniqueIndexes(record, existing); err != nil {
+			return nil, err
+		}
+		c.records[i] = record
+
+		previous, err := CreateNewAsExample(object)
+		if err != nil {
+			return nil, err
+		}
+		if err = MapToInterface(&existing, &previous); err != nil {
+			return nil, err
+		}
+		return previous, nil
+	}
+
+	return nil, ErrNotFound("record not found")
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record if there is no such
+// record. The check and the write are atomic. See Repository.SaveUpsert.
+func (c *MemoryCollection) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	if len(filter) == 0 {
+		return nil, false, ErrInvalidInput("filter is required for upsert")
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record, err := toMemoryRecord(*payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		result, err := c.updateRecord(existing, record, object)
+		if err != nil {
+			return nil, false, err
+		}
+		return result, false, nil
+	}
+
+	properties, err := toMemoryRecord(upsertProperties(filter))
+	if err != nil {
+		return nil, false, err
+	}
+	for property, value := range properties {
+		if _, ok := record[property]; !ok {
+			record[property] = value
+		}
+	}
+	if err = c.generateID(record); err != nil {
+		return nil, false, err
+	}
+	if err = c.checkUniqueIndexes(record, nil); err != nil {
+		return nil, false, err
+	}
+	c.records = append(c.records, record)
+	c.evictCapped()
+
+	if err = MapToInterface(&record, &object); err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// SaveIf updates the record matching the filter only if the record also matches the condition.
+// The bool result reports whether the update was applied. The check and the update are atomic.
+func (c *MemoryCollection) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	if filter == nil {
+		return nil, false, ErrInvalidInput("filter is required for conditional save")
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record, err := toMemoryRecord(*payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		ok, err = matchRecord(existing, condition)
+		if err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		result, err := c.updateRecord(existing, record, object)
+		if err != nil {
+			return nil, false, err
+		}
+		return result, true, nil
+	}
+
+	return nil, false, ErrNotFound("record not found")
+}
+
+// FindAndModify updates up to limit records matching the filter, in the order of the sort keys,
+// and returns the updated records. The records are matched and updated under the write lock.
+// See Repository.FindAndModify.
+func (c *MemoryCollection) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	if err := checkFindAndModify(update, limit, c.repoDef.GetIDField()); err != nil {
+		return nil, err
+	}
+	values, err := toMemoryRecord(update)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	matched := []map[string]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if ok {
+			matched = append(matched, record)
+		}
+	}
+	sortRecordsByKeys(matched, sort)
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	claimed := []map[string]interface{}{}
+	for _, existing := range matched {
+		updated := map[string]interface{}{}
+		for key, value := range existing {
+			updated[key] = value
+		}
+		for key, value := range values {
+			updated[key] = value
+		}
+		if err = c.checkUniqueIndexes(updated, existing); err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			existing[key] = value
+		}
+		claimed = append(claimed, updated)
+	}
+	return claimed, nil
+}
+
+// UpsertAll inserts or updates the objects, matching the existing records by the values of the
+// conflict keys. See Repository.UpsertAll for details.
+func (c *MemoryCollection) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	upserted := []map[string]interface{}{}
+	for i, object := range objects {
+		record, err := c.upsert(object, conflictKeys)
+		if err != nil {
+			return nil, UpsertError{Row: i, Cause: err}
+		}
+		upserted = append(upserted, record)
+	}
+
+	return upsertResults(objects, upserted, conflictKeys)
+}
+
+// upsert updates the record matching the conflict keys of the object or inserts a new one.
+// The caller must hold the write lock.
+func (c *MemoryCollection) upsert(object interface{}, conflictKeys []string) (map[string]interface{}, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+	record, err := toMemoryRecord(*payload)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := conflictFilter(record, conflictKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if ok {
+			if _, err = c.updateRecord(existing, record, map[string]interface{}{}); err != nil {
+				return nil, err
+			}
+			return existing, nil
+		}
+	}
+
+	if err = c.generateID(record); err != nil {
+		return nil, err
+	}
+	if err = c.checkUniqueIndexes(record, nil); err != nil {
+		return nil, err
+	}
+	c.records = append(c.records, record)
+	c.evictCapped()
+
+	return record, nil
+}
+
+// generateID sets a generated UUID as the ID of a new record, unless the record has an ID.
+func (c *MemoryCollection) generateID(record map[string]interface{}) error {
+	idField := c.repoDef.GetIDField()
+	if id, ok := record[idField]; ok && id != nil && id != "" {
+		return nil
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	record[idField] = id.String()
+	return nil
+}
+
+// updateRecord updates the existing record with the values of the record and decodes the
+// updated record into the object. The caller must hold the write lock.
+func (c *MemoryCollection) updateRecord(existing, record map[string]interface{}, object interface{}) (interface{}, error) {
+	updated := map[string]interface{}{}
+	for key, value := range existing {
+		updated[key] = value
+	}
+	for key, value := range record {
+		if key == c.repoDef.GetIDField() {
+			// the ID is immutable once the record is created
+			continue
+		}
+		updated[key] = value
+	}
+	if err := c.checkUniqueIndexes(updated, existing); err != nil {
+		return nil, err
+	}
+	for key, value := range updated {
+		existing[key] = value
+	}
+	if err := MapToInterface(&existing, &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// GetOneWithOpts fetches only one record for given filter. The in-memory reads are always
+// consistent, so the options do not change the behaviour.
+func (c *MemoryCollection) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
+	}
+	if opts.StrictDecode {
+		return getOneStrict(c, filter, result, opts)
+	}
+	return c.GetOne(filter, result)
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (c *MemoryCollection) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(c.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
+
+// GetAllWithOpts fetches all matched records for given filter, sorted with the collation of the
+// options. The in-memory reads are always consistent, so the other options do not change the behaviour.
+func (c *MemoryCollection) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if err := checkBatchSize(opts.BatchSize); err != nil {
+		return nil, err
+	}
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
+	}
+	if opts.SkipDecodeErrors {
+		return getAllSkippingDecodeErrors(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	if opts.StrictDecode {
+		return getAllStrict(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	return c.getAll(filter, resultsTypeHint, order, sorting, limit, offset, opts.Collation)
+}
+
+// GetAllWithHint fetches all matched records. The in-memory collection has no query planner,
+// so the index hint is ignored.
+func (c *MemoryCollection) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return c.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// ParallelScan reads the records matching the filter in the segments concurrently. The records
+// are split between the segments in the order of the ID. See Repository.ParallelScan.
+func (c *MemoryCollection) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	results, err := c.getAll(filter, resultsTypeHint, c.repoDef.GetIDField(), Ascending.String(), 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	records := reflect.Indirect(reflect.ValueOf(results))
+	return scanSegments(c.Context(), segments, func(ctx context.Context, segment int) error {
+		return scanSlice(ctx, records, segment, segments, batchSizeOr(c.repoDef, scanBatchSize), fn)
+	})
+}
+
+// GetAllByIndex returns all matched records. The in-memory collection has no indexes to query,
+// so this is a plain GetAll, but the index must be defined for the collection.
+func (c *MemoryCollection) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	if _, ok := c.findIndex(indexName); !ok {
+		if _, ok = c.repoDef.GetGSI()[strings.TrimSuffix(indexName, "-index")]; !ok {
+			return nil, ErrInvalidInput(fmt.Sprintf("unknown index %s", indexName))
+		}
+	}
+	return c.GetAll(filter, resultsTypeHint, "", "", limit, offset)
+}
+
+// SaveWithOpts creates or updates a record. The in-memory writes are visible immediately,
+// so the options do not change the behaviour.
+func (c *MemoryCollection) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	return c.Save(object, filter)
+}
+
+// DeleteOne deletes only one record for given filter
+func (c *MemoryCollection) DeleteOne(filter Filter) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return ErrInvalidInput(err)
+		}
+		if ok {
+			c.records = append(c.records[:i], c.records[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNotFound("record not found")
+}
+
+// DeleteIf deletes the record matching the filter only if the record also matches the condition.
+// The check and the delete are done under the write lock.
+func (c *MemoryCollection) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	if filter == nil {
+		return false, ErrInvalidInput("filter is required for conditional delete")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return false, ErrInvalidInput(err)
+		}
+		if !ok {
+			continue
+		}
+		ok, err = matchRecord(record, condition)
+		if err != nil {
+			return false, ErrInvalidInput(err)
+		}
+		if !ok {
+			return false, nil
+		}
+		c.records = append(c.records[:i], c.records[i+1:]...)
+		return true, nil
+	}
+
+	return false, ErrNotFound("record not found")
+}
+
+// DeleteAll deletes all matched records for given filter
+func (c *MemoryCollection) DeleteAll(filter Filter) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	remaining := []map[string]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return ErrInvalidInput(err)
+		}
+		if !ok {
+			remaining = append(remaining, record)
+		}
+	}
+	c.records = remaining
+
+	return nil
+}
+
+// DeleteAllReturning deletes all matched records for given filter and returns their IDs.
+func (c *MemoryCollection) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	deleted := []interface{}{}
+	remaining := []map[string]interface{}{}
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if ok {
+			deleted = append(deleted, record[c.repoDef.GetIDField()])
+			continue
+		}
+		remaining = append(remaining, record)
+	}
+	c.records = remaining
+
+	return deleted, nil
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs. The records
+// are matched and updated under the write lock, and none of them is updated if the update of any
+// of them violates a unique index, including the records updated to the same values.
+func (c *MemoryCollection) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	if err := checkUpdateFields(fields, c.repoDef.GetIDField()); err != nil {
+		return nil, err
+	}
+	values, err := toMemoryRecord(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// the records as they would be after the update, to check the unique indexes before
+	// updating any of them
+	records := make([]map[string]interface{}, 0, len(c.records))
+	matched := []map[string]interface{}{}
+	updates := []map[string]interface{}{}
+	for _, existing := range c.records {
+		ok, err := matchRecord(existing, filter)
+		if err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		if !ok {
+			records = append(records, existing)
+			continue
+		}
+		updated := map[string]interface{}{}
+		for key, value := range existing {
+			updated[key] = value
+		}
+		for key, value := range values {
+			updated[key] = value
+		}
+		records = append(records, updated)
+		matched = append(matched, existing)
+		updates = append(updates, updated)
+	}
+	for _, updated := range updates {
+		if err := c.checkUniqueIndexesIn(records, updated, updated); err != nil {
+			return nil, err
+		}
+	}
+
+	updatedIDs := []interface{}{}
+	for _, existing := range matched {
+		for key, value := range values {
+			existing[key] = value
+		}
+		updatedIDs = append(updatedIDs, existing[c.repoDef.GetIDField()])
+	}
+	return updatedIDs, nil
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (c *MemoryCollection) Exists(filter Filter) (bool, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return false, ErrInvalidInput(err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Count returns the number of records matching the filter.
+func (c *MemoryCollection) Count(filter Filter) (int, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	count := 0
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+		if ok {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records,
+// counted in one pass.
+func (c *MemoryCollection) CountBoth(filter Filter) (int64, int64, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var filtered int64
+	for _, record := range c.records {
+		ok, err := matchRecord(record, filter)
+		if err != nil {
+			return 0, 0, ErrInvalidInput(err)
+		}
+		if ok {
+			filtered++
+		}
+	}
+
+	return filtered, int64(len(c.records)), nil
+}
+
+// GetByIDs returns the records with the given IDs, in the same order as the IDs.
+// The result is a pointer to a slice with nil for each ID that was not found.
+func (c *MemoryCollection) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[idKey(id)] = true
+	}
+
+	records := map[string]map[string]interface{}{}
+	c.mutex.RLock()
+	for _, record := range c.records {
+		if key := idKey(record[c.repoDef.GetIDField()]); wanted[key] {
+			records[key] = record
+		}
+	}
+	c.mutex.RUnlock()
+
+	return orderByIDs(ids, records, resultHint)
+}
+
+// LastQueryStats returns the stats of the most recent GetAll of the collection instance. All the
+// records are examined by every query, so ItemsScanned is the number of records in the collection.
+func (c *MemoryCollection) LastQueryStats() QueryStats {
+	return c.stats.get()
+}
+
+// DescribeRepository returns the number of records and the size of their JSON encoding.
+func (c *MemoryCollection) DescribeRepository() (RepositoryStats, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	stats := RepositoryStats{ItemCount: int64(len(c.records))}
+	for _, record := range c.records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return RepositoryStats{}, err
+		}
+		stats.SizeBytes += int64(len(data))
+	}
+	return stats, nil
+}
+
+// checkUniqueIndexes checks that the record does not violate any of the unique indexes.
+// The record being replaced (if any) is not checked against. Like sparse indexes in MongoDB,
+// records that do not have all of the indexed fields are not checked.
+func (c *MemoryCollection) checkUniqueIndexes(record, replaced map[string]interface{}) error {
+	return c.checkUniqueIndexesIn(c.records, record, replaced)
+}
+
+// checkUniqueIndexesIn checks the record against the given records instead of the records of
+// the collection, see checkUniqueIndexes.
+func (c *MemoryCollection) checkUniqueIndexesIn(records []map[string]interface{}, record, replaced map[string]interface{}) error {
+	for _, index := range c.indexes {
+		if err := c.checkUniqueIndex(records, index, record, replaced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUniqueIndex checks that the record does not violate the index, if the index is unique.
+func (c *MemoryCollection) checkUniqueIndex(records []map[string]interface{}, index Index, record, replaced map[string]interface{}) error {
+	if !index.Unique() {
+		return nil
+	}
+
+	key := Filter{}
+	for _, field := range index.GetFields() {
+		if value, ok := record[field]; ok && value != nil {
+			key[field] = value
+		}
+	}
+	if len(key) != len(index.GetFields()) {
+		return nil
+	}
+
+	for _, other := range records {
+		if replaced != nil && reflect.ValueOf(other).Pointer() == reflect.ValueOf(replaced).Pointer() {
+			continue
+		}
+		ok, err := matchRecord(other, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return DuplicateKeyError{
+				Index:  index.GetName(),
+				Fields: index.GetFields(),
+			}
+		}
+	}
+	return nil
+}
+
+// findIndex looks up the index of the collection by name.
+func (c *MemoryCollection) findIndex(name string) (Index, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, index := range c.indexes {
+		if index.GetName() == name {
+			return index, true
+		}
+	}
+	return nil, false
+}
+
+// EnsureIndexes adds the indexes that the collection does not have. The indexes are matched by
+// their fields, so an existing index on the same fields is not updated. A new unique index is checked against the existing records, so it is not added
+// if the records have duplicate values. The indexes of the collection that are not in the list
+// are logged and kept.
+func (c *MemoryCollection) EnsureIndexes(indexes []Index) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	wanted := map[string]bool{}
+	for _, index := range indexes {
+		fields := strings.Join(index.GetFields(), ",")
+		wanted[fields] = true
+		if c.hasIndexOn(index.GetFields()) {
+			continue
+		}
+		for _, record := range c.records {
+			if err := c.checkUniqueIndex(c.records, index, record, record); err != nil {
+				return err
+			}
+		}
+		c.indexes = append(c.indexes, index)
+	}
+
+	for _, index := range c.indexes {
+		if !wanted[strings.Join(index.GetFields(), ",")] {
+			c.logger.Debugf("The index %s of %s is not in the ensured indexes and is kept", index.GetName(), c.name)
+		}
+	}
+	return nil
+}
+
+// hasIndexOn checks if the collection has an index on exactly the given fields.
+func (c *MemoryCollection) hasIndexOn(fields []string) bool {
+	key := strings.Join(fields, ",")
+	for _, index := range c.indexes {
+		if strings.Join(index.GetFields(), ",") == key {
+			return true
+		}
+	}
+	return false
+}
+
+// toMemoryRecord converts the payload to a generic map, the same way it would look
+// like when decoded from JSON. This way the stored records do not share any
+// references with the objects passed by the caller.
+func toMemoryRecord(payload map[string]interface{}) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	if err := MapToInterface(payload, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// normalizeValue converts a filter value to its JSON representation, so it can be
+// compared with the values of the stored records.
+func normalizeValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err = json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// matchText checks if any of the words of the query is a word of the fields of the record,
+// regardless of case. Without fields, all the string properties of the record are searched.
+func matchText(record map[string]interface{}, query string, fields []string) bool {
+	if len(fields) == 0 {
+		for field := range record {
+			fields = append(fields, field)
+		}
+	}
+
+	words := map[string]bool{}
+	for _, field := range fields {
+		if text, ok := record[field].(string); ok {
+			for _, word := range textWords(text) {
+				words[word] = true
+			}
+		}
+	}
+	for _, word := range textWords(query) {
+		if words[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyFilter checks if the record matches any of the alternatives of Filter.AnyFieldMatches.
+func matchAnyFilter(record map[string]interface{}, value interface{}) (bool, error) {
+	alternatives, err := alternativeFilters(value)
+	if err != nil {
+		return false, err
+	}
+	for _, alternative := range alternatives {
+		matched, err := matchRecord(record, alternative)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+// matchRecord checks if the record matches all properties of the filter.
+func matchRecord(record map[string]interface{}, filter Filter) (bool, error) {
+	for property, value := range filter {
+		if property == NotOperator {
+			negated, err := negatedFilter(value)
+			if err != nil {
+				return false, err
+			}
+			matched, err := matchRecord(record, negated)
+			if err != nil || matched {
+				return false, err
+			}
+			continue
+		}
+		if property == TextOperator {
+			query, fields, err := textSearch(value)
+			if err != nil || !matchText(record, query, fields) {
+				return false, err
+			}
+			continue
+		}
+		if property == OrOperator {
+			matched, err := matchAnyFilter(record, value)
+			if err != nil || !matched {
+				return false, err
+			}
+			continue
+		}
+
+		recordValue := record[property]
+
+		if specs, ok := operatorSpecs(value); ok {
+			for operator, operand := range specs {
+				if operator == "$fieldCmp" {
+					matched, err := matchFieldComparison(record, property, operand)
+					if err != nil || !matched {
+						return false, err
+					}
+					continue
+				}
+				matched, err := matchOperator(recordValue, operator, operand)
+				if err != nil || !matched {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		if decimal, ok := value.(Decimal); ok {
+			cmp, ok, err := compareDecimal(recordValue, decimal)
+			if err != nil || !ok || cmp != 0 {
+				return false, err
+			}
+			continue
+		}
+
+		expected, err := normalizeValue(value)
+		if err != nil {
+			return false, err
+		}
+		if !reflect.DeepEqual(recordValue, expected) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchFieldComparison checks if the property of the record compares to the other property
+// as set with Filter.MatchField.
+func matchFieldComparison(record map[string]interface{}, property string, operand interface{}) (bool, error) {
+	operator, otherProperty, err := fieldComparison(operand)
+	if err != nil {
+		return false, err
+	}
+
+	value, other := record[property], record[otherProperty]
+	if value == nil || other == nil {
+		return false, nil
+	}
+
+	switch operator {
+	case "=":
+		return reflect.DeepEqual(value, other), nil
+	case "!=":
+		return !reflect.DeepEqual(value, other), nil
+	}
+
+	cmp, ok := compareOrdered(value, other)
+	if !ok {
+		return false, nil
+	}
+	switch operator {
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
+
+// matchOperator checks if the record value matches the filter operator.
+func matchOperator(recordValue interface{}, operator string, operand interface{}) (bool, error) {
+	switch operator {
+	case "$pattern":
+		pattern, ok := operand.(string)
+		if !ok {
+			return false, fmt.Errorf("pattern must be a string")
+		}
+		strValue, ok := recordValue.(string)
+		if !ok {
+			return false, nil
+		}
+		return regexp.MatchString(toMongoPattern(pattern), strValue)
+	case "$startsWith", "$endsWith":
+		affix, ok := operand.(string)
+		if !ok {
+			return false, fmt.Errorf("%s must be a string", operator)
+		}
+		strValue, ok := recordValue.(string)
+		if !ok {
+			return false, nil
+		}
+		if operator == "$startsWith" {
+			return strings.HasPrefix(strValue, affix), nil
+		}
+		return strings.HasSuffix(strValue, affix), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		var cmp int
+		var ok bool
+		if decimal, isDecimal := operand.(Decimal); isDecimal {
+			var err error
+			if cmp, ok, err = compareDecimal(recordValue, decimal); err != nil {
+				return false, err
+			}
+		} else {
+			expected, err := normalizeValue(operand)
+			if err != nil {
+				return false, err
+			}
+			cmp, ok = compareOrdered(recordValue, expected)
+		}
+		if !ok {
+			// values of different types (or missing values) never match a comparison
+			return false, nil
+		}
+		switch operator {
+		case "$gt":
+			return cmp > 0, nil
+		case "$gte":
+			return cmp >= 0, nil
+		case "$lt":
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+	case "$in":
+		values, ok := inValues(operand)
+		if !ok {
+			return false, fmt.Errorf("values for $in must be a list")
+		}
+		for _, value := range values {
+			expected, err := normalizeValue(value)
+			if err != nil {
+				return false, err
+			}
+			if reflect.DeepEqual(recordValue, expected) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$mod":
+		divisor, remainder, err := modOperands(operand)
+		if err != nil {
+			return false, err
+		}
+		value, ok := integerValue(recordValue)
+		if !ok {
+			return false, nil
+		}
+		return value%divisor == remainder, nil
+	case "$any":
+		return true, nil
+	case "$near", "$withinBox":
+		return matchGeo(recordValue, operator, operand)
+	case "$contains":
+		return arrayContains(recordValue, operand)
+	case "$all":
+		values, ok := inValues(operand)
+		if !ok || len(values) == 0 {
+			return false, fmt.Errorf("values for $all must be a non-empty list")
+		}
+		for _, value := range values {
+			contains, err := arrayContains(recordValue, value)
+			if err != nil || !contains {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown filter operator %s", operator)
+}
+
+// arrayContains checks if the record value is an array that contains the value.
+func arrayContains(recordValue interface{}, value interface{}) (bool, error) {
+	elements, ok := recordValue.([]interface{})
+	if !ok {
+		return false, nil
+	}
+	expected, err := normalizeValue(value)
+	if err != nil {
+		return false, err
+	}
+	for _, element := range elements {
+		if reflect.DeepEqual(element, expected) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareValues compares two values decoded from JSON. Returns negative number if a < b,
+// zero if they are equal and positive number if a > b. Nil values are always first.
+// Values of different types are compared by their string representation.
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if cmp, ok := compareOrdered(a, b); ok {
+		return cmp
+	}
+
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// compareOrdered compares two values decoded from JSON of the same type (number, string or bool).
+// Strings that are both RFC 3339 timestamps are compared as times.
+// The second return value is false if the values cannot be compared.
+func compareOrdered(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1, true
+			case av > bv:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			// time.Time values are encoded as RFC 3339 strings, which do not sort lexicographically
+			at, aErr := time.Parse(time.RFC3339Nano, av)
+			bt, bErr := time.Parse(time.RFC3339Nano, bv)
+			if aErr == nil && bErr == nil {
+				switch {
+				case at.Before(bt):
+					return -1, true
+				case at.After(bt):
+					return 1, true
+				}
+				return 0, true
+			}
+			return strings.Compare(av, bv), true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, true
+			case !av:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
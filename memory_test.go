@@ -0,0 +1,1725 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+type memoryTestEntry struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Age   int    `json:"age"`
+}
+
+func newMemoryTestRepo(t *testing.T, def RepositoryDefinitionMap) Repository {
+	bm := NewBackendSupport(map[string]*config.DBInfo{
+		"memory": &config.DBInfo{},
+	})
+
+	backend, err := bm.GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := backend.DefineRepository(def.GetName(), def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestMemorySaveAndGetOne(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := result.(*memoryTestEntry)
+	if saved.ID == "" {
+		t.Fatal("Expected the ID to be generated")
+	}
+
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOne(NewFilter().Match("id", saved.ID), entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Email != "john@example.com" {
+		t.Fatal("Expected to get the saved entry. Got: ", entry)
+	}
+
+	_, err = repo.GetOne(NewFilter().Match("id", "unknown"), &memoryTestEntry{})
+	if err == nil || !IsErrNotFound(err) {
+		t.Fatal("Expected not found error. Got: ", err)
+	}
+}
+
+func TestMemorySaveWithTimestamp(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	before := time.Now()
+	result, created, err := repo.SaveWithTimestamp(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Before(before) || created.After(time.Now()) {
+		t.Fatal("Expected the time of the insert to be populated. Got: ", created)
+	}
+
+	saved := result.(*memoryTestEntry)
+	_, updated, err := repo.SaveWithTimestamp(&memoryTestEntry{Name: "Jane"}, NewFilter().Match("id", saved.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Before(created) {
+		t.Fatalf("Expected the time of the update %v to be after the time of the insert %v", updated, created)
+	}
+
+	_, committedAt, err := repo.SaveWithTimestamp(&memoryTestEntry{}, NewFilter().Match("id", "unknown"))
+	if !IsErrNotFound(err) || !committedAt.IsZero() {
+		t.Fatal("Expected not found error and no time. Got: ", err, committedAt)
+	}
+}
+
+func TestMemoryIDField(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users", "idField": "userId"})
+
+	result, err := repo.Save(&map[string]interface{}{"name": "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := (*(result.(*map[string]interface{})))["userId"].(string)
+	if id == "" {
+		t.Fatal("Expected the ID to be generated in the ID field. Got: ", *(result.(*map[string]interface{})))
+	}
+
+	results, err := repo.GetByIDs([]interface{}{id}, &map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := *(results.(*[]*map[string]interface{})); len(records) != 1 || records[0] == nil {
+		t.Fatal("Expected the record to be found by the ID field. Got: ", records)
+	}
+}
+
+func TestMemoryExists(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	if _, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com", Age: 30}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := repo.Exists(NewFilter().Match("email", "john@example.com").Match("age", 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("Expected a record matching the filter to exist")
+	}
+
+	exists, err = repo.Exists(NewFilter().MatchPattern("name", "Jo%"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("Expected a record matching the pattern to exist")
+	}
+
+	exists, err = repo.Exists(NewFilter().Match("email", "jane@example.com"))
+	if err != nil {
+		t.Fatal("Expected no error when nothing matches. Got: ", err)
+	}
+	if exists {
+		t.Fatal("Expected no record to match the filter")
+	}
+}
+
+func TestMemoryCountBoth(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for i := 0; i < 10; i++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: fmt.Sprint("user", i), Age: 20 + i}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filtered, total, err := repo.CountBoth(NewFilter().Gte("age", 25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filtered != 5 || total != 10 {
+		t.Fatalf("Expected 5 of 10 records. Got %d of %d", filtered, total)
+	}
+
+	if filtered, total, err = repo.CountBoth(nil); err != nil || filtered != 10 || total != 10 {
+		t.Fatalf("Expected all the records without a filter. Got %d of %d: %v", filtered, total, err)
+	}
+	if filtered, total, err = repo.CountBoth(NewFilter().TextSearch("USER3 user7", "name")); err != nil || filtered != 2 || total != 10 {
+		t.Fatalf("Expected the records matching the text search. Got %d of %d: %v", filtered, total, err)
+	}
+}
+
+func TestMemoryReadAfterWriteWithOpts(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "accounts"})
+
+	result, err := repo.SaveWithOpts(&memoryTestEntry{Name: "John", Age: 30}, nil, WriteOpts{Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := result.(*memoryTestEntry)
+
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOneWithOpts(NewFilter().Match("id", saved.ID), entry, ReadOpts{Consistent: true}); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Age != 30 {
+		t.Fatal("Expected to read the written entry. Got: ", entry)
+	}
+}
+
+func TestMemoryDuplicateKey(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewUniqueIndex("email")},
+	})
+
+	if _, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := repo.Save(&memoryTestEntry{Name: "Johnny", Email: "john@example.com"}, nil)
+	if err == nil {
+		t.Fatal("Expected duplicate key error")
+	}
+
+	var dupErr DuplicateKeyError
+	if !errors.As(err, &dupErr) {
+		t.Fatal("Expected DuplicateKeyError. Got: ", err)
+	}
+	if dupErr.Index != "email" || !strArrEq(dupErr.Fields, []string{"email"}) {
+		t.Fatal("Expected the violated index to be reported. Got: ", dupErr.Index, dupErr.Fields)
+	}
+	if !errors.Is(err, ErrDuplicateKey) || !IsErrAlreadyExists(err) {
+		t.Fatal("Expected the error to be of the already exists class")
+	}
+
+	other, err := repo.Save(&memoryTestEntry{Name: "Jane", Email: "jane@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Save(&memoryTestEntry{Email: "john@example.com"}, NewFilter().Match("id", other.(*memoryTestEntry).ID))
+	if !errors.As(err, &dupErr) {
+		t.Fatal("Expected DuplicateKeyError on update. Got: ", err)
+	}
+
+	if _, err = repo.Save(&memoryTestEntry{Name: "Jane Doe", Email: "jane@example.com"}, NewFilter().Match("email", "jane@example.com")); err != nil {
+		t.Fatal("Expected the record not to collide with itself. Got: ", err)
+	}
+}
+
+func TestMemoryNamePrefix(t *testing.T) {
+	bm := NewBackendSupport(map[string]*config.DBInfo{
+		"memory": &config.DBInfo{},
+	})
+
+	staging, err := MemoryBackendBuilder(&config.DBInfo{}, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging.Configure(WithNamePrefix("staging_"))
+
+	production, err := MemoryBackendBuilder(&config.DBInfo{}, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	production.Configure(WithNamePrefix("production_"))
+
+	def := RepositoryDefinitionMap{"name": "users"}
+	for backend, expected := range map[Backend]string{
+		staging:    "staging_users",
+		production: "production_users",
+	} {
+		if _, err := backend.DefineRepository("users", def); err != nil {
+			t.Fatal(err)
+		}
+		repo, err := backend.GetRepository("users")
+		if err != nil {
+			t.Fatal("Expected the repository to be found by its logical name. Got: ", err)
+		}
+		if name := repo.(*MemoryCollection).Name(); name != expected {
+			t.Errorf("Expected physical name %s, got %s", expected, name)
+		}
+	}
+
+	if def.GetName() != "users" {
+		t.Fatal("Expected the definition name to stay logical. Got: ", def.GetName())
+	}
+}
+
+func TestMemoryCapabilities(t *testing.T) {
+	bm := NewBackendSupport(map[string]*config.DBInfo{
+		"memory": &config.DBInfo{},
+	})
+	backend, err := bm.GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := BackendCapabilities{
+		Regex:            true,
+		TextSearch:       true,
+		CaseInsensitive:  true,
+		Sorting:          true,
+		Geo:              true,
+		ServerTimestamps: true,
+		ParallelScan:     true,
+	}
+	if capabilities := backend.Capabilities(); capabilities != expected {
+		t.Fatalf("Expected the capabilities %+v. Got: %+v", expected, capabilities)
+	}
+}
+
+func TestMemoryIn(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, name := range []string{"John", "Jane", "Jim"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := repo.GetAll(NewFilter().In("name", "John", "Jim"), &memoryTestEntry{}, "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := *(results.(*[]*memoryTestEntry))
+	if len(entries) != 2 || entries[0].Name != "Jim" || entries[1].Name != "John" {
+		t.Fatal("Expected to match the entries with any of the names. Got: ", entries)
+	}
+}
+
+func TestMemoryPaging(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, name := range []string{"John", "Jane", "Jim"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := repo.GetAll(nil, &memoryTestEntry{}, "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := *(results.(*[]*memoryTestEntry)); len(entries) != 3 {
+		t.Fatal("Expected no limit to return all the entries. Got: ", entries)
+	}
+
+	results, err = repo.GetAll(nil, &memoryTestEntry{}, "name", "asc", -1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := *(results.(*[]*memoryTestEntry)); len(entries) != 2 || entries[0].Name != "Jim" {
+		t.Fatal("Expected a negative limit to return the rest of the entries. Got: ", entries)
+	}
+
+	_, err = repo.GetAll(nil, &memoryTestEntry{}, "name", "asc", 10, -1)
+	if !IsErrInvalidInput(err) || !strings.Contains(err.(*BackendErrorInfo).Details(), "offset must not be negative") {
+		t.Fatal("Expected an invalid input error for the negative offset. Got: ", err)
+	}
+
+	first, err := repo.GetFirst(NewFilter().In("name", "John", "Jim"), &memoryTestEntry{}, "name", "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := first.(*memoryTestEntry); entry.Name != "John" {
+		t.Fatal("Expected the first entry in the order. Got: ", entry)
+	}
+
+	if _, err = repo.GetFirst(NewFilter().Match("name", "Jack"), &memoryTestEntry{}, "name", "asc"); !IsErrNotFound(err) {
+		t.Fatal("Expected a not found error. Got: ", err)
+	}
+}
+
+func TestMemoryStartsWithEndsWith(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, name := range []string{"100% John", "100 John", "1000 Jane", "Jim 100%"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(filter Filter) []string {
+		results, err := repo.GetAll(filter, &memoryTestEntry{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, entry := range *(results.(*[]*memoryTestEntry)) {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	if found := names(NewFilter().StartsWith("name", "100%")); !strArrEq(found, []string{"100% John"}) {
+		t.Fatal("Expected the % in the prefix to match literally. Got: ", found)
+	}
+	if found := names(NewFilter().EndsWith("name", "100%")); !strArrEq(found, []string{"Jim 100%"}) {
+		t.Fatal("Expected the % in the suffix to match literally. Got: ", found)
+	}
+	if found := names(NewFilter().StartsWith("name", "100").EndsWith("name", "John")); !strArrEq(found, []string{"100 John", "100% John"}) {
+		t.Fatal("Expected both the prefix and the suffix to match. Got: ", found)
+	}
+}
+
+// memoryTestName is a DTO with only the name of memoryTestEntry.
+type memoryTestName struct {
+	Name string `json:"name"`
+	Nick string `json:"nick"`
+}
+
+func TestMemoryDecodeInto(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	if _, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com", Age: 30}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := repo.GetAll(nil, &memoryTestName{}, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := *(results.(*[]*memoryTestName))
+	if len(names) != 1 || names[0].Name != "John" || names[0].Nick != "" {
+		t.Fatal("Expected the extra properties to be ignored and the missing fields to be zero. Got: ", names)
+	}
+
+	strict := ReadOpts{StrictDecode: true}
+	if _, err = repo.GetAllWithOpts(nil, &memoryTestName{}, "", "", 0, 0, strict); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the strict decode to fail on the extra properties. Got: ", err)
+	}
+	if _, err = repo.GetOneWithOpts(nil, &memoryTestName{}, strict); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the strict decode to fail on the extra properties. Got: ", err)
+	}
+
+	results, err = repo.GetAllWithOpts(nil, &memoryTestEntry{}, "", "", 0, 0, strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := *(results.(*[]*memoryTestEntry)); len(entries) != 1 || entries[0].Age != 30 {
+		t.Fatal("Expected the matching type to be decoded. Got: ", entries)
+	}
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOneWithOpts(nil, entry, strict); err != nil || entry.Email != "john@example.com" {
+		t.Fatal("Expected the matching type to be decoded. Got: ", entry, err)
+	}
+}
+
+func TestMemoryNot(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, entry := range []*memoryTestEntry{
+		{Name: "John", Age: 30},
+		{Name: "John", Age: 40},
+		{Name: "Jane", Age: 30},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(filter Filter) []string {
+		results, err := repo.GetAll(filter, &memoryTestEntry{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range *(results.(*[]*memoryTestEntry)) {
+			names = append(names, fmt.Sprintf("%s-%d", entry.Name, entry.Age))
+		}
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{"single condition", NewFilter().Not(NewFilter().Match("name", "John")), []string{"Jane-30"}},
+		{"compound condition", NewFilter().Not(NewFilter().Match("name", "John").Match("age", 30)), []string{"Jane-30", "John-40"}},
+		{"pattern", NewFilter().Not(NewFilter().MatchPattern("name", "%hn")), []string{"Jane-30"}},
+		{"range", NewFilter().Not(NewFilter().Gt("age", 30)), []string{"Jane-30", "John-30"}},
+		{"with other conditions", NewFilter().Match("name", "John").Not(NewFilter().Match("age", 30)), []string{"John-40"}},
+		{"double negation", NewFilter().Not(NewFilter().Not(NewFilter().Match("name", "Jane"))), []string{"Jane-30"}},
+	}
+
+	for _, test := range tests {
+		if result := names(test.filter); !strArrEq(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+
+	if _, err := repo.GetAll(NewFilter().Not(NewFilter()), &memoryTestEntry{}, "", "", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an empty negated filter to be rejected. Got: ", err)
+	}
+}
+
+func TestMemoryAnyFieldMatches(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users", "fieldTypes": map[string]interface{}{"age": "int"}})
+
+	for _, entry := range []*memoryTestEntry{
+		{Name: "John", Email: "john@example.com", Age: 30},
+		{Name: "Jane", Email: "jane@example.com", Age: 30},
+		{Name: "jane@example.com", Email: "other@example.com", Age: 40},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := repo.GetAll(NewFilter().AnyFieldMatches("jane@example.com", "name", "email"), &memoryTestEntry{}, "age", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := *results.(*[]*memoryTestEntry); len(entries) != 2 || entries[0].Name != "Jane" || entries[1].Age != 40 {
+		t.Fatal("Expected the entries with the value in the name or in the email. Got: ", entries)
+	}
+
+	count, err := repo.Count(NewFilter().Match("age", "30").AnyFieldMatches("jane@example.com", "name", "email"))
+	if err != nil || count != 1 {
+		t.Fatal("Expected the alternatives to be combined with the other conditions. Got: ", count, err)
+	}
+
+	if _, err = repo.Count(NewFilter().AnyFieldMatches("jane@example.com")); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the alternatives without fields to be rejected. Got: ", err)
+	}
+}
+
+func TestMemoryMod(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for age := 1; age <= 7; age++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: fmt.Sprintf("user%d", age), Age: age}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[int]int{}
+	for shard := int64(0); shard < 2; shard++ {
+		results, err := repo.GetAll(NewFilter().Mod("age", 2, shard), &memoryTestEntry{}, "", "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range *results.(*[]*memoryTestEntry) {
+			if int64(entry.Age)%2 != shard {
+				t.Fatal("Expected only the entries of the shard. Got: ", shard, entry.Age)
+			}
+			seen[entry.Age]++
+		}
+	}
+	if len(seen) != 7 {
+		t.Fatal("Expected the shards to cover all the entries. Got: ", seen)
+	}
+	for age, count := range seen {
+		if count != 1 {
+			t.Fatal("Expected the shards to be disjoint. Got: ", age, count)
+		}
+	}
+
+	if _, err := repo.Count(NewFilter().Mod("age", 0, 0)); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the zero divisor to be rejected. Got: ", err)
+	}
+}
+
+type memoryArticle struct {
+	ID    string      `json:"id"`
+	Title string      `json:"title"`
+	Tags  interface{} `json:"tags"`
+}
+
+func TestMemoryArrayContains(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "articles"})
+
+	for _, article := range []*memoryArticle{
+		{Title: "a", Tags: []string{"go", "mongo"}},
+		{Title: "b", Tags: []string{"go"}},
+		{Title: "c", Tags: []string{"java"}},
+		{Title: "d", Tags: "go"},
+		{Title: "e"},
+	} {
+		if _, err := repo.Save(article, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titles := func(filter Filter) []string {
+		results, err := repo.GetAll(filter, &memoryArticle{}, "title", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, article := range *(results.(*[]*memoryArticle)) {
+			titles = append(titles, article.Title)
+		}
+		return titles
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{"contains", NewFilter().ArrayContains("tags", "go"), []string{"a", "b"}},
+		{"contains missing value", NewFilter().ArrayContains("tags", "python"), nil},
+		{"contains all", NewFilter().ArrayContainsAll("tags", "mongo", "go"), []string{"a"}},
+		{"contains all with missing value", NewFilter().ArrayContainsAll("tags", "go", "python"), nil},
+		{"negated contains", NewFilter().Not(NewFilter().ArrayContains("tags", "go")), []string{"c", "d", "e"}},
+	}
+
+	for _, test := range tests {
+		if result := titles(test.filter); !strArrEq(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+		}
+	}
+
+	if _, err := repo.GetAll(NewFilter().ArrayContainsAll("tags"), &memoryArticle{}, "", "", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the empty list of values to be rejected. Got: ", err)
+	}
+}
+
+func TestMemoryCollation(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, name := range []string{"banana", "apple", "Cherry", "Apple", "cherry"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sorted := func(sorting string, opts ReadOpts, limit, offset int) []string {
+		results, err := repo.GetAllWithOpts(NewFilter(), &memoryTestEntry{}, "name", sorting, limit, offset, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range *(results.(*[]*memoryTestEntry)) {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	caseInsensitive := ReadOpts{Collation: &Collation{CaseInsensitive: true}}
+
+	tests := []struct {
+		name     string
+		result   []string
+		expected []string
+	}{
+		{"default", sorted("asc", ReadOpts{}, 0, 0), []string{"Apple", "Cherry", "apple", "banana", "cherry"}},
+		{"case insensitive", sorted("asc", caseInsensitive, 0, 0), []string{"Apple", "apple", "banana", "Cherry", "cherry"}},
+		{"case insensitive desc", sorted("desc", caseInsensitive, 0, 0), []string{"cherry", "Cherry", "banana", "apple", "Apple"}},
+		{"case insensitive page", sorted("asc", caseInsensitive, 2, 1), []string{"apple", "banana"}},
+	}
+
+	for _, test := range tests {
+		if !strArrEq(test.result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.result)
+		}
+	}
+}
+
+type memoryDocument struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	CreatedAt interface{} `json:"created_at,omitempty"`
+	UpdatedAt interface{} `json:"updated_at,omitempty"`
+}
+
+func TestMemoryMatchField(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "documents"})
+
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, document := range []*memoryDocument{
+		{Name: "updated", CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+		{Name: "unchanged", CreatedAt: created, UpdatedAt: created},
+		{Name: "skewed", CreatedAt: created, UpdatedAt: created.Add(-time.Minute)},
+		{Name: "new", CreatedAt: created},
+		{Name: "mixed", CreatedAt: created, UpdatedAt: 10},
+	} {
+		if _, err := repo.Save(document, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(filter Filter) []string {
+		results, err := repo.GetAll(filter, &memoryDocument{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, document := range *(results.(*[]*memoryDocument)) {
+			names = append(names, document.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		operator string
+		expected []string
+	}{
+		{">", []string{"updated"}},
+		{">=", []string{"unchanged", "updated"}},
+		{"<", []string{"skewed"}},
+		{"<=", []string{"skewed", "unchanged"}},
+		{"=", []string{"unchanged"}},
+		{"!=", []string{"mixed", "skewed", "updated"}},
+	}
+
+	for _, test := range tests {
+		if result := names(NewFilter().MatchField("updated_at", test.operator, "created_at")); !strArrEq(result, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.operator, test.expected, result)
+		}
+	}
+
+	if _, err := repo.GetAll(NewFilter().MatchField("updated_at", "~", "created_at"), &memoryDocument{}, "", "", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an unknown operator to be rejected. Got: ", err)
+	}
+}
+
+func TestMemoryGetByIDs(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	ids := map[string]string{}
+	for _, name := range []string{"John", "Jane", "Jim"} {
+		result, err := repo.Save(&memoryTestEntry{Name: name}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = result.(*memoryTestEntry).ID
+	}
+
+	results, err := repo.GetByIDs([]interface{}{ids["Jim"], "missing", ids["John"], ids["Jim"]}, &memoryTestEntry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := *(results.(*[]*memoryTestEntry))
+	if len(entries) != 4 {
+		t.Fatalf("Expected one result per requested ID, got %d", len(entries))
+	}
+	if entries[1] != nil {
+		t.Fatal("Expected nil for the missing ID. Got: ", entries[1])
+	}
+	for i, expected := range map[int]string{0: "Jim", 2: "John", 3: "Jim"} {
+		if entries[i] == nil || entries[i].Name != expected {
+			t.Errorf("Expected %s at position %d, got %v", expected, i, entries[i])
+		}
+	}
+}
+
+type memoryOrder struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+func TestMemorySaveIf(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "orders"})
+
+	result, err := repo.Save(&memoryOrder{State: "pending"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := NewFilter().Match("id", result.(*memoryOrder).ID)
+
+	updated, applied, err := repo.SaveIf(&memoryOrder{State: "paid"}, byID, NewFilter().Match("state", "pending"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied || updated.(*memoryOrder).State != "paid" {
+		t.Fatal("Expected the pending -> paid transition to be applied. Got: ", updated)
+	}
+
+	// the order is no longer pending, so a concurrent pending -> cancelled transition must fail
+	_, applied, err = repo.SaveIf(&memoryOrder{State: "cancelled"}, byID, NewFilter().Match("state", "pending"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Fatal("Expected the transition not to be applied when the condition does not hold")
+	}
+
+	order := &memoryOrder{}
+	if _, err = repo.GetOne(byID, order); err != nil {
+		t.Fatal(err)
+	}
+	if order.State != "paid" {
+		t.Fatal("Expected the record to stay unchanged. Got: ", order.State)
+	}
+
+	_, _, err = repo.SaveIf(&memoryOrder{State: "paid"}, NewFilter().Match("id", "missing"), NewFilter())
+	if !IsErrNotFound(err) {
+		t.Fatal("Expected not found error. Got: ", err)
+	}
+}
+
+func TestMemoryDeleteIf(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "orders"})
+
+	result, err := repo.Save(&memoryOrder{State: "pending"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := NewFilter().Match("id", result.(*memoryOrder).ID)
+
+	// another process moved the order on since it was read as cancelled
+	if _, err = repo.Save(&memoryOrder{State: "paid"}, byID); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := repo.DeleteIf(byID, NewFilter().Match("state", "cancelled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Fatal("Expected the delete to be refused when the condition does not hold")
+	}
+	if exists, err := repo.Exists(byID); err != nil || !exists {
+		t.Fatal("Expected the record to be kept. Got: ", exists, err)
+	}
+
+	deleted, err = repo.DeleteIf(byID, NewFilter().Match("state", "paid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Fatal("Expected the record to be deleted when the condition holds")
+	}
+	if exists, err := repo.Exists(byID); err != nil || exists {
+		t.Fatal("Expected the record to be deleted. Got: ", exists, err)
+	}
+
+	if _, err = repo.DeleteIf(byID, NewFilter()); !IsErrNotFound(err) {
+		t.Fatal("Expected not found error. Got: ", err)
+	}
+}
+
+type memoryJob struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Priority int    `json:"priority"`
+}
+
+func TestMemoryFindAndModify(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "jobs"})
+
+	for i := 0; i < 20; i++ {
+		if _, err := repo.Save(&memoryJob{Status: "queued", Priority: i % 5}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := repo.FindAndModify(NewFilter().Match("status", "queued"), map[string]interface{}{
+		"status": "processing",
+	}, 2, []SortKey{{Property: "priority", Sorting: "desc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := claimed.([]map[string]interface{})
+	if len(first) != 2 || first[0]["priority"] != float64(4) || first[1]["priority"] != float64(4) || first[0]["status"] != "processing" {
+		t.Fatal("Expected the two jobs of the highest priority to be claimed. Got: ", first)
+	}
+
+	// concurrent claimers must not get the same job
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[interface{}]bool{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := repo.FindAndModify(NewFilter().Match("status", "queued"), map[string]interface{}{
+				"status": "processing",
+			}, 3, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, job := range claimed.([]map[string]interface{}) {
+				if seen[job["id"]] {
+					t.Error("Expected the job to be claimed only once: ", job["id"])
+				}
+				seen[job["id"]] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 18 {
+		t.Fatal("Expected all the remaining jobs to be claimed. Got: ", len(seen))
+	}
+	if count, _ := repo.Count(NewFilter().Match("status", "queued")); count != 0 {
+		t.Fatal("Expected no queued jobs. Got: ", count)
+	}
+
+	if _, err = repo.FindAndModify(NewFilter(), map[string]interface{}{"id": "other"}, 1, nil); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for updating the ID. Got: ", err)
+	}
+	if _, err = repo.FindAndModify(NewFilter(), map[string]interface{}{"status": "done"}, 0, nil); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the limit that is not positive. Got: ", err)
+	}
+}
+
+func TestMemoryParallelScan(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "jobs"})
+
+	for i := 0; i < 450; i++ {
+		if _, err := repo.Save(&memoryJob{Status: "queued", Priority: i % 3}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	visits := map[string]int{}
+	err := repo.ParallelScan(NewFilter().Match("status", "queued"), 4, &memoryJob{}, func(batch interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, job := range batch.([]*memoryJob) {
+			visits[job.ID]++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visits) != 450 {
+		t.Fatal("Expected all the records to be visited. Got: ", len(visits))
+	}
+	for id, count := range visits {
+		if count != 1 {
+			t.Fatalf("Expected the record %s to be visited once. Got: %d", id, count)
+		}
+	}
+
+	failed := errors.New("export failed")
+	var calls int
+	err = repo.ParallelScan(NewFilter(), 4, &memoryJob{}, func(batch interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return failed
+	})
+	if err != failed {
+		t.Fatal("Expected the error of the callback. Got: ", err)
+	}
+	if calls > 4 {
+		t.Fatal("Expected the remaining batches to be cancelled. Got calls: ", calls)
+	}
+
+	if err = repo.ParallelScan(NewFilter(), 0, &memoryJob{}, func(batch interface{}) error { return nil }); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for no segments. Got: ", err)
+	}
+}
+
+func TestMemoryGetAllByIndex(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewNonUniqueIndex("email")},
+	})
+
+	for _, name := range []string{"John", "Jane"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name, Email: "family@example.com"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := repo.GetAllByIndex("email", NewFilter().Match("email", "family@example.com"), &memoryTestEntry{}, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := *(results.(*[]*memoryTestEntry))
+	if len(entries) != 1 || entries[0].Name != "Jane" {
+		t.Fatal("Expected the limit and offset to be applied. Got: ", entries)
+	}
+
+	if _, err = repo.GetAllByIndex("name", NewFilter(), &memoryTestEntry{}, 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for unknown index. Got: ", err)
+	}
+}
+
+func TestMemoryEnsureIndexes(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewNonUniqueIndex("name")},
+	})
+
+	if _, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetAllByIndex("email", NewFilter(), &memoryTestEntry{}, 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the index that is not created yet. Got: ", err)
+	}
+
+	indexes := []Index{NewNonUniqueIndex("name"), NewUniqueIndex("email")}
+	for i := 0; i < 2; i++ {
+		if err := repo.EnsureIndexes(indexes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := len(repo.(*MemoryCollection).indexes); count != 2 {
+		t.Fatalf("Expected the indexes to be created once, got %d indexes", count)
+	}
+
+	if _, err := repo.GetAllByIndex("email", NewFilter(), &memoryTestEntry{}, 0, 0); err != nil {
+		t.Fatal("Expected the created index to be available. Got: ", err)
+	}
+	_, err := repo.Save(&memoryTestEntry{Name: "Johnny", Email: "john@example.com"}, nil)
+	if _, ok := err.(DuplicateKeyError); !ok {
+		t.Fatal("Expected the created unique index to be enforced. Got: ", err)
+	}
+
+	if _, err = repo.Save(&memoryTestEntry{Name: "John", Email: "johnny@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.EnsureIndexes([]Index{NewUniqueIndex("name", "age")})
+	if _, ok := err.(DuplicateKeyError); !ok {
+		t.Fatal("Expected a unique index not to be created over duplicate records. Got: ", err)
+	}
+}
+
+func TestMemoryReplaceOne(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com", Age: 30}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := result.(*memoryTestEntry).ID
+	filter := NewFilter().Match("id", id)
+
+	previous, err := repo.ReplaceOne(filter, &map[string]interface{}{"name": "Johnny", "age": 31})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *(previous.(*map[string]interface{}))
+	if old["name"] != "John" || old["email"] != "john@example.com" || old["age"] != float64(30) {
+		t.Fatal("Expected the previous record. Got: ", old)
+	}
+
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOne(filter, entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != id || entry.Name != "Johnny" || entry.Age != 31 {
+		t.Fatal("Expected the record to be replaced. Got: ", entry)
+	}
+	if entry.Email != "" {
+		t.Fatal("Expected the properties missing in the replacement to be removed. Got: ", entry.Email)
+	}
+
+	previous, err = repo.ReplaceOne(filter, &memoryTestEntry{Name: "Jack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev := previous.(*memoryTestEntry); prev.Name != "Johnny" || prev.Age != 31 {
+		t.Fatal("Expected the previous record of the object type. Got: ", prev)
+	}
+
+	if _, err = repo.ReplaceOne(NewFilter().Match("id", "missing"), &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected ErrNotFound. Got: ", err)
+	}
+}
+
+func TestMemorySaveUpsert(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	filter := NewFilter().Match("email", "john@example.com")
+
+	result, created, err := repo.SaveUpsert(&map[string]interface{}{"name": "John"}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("Expected the record to be created")
+	}
+	inserted := &memoryTestEntry{}
+	if err = MapToInterface(result, inserted); err != nil {
+		t.Fatal(err)
+	}
+	if inserted.ID == "" || inserted.Email != "john@example.com" {
+		t.Fatal("Expected the new record to get the ID and the filter properties. Got: ", inserted)
+	}
+
+	result, created, err = repo.SaveUpsert(&map[string]interface{}{"name": "Johnny"}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Fatal("Expected the existing record to be updated")
+	}
+	updated := *(result.(*map[string]interface{}))
+	if updated["id"] != inserted.ID || updated["name"] != "Johnny" {
+		t.Fatal("Expected the inserted record to be updated. Got: ", updated)
+	}
+
+	if count, _ := repo.Count(NewFilter()); count != 1 {
+		t.Fatalf("Expected one record, got %d", count)
+	}
+
+	if _, _, err = repo.SaveUpsert(&memoryTestEntry{}, nil); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the filter to be required. Got: ", err)
+	}
+}
+
+func TestMemoryUpsertAll(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	existing, err := repo.Save(&memoryTestEntry{Name: "John", Email: "john@example.com", Age: 30}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := repo.UpsertAll([]interface{}{
+		&memoryTestEntry{Name: "Johnny", Email: "john@example.com", Age: 31},
+		&memoryTestEntry{Name: "Jane", Email: "jane@example.com", Age: 25},
+		&memoryTestEntry{Name: "Jane Doe", Email: "jane@example.com", Age: 26},
+	}, []string{"email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected one result per object, got %d", len(results))
+	}
+
+	john := results[0].(*memoryTestEntry)
+	if john.ID != existing.(*memoryTestEntry).ID || john.Name != "Johnny" || john.Age != 31 {
+		t.Fatal("Expected the existing record to be updated. Got: ", john)
+	}
+
+	jane, janeDoe := results[1].(*memoryTestEntry), results[2].(*memoryTestEntry)
+	if jane.ID == "" || jane.ID != janeDoe.ID {
+		t.Fatal("Expected the colliding objects to be upserted to the same new record. Got: ", jane, janeDoe)
+	}
+	if jane.Name != "Jane Doe" || jane.Age != 26 {
+		t.Fatal("Expected the later object to win. Got: ", jane)
+	}
+
+	if count, _ := repo.Count(NewFilter()); count != 2 {
+		t.Fatal("Expected one insert and one update. Count: ", count)
+	}
+
+	_, err = repo.UpsertAll([]interface{}{
+		&memoryTestEntry{Name: "Jim", Email: "jim@example.com"},
+		map[string]interface{}{"name": "No email"},
+	}, []string{"email"})
+	var upsertErr UpsertError
+	if !errors.As(err, &upsertErr) || upsertErr.Row != 1 || !IsErrInvalidInput(upsertErr.Cause) {
+		t.Fatal("Expected the row without the conflict key to fail. Got: ", err)
+	}
+	if exists, _ := repo.Exists(NewFilter().Match("email", "jim@example.com")); !exists {
+		t.Fatal("Expected the rows before the failed row to be written")
+	}
+}
+
+func TestMemoryLastQueryStats(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	if stats := repo.LastQueryStats(); stats != (QueryStats{}) {
+		t.Fatal("Expected zero stats before the first query. Got: ", stats)
+	}
+
+	for age := 20; age < 30; age++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: "John", Age: age}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.GetAll(NewFilter().Gte("age", 25), &memoryTestEntry{}, "", "", 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	stats := repo.LastQueryStats()
+	if stats.ItemsScanned != 10 || stats.ItemsReturned != 3 {
+		t.Fatal("Expected all the records scanned and the page returned. Got: ", stats)
+	}
+
+	// the stats are kept per instance
+	timed := NewTimeoutRepository(repo, time.Second)
+	if _, err := timed.GetAll(NewFilter(), &memoryTestEntry{}, "", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if stats = timed.LastQueryStats(); stats.ItemsScanned < stats.ItemsReturned || stats.ItemsReturned != 10 {
+		t.Fatal("Expected the stats of the query through the wrapper. Got: ", stats)
+	}
+	if stats = repo.LastQueryStats(); stats.ItemsReturned != 3 {
+		t.Fatal("Expected the stats of the repository to stay unchanged. Got: ", stats)
+	}
+}
+
+func TestMemoryMatchNil(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	records := []map[string]interface{}{
+		{"name": "null", "email": nil},
+		{"name": "missing"},
+		{"name": "set", "email": "john@example.com"},
+	}
+	for _, record := range records {
+		record := record
+		if _, err := repo.Save(&record, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var noEmail *string
+	matched, err := repo.GetAll(NewFilter().Match("email", noEmail), &map[string]interface{}{}, "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, record := range *matched.(*[]*map[string]interface{}) {
+		names = append(names, (*record)["name"].(string))
+	}
+	if !strArrEq(names, []string{"missing", "null"}) {
+		t.Fatal("Expected the nil match to match the null and the missing emails. Got: ", names)
+	}
+	if count, err := repo.Count(NewFilter().Set("email", noEmail)); err != nil || count != 2 {
+		t.Fatal("Expected Set to match nil like Match. Got: ", count, err)
+	}
+
+	if count, err := repo.Count(NewFilter().Match("email", "john@example.com").MatchAny("email")); err != nil || count != 3 {
+		t.Fatal("Expected MatchAny to match all the records. Got: ", count, err)
+	}
+	if count, err := repo.Count(NewFilter().Not(NewFilter().Match("email", nil))); err != nil || count != 1 {
+		t.Fatal("Expected the negated nil match to match the set email. Got: ", count, err)
+	}
+}
+
+func TestMemoryTextSearch(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "posts"})
+
+	records := []map[string]interface{}{
+		{"title": "The best Coffee in town", "body": "A review of the local shops."},
+		{"title": "Tea time", "body": "Brewing green tea, step by step."},
+		{"title": "Shopping list", "body": "Milk, bread and coffee-beans."},
+	}
+	for _, record := range records {
+		record := record
+		if _, err := repo.Save(&record, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titles := func(filter Filter) []string {
+		matched, err := repo.GetAll(filter, &map[string]interface{}{}, "title", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, record := range *matched.(*[]*map[string]interface{}) {
+			titles = append(titles, (*record)["title"].(string))
+		}
+		return titles
+	}
+
+	if found := titles(NewFilter().TextSearch("COFFEE tea", "title")); !strArrEq(found, []string{"Tea time", "The best Coffee in town"}) {
+		t.Fatal("Expected the titles with any of the words. Got: ", found)
+	}
+	if found := titles(NewFilter().TextSearch("coffee")); !strArrEq(found, []string{"Shopping list", "The best Coffee in town"}) {
+		t.Fatal("Expected all the properties to be searched without fields. Got: ", found)
+	}
+	if found := titles(NewFilter().TextSearch("shop", "body")); len(found) != 0 {
+		t.Fatal("Expected only whole words to match. Got: ", found)
+	}
+}
+
+func TestMemoryDeleteAllReturning(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	expected := []string{}
+	for _, entry := range []*memoryTestEntry{
+		{Name: "John", Age: 17},
+		{Name: "Jane", Age: 16},
+		{Name: "Bob", Age: 30},
+		{Name: "Ann", Age: 15},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Age < 18 {
+			expected = append(expected, entry.ID)
+		}
+	}
+
+	ids, err := repo.DeleteAllReturning(NewFilter().Lt("age", 18))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := []string{}
+	for _, id := range ids {
+		deleted = append(deleted, id.(string))
+	}
+	sort.Strings(expected)
+	sort.Strings(deleted)
+	if !strArrEq(deleted, expected) {
+		t.Fatalf("Expected the IDs %v of the deleted records. Got: %v", expected, deleted)
+	}
+	if count, _ := repo.Count(nil); count != 1 {
+		t.Fatal("Expected one record to remain. Got: ", count)
+	}
+
+	ids, err = repo.UpdateFieldsReturning(NewFilter().Match("name", "Bob"), map[string]interface{}{"email": "bob@example.com"})
+	if err != nil || len(ids) != 1 {
+		t.Fatal("Expected the ID of the updated record. Got: ", ids, err)
+	}
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOne(NewFilter().Match("id", ids[0]), entry); err != nil || entry.Email != "bob@example.com" || entry.Age != 30 {
+		t.Fatal("Expected the field to be updated and the others kept. Got: ", entry, err)
+	}
+
+	if ids, err = repo.DeleteAllReturning(NewFilter().Match("name", "Nobody")); err != nil || len(ids) != 0 {
+		t.Fatal("Expected no IDs. Got: ", ids, err)
+	}
+	if _, err = repo.UpdateFieldsReturning(nil, map[string]interface{}{"id": "other"}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the update of the ID. Got: ", err)
+	}
+}
+
+func TestMemoryUpdateFieldsReturningUnique(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":    "users",
+		"indexes": []Index{NewUniqueIndex("email")},
+	})
+	for _, entry := range []*memoryTestEntry{
+		{Name: "John", Email: "john@example.com"},
+		{Name: "John", Email: "johnny@example.com"},
+		{Name: "Jane", Email: "jane@example.com"},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, email := range []string{"jane@example.com", "new@example.com"} {
+		_, err := repo.UpdateFieldsReturning(NewFilter().Match("name", "John"), map[string]interface{}{"email": email})
+		if _, ok := err.(DuplicateKeyError); !ok {
+			t.Fatalf("Expected DuplicateKeyError for the email %s. Got: %v", email, err)
+		}
+		for _, email := range []string{"john@example.com", "johnny@example.com"} {
+			if exists, _ := repo.Exists(NewFilter().Match("email", email)); !exists {
+				t.Fatalf("Expected none of the records to be updated, the email %s is missing", email)
+			}
+		}
+	}
+
+	if ids, err := repo.UpdateFieldsReturning(NewFilter().Match("email", "john@example.com"), map[string]interface{}{"email": "john@example.com"}); err != nil || len(ids) != 1 {
+		t.Fatal("Expected a record to keep its own unique value. Got: ", ids, err)
+	}
+}
+
+func TestMemorySkipDecodeErrors(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	for _, record := range []map[string]interface{}{
+		{"id": "1", "name": "John", "age": 30},
+		{"id": "2", "name": "Jane", "age": "thirty"},
+		{"id": "3", "name": "Bob", "age": 40},
+	} {
+		if _, err := repo.Save(&record, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.GetAll(nil, &memoryTestEntry{}, "id", "asc", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected the undecodable record to fail the read by default. Got: ", err)
+	}
+
+	results, err := repo.GetAllWithOpts(nil, &memoryTestEntry{}, "id", "asc", 0, 0, ReadOpts{SkipDecodeErrors: true})
+	decodeErrs, ok := err.(DecodeErrors)
+	if !ok || len(decodeErrs) != 1 || !strings.Contains(decodeErrs[0].Error(), "record 2") {
+		t.Fatal("Expected the error of the undecodable record. Got: ", err)
+	}
+	entries := *results.(*[]*memoryTestEntry)
+	if len(entries) != 2 || entries[0].Name != "John" || entries[1].Name != "Bob" {
+		t.Fatal("Expected the decoded records. Got: ", entries)
+	}
+
+	if _, err = repo.GetAllWithOpts(NewFilter().Match("id", "1"), &memoryTestEntry{}, "", "", 0, 0, ReadOpts{SkipDecodeErrors: true}); err != nil {
+		t.Fatal("Expected no error when all the records are decoded. Got: ", err)
+	}
+}
+
+func TestMemoryBulk(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for _, entry := range []*memoryTestEntry{{Name: "John", Age: 17}, {Name: "Jane", Age: 16}, {Name: "Bob", Age: 30}} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ann := &memoryTestEntry{Name: "Ann", Age: 15}
+	result, err := repo.Bulk().
+		Insert(ann).
+		Insert(&memoryTestEntry{Name: "Tom", Age: 40}).
+		Update(NewFilter().Lt("age", 18), map[string]interface{}{"email": "minor@example.com"}).
+		Delete(NewFilter().Match("name", "Bob")).
+		Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (BulkResult{Inserted: 2, Updated: 3, Deleted: 1}) {
+		t.Fatal("Expected 2 inserted, 3 updated and 1 deleted records. Got: ", result)
+	}
+	if ann.ID == "" {
+		t.Fatal("Expected the ID of the inserted record to be set on the object")
+	}
+	if count, _ := repo.Count(NewFilter().Match("email", "minor@example.com")); count != 3 {
+		t.Fatal("Expected the update to see the records inserted before it. Got: ", count)
+	}
+
+	result, err = repo.Bulk().
+		Delete(NewFilter().Match("name", "Tom")).
+		Update(NewFilter().Match("name", "Ann"), map[string]interface{}{}).
+		Delete(NewFilter()).
+		Execute()
+	var bulkErr BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Operation != 1 || !IsErrInvalidInput(err) {
+		t.Fatal("Expected the error of the invalid update. Got: ", err)
+	}
+	if result != (BulkResult{Deleted: 1}) {
+		t.Fatal("Expected only the operation before the failed one to be executed. Got: ", result)
+	}
+	if count, _ := repo.Count(NewFilter()); count != 3 {
+		t.Fatal("Expected the operations after the failed one not to be executed. Got: ", count)
+	}
+}
+
+func TestMemoryQueryRange(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users", "hashKey": "name", "rangeKey": "age"})
+	for _, entry := range []*memoryTestEntry{
+		{Name: "John", Age: 17},
+		{Name: "John", Age: 30},
+		{Name: "John", Age: 45},
+		{Name: "Jane", Age: 30},
+	} {
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := repo.QueryRange("John", "$between", []interface{}{18, 50}, &memoryTestEntry{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := *results.(*[]*memoryTestEntry)
+	if len(entries) != 2 || entries[0].Age != 30 || entries[1].Age != 45 {
+		t.Fatal("Expected the entries of John between 18 and 50 ordered by age. Got: ", entries)
+	}
+
+	if results, err = repo.QueryRange("John", "$gt", 17, &memoryTestEntry{}, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if entries = *results.(*[]*memoryTestEntry); len(entries) != 1 || entries[0].Age != 45 {
+		t.Fatal("Expected the second page of the entries of John older than 17. Got: ", entries)
+	}
+
+	if _, err = repo.QueryRange("John", "$between", 18, &memoryTestEntry{}, 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the $between without two bounds. Got: ", err)
+	}
+	noRange := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	if _, err = noRange.QueryRange("John", "$eq", 30, &memoryTestEntry{}, 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the repository without a range key. Got: ", err)
+	}
+}
+
+func TestMemoryUsesIndex(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	err := repo.EnsureIndexes([]Index{NewNonUniqueIndex("name"), NewIndex("email_unique", true, "email")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, ok := repo.UsesIndex(NewFilter().Match("name", "John").Match("email", "john@example.com"))
+	if !ok || index.GetName() != "email_unique" || !index.Unique() {
+		t.Fatal("Expected the filter to use the unique index on the email. Got: ", index, ok)
+	}
+	if index, ok = repo.UsesIndex(NewFilter().Match("id", "abc")); !ok || !index.Unique() {
+		t.Fatal("Expected the filter on the ID to use the unique index of the IDs. Got: ", index, ok)
+	}
+	if index, ok = repo.UsesIndex(NewFilter().Match("age", 30)); ok {
+		t.Fatal("Expected the filter on the age to use no index. Got: ", index)
+	}
+	if index, ok = repo.UsesIndex(Filter{"email": map[string]interface{}{"$ne": "john@example.com"}}); ok {
+		t.Fatal("Expected the filter with an operator to use no index. Got: ", index)
+	}
+}
+
+func TestMemoryArrayAppendAndRemove(t *testing.T) {
+	type auditedEntry struct {
+		ID    string   `json:"id"`
+		Name  string   `json:"name"`
+		Trail []string `json:"trail"`
+	}
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for _, name := range []string{"John", "Jane"} {
+		if _, err := repo.Save(&auditedEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	john := NewFilter().Match("name", "John")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := repo.ArrayAppend(john, "trail", fmt.Sprintf("event-%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	result, err := repo.GetOne(john, &auditedEntry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trail := result.(*auditedEntry).Trail; len(trail) != 50 {
+		t.Fatal("Expected all the 50 concurrent appends in the trail. Got: ", len(trail))
+	}
+
+	removed, err := repo.ArrayRemove(NewFilter(), "trail", "event-1", "event-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatal("Expected only the trail of John to be changed. Got: ", removed)
+	}
+	if result, err = repo.GetOne(john, &auditedEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if trail := result.(*auditedEntry).Trail; len(trail) != 48 {
+		t.Fatal("Expected two events removed from the trail. Got: ", trail)
+	}
+
+	if _, err = repo.ArrayAppend(john, "name", "Doe"); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for appending to a field that is not an array. Got: ", err)
+	}
+	if _, err = repo.ArrayAppend(john, "id", "abc"); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for appending to the ID. Got: ", err)
+	}
+}
+
+func TestMemoryUpdatePath(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	saved, err := repo.Save(&map[string]interface{}{
+		"name": "John",
+		"settings": map[string]interface{}{
+			"notifications": map[string]interface{}{"email": true, "sms": true},
+		},
+		"addresses": []interface{}{map[string]interface{}{"city": "Skopje"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	john := NewFilter().Match("id", (*saved.(*map[string]interface{}))["id"])
+
+	if updated, err := repo.UpdatePath(john, "settings.notifications.email", false); err != nil || updated != 1 {
+		t.Fatal("Expected the nested field to be updated. Got: ", updated, err)
+	}
+	if _, err = repo.UpdatePath(john, "settings.privacy.public", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.UpdatePath(john, "addresses.0.city", "Ohrid"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.GetOne(john, &map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"notifications": map[string]interface{}{"email": false, "sms": true},
+		"privacy":       map[string]interface{}{"public": false},
+	}
+	if record := *result.(*map[string]interface{}); !reflect.DeepEqual(record["settings"], expected) {
+		t.Fatal("Expected the nested field updated and the missing document created. Got: ", record["settings"])
+	}
+	if addresses := (*result.(*map[string]interface{}))["addresses"]; !reflect.DeepEqual(addresses, []interface{}{map[string]interface{}{"city": "Ohrid"}}) {
+		t.Fatal("Expected the field of the array element to be updated. Got: ", addresses)
+	}
+
+	for _, path := range []string{"name.first", "addresses.1.city", "id", "settings..email"} {
+		if _, err = repo.UpdatePath(john, path, "x"); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected the path %s to be rejected. Got: %v", path, err)
+		}
+	}
+}
+
+func TestMemoryDefaultSort(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":        "users",
+		"defaultSort": []interface{}{map[string]interface{}{"property": "name"}, map[string]interface{}{"property": "age", "sorting": "desc"}},
+	})
+	for _, user := range []map[string]interface{}{
+		{"name": "Mary", "age": 30},
+		{"name": "Ana", "age": 20},
+		{"name": "John", "age": 40},
+		{"name": "Ana", "age": 25},
+	} {
+		if _, err := repo.Save(&user, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(order string) []string {
+		results, err := repo.GetAll(nil, &map[string]interface{}{}, order, "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, user := range *results.(*[]*map[string]interface{}) {
+			names = append(names, fmt.Sprint((*user)["name"], (*user)["age"]))
+		}
+		return names
+	}
+	first, second := names(""), names("")
+	if expected := []string{"Ana25", "Ana20", "John40", "Mary30"}; !reflect.DeepEqual(first, expected) || !reflect.DeepEqual(second, expected) {
+		t.Fatal("Expected the reads without an order in the default sort. Got: ", first, second)
+	}
+	if byAge := names("age"); !reflect.DeepEqual(byAge, []string{"Ana20", "Ana25", "Mary30", "John40"}) {
+		t.Fatal("Expected the explicit order to replace the default sort. Got: ", byAge)
+	}
+
+	backend, err := NewBackendSupport(map[string]*config.DBInfo{"memory": &config.DBInfo{}}).GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.DefineRepository("accounts", RepositoryDefinitionMap{
+		"name":        "accounts",
+		"fields":      []string{"name"},
+		"defaultSort": []SortKey{{Property: "createdAt"}},
+	})
+	if !IsErrInvalidInput(err) {
+		t.Fatal("Expected the default sort on an undeclared field to be rejected. Got: ", err)
+	}
+}
+
+func TestMemoryPagesWithEqualSortValues(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for i := 0; i < 50; i++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: fmt.Sprint("user", i), Age: i % 3}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, sorting := range []string{"asc", "desc"} {
+		seen := map[string]int{}
+		lastAge := -1
+		for offset := 0; ; offset += 7 {
+			results, err := repo.GetAll(nil, &memoryTestEntry{}, "age", sorting, 7, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := *(results.(*[]*memoryTestEntry))
+			if len(entries) == 0 {
+				break
+			}
+			for _, entry := range entries {
+				if lastAge >= 0 && (sorting == "asc" && entry.Age < lastAge || sorting == "desc" && entry.Age > lastAge) {
+					t.Fatalf("Expected the pages sorted by age %s. Got %d after %d", sorting, entry.Age, lastAge)
+				}
+				lastAge = entry.Age
+				seen[entry.Name]++
+			}
+		}
+		if len(seen) != 50 {
+			t.Fatalf("Expected every record in the pages sorted %s. Got %d of 50", sorting, len(seen))
+		}
+		for name, count := range seen {
+			if count != 1 {
+				t.Fatalf("Expected %s once in the pages sorted %s. Got it %d times", name, sorting, count)
+			}
+		}
+	}
+}
+
+func TestMemoryResultsTypeHint(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	if _, err := repo.Save(&memoryTestEntry{Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &memoryTestEntry{}
+	for _, hint := range []interface{}{memoryTestEntry{}, &memoryTestEntry{}, map[string]interface{}{}, &map[string]interface{}{}} {
+		results, err := repo.GetAll(nil, hint, "", "", 0, 0)
+		if err != nil {
+			t.Fatalf("Expected the hint %T to be supported. Got: %v", hint, err)
+		}
+		if err = IterateOverSlice(results, func(i int, item interface{}) error {
+			return MapToInterface(item, entry)
+		}); err != nil || entry.Name != "John" {
+			t.Fatalf("Expected the record decoded with the hint %T. Got: %v, %v", hint, entry, err)
+		}
+	}
+
+	for _, hint := range []interface{}{nil, []memoryTestEntry{}, &[]*memoryTestEntry{}, &entry, "user", map[int]interface{}{}} {
+		if _, err := repo.GetAll(nil, hint, "", "", 0, 0); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected the hint %T to be rejected. Got: %v", hint, err)
+		}
+	}
+}
+
+func TestMemoryCapped(t *testing.T) {
+	// a whole float64, like in a definition decoded from JSON
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "events", "capped": true, "maxDocuments": 3.0})
+	for i := 1; i <= 5; i++ {
+		if _, err := repo.Save(&map[string]interface{}{"seq": i}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := GetAllRaw(repo, nil, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := []interface{}{}
+	for _, record := range records {
+		seqs = append(seqs, record["seq"])
+	}
+	if !reflect.DeepEqual(seqs, []interface{}{float64(3), float64(4), float64(5)}) {
+		t.Fatal("Expected the oldest records evicted and the rest in the insertion order. Got: ", seqs)
+	}
+
+	repo = newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "logs", "capped": true, "maxBytes": 100})
+	for i := 0; i < 10; i++ {
+		if _, err := repo.Save(&map[string]interface{}{"line": "0123456789"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := repo.Count(nil); err != nil || count == 0 || count >= 10 {
+		t.Fatal("Expected the records evicted to fit the size limit. Got: ", count, err)
+	}
+
+	backend, err := NewBackendSupport(map[string]*config.DBInfo{"memory": &config.DBInfo{}}).GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, def := range []RepositoryDefinitionMap{
+		{"name": "events", "capped": true},
+		{"name": "events", "maxDocuments": 10},
+		{"name": "events", "capped": true, "maxDocuments": -1},
+		{"name": "events", "capped": true, "maxDocuments": 2.5},
+		{"name": "events", "capped": true, "maxBytes": "100KB"},
+	} {
+		if _, err = backend.DefineRepository("events", def); !IsErrInvalidInput(err) {
+			t.Fatal("Expected the invalid capped definition to be rejected. Got: ", def, err)
+		}
+	}
+}
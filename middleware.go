@@ -0,0 +1,75 @@
+package backends
+
+import "time"
+
+// RepositoryMiddleware wraps a repository with additional behaviour, like a timeout or a cache,
+// and returns the wrapping repository.
+type RepositoryMiddleware func(Repository) Repository
+
+// Chain wraps the repository with the middlewares. The first middleware is the outermost one:
+//
+// 		Chain(repo, a, b, c)
+//
+// is a(b(c(repo))), so a call on the returned repository goes through a, then b, then c before
+// it reaches repo. Nil middlewares are skipped. With no middlewares, the repository is returned
+// as is.
+func Chain(repo Repository, middlewares ...RepositoryMiddleware) Repository {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] == nil {
+			continue
+		}
+		repo = middlewares[i](repo)
+	}
+	return repo
+}
+
+// TimeoutMiddleware wraps the repository with a TimeoutRepository. See NewTimeoutRepository.
+func TimeoutMiddleware(timeout time.Duration) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewTimeoutRepository(repo, timeout)
+	}
+}
+
+// CacheMiddleware wraps the repository with a CachingRepository. See CachedRepository.
+func CacheMiddleware(ttl time.Duration, keyFn func(Filter) string) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return CachedRepository(repo, ttl, keyFn)
+	}
+}
+
+// CodecMiddleware wraps the repository with a CodecRepository. See NewCodecRepository.
+func CodecMiddleware(codec Codec) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewCodecRepository(repo, codec)
+	}
+}
+
+// DeleteLimitMiddleware wraps the repository with a DeleteLimitRepository. See
+// NewDeleteLimitRepository.
+func DeleteLimitMiddleware(limit int) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewDeleteLimitRepository(repo, limit)
+	}
+}
+
+// RequireIndexMiddleware wraps the repository with a RequireIndexRepository. See
+// NewRequireIndexRepository.
+func RequireIndexMiddleware(allowed [][]string) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewRequireIndexRepository(repo, allowed)
+	}
+}
+
+// RetryMiddleware wraps the repository with a RetryRepository. See NewRetryRepository.
+func RetryMiddleware(policy RetryPolicy) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewRetryRepository(repo, policy)
+	}
+}
+
+// RedactMiddleware wraps the repository with a RedactingRepository. See NewRedactingRepository.
+func RedactMiddleware(fields ...string) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return NewRedactingRepository(repo, fields...)
+	}
+}
@@ -0,0 +1,65 @@
+package backends
+
+import (
+	"testing"
+	"time"
+)
+
+// tracingRepository records the name of the wrapper in the trace on every GetOne call.
+type tracingRepository struct {
+	Repository
+	name  string
+	trace *[]string
+}
+
+func (r *tracingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	*r.trace = append(*r.trace, r.name)
+	return r.Repository.GetOne(filter, result)
+}
+
+func tracingMiddleware(name string, trace *[]string) RepositoryMiddleware {
+	return func(repo Repository) Repository {
+		return &tracingRepository{Repository: repo, name: name, trace: trace}
+	}
+}
+
+func TestChain(t *testing.T) {
+	memoryRepo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	trace := []string{}
+
+	repo := Chain(memoryRepo, tracingMiddleware("outer", &trace), nil, tracingMiddleware("inner", &trace))
+
+	outer, ok := repo.(*tracingRepository)
+	if !ok || outer.name != "outer" {
+		t.Fatal("Expected the first middleware to be the outermost. Got: ", repo)
+	}
+	if inner, ok := outer.Repository.(*tracingRepository); !ok || inner.name != "inner" || inner.Repository != memoryRepo {
+		t.Fatal("Expected the last middleware to wrap the repository. Got: ", outer.Repository)
+	}
+
+	if _, err := repo.GetOne(NewFilter().Match("name", "John"), &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected a not found error. Got: ", err)
+	}
+	if !strArrEq(trace, []string{"outer", "inner"}) {
+		t.Fatal("Expected the call to go through the outer middleware first. Got: ", trace)
+	}
+
+	if Chain(memoryRepo) != memoryRepo {
+		t.Fatal("Expected the repository to be returned as is without middlewares")
+	}
+}
+
+func TestChainWrappers(t *testing.T) {
+	repo := Chain(newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}),
+		CacheMiddleware(time.Minute, nil),
+		TimeoutMiddleware(time.Second),
+	)
+
+	cached, ok := repo.(*CachingRepository)
+	if !ok {
+		t.Fatalf("Expected the cache to be the outermost wrapper. Got: %T", repo)
+	}
+	if _, ok = cached.Repository.(*TimeoutRepository); !ok {
+		t.Fatalf("Expected the cache to wrap the timeout. Got: %T", cached.Repository)
+	}
+}
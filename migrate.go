@@ -0,0 +1,137 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SchemaVersionsRepository is the name of the metadata repository in which Repository.Migrate
+// records the applied schema versions of the repositories of a backend, one record per
+// repository.
+const SchemaVersionsRepository = "backends_schema_versions"
+
+// MigrationFunc migrates the records of the repository to a schema version, like backfilling a
+// new field with UpdateFieldsReturning. The repository is the one Migrate was called on, bound to
+// the context of the migration.
+type MigrationFunc func(ctx context.Context, repo Repository) error
+
+// MigrationError is returned by Repository.Migrate when a migration fails. The versions before
+// the failed one remain applied and recorded. Use errors.As to get the version:
+// 		var migrationErr backends.MigrationError
+// 		if errors.As(err, &migrationErr) {
+// 			fmt.Println(migrationErr.Version, migrationErr.Cause)
+// 		}
+type MigrationError struct {
+	// Repository is the name of the migrated repository.
+	Repository string
+	// Version is the version of the failed migration.
+	Version int
+	// Cause is the error returned by the migration.
+	Cause error
+}
+
+// Error returns the error message.
+func (e MigrationError) Error() string {
+	return fmt.Sprintf("migration of %s to version %d failed: %s", e.Repository, e.Version, e.Cause.Error())
+}
+
+// Unwrap returns the error returned by the migration.
+func (e MigrationError) Unwrap() error {
+	return e.Cause
+}
+
+// schemaVersionRecord is the record of the applied schema version of a repository.
+type schemaVersionRecord struct {
+	ID        string    `json:"id"`
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// schemaVersionsDefinition is the definition of the metadata repository of the schema versions,
+// keyed by the name of the repository.
+func schemaVersionsDefinition() RepositoryDefinitionMap {
+	return RepositoryDefinitionMap{
+		"name":          SchemaVersionsRepository,
+		"customId":      true,
+		"hashKey":       "id",
+		"readCapacity":  int64(1),
+		"writeCapacity": int64(1),
+	}
+}
+
+// AppliedSchemaVersion returns the schema version of the repository recorded by the last
+// migration applied with Repository.Migrate, or zero if none was applied. A service can check it
+// at startup, during a rolling deploy, against the version its code expects.
+func AppliedSchemaVersion(backend Backend, name string) (int, error) {
+	versions, err := backend.DefineRepository(SchemaVersionsRepository, schemaVersionsDefinition())
+	if err != nil {
+		return 0, err
+	}
+	record := &schemaVersionRecord{}
+	if _, err = versions.GetOne(NewFilter().Match("id", name), record); err != nil {
+		if IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return record.Version, nil
+}
+
+// runMigrations applies the migrations of the repository of the backend, see Repository.Migrate.
+func runMigrations(ctx context.Context, repo Repository, backend Backend, name string, from, to int, migrations map[int]MigrationFunc) error {
+	if backend == nil {
+		return ErrBackendError("the repository is not defined on a backend")
+	}
+	if from < 0 || from > to {
+		return ErrInvalidInput(fmt.Sprintf("cannot migrate from version %d to version %d", from, to))
+	}
+	for version := from + 1; version <= to; version++ {
+		if migrations[version] == nil {
+			return ErrInvalidInput(fmt.Sprintf("the migration to version %d is missing", version))
+		}
+	}
+
+	applied, err := AppliedSchemaVersion(backend, name)
+	if err != nil {
+		return err
+	}
+	if applied < from {
+		return ErrInvalidInput(fmt.Sprintf("the schema of %s is at version %d, the migrations start at version %d", name, applied, from))
+	}
+
+	versions, err := backend.DefineRepository(SchemaVersionsRepository, schemaVersionsDefinition())
+	if err != nil {
+		return err
+	}
+	for version := applied + 1; version <= to; version++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = migrations[version](ctx, repo.WithContext(ctx)); err != nil {
+			return MigrationError{Repository: name, Version: version, Cause: err}
+		}
+		record := &schemaVersionRecord{ID: name, Version: version, UpdatedAt: time.Now().UTC()}
+		if _, _, err = versions.SaveUpsert(record, NewFilter().Match("id", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindMigrations returns the migrations run with the repository instead of the one they are
+// called with. The repository wrappers bind the migrations to themselves, so the migrations run
+// through all the wrappers of the repository Migrate was called on.
+func bindMigrations(migrations map[int]MigrationFunc, repo Repository) map[int]MigrationFunc {
+	bound := make(map[int]MigrationFunc, len(migrations))
+	for version, migration := range migrations {
+		if migration == nil {
+			continue
+		}
+		migration := migration
+		bound[version] = func(ctx context.Context, _ Repository) error {
+			return migration(ctx, repo.WithContext(ctx))
+		}
+	}
+	return bound
+}
@@ -0,0 +1,92 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestMigrate(t *testing.T) {
+	backend, err := NewBackendSupport(map[string]*config.DBInfo{"memory": &config.DBInfo{}}).GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users", "schemaVersion": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"John", "Jane"} {
+		if _, err = repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	applied := []int{}
+	migrations := map[int]MigrationFunc{
+		1: func(ctx context.Context, repo Repository) error {
+			applied = append(applied, 1)
+			_, err := repo.UpdateFieldsReturning(NewFilter().Match("age", 0), map[string]interface{}{"age": 18})
+			return err
+		},
+		2: func(ctx context.Context, repo Repository) error {
+			applied = append(applied, 2)
+			_, err := repo.UpdateFieldsReturning(NewFilter().Match("email", ""), map[string]interface{}{"email": "unknown"})
+			return err
+		},
+	}
+
+	if err = repo.Migrate(context.Background(), 0, 2, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0] != 1 || applied[1] != 2 {
+		t.Fatal("Expected the migrations applied in the order of the versions. Got: ", applied)
+	}
+	if version, err := AppliedSchemaVersion(backend, "users"); err != nil || version != 2 {
+		t.Fatal("Expected the recorded version to be bumped to 2. Got: ", version, err)
+	}
+	if count, _ := repo.Count(NewFilter().Match("age", 18).Match("email", "unknown")); count != 2 {
+		t.Fatal("Expected the records backfilled by the migrations. Got: ", count)
+	}
+
+	if err = repo.Migrate(context.Background(), 0, 2, migrations); err != nil || len(applied) != 2 {
+		t.Fatal("Expected the applied migrations to be skipped. Got: ", applied, err)
+	}
+
+	failure := errors.New("backfill failed")
+	migrations[3] = func(ctx context.Context, repo Repository) error {
+		return failure
+	}
+	err = repo.Migrate(context.Background(), 2, 3, migrations)
+	var migrationErr MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.Version != 3 || !errors.Is(err, failure) {
+		t.Fatal("Expected the failed migration to be reported. Got: ", err)
+	}
+	if version, _ := AppliedSchemaVersion(backend, "users"); version != 2 {
+		t.Fatal("Expected the recorded version to stay at 2 after the failed migration. Got: ", version)
+	}
+
+	if err = repo.Migrate(context.Background(), 2, 4, migrations); !IsErrInvalidInput(err) {
+		t.Fatal("Expected a missing migration to be rejected. Got: ", err)
+	}
+}
+
+func TestSchemaVersionDefinition(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	for _, schemaVersion := range []interface{}{-1, 1.5, "v2"} {
+		if _, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users", "schemaVersion": schemaVersion}); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected an error for the schema version %v. Got: %v", schemaVersion, err)
+		}
+	}
+
+	// a whole float64, like in a definition decoded from JSON
+	def := RepositoryDefinitionMap{"name": "users", "schemaVersion": 2.0}
+	if _, err := backend.DefineRepository("users", def); err != nil {
+		t.Fatal(err)
+	}
+	if version := def.GetSchemaVersion(); version != 2 {
+		t.Fatal("Expected the schema version 2. Got: ", version)
+	}
+}
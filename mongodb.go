@@ -3,8 +3,8 @@ package backends
 import (
 	"context"
 	"fmt"
-	"log"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 
@@ -20,41 +20,56 @@ var MONGO_CTX_KEY = "MONGO_SESSION"
 // MongoCollection wraps a mgo.Collection to embed methods in models.
 type MongoCollection struct {
 	*mgo.Collection
-	repoDef RepositoryDefinition
+	repoDef   RepositoryDefinition
+	ctx       context.Context
+	backend   Backend
+	hint      []string
+	batchSize int
+	stats     *queryStatsRecorder
+	logger    Logger
 }
 
 // MongoDBRepoBuilder builds new mongo collection.
 // If it does not exist builder will create it
 func MongoDBRepoBuilder(repoDef RepositoryDefinition, backend Backend) (Repository, error) {
 
-	sessionObj := backend.GetFromContext(MONGO_CTX_KEY)
-	if sessionObj == nil {
-		return nil, ErrBackendError("mongo session not configured")
+	session, databaseName, collectionName, err := mongoRepoParams(repoDef, backend)
+	if err != nil {
+		return nil, err
 	}
 
-	session, ok := sessionObj.(*mgo.Session)
-	if !ok {
-		return nil, ErrBackendError("unknown session type")
+	if IsReadReplica(backend) {
+		// the collection and the indexes are created on the primary
+		return &MongoCollection{
+			Collection: session.DB(databaseName).C(collectionName),
+			repoDef:    repoDef,
+			backend:    backend,
+			stats:      newQueryStatsRecorder(),
+			logger:     backendLogger{backend},
+		}, nil
 	}
 
-	databaseName := backend.GetConfig().DatabaseName
-	if databaseName == "" {
-		return nil, ErrBackendError("database name is missing and required")
+	if repoDef.IsCapped() {
+		if err = ensureCappedCollection(session.DB(databaseName), collectionName, repoDef); err != nil {
+			return nil, err
+		}
 	}
 
-	collectionName := repoDef.GetName()
-	if collectionName == "" {
-		return nil, ErrBackendError("collection name is missing and required")
+	// the indexes are managed elsewhere unless they are created if missing
+	indexes := repoDef.GetIndexes()
+	if repoDef.GetIndexCreationMode() != IndexCreateIfMissing {
+		indexes = nil
 	}
 
-	mongoColl, err := PrepareDB(
+	mongoColl, err := prepareDB(
 		session,
 		databaseName,
 		collectionName,
-		repoDef.GetIndexes(),
+		indexes,
 		repoDef.EnableTTL(),
 		repoDef.GetTTL(),
 		repoDef.GetTTLAttribute(),
+		BackendLogger(backend),
 	)
 
 	if err != nil {
@@ -64,23 +79,97 @@ func MongoDBRepoBuilder(repoDef RepositoryDefinition, backend Backend) (Reposito
 	return &MongoCollection{
 		Collection: mongoColl,
 		repoDef:    repoDef,
+		backend:    backend,
+		stats:      newQueryStatsRecorder(),
+		logger:     backendLogger{backend},
 	}, nil
 }
 
+// MongoDBRepoPlanner plans the creation of the mongo collection and its indexes.
+// Only the collection and indexes that do not already exist are planned.
+func MongoDBRepoPlanner(repoDef RepositoryDefinition, backend Backend) (*RepositoryPlan, error) {
+
+	session, databaseName, collectionName, err := mongoRepoParams(repoDef, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RepositoryPlan{
+		Repository: collectionName,
+	}
+
+	db := session.DB(databaseName)
+	collectionNames, err := db.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	existingIndexes := map[string]bool{}
+	if containsString(collectionNames, collectionName) {
+		indexes, err := db.C(collectionName).Indexes()
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			existingIndexes[index.Name] = true
+		}
+	} else {
+		plan.Add(ActionCreateRepository, collectionName)
+	}
+
+	for _, index := range repoDef.GetIndexes() {
+		if !existingIndexes[mongoIndexName(index.GetFields())] {
+			plan.Add(ActionCreateIndex, index.GetName(), index.GetFields()...)
+		}
+	}
+
+	if repoDef.EnableTTL() && !existingIndexes[mongoIndexName([]string{repoDef.GetTTLAttribute()})] {
+		plan.Add(ActionEnableTTL, repoDef.GetTTLAttribute(), repoDef.GetTTLAttribute())
+	}
+
+	return plan, nil
+}
+
+// mongoRepoParams returns the session, the database name and the collection name for the repository.
+func mongoRepoParams(repoDef RepositoryDefinition, backend Backend) (*mgo.Session, string, string, error) {
+
+	sessionObj := backend.GetFromContext(MONGO_CTX_KEY)
+	if sessionObj == nil {
+		return nil, "", "", ErrBackendError("mongo session not configured")
+	}
+
+	session, ok := sessionObj.(*mgo.Session)
+	if !ok {
+		return nil, "", "", ErrBackendError("unknown session type")
+	}
+
+	databaseName := backend.GetConfig().DatabaseName
+	if databaseName == "" {
+		return nil, "", "", ErrBackendError("database name is missing and required")
+	}
+
+	if repoDef.GetName() == "" {
+		return nil, "", "", ErrBackendError("collection name is missing and required")
+	}
+
+	return session, databaseName, backend.PhysicalName(repoDef.GetName()), nil
+}
+
 // MongoDBBackendBuilder returns RepositoriesBackend
 func MongoDBBackendBuilder(conf *config.DBInfo, manager BackendManager) (Backend, error) {
 
 	session, err := NewSession(conf.Host, conf.Username, conf.Password, conf.DatabaseName)
 	if err != nil {
-		return nil, err
+		return nil, maskError(err, conf)
 	}
 
-	ctx := context.WithValue(context.Background(), MONGO_CTX_KEY, session)
 	cleanup := func() {
 		session.Close()
 	}
 
-	return NewRepositoriesBackend(ctx, conf, MongoDBRepoBuilder, cleanup), nil
+	backend := NewRepositoriesBackend(context.Background(), conf, MongoDBRepoBuilder, cleanup, WithRepoPlanner(MongoDBRepoPlanner), WithCapabilities(mongoCapabilities))
+	backend.SetInContext(MONGO_CTX_KEY, session)
+	return backend, nil
 }
 
 // NewSession returns a new Mongo Session.
@@ -105,32 +194,18 @@ func NewSession(Host string, Username string, Password string, Database string)
 
 // PrepareDB ensure presence of persistent and immutable data in the DB. It creates indexes
 func PrepareDB(session *mgo.Session, db string, dbCollection string, indexes []Index, enableTTL bool, TTL int, TTLField string) (*mgo.Collection, error) {
+	return prepareDB(session, db, dbCollection, indexes, enableTTL, TTL, TTLField, nopLogger{})
+}
+
+// prepareDB is PrepareDB that logs the index failures to the logger.
+func prepareDB(session *mgo.Session, db string, dbCollection string, indexes []Index, enableTTL bool, TTL int, TTLField string, logger Logger) (*mgo.Collection, error) {
 
 	collection := session.DB(db).C(dbCollection)
 
 	// Define indexes
 	for _, elem := range indexes {
-		i := elem.GetFields()
-		index := mgo.Index{
-			Key:        i,
-			Unique:     elem.Unique(),
-			DropDups:   true,
-			Background: true,
-			Sparse:     true,
-		}
-
-		// Create indexes
-		if err := collection.EnsureIndex(index); err != nil {
-			if qe, ok := err.(*mgo.QueryError); ok {
-				if qe.Code == 85 {
-					// IndexOptionsConflict - see here https://github.com/mongodb/mongo/blob/master/src/mongo/base/error_codes.err
-					// It means that there is already defined index and we try to redefine it, which is (mostly) fine.
-					log.Println("WARN: The index already exists and will not be updated. MongoDB error: ", err.Error())
-				}
-			} else {
-				log.Println("ERROR: while creating index. of type: ", reflect.TypeOf(err), " and values: ", fmt.Sprintf("%v", err))
-				return nil, err
-			}
+		if err := ensureMongoIndex(collection, elem, logger); err != nil {
+			return nil, err
 		}
 	}
 
@@ -171,7 +246,13 @@ func (c *MongoCollection) GetOne(filter Filter, result interface{}) (interface{}
 		}
 	}
 
-	err := c.Find(filter).One(&record)
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	c.logger.Debugf("mongodb %s: find one %v", c.Name, mongoFilter)
+	err = c.find(mongoFilter).One(&record)
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return nil, err
@@ -183,6 +264,7 @@ func (c *MongoCollection) GetOne(filter Filter, result interface{}) (interface{}
 	} else {
 		record["id"] = record["_id"].(bson.ObjectId).Hex()
 	}
+	mongoDecimals(record)
 
 	err = MapToInterface(&record, &result)
 	if err != nil {
@@ -194,10 +276,20 @@ func (c *MongoCollection) GetOne(filter Filter, result interface{}) (interface{}
 
 // GetAll fetches all matched records for given filter
 func (c *MongoCollection) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
-	resultsTypeHint = AsPtr(resultsTypeHint)
+	start := time.Now()
+	limit, err := pageBounds(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSorting(sorting); err != nil {
+		return nil, err
+	}
+
+	resultsTypeHint, err = resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
 	results := NewSliceOfType(resultsTypeHint)
-	fmt.Println("****************************************************")
-	fmt.Printf("filter: %v", filter)
 
 	// Create a pointer to a slice value and set it to the slice
 	slicePointer := reflect.New(results.Type())
@@ -214,15 +306,27 @@ func (c *MongoCollection) GetAll(filter Filter, resultsTypeHint interface{}, ord
 		return nil, ErrInvalidInput(err)
 	}
 
-	query := c.Find(mongoFilter)
+	if order == "id" && !c.repoDef.IsCustomID() {
+		order = "_id"
+	}
+
+	c.logger.Debugf("mongodb %s: find %v, sort %q %q, skip %d, limit %d", c.Name, mongoFilter, order, sorting, offset, limit)
+	query := c.find(mongoFilter)
+	sortFields := []string{}
 	if order != "" {
-		if sorting == "desc" {
+		if isDescending(sorting) {
 			order = "-" + order
 		}
-		fmt.Printf("ORDERBEFORESORTING %v\n", order)
-		query = query.Sort(order)
+		sortFields = append(sortFields, order)
+	} else {
+		sortFields = c.defaultSortFields()
+	}
+	if limit != 0 || offset != 0 {
+		sortFields = c.pageSortFields(sortFields)
+	}
+	if len(sortFields) > 0 {
+		query = query.Sort(sortFields...)
 	}
-	fmt.Printf("ORDERAFTERSORTING %v\n", order)
 	if offset != 0 {
 		query = query.Skip(offset)
 	}
@@ -238,55 +342,31 @@ func (c *MongoCollection) GetAll(filter Filter, resultsTypeHint interface{}, ord
 		return nil, err
 	}
 
-	fmt.Printf("slicePointer.Interface() %v", slicePointer.Interface())
-
 	// results is always a Slice
 	err = IterateOverSlice(slicePointer.Interface(), func(i int, item interface{}) error {
-		fmt.Println("IterateOverSlice start ******************************")
 		if item == nil {
 			return nil // ignore
 		}
 
-		fmt.Printf("AJJJJTEEEEEM %v", item)
-
-		fmt.Println("IterateOverSlice after is nil ******************************")
-
 		itemValue := reflect.ValueOf(item)
 		itemType := reflect.TypeOf(item)
-		fmt.Printf("itemValue: %v", itemValue)
-		fmt.Printf("itemType: %v", itemType)
 		if itemType.Kind() == reflect.Ptr {
-			fmt.Println("item is pointer *******************")
 			// item is pointer to something
 			itemType = itemType.Elem()
 			itemValue = reflect.Indirect(itemValue)
-			fmt.Printf("itemValue inside if: %v", itemValue)
-			fmt.Printf("itemType inside if: %v", itemType)
-		}
-
-		if itemType.Kind() == reflect.Struct {
-			fmt.Println("inside struct")
-			fmt.Printf("ITEM IS STRUCT\n %v", itemValue.FieldByName("_id"))
-			idValue := itemValue.FieldByName("_id")
-			fmt.Printf("idValue in struct is: %v", idValue)
 		}
 
 		if itemType.Kind() == reflect.Map {
 			// we have a map[string]<some-type>
 			idValue := itemValue.MapIndex(reflect.ValueOf("_id"))
-			fmt.Printf("idValue is %v", idValue)
 			if idValue.IsValid() {
-				fmt.Println("id is valid")
 				// ok,there is such value
 				if bsonID, ok := idValue.Interface().(bson.ObjectId); ok {
 					idStr := bsonID.Hex()
-					fmt.Printf("id is bson %v", idStr)
 					if c.repoDef.IsCustomID() {
-						fmt.Println("id is custom id")
 						// we have a custom handling on property "id", so we'll map _id => HEX(_id)
 						itemValue.SetMapIndex(reflect.ValueOf("_id"), reflect.ValueOf(idStr))
 					} else {
-						fmt.Printf("id default %v", reflect.ValueOf(idStr))
 						// no custom mapping set, so the default behaviour is to map id => HEX(_id)
 						itemValue.SetMapIndex(reflect.ValueOf("id"), reflect.ValueOf(idStr))
 						itemValue.SetMapIndex(reflect.ValueOf("_id"), reflect.Value{})
@@ -294,11 +374,15 @@ func (c *MongoCollection) GetAll(filter Filter, resultsTypeHint interface{}, ord
 
 				}
 			}
+			if document, ok := itemValue.Interface().(map[string]interface{}); ok {
+				mongoDecimals(document)
+			}
 		}
 
 		return nil
 	})
 
+	c.stats.record(QueryStats{ItemsReturned: resultsCount(slicePointer.Interface())}, start)
 	return slicePointer.Interface(), nil
 }
 
@@ -308,11 +392,10 @@ func (c *MongoCollection) Save(object interface{}, filter Filter) (interface{},
 	var result interface{}
 
 	payload, err := InterfaceToMap(object)
-	log.Println("Save:Payload")
-	log.Printf("%+v\n", payload)
 	if err != nil {
 		return nil, err
 	}
+	c.logger.Debugf("mongodb %s: save %+v, filter %v", c.Name, *payload, filter)
 
 	if filter == nil {
 
@@ -324,10 +407,7 @@ func (c *MongoCollection) Save(object interface{}, filter Filter) (interface{},
 
 		err = c.Insert(payload)
 		if err != nil {
-			if mgo.IsDup(err) {
-				return nil, ErrAlreadyExists("record already exists!")
-			}
-			return nil, err
+			return nil, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
 		}
 
 		if !c.repoDef.IsCustomID() {
@@ -337,8 +417,6 @@ func (c *MongoCollection) Save(object interface{}, filter Filter) (interface{},
 		if err != nil {
 			return nil, err
 		}
-		log.Println("Save:Object")
-		log.Printf("%+v\n", object)
 		return object, nil
 	}
 
@@ -353,16 +431,18 @@ func (c *MongoCollection) Save(object interface{}, filter Filter) (interface{},
 		delete(*payload, "_id")
 	}
 
-	err = c.Update(filter, bson.M{"$set": payload})
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	err = c.Update(mongoFilter, bson.M{"$set": payload})
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return nil, ErrNotFound(err)
 		}
-		if mgo.IsDup(err) {
-			return nil, ErrAlreadyExists("record already exists!")
-		}
 
-		return nil, err
+		return nil, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
 	}
 
 	result, err = c.GetOne(filter, object)
@@ -382,7 +462,12 @@ func (c *MongoCollection) DeleteOne(filter Filter) error {
 		}
 	}
 
-	err := c.Remove(filter)
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return ErrInvalidInput(err)
+	}
+
+	err = c.Remove(mongoFilter)
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return ErrNotFound(err)
@@ -393,6 +478,51 @@ func (c *MongoCollection) DeleteOne(filter Filter) error {
 	return nil
 }
 
+// DeleteIf deletes the document matching the filter only if the document also matches the
+// condition. The filter and the condition are merged in a single findAndModify with remove, so
+// the check and the delete are atomic.
+func (c *MongoCollection) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	if filter == nil {
+		return false, ErrInvalidInput("filter is required for conditional delete")
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return false, ErrInvalidInput(err)
+		}
+		if err := stringToObjectID(condition); err != nil {
+			return false, ErrInvalidInput(err)
+		}
+	}
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return false, ErrInvalidInput(err)
+	}
+	mongoCondition, err := toMongoFilter(condition)
+	if err != nil {
+		return false, ErrInvalidInput(err)
+	}
+
+	var removed map[string]interface{}
+	_, err = c.find(bson.M{"$and": []interface{}{mongoFilter, mongoCondition}}).Apply(mgo.Change{Remove: true}, &removed)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			return false, err
+		}
+		// either the document does not exist or the condition does not hold
+		count, err := c.find(mongoFilter).Limit(1).Count()
+		if err != nil {
+			return false, err
+		}
+		if count == 0 {
+			return false, ErrNotFound("record not found")
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
 // DeleteAll deletes all matched records for given filter
 func (c *MongoCollection) DeleteAll(filter Filter) error {
 
@@ -402,7 +532,12 @@ func (c *MongoCollection) DeleteAll(filter Filter) error {
 		}
 	}
 
-	_, err := c.RemoveAll(filter)
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return ErrInvalidInput(err)
+	}
+
+	_, err = c.RemoveAll(mongoFilter)
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return ErrNotFound(err)
@@ -413,22 +548,1347 @@ func (c *MongoCollection) DeleteAll(filter Filter) error {
 	return nil
 }
 
-func toMongoFilter(filter Filter) (map[string]interface{}, error) {
-	mgf := map[string]interface{}{}
-	for key, value := range filter {
-		if specs, ok := value.(map[string]string); ok {
-			if pattern, ok := specs["$pattern"]; ok {
-				mongoPattern := toMongoPattern(pattern)
-				mgf[key] = bson.M{
-					"$regex": mongoPattern,
+// matchingIDs reads the IDs of the documents matching the filter, for the bulk writes that return
+// the IDs. It returns the query of the matched documents, by their _id and the filter, so the
+// documents changed to no longer match the filter since the read are not written.
+func (c *MongoCollection) matchingIDs(filter Filter) (bson.M, []interface{}, error) {
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, nil, ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, nil, ErrInvalidInput(err)
+	}
+
+	var found []map[string]interface{}
+	if err = c.find(mongoFilter).Select(bson.M{"_id": 1, "id": 1}).All(&found); err != nil {
+		return nil, nil, err
+	}
+	objectIDs := []interface{}{}
+	ids := []interface{}{}
+	for _, record := range found {
+		objectIDs = append(objectIDs, record["_id"])
+		if c.repoDef.IsCustomID() {
+			ids = append(ids, record["id"])
+			continue
+		}
+		ids = append(ids, record["_id"].(bson.ObjectId).Hex())
+	}
+	return bson.M{"$and": []interface{}{mongoFilter, bson.M{"_id": bson.M{"$in": objectIDs}}}}, ids, nil
+}
+
+// DeleteAllReturning deletes all matched documents and returns their IDs. The IDs are read
+// first, then the documents are deleted with a single remove.
+func (c *MongoCollection) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	query, ids, err := c.matchingIDs(filter)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+	if _, err = c.RemoveAll(query); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// UpdateFieldsReturning sets the fields on all matched documents and returns their IDs. The IDs
+// are read first, then the documents are updated with a single multi-document update.
+func (c *MongoCollection) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	if err := checkUpdateFields(fields, "_id", c.repoDef.GetIDField()); err != nil {
+		return nil, err
+	}
+	query, ids, err := c.matchingIDs(filter)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+	if _, err = c.UpdateAll(query, bson.M{"$set": fields}); err != nil {
+		return nil, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
+	}
+	return ids, nil
+}
+
+// ArrayAppend appends the values to the array of the field of all the matched documents with a
+// single multi-document update with $push. A document whose field is not an array fails the
+// update, and the documents updated before it stay updated.
+func (c *MongoCollection) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	return c.updateArrays(filter, field, values, bson.M{"$push": bson.M{field: bson.M{"$each": values}}})
+}
+
+// ArrayRemove removes the values from the array of the field of all the matched documents with a
+// single multi-document update with $pull. A document whose field is not an array fails the
+// update, and the documents updated before it stay updated.
+func (c *MongoCollection) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	return c.updateArrays(filter, field, values, bson.M{"$pull": bson.M{field: bson.M{"$in": values}}})
+}
+
+// UpdatePath sets the value at the path of all the matched documents with a single
+// multi-document update with $set of the dotted path, which creates the missing documents of the
+// path. A document in which the path crosses a value that is not a document or an array fails the
+// update, and the documents updated before it stay updated.
+func (c *MongoCollection) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	if _, err := splitPath(path, "_id", c.repoDef.GetIDField()); err != nil {
+		return 0, err
+	}
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+
+	info, err := c.UpdateAll(mongoFilter, bson.M{"$set": bson.M{path: value}})
+	if err != nil {
+		if isMongoPathNotViableErr(err) {
+			return 0, ErrInvalidInput(fmt.Sprintf("cannot set the path %s: %s", path, err.Error()))
+		}
+		return 0, err
+	}
+	return int64(info.Updated), nil
+}
+
+// isMongoPathNotViableErr checks if the update failed because the path crosses a value that is not
+// a document or an array (PathNotViable on MongoDB 3.6 and later, 16837 on the earlier versions).
+func isMongoPathNotViableErr(err error) bool {
+	if lastErr, ok := err.(*mgo.LastError); ok {
+		return lastErr.Code == 28 || lastErr.Code == 16837
+	}
+	return false
+}
+
+// updateArrays applies the array update to the matched documents and returns the number of the
+// modified documents.
+func (c *MongoCollection) updateArrays(filter Filter, field string, values []interface{}, update bson.M) (int64, error) {
+	if err := checkArrayUpdate(field, values, "_id", c.repoDef.GetIDField()); err != nil {
+		return 0, err
+	}
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+
+	info, err := c.UpdateAll(mongoFilter, update)
+	if err != nil {
+		if isMongoNotArrayErr(err) {
+			return 0, ErrInvalidInput(fmt.Sprintf("the field %s is not an array: %s", field, err.Error()))
+		}
+		return 0, err
+	}
+	return int64(info.Updated), nil
+}
+
+// isMongoNotArrayErr checks if the update failed because $push or $pull was applied to a value
+// that is not an array (BadValue on MongoDB 3.6 and later, 16837 on the earlier versions).
+func isMongoNotArrayErr(err error) bool {
+	if lastErr, ok := err.(*mgo.LastError); ok {
+		return lastErr.Code == 2 || lastErr.Code == 16837
+	}
+	return false
+}
+
+// UsesIndex returns the index of the definition matched exactly by the filter, or the "_id_"
+// index for a filter on the ID.
+func (c *MongoCollection) UsesIndex(filter Filter) (Index, bool) {
+	indexes := []Index{NewIndex("_id_", true, "_id")}
+	if !c.repoDef.IsCustomID() {
+		indexes = append(indexes, NewIndex("_id_", true, "id"))
+	}
+	return usesIndex(append(indexes, c.repoDef.GetIndexes()...), filter)
+}
+
+// Migrate applies the migrations of the collection and records the applied version in the
+// SchemaVersionsRepository of the backend. See Repository.Migrate.
+func (c *MongoCollection) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return runMigrations(ctx, c, c.backend, c.repoDef.GetName(), from, to, migrations)
+}
+
+// SaveIdempotent saves a new document unless the idempotency key was processed, recording the keys
+// in the IdempotencyKeysRepository of the backend. See Repository.SaveIdempotent.
+func (c *MongoCollection) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return saveIdempotent(c, c.backend, c.repoDef.GetName(), c.repoDef.GetIDField(), object, idempotencyKey, ttl)
+}
+
+// Watch subscribes to the changes of the collection with a MongoDB change stream, on a copy of
+// the session that is closed with the stream. The updates are sent with the current document,
+// looked up after the update. The change streams need a replica set or a sharded cluster, so
+// Watch fails with ErrBackendError on a standalone server.
+func (c *MongoCollection) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	session := c.Database.Session.Copy()
+	pipeline := []bson.M{{"$changeStream": bson.M{"fullDocument": "updateLookup"}}}
+	iter := c.Collection.With(session).Pipe(pipeline).Iter()
+	if err := iter.Err(); err != nil {
+		session.Close()
+		return nil, ErrBackendError(err)
+	}
+	return watchStream(ctx, &mongoChangeStream{collection: c, session: session, iter: iter}, filter)
+}
+
+// QueryRange fetches the documents matching the hash key value and the range condition, with a
+// filter on the two fields.
+func (c *MongoCollection) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	return queryRangeWithFilter(c, c.repoDef, hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+}
+
+// Bulk returns a BulkOp that sends each run of consecutive operations of the same kind as one
+// ordered bulk write, so the counts of the result are exact per kind of the operation.
+func (c *MongoCollection) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		result := BulkResult{}
+		for start := 0; start < len(operations); {
+			end := start + 1
+			for end < len(operations) && operations[end].Kind == operations[start].Kind {
+				end++
+			}
+			written, err := c.runBulk(operations[start:end], start)
+			if err != nil {
+				return result, err
+			}
+			result.add(written)
+			start = end
+		}
+		return result, nil
+	})
+}
+
+// runBulk sends the operations of the same kind as one bulk write. The offset is the index of
+// the first operation in the BulkOp, for the BulkError.
+func (c *MongoCollection) runBulk(operations []BulkOperation, offset int) (BulkResult, error) {
+	bulk := c.Collection.Bulk()
+	inserted := []*map[string]interface{}{}
+	for i, operation := range operations {
+		var err error
+		switch operation.Kind {
+		case BulkInsert:
+			var payload *map[string]interface{}
+			if payload, err = InterfaceToMap(operation.Object); err == nil {
+				id := bson.NewObjectId()
+				(*payload)["_id"] = id
+				if !c.repoDef.IsCustomID() {
+					delete(*payload, "id")
 				}
-				continue
+				bulk.Insert(payload)
+				inserted = append(inserted, payload)
+			}
+		case BulkUpdate:
+			var selector bson.M
+			if err = checkUpdateFields(operation.Fields, "_id", c.repoDef.GetIDField()); err == nil {
+				if selector, err = c.bulkSelector(operation.Filter); err == nil {
+					bulk.UpdateAll(selector, bson.M{"$set": operation.Fields})
+				}
+			}
+		case BulkDelete:
+			var selector bson.M
+			if selector, err = c.bulkSelector(operation.Filter); err == nil {
+				bulk.RemoveAll(selector)
 			}
-			return nil, fmt.Errorf("unknown filter specification - supported type is $pattern")
+		default:
+			err = ErrInvalidInput(fmt.Sprintf("unknown bulk operation %q", operation.Kind))
+		}
+		if err != nil {
+			return BulkResult{}, BulkError{Operation: offset + i, Cause: err}
 		}
-		mgf[key] = value // copy over the key=>value pairs to do exact matching
 	}
-	return mgf, nil
+
+	c.logger.Debugf("mongodb %s: bulk %d %s operations", c.Name, len(operations), operations[0].Kind)
+	written, err := bulk.Run()
+	if err != nil {
+		index := offset
+		if bulkErr, ok := err.(*mgo.BulkError); ok {
+			for _, errCase := range bulkErr.Cases() {
+				if errCase.Index >= 0 {
+					index = offset + errCase.Index
+					err = errCase.Err
+					break
+				}
+			}
+		}
+		return BulkResult{}, BulkError{Operation: index, Cause: WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)}
+	}
+
+	switch operations[0].Kind {
+	case BulkInsert:
+		for i, payload := range inserted {
+			if !c.repoDef.IsCustomID() {
+				(*payload)["id"] = (*payload)["_id"].(bson.ObjectId).Hex()
+			}
+			object := operations[i].Object
+			if err := MapToInterface(payload, &object); err != nil {
+				return BulkResult{}, BulkError{Operation: offset + i, Cause: err}
+			}
+		}
+		return BulkResult{Inserted: len(inserted)}, nil
+	case BulkUpdate:
+		return BulkResult{Updated: written.Matched}, nil
+	}
+	return BulkResult{Deleted: written.Matched}, nil
+}
+
+// bulkSelector returns the MongoDB selector of the filter of a bulk update or delete.
+func (c *MongoCollection) bulkSelector(filter Filter) (bson.M, error) {
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+	return mongoFilter, nil
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+// A consistent read is done on a copy of the session in Strong mode, which reads from the primary,
+// and a read with a read preference on a copy of the session with the read preference.
+func (c *MongoCollection) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
+	}
+	if opts.StrictDecode {
+		return getOneStrict(c, filter, result, opts)
+	}
+	if !opts.Consistent && opts.ReadPreference == nil {
+		return c.GetOne(filter, result)
+	}
+
+	collection, closeSession := c.readSession(opts)
+	defer closeSession()
+
+	return collection.GetOne(filter, result)
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+// With a collation set, the matched documents are sorted on the client. See Collation.
+func (c *MongoCollection) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if err := checkBatchSize(opts.BatchSize); err != nil {
+		return nil, err
+	}
+	if err := checkReadPreference(opts.ReadPreference); err != nil {
+		return nil, err
+	}
+	if opts.SkipDecodeErrors {
+		return getAllSkippingDecodeErrors(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+	if opts.StrictDecode {
+		return getAllStrict(c, filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	}
+
+	collection := c
+	if opts.Consistent || opts.ReadPreference != nil {
+		readCollection, closeSession := c.readSession(opts)
+		defer closeSession()
+		collection = readCollection
+	}
+	if opts.BatchSize > 0 {
+		collection = collection.withSession(collection.Database.Session)
+		collection.batchSize = opts.BatchSize
+	}
+
+	if opts.Collation != nil {
+		return collection.getAllCollated(filter, resultsTypeHint, order, sorting, limit, offset, opts.Collation)
+	}
+	return collection.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// readSession returns the collection on a copy of the session for the consistency and the read
+// preference of the read options, and the function closing the copy. A consistent read is in
+// the Strong mode, which reads from the primary, regardless of the read preference.
+func (c *MongoCollection) readSession(opts ReadOpts) (*MongoCollection, func()) {
+	session := c.Database.Session.Copy()
+	if opts.Consistent {
+		session.SetMode(mgo.Strong, false)
+	} else {
+		opts.ReadPreference.apply(session)
+	}
+	return c.withSession(session), session.Close
+}
+
+// defaultSortFields returns the sort fields of the default sort of the definition.
+func (c *MongoCollection) defaultSortFields() []string {
+	fields := []string{}
+	for _, key := range c.repoDef.GetDefaultSort() {
+		field := key.Property
+		if field == "id" && !c.repoDef.IsCustomID() {
+			field = "_id"
+		}
+		if isDescending(key.Sorting) {
+			field = "-" + field
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// pageSortFields adds _id as the last of the sort fields of a page of the results, as the
+// tiebreaker of the documents with equal sort values, see sortPage. An unsorted page is sorted by
+// _id, as the natural order is not stable, except for a capped collection, which keeps the
+// insertion order.
+func (c *MongoCollection) pageSortFields(sortFields []string) []string {
+	if len(sortFields) == 0 && c.repoDef.IsCapped() {
+		return sortFields
+	}
+	for _, field := range sortFields {
+		if strings.TrimPrefix(field, "-") == "_id" {
+			return sortFields
+		}
+	}
+	return append(sortFields, "_id")
+}
+
+// getAllCollated fetches all matched documents and sorts them with the collation on the client,
+// because the driver does not support collations. The offset and limit are applied after sorting.
+func (c *MongoCollection) getAllCollated(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, collation *Collation) (interface{}, error) {
+	start := time.Now()
+	limit, err := pageBounds(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSorting(sorting); err != nil {
+		return nil, err
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+	}
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	documents := []map[string]interface{}{}
+	if err = c.find(mongoFilter).All(&documents); err != nil {
+		return nil, err
+	}
+
+	records := make([]map[string]interface{}, len(documents))
+	for i, document := range documents {
+		if c.repoDef.IsCustomID() {
+			document["_id"] = document["_id"].(bson.ObjectId).Hex()
+		} else {
+			document["id"] = document["_id"].(bson.ObjectId).Hex()
+			delete(document, "_id")
+		}
+		mongoDecimals(document)
+		// compare the values in their JSON form, like the results are decoded
+		record, err := toMemoryRecord(document)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+
+	idField := "id"
+	if c.repoDef.IsCustomID() {
+		idField = "_id"
+	}
+	if order == "" && len(c.repoDef.GetDefaultSort()) == 0 && (limit > 0 || offset > 0) && !c.repoDef.IsCapped() {
+		// like pageSortFields, an unsorted page is in the _id order
+		sortRecordsByKeys(records, []SortKey{{Property: idField}})
+	}
+	sortPage(records, c.repoDef, idField, order, sorting, limit, offset, collation)
+
+	results, err := recordsPage(records, resultsTypeHint, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	c.stats.record(QueryStats{ItemsReturned: resultsCount(results)}, start)
+	return results, nil
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (c *MongoCollection) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(c.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
+
+// GetAllByIndex fetches all matched records using the named index. The index must be defined
+// for the collection and it is passed to MongoDB as a hint, so the query planner does not pick
+// a different index.
+func (c *MongoCollection) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	index, ok := findIndex(c.repoDef, indexName)
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("unknown index %s", indexName))
+	}
+
+	hinted := c.withSession(c.Database.Session)
+	hinted.hint = index.GetFields()
+
+	return hinted.GetAll(filter, resultsTypeHint, "", "", limit, offset)
+}
+
+// SaveUpsert updates the document matching the filter or inserts a new one, with a single
+// findAndModify with upsert. See Repository.SaveUpsert.
+func (c *MongoCollection) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	if len(filter) == 0 {
+		return nil, false, ErrInvalidInput("filter is required for upsert")
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+		delete(*payload, "id")
+	}
+	// we can't update MongoDB's own id - it is immutable.
+	delete(*payload, "_id")
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, false, ErrInvalidInput(err)
+	}
+
+	var record map[string]interface{}
+	info, err := c.find(mongoFilter).Apply(mgo.Change{
+		Update:    bson.M{"$set": payload},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &record)
+	if err != nil {
+		return nil, false, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
+	}
+
+	if c.repoDef.IsCustomID() {
+		record["_id"] = record["_id"].(bson.ObjectId).Hex()
+	} else {
+		record["id"] = record["_id"].(bson.ObjectId).Hex()
+	}
+
+	if err = MapToInterface(&record, &object); err != nil {
+		return nil, false, err
+	}
+
+	return object, info.UpsertedId != nil, nil
+}
+
+// GetAllWithHint fetches all matched records, forcing MongoDB to use the named index with a hint.
+// The index must be defined for the collection.
+func (c *MongoCollection) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	index, ok := findIndex(c.repoDef, indexName)
+	if !ok {
+		return nil, ErrInvalidInput(fmt.Sprintf("unknown index %s", indexName))
+	}
+
+	hinted := c.withSession(c.Database.Session)
+	hinted.hint = index.GetFields()
+
+	return hinted.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// ParallelScan reads the documents matching the filter a page at a time in the order of the ID.
+// MongoDB has no parallel scan, so the documents are read in a single pass and fn is called
+// sequentially. See Repository.ParallelScan.
+func (c *MongoCollection) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	return scanInPages(c, filter, "_id", segments, batchSizeOr(c.repoDef, scanBatchSize), resultsTypeHint, fn)
+}
+
+// SaveWithOpts creates or updates a record using the given write options.
+// A durable write is done with "majority" write concern and journaling.
+func (c *MongoCollection) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	if !opts.Durable {
+		return c.Save(object, filter)
+	}
+
+	session := c.Database.Session.Copy()
+	defer session.Close()
+	session.SetSafe(&mgo.Safe{
+		WMode: "majority",
+		J:     true,
+	})
+
+	return c.withSession(session).Save(object, filter)
+}
+
+// SaveWithTimestamp saves the object like Save and returns the time of the clock of the server
+// right after the write, the "localTime" of the isMaster command. The driver does not report the
+// operation time of the writes, so the command is an extra round trip. If the write succeeds but
+// the command fails, the saved object is returned along with the error.
+func (c *MongoCollection) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	saved, err := c.Save(object, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var isMaster struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+	if err = c.Database.Run(bson.D{{Name: "isMaster", Value: 1}}, &isMaster); err != nil {
+		return saved, time.Time{}, err
+	}
+	return saved, isMaster.LocalTime, nil
+}
+
+// SaveIf updates the document matching the filter only if the document also matches the condition.
+// The filter and the condition are merged in a single findAndModify, so the check and the update
+// are atomic. The bool result reports whether the update was applied.
+func (c *MongoCollection) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+
+	if filter == nil {
+		return nil, false, ErrInvalidInput("filter is required for conditional save")
+	}
+
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+		if err := stringToObjectID(condition); err != nil {
+			return nil, false, ErrInvalidInput(err)
+		}
+	}
+
+	// we can't update MongoDB's own id - it is immutable.
+	delete(*payload, "_id")
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, false, ErrInvalidInput(err)
+	}
+	mongoCondition, err := toMongoFilter(condition)
+	if err != nil {
+		return nil, false, ErrInvalidInput(err)
+	}
+
+	var record map[string]interface{}
+	_, err = c.find(bson.M{"$and": []interface{}{mongoFilter, mongoCondition}}).Apply(mgo.Change{
+		Update:    bson.M{"$set": payload},
+		ReturnNew: true,
+	}, &record)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			return nil, false, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
+		}
+		// either the document does not exist or the condition does not hold
+		count, err := c.find(mongoFilter).Limit(1).Count()
+		if err != nil {
+			return nil, false, err
+		}
+		if count == 0 {
+			return nil, false, ErrNotFound("record not found")
+		}
+		return nil, false, nil
+	}
+
+	if !c.repoDef.IsCustomID() {
+		record["id"] = record["_id"].(bson.ObjectId).Hex()
+	}
+
+	err = MapToInterface(&record, &object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return object, true, nil
+}
+
+// FindAndModify claims up to limit documents matching the filter with findAndModify, one
+// document at a time, in the order of the sort keys. Each findAndModify is atomic. The documents
+// claimed by the call are excluded from the following ones, so a document is not claimed twice
+// even if the update leaves it matching the filter. See Repository.FindAndModify.
+func (c *MongoCollection) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	if err := checkFindAndModify(update, limit, "_id", c.repoDef.GetIDField()); err != nil {
+		return nil, err
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	sortFields := []string{}
+	for _, key := range sort {
+		if isDescending(key.Sorting) {
+			sortFields = append(sortFields, "-"+key.Property)
+			continue
+		}
+		sortFields = append(sortFields, key.Property)
+	}
+
+	claimed := []map[string]interface{}{}
+	claimedIDs := []interface{}{}
+	for len(claimed) < limit {
+		query := c.find(bson.M{"$and": []interface{}{mongoFilter, bson.M{"_id": bson.M{"$nin": claimedIDs}}}})
+		if len(sortFields) > 0 {
+			query = query.Sort(sortFields...)
+		}
+
+		var record map[string]interface{}
+		_, err = query.Apply(mgo.Change{
+			Update:    bson.M{"$set": update},
+			ReturnNew: true,
+		}, &record)
+		if err == mgo.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
+		}
+
+		claimedIDs = append(claimedIDs, record["_id"])
+		if !c.repoDef.IsCustomID() {
+			record["id"] = record["_id"].(bson.ObjectId).Hex()
+		}
+		claimed = append(claimed, record)
+	}
+	return claimed, nil
+}
+
+// ReplaceOne replaces the document matching the filter with the object and returns the
+// previous document. The document is replaced with a single findAndModify.
+func (c *MongoCollection) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, ErrInvalidInput(err)
+		}
+		delete(*payload, "id")
+	}
+	// the replacement keeps the _id of the replaced document
+	delete(*payload, "_id")
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+
+	var record map[string]interface{}
+	_, err = c.find(mongoFilter).Apply(mgo.Change{
+		Update:    payload,
+		ReturnNew: false,
+	}, &record)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNotFound(err)
+		}
+		return nil, WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)
+	}
+
+	if c.repoDef.IsCustomID() {
+		record["_id"] = record["_id"].(bson.ObjectId).Hex()
+	} else {
+		record["id"] = record["_id"].(bson.ObjectId).Hex()
+	}
+
+	previous, err := CreateNewAsExample(object)
+	if err != nil {
+		return nil, err
+	}
+	if err = MapToInterface(&record, &previous); err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// mongoDecimals replaces the Decimal128 values of the document, also in the nested documents and
+// arrays, with Decimal. Decimal128 has no JSON form, so it would not survive the decoding of the
+// document into the result; Decimal is written to JSON as a string.
+func mongoDecimals(document map[string]interface{}) {
+	for key, value := range document {
+		document[key] = mongoDecimal(value)
+	}
+}
+
+func mongoDecimal(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.Decimal128:
+		return Decimal(v.String())
+	case map[string]interface{}:
+		mongoDecimals(v)
+	case bson.M:
+		mongoDecimals(v)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = mongoDecimal(item)
+		}
+	}
+	return value
+}
+
+// mongoUpsertBatchSize is the default number of upserts sent to MongoDB in one bulk operation.
+const mongoUpsertBatchSize = 1000
+
+// UpsertAll inserts or updates the objects, matching the existing documents by the values of the
+// conflict keys. The objects are sent as ordered bulk upserts in batches of 1000 (or of the batch
+// size of the definition), and the upserted documents of each batch are read back with a single
+// query. See Repository.UpsertAll for details.
+func (c *MongoCollection) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	upserted := []map[string]interface{}{}
+
+	batchSize := batchSizeOr(c.repoDef, mongoUpsertBatchSize)
+	for start := 0; start < len(objects); start += batchSize {
+		end := start + batchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		bulk := c.Collection.Bulk()
+		selectors := []interface{}{}
+		var invalid error
+		for i := start; i < end; i++ {
+			selector, update, err := c.upsertOperation(objects[i], conflictKeys)
+			if err != nil {
+				// the objects before the invalid one are still written
+				invalid = UpsertError{Row: i, Cause: err}
+				break
+			}
+			bulk.Upsert(selector, update)
+			selectors = append(selectors, selector)
+		}
+		if len(selectors) == 0 {
+			return nil, invalid
+		}
+
+		if _, err := bulk.Run(); err != nil {
+			row := start
+			if bulkErr, ok := err.(*mgo.BulkError); ok {
+				for _, errCase := range bulkErr.Cases() {
+					if errCase.Index >= 0 {
+						row = start + errCase.Index
+						err = errCase.Err
+						break
+					}
+				}
+			}
+			return nil, UpsertError{Row: row, Cause: WrapDuplicateKeyError(err, c.repoDef, c.detectDuplicateKey)}
+		}
+		if invalid != nil {
+			return nil, invalid
+		}
+
+		var documents []map[string]interface{}
+		if err := c.find(bson.M{"$or": selectors}).All(&documents); err != nil {
+			return nil, err
+		}
+		byKey := map[string]map[string]interface{}{}
+		for _, document := range documents {
+			if !c.repoDef.IsCustomID() {
+				document["id"] = document["_id"].(bson.ObjectId).Hex()
+			}
+			byKey[conflictKey(document, conflictKeys)] = document
+		}
+
+		for i := start; i < end; i++ {
+			payload, err := InterfaceToMap(objects[i])
+			if err != nil {
+				return nil, UpsertError{Row: i, Cause: err}
+			}
+			document, ok := byKey[conflictKey(*payload, conflictKeys)]
+			if !ok {
+				return nil, UpsertError{Row: i, Cause: ErrNotFound("upserted document not found")}
+			}
+			upserted = append(upserted, document)
+		}
+	}
+
+	return upsertResults(objects, upserted, conflictKeys)
+}
+
+// upsertOperation returns the selector and the update of the bulk upsert for the object.
+func (c *MongoCollection) upsertOperation(object interface{}, conflictKeys []string) (bson.M, bson.M, error) {
+	payload, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter, err := conflictFilter(*payload, conflictKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return nil, nil, ErrInvalidInput(err)
+		}
+		delete(*payload, "id")
+	}
+	selector, err := toMongoFilter(filter)
+	if err != nil {
+		return nil, nil, ErrInvalidInput(err)
+	}
+
+	// we can't update MongoDB's own id - it is immutable.
+	delete(*payload, "_id")
+
+	return selector, bson.M{"$set": *payload}, nil
+}
+
+// WithContext returns a copy of the collection whose queries carry the request-scoped values
+// of the context. The request ID (see WithRequestID) is set as the comment of the queries,
+// so it shows up in the MongoDB profiler and the slow query logs. Note that the inserts,
+// updates and removes do not support comments.
+func (c *MongoCollection) WithContext(ctx context.Context) Repository {
+	return &MongoCollection{
+		Collection: c.Collection,
+		repoDef:    c.repoDef,
+		ctx:        ctx,
+		backend:    c.backend,
+		hint:       c.hint,
+		batchSize:  c.batchSize,
+		stats:      newQueryStatsRecorder(),
+		logger:     c.logger,
+	}
+}
+
+// find prepares a query for the given selector, with the comment for the context and the index hint set.
+func (c *MongoCollection) find(query interface{}) *mgo.Query {
+	q := c.Find(query)
+	if comment := queryComment(c.requestContext()); comment != "" {
+		q = q.Comment(comment)
+	}
+	if len(c.hint) > 0 {
+		q = q.Hint(c.hint...)
+	}
+	if c.batchSize > 0 {
+		q = q.Batch(c.batchSize)
+	} else if batchSize := c.repoDef.GetBatchSize(); batchSize > 0 {
+		q = q.Batch(batchSize)
+	}
+	return q
+}
+
+// WithSession returns a copy of the collection that uses the MongoDB session of the session: a
+// copy of the session of the collection in the Strong mode, shared by the collections bound to
+// the session, so the reads go to the primary over the connection of the writes.
+func (c *MongoCollection) WithSession(session *Session) Repository {
+	mongoSession := session.resource(MONGO_CTX_KEY, func() (interface{}, func()) {
+		mongoSession := c.Database.Session.Copy()
+		mongoSession.SetMode(mgo.Strong, true)
+		return mongoSession, mongoSession.Close
+	}).(*mgo.Session)
+	return c.withSession(mongoSession)
+}
+
+// requestContext returns the context of the queries, with the values of the backend context.
+func (c *MongoCollection) requestContext() context.Context {
+	return withBackendContext(c.ctx, c.backend)
+}
+
+// withSession returns a copy of the collection that uses the given session.
+func (c *MongoCollection) withSession(session *mgo.Session) *MongoCollection {
+	return &MongoCollection{
+		Collection: c.Collection.With(session),
+		repoDef:    c.repoDef,
+		ctx:        c.ctx,
+		backend:    c.backend,
+		hint:       c.hint,
+		batchSize:  c.batchSize,
+		stats:      c.stats,
+		logger:     c.logger,
+	}
+}
+
+// Exists checks if there is at least one record matching the filter.
+// Only the count of the matched records (limited to 1) is fetched from the database.
+func (c *MongoCollection) Exists(filter Filter) (bool, error) {
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return false, ErrInvalidInput(err)
+		}
+	}
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return false, ErrInvalidInput(err)
+	}
+
+	count, err := c.find(mongoFilter).Limit(1).Count()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Count returns the number of documents matching the filter.
+func (c *MongoCollection) Count(filter Filter) (int, error) {
+
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return 0, ErrInvalidInput(err)
+		}
+	}
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return 0, ErrInvalidInput(err)
+	}
+
+	return c.find(mongoFilter).Count()
+}
+
+// CountBoth returns the number of documents matching the filter and the number of all the
+// documents, counted with two count commands. One $facet aggregation cannot count both, as the
+// $text and $near filters are not allowed in the $match of a $facet.
+func (c *MongoCollection) CountBoth(filter Filter) (int64, int64, error) {
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return 0, 0, ErrInvalidInput(err)
+		}
+	}
+
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return 0, 0, ErrInvalidInput(err)
+	}
+
+	filtered, err := c.find(mongoFilter).Count()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(mongoFilter) == 0 {
+		return int64(filtered), int64(filtered), nil
+	}
+	total, err := c.find(bson.M{}).Count()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(filtered), int64(total), nil
+}
+
+// Dump returns the shell command of the operation with the filter, like
+// db.users.find({"email":"john@example.com"}).
+func (c *MongoCollection) Dump(op string, filter Filter) (string, error) {
+	if err := checkDumpOperation(op); err != nil {
+		return "", err
+	}
+	filter = copyFilter(filter)
+	if !c.repoDef.IsCustomID() {
+		if err := stringToObjectID(filter); err != nil {
+			return "", ErrInvalidInput(err)
+		}
+	}
+	mongoFilter, err := toMongoFilter(filter)
+	if err != nil {
+		return "", ErrInvalidInput(err)
+	}
+
+	command := map[string]string{DumpFind: "find", DumpCount: "count", DumpDelete: "remove"}[op]
+	return fmt.Sprintf("db.%s.%s(%s)", c.Name, command, dumpValue(mongoFilter)), nil
+}
+
+// DescribeRepository returns the number of documents and their size from the collStats command.
+// MongoDB has no provisioned capacity, so the capacity is zero.
+func (c *MongoCollection) DescribeRepository() (RepositoryStats, error) {
+	var collStats struct {
+		Count int64 `bson:"count"`
+		Size  int64 `bson:"size"`
+	}
+	if err := c.Database.Run(bson.D{{Name: "collStats", Value: c.Name}}, &collStats); err != nil {
+		return RepositoryStats{}, err
+	}
+	return RepositoryStats{
+		ItemCount: collStats.Count,
+		SizeBytes: collStats.Size,
+	}, nil
+}
+
+// LastQueryStats returns the stats of the most recent GetAll of the collection instance.
+// ItemsScanned is not reported, as MongoDB reports the examined documents only with explain.
+// Use the MongoDB profiler to find the queries that examine many more documents than they return.
+func (c *MongoCollection) LastQueryStats() QueryStats {
+	return c.stats.get()
+}
+
+// GetByIDs fetches the documents with the given IDs with a single $in query and returns
+// them in the same order as the IDs. The result is a pointer to a slice with nil for
+// each ID that was not found. An ID that is not a valid ObjectId cannot match a document, so it
+// is not found either, like in the other backends.
+func (c *MongoCollection) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	idField := "id"
+	lookup := ids
+	if !c.repoDef.IsCustomID() {
+		idField = "_id"
+		lookup = []interface{}{}
+		for _, id := range ids {
+			if hex, ok := id.(string); ok && bson.IsObjectIdHex(hex) {
+				lookup = append(lookup, bson.ObjectIdHex(hex))
+			}
+		}
+	}
+
+	var found []map[string]interface{}
+	if len(lookup) > 0 {
+		if err := c.find(bson.M{idField: bson.M{"$in": lookup}}).All(&found); err != nil {
+			return nil, err
+		}
+	}
+
+	records := map[string]map[string]interface{}{}
+	for _, record := range found {
+		if !c.repoDef.IsCustomID() {
+			record["id"] = record["_id"].(bson.ObjectId).Hex()
+		}
+		records[idKey(record["id"])] = record
+	}
+
+	return orderByIDs(ids, records, resultHint)
+}
+
+// detectDuplicateKey is the DuplicateKeyDetector for MongoDB. It maps the name of the
+// MongoDB index reported in the error (like "email_1") back to the name of the defined index.
+func (c *MongoCollection) detectDuplicateKey(err error) (string, bool) {
+	if !mgo.IsDup(err) {
+		return "", false
+	}
+
+	match := mongoDupIndexRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "", true
+	}
+	for _, idx := range c.repoDef.GetIndexes() {
+		if mongoIndexName(idx.GetFields()) == match[1] || idx.GetName() == match[1] {
+			return idx.GetName(), true
+		}
+	}
+
+	return match[1], true
+}
+
+// mongoDupIndexRegexp matches the index name in the MongoDB duplicate key error message.
+var mongoDupIndexRegexp = regexp.MustCompile(`index: (?:\S+\.\$)?(\S+) dup key`)
+
+// ensureMongoIndex creates the index if the collection does not have it.
+func ensureMongoIndex(collection *mgo.Collection, elem Index, logger Logger) error {
+	index := mgo.Index{
+		Key:        elem.GetFields(),
+		Unique:     elem.Unique(),
+		DropDups:   true,
+		Background: true,
+		Sparse:     true,
+	}
+
+	// Create indexes
+	if err := collection.EnsureIndex(index); err != nil {
+		if qe, ok := err.(*mgo.QueryError); ok {
+			if qe.Code == 85 {
+				// IndexOptionsConflict - see here https://github.com/mongodb/mongo/blob/master/src/mongo/base/error_codes.err
+				// It means that there is already defined index and we try to redefine it, which is (mostly) fine.
+				logger.Errorf("The index %v of %s already exists and will not be updated. MongoDB error: %s", elem.GetFields(), collection.Name, err.Error())
+			}
+		} else {
+			logger.Errorf("Failed to create the index %v of %s: %v", elem.GetFields(), collection.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureIndexes creates the indexes that the collection does not have. An index that already
+// exists with different options is not updated. The indexes of the collection that are not in
+// the list are logged and kept, except the _id index and the TTL index.
+func (c *MongoCollection) EnsureIndexes(indexes []Index) error {
+	wanted := map[string]bool{
+		"_id_": true,
+	}
+	if c.repoDef.EnableTTL() {
+		wanted[mongoIndexName([]string{c.repoDef.GetTTLAttribute()})] = true
+	}
+	for _, index := range indexes {
+		if err := ensureMongoIndex(c.Collection, index, c.logger); err != nil {
+			return err
+		}
+		wanted[mongoIndexName(index.GetFields())] = true
+	}
+
+	existing, err := c.Indexes()
+	if err != nil {
+		return err
+	}
+	for _, index := range existing {
+		if !wanted[index.Name] {
+			c.logger.Debugf("The index %s of %s is not in the ensured indexes and is kept", index.Name, c.Name)
+		}
+	}
+	return nil
+}
+
+// mongoIndexName returns the default name that MongoDB gives to an ascending index on the fields.
+func mongoIndexName(fields []string) string {
+	parts := []string{}
+	for _, field := range fields {
+		parts = append(parts, field+"_1")
+	}
+	return strings.Join(parts, "_")
+}
+
+// mongoFieldComparisonOperators maps the Filter.MatchField operators to the MongoDB
+// aggregation comparison operators.
+var mongoFieldComparisonOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+func toMongoFilter(filter Filter) (map[string]interface{}, error) {
+	mgf := map[string]interface{}{}
+	fieldComparisons := []interface{}{}
+	for key, value := range filter {
+		if key == NotOperator {
+			negated, err := negatedFilter(value)
+			if err != nil {
+				return nil, err
+			}
+			negatedQuery, err := toMongoFilter(negated)
+			if err != nil {
+				return nil, err
+			}
+			// $not applies to a single field only, $nor with one expression negates the whole query
+			mgf["$nor"] = []interface{}{negatedQuery}
+			continue
+		}
+		if key == OrOperator {
+			alternatives, err := alternativeFilters(value)
+			if err != nil {
+				return nil, err
+			}
+			alternativeQueries := make([]interface{}, len(alternatives))
+			for i, alternative := range alternatives {
+				if alternativeQueries[i], err = toMongoFilter(alternative); err != nil {
+					return nil, err
+				}
+			}
+			mgf["$or"] = alternativeQueries
+			continue
+		}
+		if key == TextOperator {
+			query, _, err := textSearch(value)
+			if err != nil {
+				return nil, err
+			}
+			// the fields are the fields of the text index of the collection
+			mgf["$text"] = bson.M{"$search": query}
+			continue
+		}
+		if specs, ok := operatorSpecs(value); ok {
+			mongoSpecs := bson.M{}
+			for operator, operand := range specs {
+				switch operator {
+				case "$pattern":
+					pattern, ok := operand.(string)
+					if !ok {
+						return nil, fmt.Errorf("pattern for %s must be a string", key)
+					}
+					mongoSpecs["$regex"] = toMongoPattern(pattern)
+				case "$startsWith", "$endsWith":
+					if _, ok := operand.(string); !ok {
+						return nil, fmt.Errorf("%s for %s must be a string", operator, key)
+					}
+					if _, hasPattern := specs["$pattern"]; hasPattern {
+						return nil, fmt.Errorf("%s for %s cannot be combined with a pattern", operator, key)
+					}
+					mongoSpecs["$regex"] = toMongoAffixPattern(specs)
+				case "$gt", "$gte", "$lt", "$lte":
+					mongoSpecs[operator] = operand
+				case "$in":
+					values, ok := inValues(operand)
+					if !ok {
+						return nil, fmt.Errorf("values for %s must be a list", key)
+					}
+					mongoSpecs["$in"] = values
+				case "$fieldCmp":
+					operator, otherKey, err := fieldComparison(operand)
+					if err != nil {
+						return nil, err
+					}
+					// missing and null values are the lowest in the comparison order, so the
+					// comparisons with null leave out the documents missing either field
+					fieldComparisons = append(fieldComparisons,
+						bson.M{"$gt": []interface{}{"$" + key, nil}},
+						bson.M{"$gt": []interface{}{"$" + otherKey, nil}},
+						bson.M{mongoFieldComparisonOperators[operator]: []interface{}{"$" + key, "$" + otherKey}},
+					)
+				case "$mod":
+					divisor, remainder, err := modOperands(operand)
+					if err != nil {
+						return nil, err
+					}
+					mongoSpecs["$mod"] = []int64{divisor, remainder}
+				case "$any":
+					// no constraint on the property
+				case "$contains":
+					// unlike the plain match, $elemMatch does not match scalar values
+					mongoSpecs["$elemMatch"] = bson.M{"$eq": operand}
+				case "$all":
+					values, ok := inValues(operand)
+					if !ok || len(values) == 0 {
+						return nil, fmt.Errorf("values for %s must be a non-empty list", key)
+					}
+					elements := make([]interface{}, len(values))
+					for i, value := range values {
+						elements[i] = bson.M{"$elemMatch": bson.M{"$eq": value}}
+					}
+					mongoSpecs["$all"] = elements
+				case "$near", "$withinBox":
+					if _, hasGeo := mongoSpecs["$geoWithin"]; hasGeo {
+						return nil, fmt.Errorf("$near and $withinBox for %s cannot be combined", key)
+					}
+					geoWithin, err := toMongoGeo(operator, operand)
+					if err != nil {
+						return nil, err
+					}
+					mongoSpecs["$geoWithin"] = geoWithin
+				default:
+					return nil, fmt.Errorf("unknown filter operator %s", operator)
+				}
+			}
+			if len(mongoSpecs) > 0 {
+				mgf[key] = mongoSpecs
+			}
+			continue
+		}
+		mgf[key] = value // copy over the key=>value pairs to do exact matching
+	}
+	if len(fieldComparisons) > 0 {
+		mgf["$expr"] = bson.M{"$and": fieldComparisons}
+	}
+	return mgf, nil
+}
+
+// toMongoAffixPattern builds the regular expression for the $startsWith and $endsWith
+// operators. The prefix and the suffix are quoted, so they match literally. With both of them
+// set, the prefix is a lookahead, so the prefix and the suffix can overlap in the value.
+func toMongoAffixPattern(specs map[string]interface{}) string {
+	prefix, hasPrefix := specs["$startsWith"].(string)
+	suffix, hasSuffix := specs["$endsWith"].(string)
+	switch {
+	case hasPrefix && hasSuffix:
+		return "^(?=" + regexp.QuoteMeta(prefix) + ")[\\s\\S]*" + regexp.QuoteMeta(suffix) + "$"
+	case hasPrefix:
+		return "^" + regexp.QuoteMeta(prefix)
+	default:
+		return regexp.QuoteMeta(suffix) + "$"
+	}
 }
 
 func toMongoPattern(pattern string) string {
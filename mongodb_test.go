@@ -1,10 +1,12 @@
 package backends
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
 	"github.com/Microkubes/microservice-tools/config"
+	"gopkg.in/mgo.v2"
 )
 
 func TestToMongoPattern(t *testing.T) {
@@ -45,6 +47,23 @@ func TestToMongoPattern(t *testing.T) {
 
 }
 
+func TestToMongoAffixPattern(t *testing.T) {
+	pattern := toMongoAffixPattern(map[string]interface{}{"$startsWith": "100%.5"})
+	if pattern != `^100%\.5` {
+		t.Fatal("Expected the prefix to be quoted. Got: ", pattern)
+	}
+
+	pattern = toMongoAffixPattern(map[string]interface{}{"$endsWith": "(off)"})
+	if pattern != `\(off\)$` {
+		t.Fatal("Expected the suffix to be quoted. Got: ", pattern)
+	}
+
+	pattern = toMongoAffixPattern(map[string]interface{}{"$startsWith": "a", "$endsWith": "b"})
+	if pattern != `^(?=a)[\s\S]*b$` {
+		t.Fatal("Expected the prefix to be a lookahead. Got: ", pattern)
+	}
+}
+
 type TestEntry struct {
 	ID    string `json:"id" bson:"id"`
 	Value string `json:"value" bson:"value"`
@@ -128,3 +147,87 @@ func TestMongoDBIntergration(t *testing.T) {
 		t.Fatal("Expected exactly 1 result, but got: ", len(*resArr))
 	}
 }
+
+func TestMongoDetectDuplicateKey(t *testing.T) {
+	coll := &MongoCollection{
+		repoDef: RepositoryDefinitionMap{
+			"name":    "users",
+			"indexes": []Index{NewUniqueIndex("email")},
+		},
+	}
+
+	err := WrapDuplicateKeyError(&mgo.LastError{
+		Code: 11000,
+		Err:  `E11000 duplicate key error collection: testdb.users index: email_1 dup key: { : "john@example.com" }`,
+	}, coll.repoDef, coll.detectDuplicateKey)
+
+	dupErr, ok := err.(DuplicateKeyError)
+	if !ok {
+		t.Fatal("Expected DuplicateKeyError. Got: ", err)
+	}
+	if dupErr.Index != "email" || !strArrEq(dupErr.Fields, []string{"email"}) {
+		t.Fatal("Expected the index to be mapped to the defined index. Got: ", dupErr.Index, dupErr.Fields)
+	}
+
+	other := fmt.Errorf("some error")
+	if WrapDuplicateKeyError(other, coll.repoDef, coll.detectDuplicateKey) != other {
+		t.Fatal("Expected other errors to be returned unchanged")
+	}
+}
+
+func TestMongoGetByIDsInvalidObjectID(t *testing.T) {
+	coll := &MongoCollection{
+		Collection: &mgo.Collection{Name: "users"},
+		repoDef:    RepositoryDefinitionMap{"name": "users"},
+	}
+
+	results, err := coll.GetByIDs([]interface{}{"not-an-object-id", 42}, &map[string]interface{}{})
+	if err != nil {
+		t.Fatal("Expected the invalid IDs to be not found. Got: ", err)
+	}
+	records := *results.(*[]*map[string]interface{})
+	if len(records) != 2 || records[0] != nil || records[1] != nil {
+		t.Fatal("Expected a nil placeholder for each invalid ID. Got: ", records)
+	}
+}
+
+func TestMongoCountBothTextSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode.")
+	}
+
+	backend, err := NewBackendSupport(map[string]*config.DBInfo{
+		"mongodb": &config.DBInfo{
+			DatabaseName: "testdb",
+			Host:         "localhost:27017",
+			Username:     "testuser",
+			Password:     "testpass",
+		},
+	}).GetBackend("mongodb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := backend.DefineRepository("test_count_text", RepositoryDefinitionMap{
+		"name":    "test_count_text",
+		"indexes": []Index{NewNonUniqueIndex("$text:value")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DeleteAll(NewFilter().MatchPattern("value", "%"))
+
+	for _, value := range []string{"coffee shop", "tea house", "coffee bar"} {
+		if _, err = repo.Save(&TestEntry{Value: value}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the $text filter is not allowed in the $match of a $facet
+	filtered, total, err := repo.CountBoth(NewFilter().TextSearch("coffee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filtered != 2 || total != 3 {
+		t.Fatalf("Expected 2 of 3 documents. Got %d of %d", filtered, total)
+	}
+}
@@ -0,0 +1,173 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options are the free-form options of a backend or of a repository, for the settings that
+// config.DBInfo and the repository definition have no place for. The backend options are set with
+// DefaultBackendManager.WithBackendOptions and read by the backend builders with
+// GetBackendOptions; the repository options are the "options" entry of the repository
+// definition. Decode them into a struct with DecodeOptions.
+type Options map[string]interface{}
+
+// DecodeOptions decodes the options into the struct pointed to by target, like mapstructure. The
+// options are matched to the exported fields by the name in the "option" tag of the field, or by
+// the name of the field, regardless of case. The tag can mark the option as required:
+// 		type redisOptions struct {
+// 			Address  string        `option:"address,required"`
+// 			PoolSize int           `option:"poolSize"`
+// 			Timeout  time.Duration `option:"timeout"`
+// 			Internal string        `option:"-"`
+// 		}
+//
+// 		opts := redisOptions{PoolSize: 10}
+// 		err := backends.DecodeOptions(backends.GetBackendOptions(manager, "redis"), &opts)
+//
+// The values are converted to the types of the fields like JSON values, so a number cannot be
+// decoded into a string field, nor a fractional number into an int field. The time.Duration
+// fields also accept the duration strings, like "5s". The fields of the missing options keep
+// their values, which are the defaults. DecodeOptions fails with ErrInvalidInput listing all the
+// missing required options, the unknown options and the values of the wrong type.
+func DecodeOptions(opts Options, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return ErrInvalidInput("the options can be decoded only into a pointer to a struct")
+	}
+	value = value.Elem()
+
+	problems := []string{}
+	decoded := map[string]bool{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, required, skip := optionName(field)
+		if skip {
+			continue
+		}
+		key, ok := optionKey(opts, name)
+		if !ok {
+			if required {
+				problems = append(problems, fmt.Sprintf("the option %s is required", name))
+			}
+			continue
+		}
+		decoded[key] = true
+		if err := decodeOption(opts[key], value.Field(i)); err != nil {
+			problems = append(problems, fmt.Sprintf("the option %s is invalid: %s", name, err.Error()))
+		}
+	}
+	for key := range opts {
+		if !decoded[key] {
+			problems = append(problems, fmt.Sprintf("the option %s is unknown", key))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return ErrInvalidInput(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// optionName returns the name of the option of the struct field and whether it is required. It
+// reports the unexported fields and the fields tagged with "-" as skipped.
+func optionName(field reflect.StructField) (string, bool, bool) {
+	if field.PkgPath != "" {
+		return "", false, true
+	}
+	tag := field.Tag.Get("option")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	required := false
+	for _, flag := range parts[1:] {
+		if flag == "required" {
+			required = true
+		}
+	}
+	return name, required, false
+}
+
+// optionKey returns the key of the options matching the name, exactly or else regardless of case.
+func optionKey(opts Options, name string) (string, bool) {
+	if _, ok := opts[name]; ok {
+		return name, true
+	}
+	for key := range opts {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// decodeOption converts the value of the option to the type of the field and sets it.
+func decodeOption(option interface{}, field reflect.Value) error {
+	if duration, ok := option.(string); ok && field.Type() == reflect.TypeOf(time.Duration(0)) {
+		parsed, err := time.ParseDuration(duration)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+		return nil
+	}
+
+	data, err := json.Marshal(option)
+	if err != nil {
+		return err
+	}
+	decoded := reflect.New(field.Type())
+	if err = json.Unmarshal(data, decoded.Interface()); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("expected %s, got %s", field.Type(), typeErr.Value)
+		}
+		return err
+	}
+	field.Set(decoded.Elem())
+	return nil
+}
+
+// WithBackendOptions sets the options of the backends of the type, read by the builder of the
+// backend with GetBackendOptions.
+func (m *DefaultBackendManager) WithBackendOptions(backendType string, opts Options) *DefaultBackendManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.backendOptions == nil {
+		m.backendOptions = map[string]Options{}
+	}
+	m.backendOptions[backendType] = opts
+	return m
+}
+
+// BackendOptions returns a copy of the options of the backends of the type, empty if none are set.
+func (m *DefaultBackendManager) BackendOptions(backendType string) Options {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	opts := Options{}
+	for key, value := range m.backendOptions[backendType] {
+		opts[key] = value
+	}
+	return opts
+}
+
+// GetBackendOptions returns the options of the backends of the type set with
+// DefaultBackendManager.WithBackendOptions, for the backend builders. It is empty for the other
+// implementations of BackendManager.
+func GetBackendOptions(manager BackendManager, backendType string) Options {
+	if m, ok := manager.(*DefaultBackendManager); ok {
+		return m.BackendOptions(backendType)
+	}
+	return Options{}
+}
@@ -0,0 +1,68 @@
+package backends
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBackendOptions struct {
+	Address  string        `option:"address,required"`
+	PoolSize int           `option:"poolSize"`
+	Timeout  time.Duration `option:"timeout"`
+	Verbose  bool
+	Labels   map[string]string `option:"labels"`
+	internal string
+}
+
+func TestDecodeOptions(t *testing.T) {
+	opts := testBackendOptions{PoolSize: 10}
+	err := DecodeOptions(Options{
+		"address": "localhost:6379",
+		"timeout": "5s",
+		"verbose": true,
+		"labels":  map[string]interface{}{"env": "test"},
+	}, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Address != "localhost:6379" || opts.PoolSize != 10 || opts.Timeout != 5*time.Second || !opts.Verbose {
+		t.Fatal("Expected the options decoded, with the default pool size. Got: ", opts)
+	}
+	if opts.Labels["env"] != "test" {
+		t.Fatal("Expected the labels decoded. Got: ", opts.Labels)
+	}
+
+	err = DecodeOptions(Options{"poolSize": 2.5, "extra": 1}, &testBackendOptions{})
+	if !IsErrInvalidInput(err) {
+		t.Fatal("Expected ErrInvalidInput for the invalid options. Got: ", err)
+	}
+	for _, problem := range []string{"address is required", "poolSize is invalid", "extra is unknown"} {
+		if details := err.(*BackendErrorInfo).Details(); !strings.Contains(details, problem) {
+			t.Fatalf("Expected the error to report that the option %s. Got: %s", problem, details)
+		}
+	}
+
+	if err = DecodeOptions(Options{}, opts); !IsErrInvalidInput(err) {
+		t.Fatal("Expected ErrInvalidInput for a target that is not a pointer. Got: ", err)
+	}
+}
+
+func TestBackendAndRepositoryOptions(t *testing.T) {
+	manager := NewBackendManager(nil).(*DefaultBackendManager).WithBackendOptions("memory", Options{"address": "local"})
+	if opts := GetBackendOptions(manager, "memory"); opts["address"] != "local" {
+		t.Fatal("Expected the options of the memory backend. Got: ", opts)
+	}
+	if opts := GetBackendOptions(manager, "mongodb"); len(opts) != 0 {
+		t.Fatal("Expected no options for the mongodb backend. Got: ", opts)
+	}
+
+	def := RepositoryDefinitionMap{"name": "users", "options": map[string]interface{}{"address": "local", "poolSize": 3}}
+	opts := testBackendOptions{}
+	if err := DecodeOptions(def.GetOptions(), &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Address != "local" || opts.PoolSize != 3 {
+		t.Fatal("Expected the options of the repository decoded. Got: ", opts)
+	}
+}
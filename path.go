@@ -0,0 +1,128 @@
+package backends
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitPath splits the dot path of UpdatePath into its segments. The first segment cannot be one
+// of the key properties.
+func splitPath(path string, keys ...string) ([]string, error) {
+	if path == "" {
+		return nil, ErrInvalidInput("path is required")
+	}
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" || strings.HasPrefix(segment, "$") {
+			return nil, ErrInvalidInput(fmt.Sprintf("invalid path %q", path))
+		}
+	}
+	if err := checkUpdateFields(map[string]interface{}{segments[0]: nil}, keys...); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// pathUpdate returns the update that sets the value at the path of the record, creating the
+// missing documents of the path: the segments of the path to set, which end at the first segment
+// missing in the record, and the value to set there, nested in the documents of the remaining
+// segments. A segment of an array is the index of an element, which must exist. It fails with
+// ErrInvalidInput if the path crosses a value that is not a document or an array.
+func pathUpdate(record map[string]interface{}, segments []string, value interface{}) ([]string, interface{}, error) {
+	var current interface{} = record
+	for i, segment := range segments {
+		var next interface{}
+		switch container := current.(type) {
+		case map[string]interface{}:
+			next = container[segment]
+		case []interface{}:
+			index, err := arrayIndex(segments[:i+1], len(container))
+			if err != nil {
+				return nil, nil, err
+			}
+			next = container[index]
+		default:
+			return nil, nil, ErrInvalidInput(fmt.Sprintf("the path %s crosses %s, which is not a document or an array",
+				strings.Join(segments, "."), strings.Join(segments[:i], ".")))
+		}
+		if i == len(segments)-1 {
+			break
+		}
+		if next == nil {
+			return segments[:i+1], nestedValue(segments[i+1:], value), nil
+		}
+		current = next
+	}
+	return segments, value, nil
+}
+
+// arrayIndex returns the index of the array element of the last segment of the path.
+func arrayIndex(segments []string, length int) (int, error) {
+	segment := segments[len(segments)-1]
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index >= length {
+		return 0, ErrInvalidInput(fmt.Sprintf("the path %s is not an index of the array of length %d", strings.Join(segments, "."), length))
+	}
+	return index, nil
+}
+
+// nestedValue returns the value nested in the documents of the segments, like
+// {"b": {"c": value}} for the segments b and c.
+func nestedValue(segments []string, value interface{}) interface{} {
+	for i := len(segments) - 1; i >= 0; i-- {
+		value = map[string]interface{}{segments[i]: value}
+	}
+	return value
+}
+
+// setPath sets the value at the path of the record, see pathUpdate.
+func setPath(record map[string]interface{}, segments []string, value interface{}) error {
+	segments, value, err := pathUpdate(record, segments, value)
+	if err != nil {
+		return err
+	}
+	var current interface{} = record
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch container := current.(type) {
+		case map[string]interface{}:
+			if last {
+				container[segment] = value
+			}
+			current = container[segment]
+		case []interface{}:
+			index, _ := strconv.Atoi(segment)
+			if last {
+				container[index] = value
+			}
+			current = container[index]
+		}
+	}
+	return nil
+}
+
+// documentPath returns the path of the segments in the record as a DynamoDB document path, with
+// the indexes of the array elements in brackets, like addresses[0].city.
+func documentPath(record map[string]interface{}, segments []string) string {
+	var path strings.Builder
+	var current interface{} = record
+	for i, segment := range segments {
+		switch container := current.(type) {
+		case []interface{}:
+			index, _ := strconv.Atoi(segment)
+			fmt.Fprintf(&path, "[%d]", index)
+			current = container[index]
+			continue
+		case map[string]interface{}:
+			current = container[segment]
+		default:
+			current = nil
+		}
+		if i > 0 {
+			path.WriteString(".")
+		}
+		path.WriteString(segment)
+	}
+	return path.String()
+}
@@ -0,0 +1,74 @@
+package backends
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// QueryStats reports how much work the backend did for a query, to find the queries that scan
+// much more than they return, like the queries on properties without an index.
+type QueryStats struct {
+	// ItemsScanned is the number of records the backend examined. Zero if the backend does not
+	// report it: MongoDB reports the examined documents only with explain, and the DynamoDB client
+	// does not expose the scanned count, so use ConsumedCapacity there.
+	ItemsScanned int64
+	// ItemsReturned is the number of records returned by the query.
+	ItemsReturned int64
+	// ConsumedCapacity is the total capacity units consumed by the query (DynamoDB only).
+	// A scan consumes capacity for all the data it reads, including the items the filter drops.
+	ConsumedCapacity float64
+	// DurationMs is how long the query took, in milliseconds.
+	DurationMs int64
+}
+
+// queryStatsRecorder keeps the stats of the most recent query of a repository instance.
+// The methods are safe to call on a nil recorder, which records nothing.
+type queryStatsRecorder struct {
+	mutex sync.Mutex
+	last  QueryStats
+}
+
+func newQueryStatsRecorder() *queryStatsRecorder {
+	return &queryStatsRecorder{}
+}
+
+// record sets the stats of the query started at start. The duration is set from start.
+func (s *queryStatsRecorder) record(stats QueryStats, start time.Time) {
+	stats.DurationMs = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	s.set(stats)
+}
+
+// set sets the stats as they are. It is used by the wrappers to keep the stats of the wrapped
+// repository.
+func (s *queryStatsRecorder) set(stats QueryStats) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.last = stats
+}
+
+func (s *queryStatsRecorder) get() QueryStats {
+	if s == nil {
+		return QueryStats{}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.last
+}
+
+// resultsCount returns the number of results in the slice (or pointer to slice) of results.
+func resultsCount(results interface{}) int64 {
+	value := reflect.ValueOf(results)
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Slice {
+		return 0
+	}
+	return int64(value.Len())
+}
@@ -0,0 +1,47 @@
+package backends
+
+// GetOneRaw fetches one record matching the filter as a generic map, for the ad-hoc queries
+// that have no Go type for the records, like admin tools or schema-less data. The records of
+// all the backends are returned in the same form, as they look like when decoded from JSON:
+// the nested documents are map[string]interface{}, the arrays []interface{}, the numbers
+// float64 (so integers above 2^53 lose precision), the times RFC3339 strings and the MongoDB
+// ObjectIds hex strings. The ID is in the "id" property, like when read into a struct.
+func GetOneRaw(repo Repository, filter Filter) (map[string]interface{}, error) {
+	result, err := repo.GetOne(filter, &map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return rawRecord(result)
+}
+
+// GetAllRaw fetches the records matching the filter as generic maps, in the form described in
+// GetOneRaw. The order, sorting, limit and offset are the same as of Repository.GetAll.
+func GetAllRaw(repo Repository, filter Filter, order string, sorting string, limit int, offset int) ([]map[string]interface{}, error) {
+	results, err := repo.GetAll(filter, &map[string]interface{}{}, order, sorting, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]interface{}{}
+	err = IterateOverSlice(results, func(i int, item interface{}) error {
+		record, err := rawRecord(item)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// rawRecord converts a record read with a map type hint to its JSON form.
+func rawRecord(result interface{}) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	if err := MapToInterface(result, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,66 @@
+package backends
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type rawTestAddress struct {
+	City string `json:"city"`
+	Zip  int    `json:"zip"`
+}
+
+type rawTestEntry struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Tags      []string       `json:"tags"`
+	Address   rawTestAddress `json:"address"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func TestGetRaw(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	createdAt := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, name := range []string{"John", "Jane"} {
+		entry := &rawTestEntry{
+			Name:      name,
+			Tags:      []string{"admin"},
+			Address:   rawTestAddress{City: "Skopje", Zip: 1000},
+			CreatedAt: createdAt,
+		}
+		if _, err := repo.Save(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record, err := GetOneRaw(repo, NewFilter().Match("name", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := record["id"].(string); !ok || id == "" {
+		t.Fatal("Expected the ID of the record. Got: ", record)
+	}
+	if record["name"] != "John" || record["created_at"] != "2020-01-02T15:04:05Z" {
+		t.Fatal("Expected the properties in their JSON form. Got: ", record)
+	}
+	if !reflect.DeepEqual(record["address"], map[string]interface{}{"city": "Skopje", "zip": float64(1000)}) {
+		t.Fatal("Expected the nested struct as a map. Got: ", record["address"])
+	}
+	if !reflect.DeepEqual(record["tags"], []interface{}{"admin"}) {
+		t.Fatal("Expected the array as []interface{}. Got: ", record["tags"])
+	}
+
+	records, err := GetAllRaw(repo, NewFilter(), "name", "asc", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0]["name"] != "Jane" || records[1]["name"] != "John" {
+		t.Fatal("Expected all the records in order. Got: ", records)
+	}
+
+	if _, err = GetOneRaw(repo, NewFilter().Match("name", "Nobody")); !IsErrNotFound(err) {
+		t.Fatal("Expected the record not to be found. Got: ", err)
+	}
+}
@@ -0,0 +1,110 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReadPreferenceMode is the member of a replica set that a read prefers, see ReadPreference.
+type ReadPreferenceMode string
+
+const (
+	// ReadPrimary reads from the primary.
+	ReadPrimary ReadPreferenceMode = "primary"
+	// ReadSecondary reads from a secondary, failing if there is none available.
+	ReadSecondary ReadPreferenceMode = "secondary"
+	// ReadNearest reads from the member with the lowest latency, primary or secondary.
+	ReadNearest ReadPreferenceMode = "nearest"
+)
+
+// ReadPreference selects the member of a replica set that serves a read, set with
+// ReadOpts.ReadPreference, like the nearest member for a read-heavy geo-distributed workload:
+// 		results, err := repo.GetAllWithOpts(filter, &User{}, "", "", 0, 0, backends.ReadOpts{
+// 			ReadPreference: backends.TaggedSecondary(map[string]string{"region": "eu-west-1"}),
+// 		})
+// MongoDB maps it to the read preference of the session of the read. DynamoDB reads from the
+// table of the region of the client and the in-memory backend has a single copy of the records,
+// so they ignore it. With ReadOpts.Consistent set, the read goes to the primary regardless.
+type ReadPreference struct {
+	// Mode is the member that the read prefers.
+	Mode ReadPreferenceMode
+	// Tags are the tag sets of the members eligible for the read, tried in order until one
+	// matches an available member. Empty for any member of the mode. Not valid with ReadPrimary.
+	Tags []map[string]string
+}
+
+// Primary returns the read preference of the primary.
+func Primary() *ReadPreference {
+	return &ReadPreference{Mode: ReadPrimary}
+}
+
+// Secondary returns the read preference of any secondary.
+func Secondary() *ReadPreference {
+	return &ReadPreference{Mode: ReadSecondary}
+}
+
+// Nearest returns the read preference of the member with the lowest latency.
+func Nearest() *ReadPreference {
+	return &ReadPreference{Mode: ReadNearest}
+}
+
+// TaggedSecondary returns the read preference of the secondaries with the tags, like the ones in
+// the region of the service. With several tag sets, the first one matching an available
+// secondary is used.
+func TaggedSecondary(tags ...map[string]string) *ReadPreference {
+	return &ReadPreference{Mode: ReadSecondary, Tags: tags}
+}
+
+// isPrimary checks if the read preference sends the reads to the primary. A nil read preference
+// is the default of the backend.
+func (p *ReadPreference) isPrimary() bool {
+	return p != nil && p.Mode == ReadPrimary
+}
+
+// checkReadPreference checks that the read preference has a known mode, and no tags with
+// ReadPrimary. A nil read preference is valid.
+func checkReadPreference(p *ReadPreference) error {
+	if p == nil {
+		return nil
+	}
+	switch p.Mode {
+	case ReadPrimary:
+		if len(p.Tags) > 0 {
+			return ErrInvalidInput("the primary read preference cannot have tags")
+		}
+	case ReadSecondary, ReadNearest:
+	default:
+		return ErrInvalidInput(fmt.Sprintf("unknown read preference mode %q", p.Mode))
+	}
+	return nil
+}
+
+// apply sets the mode and the tag sets of the read preference on the MongoDB session.
+func (p *ReadPreference) apply(session *mgo.Session) {
+	switch p.Mode {
+	case ReadPrimary:
+		session.SetMode(mgo.Primary, false)
+	case ReadSecondary:
+		session.SetMode(mgo.Secondary, false)
+	case ReadNearest:
+		session.SetMode(mgo.Nearest, false)
+	}
+
+	tagSets := []bson.D{}
+	for _, tags := range p.Tags {
+		names := make([]string, 0, len(tags))
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tagSet := bson.D{}
+		for _, name := range names {
+			tagSet = append(tagSet, bson.DocElem{Name: name, Value: tags[name]})
+		}
+		tagSets = append(tagSets, tagSet)
+	}
+	session.SelectServers(tagSets...)
+}
@@ -0,0 +1,44 @@
+package backends
+
+import "testing"
+
+// readPreferenceRepository captures the read preference of the reads of the wrapped repository.
+type readPreferenceRepository struct {
+	Repository
+	captured *ReadPreference
+}
+
+func (r *readPreferenceRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	r.captured = opts.ReadPreference
+	return r.Repository.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+}
+
+func TestReadPreference(t *testing.T) {
+	backend := &readPreferenceRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})}
+	repo := NewCoercingRepository(NewFieldMappingRepository(backend, map[string]string{"userName": "user_name"}), map[string]string{"age": "int"})
+
+	preference := TaggedSecondary(map[string]string{"region": "eu-west-1"})
+	if _, err := repo.GetAllWithOpts(nil, &map[string]interface{}{}, "", "", 0, 0, ReadOpts{ReadPreference: preference}); err != nil {
+		t.Fatal(err)
+	}
+	if backend.captured != preference {
+		t.Fatal("Expected the read preference to be passed to the backend. Got: ", backend.captured)
+	}
+
+	primary := &readPreferenceRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})}
+	replica := &readPreferenceRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})}
+	readWrite := NewReadWriteRepository(primary, replica)
+	if _, err := readWrite.GetAllWithOpts(nil, &map[string]interface{}{}, "", "", 0, 0, ReadOpts{ReadPreference: Primary()}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.captured == nil || primary.captured.Mode != ReadPrimary || replica.captured != nil {
+		t.Fatal("Expected the primary read preference to read from the primary endpoint")
+	}
+
+	invalid := []*ReadPreference{{Mode: "closest"}, {Mode: ReadPrimary, Tags: []map[string]string{{"region": "eu-west-1"}}}}
+	for _, preference := range invalid {
+		if _, err := repo.GetAllWithOpts(nil, &map[string]interface{}{}, "", "", 0, 0, ReadOpts{ReadPreference: preference}); !IsErrInvalidInput(err) {
+			t.Fatal("Expected an invalid read preference to be rejected. Got: ", err)
+		}
+	}
+}
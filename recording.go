@@ -0,0 +1,391 @@
+package backends
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Op is a repository operation recorded by a RecordingRepository.
+type Op struct {
+	// Name is the name of the Repository method, like "Save" or "GetAll".
+	Name string
+	// Filter is a copy of the filter of the operation, nil if the operation has no filter.
+	Filter Filter
+	// Condition is a copy of the condition of SaveIf and DeleteIf.
+	Condition Filter
+	// Object is the object written by the operation: the object of the saves, the update of
+	// FindAndModify and of UpdateFieldsReturning, the objects of UpsertAll, the operations of Bulk
+	// ([]BulkOperation), the field and the values of ArrayAppend and ArrayRemove (a map of the
+	// field to the values), the path and the value of UpdatePath (a map of the path to the value). For GetByIDs it is the IDs, for EnsureIndexes the indexes and for
+	// QueryRange the hash value, the range operator and the range value, for Migrate the versions
+	// from and to ([]int). Nil for the other reads.
+	Object interface{}
+	// Err is the error returned by the operation.
+	Err error
+}
+
+// OpLog holds the operations recorded by a RecordingRepository, in the order in which they
+// completed. It is safe for concurrent use.
+type OpLog struct {
+	mutex sync.Mutex
+	ops   []Op
+}
+
+// Ops returns a copy of the recorded operations.
+func (l *OpLog) Ops() []Op {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ops := make([]Op, len(l.ops))
+	copy(ops, l.ops)
+	return ops
+}
+
+// Names returns the names of the recorded operations, like ["Save", "GetOne"].
+func (l *OpLog) Names() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	names := make([]string, len(l.ops))
+	for i, op := range l.ops {
+		names[i] = op.Name
+	}
+	return names
+}
+
+// Reset removes the recorded operations.
+func (l *OpLog) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.ops = nil
+}
+
+func (l *OpLog) add(op Op, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	op.Err = err
+	l.ops = append(l.ops, op)
+}
+
+// copyFilter returns a shallow copy of the filter, so the recorded filter is not changed by
+// the backends that rewrite the filter, like MongoDB does with the ID.
+func copyFilter(filter Filter) Filter {
+	if filter == nil {
+		return nil
+	}
+	copied := Filter{}
+	for property, value := range filter {
+		copied[property] = value
+	}
+	return copied
+}
+
+// RecordingRepository records the operations on the wrapped repository in an OpLog, so the
+// tests of a service can assert the exact sequence of the operations the service performed.
+// The operations are delegated to the wrapped repository, like an in-memory repository.
+// LastQueryStats and WithContext are not recorded; the copies returned by WithContext record
+// to the same log.
+type RecordingRepository struct {
+	Repository
+	log *OpLog
+}
+
+// NewRecordingRepository wraps the repository so its operations are recorded in the returned log.
+func NewRecordingRepository(delegate Repository) (Repository, *OpLog) {
+	log := &OpLog{}
+	return &RecordingRepository{
+		Repository: delegate,
+		log:        log,
+	}, log
+}
+
+// GetOne fetches only one record for given filter.
+func (r *RecordingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	op := Op{Name: "GetOne", Filter: copyFilter(filter)}
+	found, err := r.Repository.GetOne(filter, result)
+	r.log.add(op, err)
+	return found, err
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *RecordingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	op := Op{Name: "GetOneWithOpts", Filter: copyFilter(filter)}
+	found, err := r.Repository.GetOneWithOpts(filter, result, opts)
+	r.log.add(op, err)
+	return found, err
+}
+
+// GetAll fetches all matched records for given filter.
+func (r *RecordingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	op := Op{Name: "GetAll", Filter: copyFilter(filter)}
+	results, err := r.Repository.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+	r.log.add(op, err)
+	return results, err
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *RecordingRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	op := Op{Name: "GetFirst", Filter: copyFilter(filter)}
+	result, err := r.Repository.GetFirst(filter, resultsTypeHint, order, sorting)
+	r.log.add(op, err)
+	return result, err
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+func (r *RecordingRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	op := Op{Name: "GetAllWithOpts", Filter: copyFilter(filter)}
+	results, err := r.Repository.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	r.log.add(op, err)
+	return results, err
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *RecordingRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	op := Op{Name: "GetAllByIndex", Filter: copyFilter(filter)}
+	results, err := r.Repository.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+	r.log.add(op, err)
+	return results, err
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition.
+func (r *RecordingRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	op := Op{Name: "QueryRange", Object: []interface{}{hashValue, rangeOp, rangeValue}}
+	results, err := r.Repository.QueryRange(hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+	r.log.add(op, err)
+	return results, err
+}
+
+// GetAllWithHint fetches all matched records, hinting the backend to use the named index.
+func (r *RecordingRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	op := Op{Name: "GetAllWithHint", Filter: copyFilter(filter)}
+	results, err := r.Repository.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+	r.log.add(op, err)
+	return results, err
+}
+
+// ParallelScan reads all matched records in segments.
+func (r *RecordingRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	op := Op{Name: "ParallelScan", Filter: copyFilter(filter)}
+	err := r.Repository.ParallelScan(filter, segments, resultsTypeHint, fn)
+	r.log.add(op, err)
+	return err
+}
+
+// Save creates new record or updates the existing one.
+func (r *RecordingRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	op := Op{Name: "Save", Filter: copyFilter(filter), Object: object}
+	saved, err := r.Repository.Save(object, filter)
+	r.log.add(op, err)
+	return saved, err
+}
+
+// SaveWithOpts creates new record or updates the existing one using the given write options.
+func (r *RecordingRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	op := Op{Name: "SaveWithOpts", Filter: copyFilter(filter), Object: object}
+	saved, err := r.Repository.SaveWithOpts(object, filter, opts)
+	r.log.add(op, err)
+	return saved, err
+}
+
+// SaveWithTimestamp creates new record or updates the existing one and returns the time of the write.
+func (r *RecordingRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	op := Op{Name: "SaveWithTimestamp", Filter: copyFilter(filter), Object: object}
+	saved, committedAt, err := r.Repository.SaveWithTimestamp(object, filter)
+	r.log.add(op, err)
+	return saved, committedAt, err
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record.
+func (r *RecordingRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	op := Op{Name: "SaveUpsert", Filter: copyFilter(filter), Object: object}
+	saved, created, err := r.Repository.SaveUpsert(object, filter)
+	r.log.add(op, err)
+	return saved, created, err
+}
+
+// SaveIf updates the record matching the filter if it matches the condition.
+func (r *RecordingRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	op := Op{Name: "SaveIf", Filter: copyFilter(filter), Condition: copyFilter(condition), Object: object}
+	saved, updated, err := r.Repository.SaveIf(object, filter, condition)
+	r.log.add(op, err)
+	return saved, updated, err
+}
+
+// FindAndModify claims up to limit records matching the filter.
+func (r *RecordingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	op := Op{Name: "FindAndModify", Filter: copyFilter(filter), Object: update}
+	claimed, err := r.Repository.FindAndModify(filter, update, limit, sort)
+	r.log.add(op, err)
+	return claimed, err
+}
+
+// UpsertAll inserts or updates each of the objects.
+func (r *RecordingRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	op := Op{Name: "UpsertAll", Object: objects}
+	written, err := r.Repository.UpsertAll(objects, conflictKeys)
+	r.log.add(op, err)
+	return written, err
+}
+
+// Bulk returns a BulkOp whose execution is recorded as one operation.
+func (r *RecordingRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		op := Op{Name: "Bulk", Object: append([]BulkOperation{}, operations...)}
+		result, err := runBulk(r.Repository, operations)
+		r.log.add(op, err)
+		return result, err
+	})
+}
+
+// ReplaceOne replaces the record matching the filter.
+func (r *RecordingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	op := Op{Name: "ReplaceOne", Filter: copyFilter(filter), Object: object}
+	previous, err := r.Repository.ReplaceOne(filter, object)
+	r.log.add(op, err)
+	return previous, err
+}
+
+// DeleteOne deletes only one record for given filter.
+func (r *RecordingRepository) DeleteOne(filter Filter) error {
+	op := Op{Name: "DeleteOne", Filter: copyFilter(filter)}
+	err := r.Repository.DeleteOne(filter)
+	r.log.add(op, err)
+	return err
+}
+
+// DeleteIf deletes the record matching the filter if it matches the condition.
+func (r *RecordingRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	op := Op{Name: "DeleteIf", Filter: copyFilter(filter), Condition: copyFilter(condition)}
+	deleted, err := r.Repository.DeleteIf(filter, condition)
+	r.log.add(op, err)
+	return deleted, err
+}
+
+// DeleteAll deletes all matched records for given filter.
+func (r *RecordingRepository) DeleteAll(filter Filter) error {
+	op := Op{Name: "DeleteAll", Filter: copyFilter(filter)}
+	err := r.Repository.DeleteAll(filter)
+	r.log.add(op, err)
+	return err
+}
+
+// DeleteAllReturning deletes all matched records and returns their IDs.
+func (r *RecordingRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	op := Op{Name: "DeleteAllReturning", Filter: copyFilter(filter)}
+	ids, err := r.Repository.DeleteAllReturning(filter)
+	r.log.add(op, err)
+	return ids, err
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs.
+func (r *RecordingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	op := Op{Name: "UpdateFieldsReturning", Filter: copyFilter(filter), Object: fields}
+	ids, err := r.Repository.UpdateFieldsReturning(filter, fields)
+	r.log.add(op, err)
+	return ids, err
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records.
+func (r *RecordingRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	op := Op{Name: "ArrayAppend", Filter: copyFilter(filter), Object: map[string]interface{}{field: values}}
+	updated, err := r.Repository.ArrayAppend(filter, field, values...)
+	r.log.add(op, err)
+	return updated, err
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records.
+func (r *RecordingRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	op := Op{Name: "ArrayRemove", Filter: copyFilter(filter), Object: map[string]interface{}{field: values}}
+	updated, err := r.Repository.ArrayRemove(filter, field, values...)
+	r.log.add(op, err)
+	return updated, err
+}
+
+// UpdatePath sets the value at the path of the matched records.
+func (r *RecordingRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	op := Op{Name: "UpdatePath", Filter: copyFilter(filter), Object: map[string]interface{}{path: value}}
+	updated, err := r.Repository.UpdatePath(filter, path, value)
+	r.log.add(op, err)
+	return updated, err
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (r *RecordingRepository) Exists(filter Filter) (bool, error) {
+	op := Op{Name: "Exists", Filter: copyFilter(filter)}
+	exists, err := r.Repository.Exists(filter)
+	r.log.add(op, err)
+	return exists, err
+}
+
+// Count returns the number of records matching the filter.
+func (r *RecordingRepository) Count(filter Filter) (int, error) {
+	op := Op{Name: "Count", Filter: copyFilter(filter)}
+	count, err := r.Repository.Count(filter)
+	r.log.add(op, err)
+	return count, err
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records.
+func (r *RecordingRepository) CountBoth(filter Filter) (int64, int64, error) {
+	op := Op{Name: "CountBoth", Filter: copyFilter(filter)}
+	filtered, total, err := r.Repository.CountBoth(filter)
+	r.log.add(op, err)
+	return filtered, total, err
+}
+
+// GetByIDs fetches the records with the given IDs.
+func (r *RecordingRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	op := Op{Name: "GetByIDs", Object: ids}
+	results, err := r.Repository.GetByIDs(ids, resultHint)
+	r.log.add(op, err)
+	return results, err
+}
+
+// DescribeRepository returns the stats of the live collection/table.
+func (r *RecordingRepository) DescribeRepository() (RepositoryStats, error) {
+	op := Op{Name: "DescribeRepository"}
+	stats, err := r.Repository.DescribeRepository()
+	r.log.add(op, err)
+	return stats, err
+}
+
+// EnsureIndexes creates the indexes that the collection/table does not have yet.
+func (r *RecordingRepository) EnsureIndexes(indexes []Index) error {
+	op := Op{Name: "EnsureIndexes", Object: indexes}
+	err := r.Repository.EnsureIndexes(indexes)
+	r.log.add(op, err)
+	return err
+}
+
+// Migrate applies the migrations through the wrapper. The operations of the migrations are
+// recorded as well.
+func (r *RecordingRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	op := Op{Name: "Migrate", Object: []int{from, to}}
+	err := r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+	r.log.add(op, err)
+	return err
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *RecordingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context, which records to the same log.
+func (r *RecordingRepository) WithContext(ctx context.Context) Repository {
+	return &RecordingRepository{
+		Repository: r.Repository.WithContext(ctx),
+		log:        r.log,
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session, which records to the same log.
+func (r *RecordingRepository) WithSession(session *Session) Repository {
+	return &RecordingRepository{
+		Repository: r.Repository.WithSession(session),
+		log:        r.log,
+	}
+}
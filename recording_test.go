@@ -0,0 +1,42 @@
+package backends
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingRepository(t *testing.T) {
+	repo, log := NewRecordingRepository(newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}))
+
+	john := &memoryTestEntry{Name: "John", Email: "john@example.com"}
+	if _, err := repo.Save(john, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.WithContext(context.Background()).GetOne(NewFilter().Match("email", "john@example.com"), &memoryTestEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := log.Ops()
+	if len(ops) != 2 {
+		t.Fatal("Expected two recorded operations. Got: ", ops)
+	}
+	if ops[0].Name != "Save" || ops[0].Object != john || ops[0].Filter != nil || ops[0].Err != nil {
+		t.Fatal("Expected the save of the object to be recorded first. Got: ", ops[0])
+	}
+	if ops[1].Name != "GetOne" || !reflect.DeepEqual(ops[1].Filter, Filter{"email": "john@example.com"}) || ops[1].Object != nil {
+		t.Fatal("Expected the get by the email to be recorded second. Got: ", ops[1])
+	}
+
+	if _, err := repo.GetOne(NewFilter().Match("email", "jane@example.com"), &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected the record not to be found. Got: ", err)
+	}
+	if names := log.Names(); !strArrEq(names, []string{"Save", "GetOne", "GetOne"}) || !IsErrNotFound(log.Ops()[2].Err) {
+		t.Fatal("Expected the failed get to be recorded with the error. Got: ", names)
+	}
+
+	log.Reset()
+	if ops := log.Ops(); len(ops) != 0 {
+		t.Fatal("Expected no operations after the reset. Got: ", ops)
+	}
+}
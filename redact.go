@@ -0,0 +1,229 @@
+package backends
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RedactingRepository empties the named fields, like the PII, in the records read from the
+// wrapped repository, for the logs and the callers with lower privileges:
+// 		publicUsers := backends.NewRedactingRepository(userRepo, "email", "ssn")
+//
+// The fields are emptied in the results of the reads (GetOne, GetAll and their variants,
+// GetByIDs, QueryRange and ParallelScan), of the writes that return the stored records
+// (FindAndModify, ReplaceOne, DeleteAllReturning and UpdateFieldsReturning) and in the documents
+// of Watch: a struct field is set to its zero value, and a map key is removed. The fields are named as the
+// stored properties (the bson or json tag) or as the Go fields of the structs, and only the
+// top-level fields are redacted.
+//
+// The filters are passed unchanged, so the records can still be queried by the redacted fields,
+// and the writes are passed unchanged too.
+type RedactingRepository struct {
+	Repository
+	fields map[string]bool
+}
+
+// NewRedactingRepository wraps the repository so the fields are emptied in the records it reads.
+func NewRedactingRepository(repo Repository, fields ...string) *RedactingRepository {
+	redacted := map[string]bool{}
+	for _, field := range fields {
+		redacted[field] = true
+	}
+	return &RedactingRepository{
+		Repository: repo,
+		fields:     redacted,
+	}
+}
+
+// redact empties the redacted fields of the records of the result, a record or a slice of the
+// records, or pointers to them, and returns the result.
+func (r *RedactingRepository) redact(result interface{}) interface{} {
+	if result != nil {
+		r.redactValue(reflect.ValueOf(result))
+	}
+	return result
+}
+
+func (r *RedactingRepository) redactValue(value reflect.Value) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			r.redactValue(value.Index(i))
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, key := range value.MapKeys() {
+			if r.fields[key.String()] {
+				value.SetMapIndex(key, reflect.Value{})
+			}
+		}
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			field := valueType.Field(i)
+			if !r.redacts(field) || !value.Field(i).CanSet() {
+				continue
+			}
+			value.Field(i).Set(reflect.Zero(field.Type))
+		}
+	}
+}
+
+// redacts checks if the struct field is redacted, by its stored name or its Go name.
+func (r *RedactingRepository) redacts(field reflect.StructField) bool {
+	if r.fields[field.Name] {
+		return true
+	}
+	for _, tagName := range []string{"bson", "json"} {
+		if tag, ok := field.Tag.Lookup(tagName); ok {
+			name := strings.Split(tag, ",")[0]
+			if name != "" && r.fields[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactAll empties the redacted fields of the records.
+func (r *RedactingRepository) redactAll(records []interface{}) []interface{} {
+	for _, record := range records {
+		r.redact(record)
+	}
+	return records
+}
+
+// GetOne fetches only one record for given filter, with the redacted fields emptied.
+func (r *RedactingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	record, err := r.Repository.GetOne(filter, result)
+	return r.redact(record), err
+}
+
+// GetOneWithOpts fetches one record with the given read options, with the redacted fields emptied.
+func (r *RedactingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	record, err := r.Repository.GetOneWithOpts(filter, result, opts)
+	return r.redact(record), err
+}
+
+// GetAll fetches all matched records, with the redacted fields emptied.
+func (r *RedactingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	results, err := r.Repository.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+	return r.redact(results), err
+}
+
+// GetFirst fetches the first matched record in the given order, with the redacted fields emptied.
+func (r *RedactingRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	record, err := r.Repository.GetFirst(filter, resultsTypeHint, order, sorting)
+	return r.redact(record), err
+}
+
+// GetAllWithOpts fetches all matched records with the given read options, with the redacted
+// fields emptied.
+func (r *RedactingRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	results, err := r.Repository.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	return r.redact(results), err
+}
+
+// GetAllByIndex fetches the records matched with the index, with the redacted fields emptied.
+func (r *RedactingRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	results, err := r.Repository.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+	return r.redact(results), err
+}
+
+// GetAllWithHint fetches all matched records using the named index, with the redacted fields
+// emptied.
+func (r *RedactingRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	results, err := r.Repository.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+	return r.redact(results), err
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition, with the
+// redacted fields emptied.
+func (r *RedactingRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	results, err := r.Repository.QueryRange(hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+	return r.redact(results), err
+}
+
+// ParallelScan scans the matched records in segments, passing the batches to fn with the
+// redacted fields emptied.
+func (r *RedactingRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	return r.Repository.ParallelScan(filter, segments, resultsTypeHint, func(batch interface{}) error {
+		return fn(r.redact(batch))
+	})
+}
+
+// GetByIDs fetches the records with the given IDs, with the redacted fields emptied.
+func (r *RedactingRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	results, err := r.Repository.GetByIDs(ids, resultHint)
+	return r.redact(results), err
+}
+
+// FindAndModify claims the matched records and returns them with the redacted fields emptied.
+func (r *RedactingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	results, err := r.Repository.FindAndModify(filter, update, limit, sort)
+	return r.redact(results), err
+}
+
+// ReplaceOne replaces the matched record and returns the previous record with the redacted fields
+// emptied.
+func (r *RedactingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	previous, err := r.Repository.ReplaceOne(filter, object)
+	return r.redact(previous), err
+}
+
+// DeleteAllReturning deletes the matched records and returns them with the redacted fields
+// emptied.
+func (r *RedactingRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	records, err := r.Repository.DeleteAllReturning(filter)
+	return r.redactAll(records), err
+}
+
+// UpdateFieldsReturning updates the matched records and returns them with the redacted fields
+// emptied.
+func (r *RedactingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	records, err := r.Repository.UpdateFieldsReturning(filter, fields)
+	return r.redactAll(records), err
+}
+
+// Watch subscribes to the changes of the records, sent with the redacted fields emptied.
+func (r *RedactingRepository) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	events, err := r.Repository.Watch(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return mapChangeEvents(ctx, events, func(event ChangeEvent) ChangeEvent {
+		r.redact(event.Document)
+		return event
+	}), nil
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent. The
+// record of a repeated key is returned with the redacted fields emptied.
+func (r *RedactingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	result, deduped, err := r.Repository.SaveIdempotent(object, idempotencyKey, ttl)
+	if deduped {
+		r.redact(result)
+	}
+	return result, deduped, err
+}
+
+// WithContext returns a copy of the repository bound to the context.
+func (r *RedactingRepository) WithContext(ctx context.Context) Repository {
+	return &RedactingRepository{Repository: r.Repository.WithContext(ctx), fields: r.fields}
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *RedactingRepository) WithSession(session *Session) Repository {
+	return &RedactingRepository{Repository: r.Repository.WithSession(session), fields: r.fields}
+}
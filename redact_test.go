@@ -0,0 +1,61 @@
+package backends
+
+import "testing"
+
+type redactTestEntry struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	SSN   string `json:"ssn"`
+	Age   int    `json:"age"`
+}
+
+func TestRedactingRepository(t *testing.T) {
+	collection := NewMemoryCollection(RepositoryDefinitionMap{"name": "users", "customId": true})
+	repo := NewRedactingRepository(collection, "email", "SSN")
+
+	if _, err := repo.Save(&redactTestEntry{ID: "1", Name: "John", Email: "john@example.com", SSN: "078-05-1120", Age: 30}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.GetOne(NewFilter().Match("email", "john@example.com"), &redactTestEntry{})
+	if err != nil {
+		t.Fatal("Expected the filter on the redacted field to match. Got: ", err)
+	}
+	expected := redactTestEntry{ID: "1", Name: "John", Age: 30}
+	if entry := result.(*redactTestEntry); *entry != expected {
+		t.Fatalf("Expected %+v. Got: %+v", expected, *entry)
+	}
+
+	results, err := repo.GetAll(nil, &map[string]interface{}{}, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := *(results.(*[]*map[string]interface{}))
+	if len(records) != 1 {
+		t.Fatalf("Expected one record. Got: %v", records)
+	}
+	record := *records[0]
+	if _, ok := record["email"]; ok {
+		t.Fatalf("Expected the email to be removed. Got: %v", record)
+	}
+	if record["name"] != "John" || record["ssn"] != "078-05-1120" {
+		t.Fatalf("Expected the other fields intact, and the ssn of the map not matched by the Go name. Got: %v", record)
+	}
+
+	previous, err := repo.ReplaceOne(NewFilter().Match("id", "1"), &redactTestEntry{Name: "Johnny", Email: "johnny@example.com", SSN: "078-05-1120", Age: 31})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := previous.(*redactTestEntry); *entry != expected {
+		t.Fatalf("Expected the previous record %+v with the redacted fields emptied. Got: %+v", expected, *entry)
+	}
+
+	stored, err := GetOneRaw(collection, NewFilter().Match("id", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored["email"] != "johnny@example.com" {
+		t.Fatalf("Expected the stored email to be intact. Got: %v", stored)
+	}
+}
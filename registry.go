@@ -0,0 +1,76 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// registeredBackend is a backend builder registered with Register.
+type registeredBackend struct {
+	builder BackendBuilder
+	schema  []PropertySpec
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]registeredBackend{}
+)
+
+// Register makes a backend builder available to the managers created with
+// NewBackendManagerFromRegistry, like the drivers of database/sql. It is meant to be called
+// from the init function of the package that implements the backend:
+//
+// 		func init() {
+// 			backends.Register("redis", RedisBackendBuilder, map[string]interface{}{
+// 				"dbName": "string",
+// 				"host":   "string",
+// 			})
+// 		}
+//
+// The properties are the configuration properties of the backend, like in SupportBackend.
+// Register panics if the builder is nil or if the backend type is already registered.
+func Register(backendType string, builder BackendBuilder, properties map[string]interface{}) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if builder == nil {
+		panic("backends: Register builder is nil")
+	}
+	if _, exists := registry[backendType]; exists {
+		panic(fmt.Sprintf("backends: Register called twice for backend %s", backendType))
+	}
+	registry[backendType] = registeredBackend{
+		builder: builder,
+		schema:  schemaFromProperties(properties),
+	}
+}
+
+// RegisteredBackends returns the types of the backends registered with Register.
+func RegisteredBackends() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	backendTypes := []string{}
+	for backendType := range registry {
+		backendTypes = append(backendTypes, backendType)
+	}
+	return backendTypes
+}
+
+// NewBackendManagerFromRegistry creates a backend manager that supports the built-in backends
+// (see NewBackendSupport) and all the backends registered with Register. A registered backend
+// replaces the built-in backend of the same type. More backends can still be added to the
+// manager with SupportBackend.
+func NewBackendManagerFromRegistry(dbConfig map[string]*config.DBInfo) BackendManager {
+	manager := NewBackendSupport(dbConfig)
+
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	for backendType, registered := range registry {
+		manager.SupportBackendWithSchema(backendType, registered.builder, registered.schema)
+	}
+	return manager
+}
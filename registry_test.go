@@ -0,0 +1,58 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// unregister removes the backend registered by a test.
+func unregister(backendType string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	delete(registry, backendType)
+}
+
+func TestRegister(t *testing.T) {
+	Register("registered", MemoryBackendBuilder, map[string]interface{}{
+		"dbName": "string",
+	})
+	defer unregister("registered")
+
+	if !containsString(RegisteredBackends(), "registered") {
+		t.Fatal("Expected the backend to be registered. Got: ", RegisteredBackends())
+	}
+
+	manager := NewBackendManagerFromRegistry(map[string]*config.DBInfo{
+		"registered": &config.DBInfo{DatabaseName: "test"},
+		"memory":     &config.DBInfo{DatabaseName: "test"},
+	})
+
+	backend, err := manager.GetBackend("registered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.GetBackend("memory"); err != nil {
+		t.Fatal("Expected the built-in backends to be supported. Got: ", err)
+	}
+	props, err := manager.GetRequiredBackendProperties("registered")
+	if err != nil || props["dbName"] != "string" {
+		t.Fatal("Expected the properties of the registered backend. Got: ", props, err)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	Register("registered-twice", MemoryBackendBuilder, map[string]interface{}{})
+	defer unregister("registered-twice")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected registering the same backend twice to panic")
+		}
+	}()
+	Register("registered-twice", MemoryBackendBuilder, map[string]interface{}{})
+}
@@ -0,0 +1,166 @@
+package backends
+
+import (
+	"context"
+	"time"
+)
+
+// ReadEndpointSuffix is appended to the backend type to get the configuration of the read
+// endpoint (read replica) of the backend. For example, with the configuration:
+// 		{
+// 			"mongodb":      {"host": "primary:27017", ...},
+// 			"mongodb-read": {"host": "replica:27017", ...}
+// 		}
+// the reads of the mongodb repositories go to "replica:27017" and the writes to "primary:27017".
+const ReadEndpointSuffix = "-read"
+
+// READ_REPLICA_CTX_KEY is set in the context of the backends that serve as a read endpoint
+var READ_REPLICA_CTX_KEY = "READ_REPLICA"
+
+// IsReadReplica checks if the backend serves as a read endpoint of another backend.
+// The repository builders must not create collections/tables or indexes on a read replica.
+func IsReadReplica(backend Backend) bool {
+	isReplica, _ := backend.GetFromContext(READ_REPLICA_CTX_KEY).(bool)
+	return isReplica
+}
+
+// ReadWriteRepository routes the reads to the replica repository and the writes to the
+// primary repository. The reads with ReadOpts.ForcePrimary, ReadOpts.Consistent or the
+// primary ReadOpts.ReadPreference set go to the primary repository.
+type ReadWriteRepository struct {
+	Repository
+	replica Repository
+	stats   *queryStatsRecorder
+}
+
+// NewReadWriteRepository creates a repository that writes to primary and reads from replica.
+func NewReadWriteRepository(primary, replica Repository) *ReadWriteRepository {
+	return &ReadWriteRepository{
+		Repository: primary,
+		replica:    replica,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// Primary returns the repository of the primary endpoint.
+func (r *ReadWriteRepository) Primary() Repository {
+	return r.Repository
+}
+
+// Replica returns the repository of the read endpoint.
+func (r *ReadWriteRepository) Replica() Repository {
+	return r.replica
+}
+
+func (r *ReadWriteRepository) reader(opts ReadOpts) Repository {
+	if opts.ForcePrimary || opts.Consistent || opts.ReadPreference.isPrimary() {
+		return r.Repository
+	}
+	return r.replica
+}
+
+// GetOne looks up for an entry on the read endpoint.
+func (r *ReadWriteRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.replica.GetOne(filter, result)
+}
+
+// GetOneWithOpts looks up for an entry on the endpoint selected by the read options.
+func (r *ReadWriteRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	return r.reader(opts).GetOneWithOpts(filter, result, opts)
+}
+
+// GetAll returns all matched entries from the read endpoint.
+func (r *ReadWriteRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	results, err := r.replica.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+	r.stats.set(r.replica.LastQueryStats())
+	return results, err
+}
+
+// GetFirst returns the first matched entry from the read endpoint.
+func (r *ReadWriteRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	results, err := r.replica.GetFirst(filter, resultsTypeHint, order, sorting)
+	r.stats.set(r.replica.LastQueryStats())
+	return results, err
+}
+
+// GetAllWithOpts returns all matched entries from the endpoint selected by the read options.
+func (r *ReadWriteRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	reader := r.reader(opts)
+	results, err := reader.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+	r.stats.set(reader.LastQueryStats())
+	return results, err
+}
+
+// GetAllByIndex returns all matched entries from the read endpoint using the named index.
+func (r *ReadWriteRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	results, err := r.replica.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+	r.stats.set(r.replica.LastQueryStats())
+	return results, err
+}
+
+// QueryRange returns the entries matching the hash key value and the range condition from the
+// read endpoint.
+func (r *ReadWriteRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	results, err := r.replica.QueryRange(hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+	r.stats.set(r.replica.LastQueryStats())
+	return results, err
+}
+
+// GetAllWithHint returns all matched entries from the read endpoint using the index hint.
+func (r *ReadWriteRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	results, err := r.replica.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+	r.stats.set(r.replica.LastQueryStats())
+	return results, err
+}
+
+// ParallelScan reads all matched entries from the read endpoint.
+func (r *ReadWriteRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	return r.replica.ParallelScan(filter, segments, resultsTypeHint, fn)
+}
+
+// LastQueryStats returns the stats of the most recent GetAll of the repository, from the endpoint
+// that served it.
+func (r *ReadWriteRepository) LastQueryStats() QueryStats {
+	return r.stats.get()
+}
+
+// Exists checks the read endpoint for an entry matching the filter.
+func (r *ReadWriteRepository) Exists(filter Filter) (bool, error) {
+	return r.replica.Exists(filter)
+}
+
+// Count returns the number of matched entries on the read endpoint.
+func (r *ReadWriteRepository) Count(filter Filter) (int, error) {
+	return r.replica.Count(filter)
+}
+
+// CountBoth returns the number of matched entries and of all the entries on the read endpoint.
+func (r *ReadWriteRepository) CountBoth(filter Filter) (int64, int64, error) {
+	return r.replica.CountBoth(filter)
+}
+
+// GetByIDs returns the entries with the given IDs from the read endpoint.
+func (r *ReadWriteRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	return r.replica.GetByIDs(ids, resultHint)
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *ReadWriteRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *ReadWriteRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository with both endpoints bound to the context.
+func (r *ReadWriteRepository) WithContext(ctx context.Context) Repository {
+	return NewReadWriteRepository(r.Repository.WithContext(ctx), r.replica.WithContext(ctx))
+}
+
+// WithSession returns the primary repository bound to the session, so the reads of the session
+// go to the primary along with the writes.
+func (r *ReadWriteRepository) WithSession(session *Session) Repository {
+	return r.Repository.WithSession(session)
+}
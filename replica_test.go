@@ -0,0 +1,114 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+func TestReadWriteRouting(t *testing.T) {
+	bm := NewBackendSupport(map[string]*config.DBInfo{
+		"memory":      &config.DBInfo{},
+		"memory-read": &config.DBInfo{},
+	})
+
+	backend, err := bm.GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, ok := repo.(*ReadWriteRepository)
+	if !ok {
+		t.Fatalf("Expected a read/write repository. Got: %T", repo)
+	}
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter().Match("id", result.(*memoryTestEntry).ID)
+
+	// the two endpoints are separate in-memory stores, so the replica never sees the write
+	if count, _ := rw.Primary().Count(NewFilter()); count != 1 {
+		t.Fatal("Expected the write to go to the primary. Count: ", count)
+	}
+	if count, _ := repo.Count(NewFilter()); count != 0 {
+		t.Fatal("Expected the count to be read from the replica. Count: ", count)
+	}
+
+	if _, err = repo.GetOne(filter, &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected the read to go to the replica. Got: ", err)
+	}
+
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOneWithOpts(filter, entry, ForcePrimary()); err != nil {
+		t.Fatal("Expected the forced read to go to the primary. Got: ", err)
+	}
+	if entry.Name != "John" {
+		t.Fatal("Expected to read the written entry from the primary. Got: ", entry)
+	}
+
+	if _, err = rw.Replica().Save(&memoryTestEntry{Name: "Jane"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	results, err := repo.GetAll(NewFilter(), &memoryTestEntry{}, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := *(results.(*[]*memoryTestEntry))
+	if len(entries) != 1 || entries[0].Name != "Jane" {
+		t.Fatal("Expected GetAll to read from the replica. Got: ", entries)
+	}
+}
+
+func TestSessionReadYourWrites(t *testing.T) {
+	bm := NewBackendSupport(map[string]*config.DBInfo{
+		"memory":      &config.DBInfo{},
+		"memory-read": &config.DBInfo{},
+	})
+
+	backend, err := bm.GetBackend("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := backend.StartSession()
+	defer session.End()
+	sessionRepo := repo.WithSession(session)
+
+	result, err := sessionRepo.Save(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter().Match("id", result.(*memoryTestEntry).ID)
+
+	entry := &memoryTestEntry{}
+	if _, err := sessionRepo.GetOne(filter, entry); err != nil {
+		t.Fatal("Expected the read of the session to observe its write. Got: ", err)
+	}
+	if entry.Name != "John" {
+		t.Fatal("Expected to read the written entry. Got: ", entry)
+	}
+	if count, _ := sessionRepo.Count(NewFilter()); count != 1 {
+		t.Fatal("Expected the count of the session to observe its write. Count: ", count)
+	}
+
+	// the reads outside of the session still go to the replica
+	if _, err := repo.GetOne(filter, &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected the read outside of the session to go to the replica. Got: ", err)
+	}
+
+	session.End()
+	if !session.Ended() {
+		t.Fatal("Expected the session to be ended")
+	}
+}
@@ -0,0 +1,64 @@
+package backends
+
+import (
+	"testing"
+)
+
+// checkSaveContract checks that Save returns the saved object with the fields generated by
+// the backend populated, as documented on Repository.Save.
+func checkSaveContract(t *testing.T, repo Repository) {
+	entry := &memoryTestEntry{Name: "John", Email: "john@example.com"}
+	result, err := repo.Save(entry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, ok := result.(*memoryTestEntry)
+	if !ok {
+		t.Fatalf("Expected the result to be of the object type. Got: %T", result)
+	}
+	if saved != entry {
+		t.Fatal("Expected the saved object to be returned")
+	}
+	if saved.ID == "" || saved.Name != "John" {
+		t.Fatal("Expected the generated ID and the saved fields to be populated. Got: ", saved)
+	}
+
+	object := &map[string]interface{}{"name": "Jane"}
+	result, err = repo.Save(object, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, ok := result.(*map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected the result to be of the object type. Got: %T", result)
+	}
+	if id, _ := (*record)["id"].(string); id == "" {
+		t.Fatal("Expected the generated ID to be populated in the map. Got: ", *record)
+	}
+
+	update := &map[string]interface{}{"name": "Johnny"}
+	result, err = repo.Save(update, NewFilter().Match("id", saved.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := *(result.(*map[string]interface{}))
+	if updated["id"] != saved.ID || updated["name"] != "Johnny" || updated["email"] != "john@example.com" {
+		t.Fatal("Expected the updated record to be populated. Got: ", updated)
+	}
+}
+
+func TestMemorySaveContract(t *testing.T) {
+	checkSaveContract(t, newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}))
+}
+
+func TestDynamoSaveContract(t *testing.T) {
+	repo, closeServer := newFakeDynamoTable(t, "id").repository(RepositoryDefinitionMap{"name": "users", "hashKey": "id"})
+	defer closeServer()
+	checkSaveContract(t, repo)
+}
+
+func TestFieldMappingSaveContract(t *testing.T) {
+	checkSaveContract(t, newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"}.WithFieldMapping(map[string]string{
+		"name": "full_name",
+	})))
+}
@@ -0,0 +1,380 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoSupportingIndex is the error wrapped by NoSupportingIndexError. It is of the
+// ErrInvalidInput class.
+var ErrNoSupportingIndex = ErrInvalidInput("no supporting index")
+
+// NoSupportingIndexError is returned by the operations of a RequireIndexRepository whose filter is
+// not backed by an index, instead of scanning the whole collection/table. Nothing is read or
+// written. Use errors.As to get the operation and the fields of the filter:
+// 		var indexErr backends.NoSupportingIndexError
+// 		if errors.As(err, &indexErr) {
+// 			fmt.Println(indexErr.Operation, indexErr.Fields)
+// 		}
+type NoSupportingIndexError struct {
+	// Operation is the name of the method of the repository, like "GetAll".
+	Operation string
+	// Fields are the fields of the filter, sorted.
+	Fields []string
+}
+
+// Error returns the error message.
+func (e NoSupportingIndexError) Error() string {
+	return fmt.Sprintf("no supporting index: %s with a filter on [%s] would scan the whole collection",
+		e.Operation, strings.Join(e.Fields, ", "))
+}
+
+// Unwrap returns ErrNoSupportingIndex, so errors.Is(err, ErrNoSupportingIndex) and
+// IsErrInvalidInput report true for a NoSupportingIndexError.
+func (e NoSupportingIndexError) Unwrap() error {
+	return ErrNoSupportingIndex
+}
+
+// IsErrNoSupportingIndex checks if the error is a NoSupportingIndexError.
+func IsErrNoSupportingIndex(err error) bool {
+	return errors.Is(err, ErrNoSupportingIndex)
+}
+
+// unindexedQueriesKey is the context key of the override of the RequireIndexRepository.
+type unindexedQueriesKey struct{}
+
+// AllowUnindexedQueries returns a copy of the context that allows the queries that are not backed
+// by an index on the repositories bound to it with Repository.WithContext:
+// 		report, err := userRepo.WithContext(backends.AllowUnindexedQueries(ctx)).GetAll(filter, ...)
+func AllowUnindexedQueries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unindexedQueriesKey{}, true)
+}
+
+// unindexedQueriesAllowed checks if the context allows the queries that are not backed by an index.
+func unindexedQueriesAllowed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	allowed, _ := ctx.Value(unindexedQueriesKey{}).(bool)
+	return allowed
+}
+
+// RequireIndexRepository refuses the operations of the wrapped repository whose filter is not
+// backed by an index with NoSupportingIndexError, so the accidental full scans are caught, for
+// example in staging, before they time out in production. A filter is backed by an index when
+// Repository.UsesIndex reports an index for it, that is, when it matches all the fields of an
+// index exactly. The ranges and the other operators are not considered backed, nor is an empty
+// filter.
+//
+// The reads with a filter, the updates and the deletes of the matched records (also in Bulk) are
+// checked. GetAllByIndex, GetAllWithHint and QueryRange name the index they use, and ParallelScan
+// is a scan by intent, so they are not checked.
+//
+// The intentionally unindexed queries are allowed by the fields of their filters: a filter whose
+// fields are exactly the fields of an entry of the allowlist is not checked. An empty entry
+// allows the empty filter. A single query can be allowed with AllowUnindexedQueries.
+//
+// The repositories of a backend are wrapped with it when the "requireIndex" entry of the
+// repository definition is set; the allowlist is the "unindexedQueries" entry.
+type RequireIndexRepository struct {
+	Repository
+	allowed map[string]bool
+	skip    bool
+}
+
+// NewRequireIndexRepository wraps the repository so its operations with filters that are not
+// backed by an index fail. The allowed are the fields of the filters of the intentionally
+// unindexed queries.
+func NewRequireIndexRepository(repo Repository, allowed [][]string) *RequireIndexRepository {
+	allowlist := map[string]bool{}
+	for _, fields := range allowed {
+		allowlist[fieldSetKey(fields)] = true
+	}
+	return &RequireIndexRepository{
+		Repository: repo,
+		allowed:    allowlist,
+	}
+}
+
+// fieldSetKey returns the key of the set of the fields, regardless of their order.
+func fieldSetKey(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
+
+// check checks that the filter of the operation is backed by an index or allowed.
+func (r *RequireIndexRepository) check(operation string, filter Filter) error {
+	if r.skip {
+		return nil
+	}
+	fields := make([]string, 0, len(filter))
+	for field := range filter {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	if r.allowed[fieldSetKey(fields)] {
+		return nil
+	}
+	if len(filter) > 0 {
+		if _, ok := r.Repository.UsesIndex(filter); ok {
+			return nil
+		}
+	}
+	return NoSupportingIndexError{Operation: operation, Fields: fields}
+}
+
+// GetOne fetches only one record for given filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	if err := r.check("GetOne", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetOne(filter, result)
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options, if the
+// filter is backed by an index.
+func (r *RequireIndexRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	if err := r.check("GetOneWithOpts", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetOneWithOpts(filter, result, opts)
+}
+
+// GetAll fetches all matched records for given filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	if err := r.check("GetAll", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+// GetFirst fetches the first of the matched records in the given order, if the filter is backed
+// by an index.
+func (r *RequireIndexRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	if err := r.check("GetFirst", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetFirst(filter, resultsTypeHint, order, sorting)
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options, if
+// the filter is backed by an index.
+func (r *RequireIndexRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	if err := r.check("GetAllWithOpts", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+}
+
+// Save creates a new record, or updates the record matching the filter if the filter is backed
+// by an index.
+func (r *RequireIndexRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	if filter != nil {
+		if err := r.check("Save", filter); err != nil {
+			return nil, err
+		}
+	}
+	return r.Repository.Save(object, filter)
+}
+
+// SaveWithOpts creates a new record, or updates the record matching the filter if the filter is
+// backed by an index, using the given write options.
+func (r *RequireIndexRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	if filter != nil {
+		if err := r.check("SaveWithOpts", filter); err != nil {
+			return nil, err
+		}
+	}
+	return r.Repository.SaveWithOpts(object, filter, opts)
+}
+
+// SaveWithTimestamp creates a new record, or updates the record matching the filter if the
+// filter is backed by an index, and returns the time of the write.
+func (r *RequireIndexRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	if filter != nil {
+		if err := r.check("SaveWithTimestamp", filter); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return r.Repository.SaveWithTimestamp(object, filter)
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record, if the filter is
+// backed by an index.
+func (r *RequireIndexRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	if err := r.check("SaveUpsert", filter); err != nil {
+		return nil, false, err
+	}
+	return r.Repository.SaveUpsert(object, filter)
+}
+
+// SaveIf updates the record matching the filter if it matches the condition, if the filter is
+// backed by an index.
+func (r *RequireIndexRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	if err := r.check("SaveIf", filter); err != nil {
+		return nil, false, err
+	}
+	return r.Repository.SaveIf(object, filter, condition)
+}
+
+// FindAndModify claims the records matching the filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	if err := r.check("FindAndModify", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.FindAndModify(filter, update, limit, sort)
+}
+
+// ReplaceOne replaces the record matching the filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	if err := r.check("ReplaceOne", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.ReplaceOne(filter, object)
+}
+
+// DeleteOne deletes the record matching the filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) DeleteOne(filter Filter) error {
+	if err := r.check("DeleteOne", filter); err != nil {
+		return err
+	}
+	return r.Repository.DeleteOne(filter)
+}
+
+// DeleteIf deletes the record matching the filter if it matches the condition, if the filter is
+// backed by an index.
+func (r *RequireIndexRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	if err := r.check("DeleteIf", filter); err != nil {
+		return false, err
+	}
+	return r.Repository.DeleteIf(filter, condition)
+}
+
+// DeleteAll deletes all the records matching the filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) DeleteAll(filter Filter) error {
+	if err := r.check("DeleteAll", filter); err != nil {
+		return err
+	}
+	return r.Repository.DeleteAll(filter)
+}
+
+// DeleteAllReturning deletes all the records matching the filter and returns their IDs, if the
+// filter is backed by an index.
+func (r *RequireIndexRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	if err := r.check("DeleteAllReturning", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.DeleteAllReturning(filter)
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs, if the
+// filter is backed by an index.
+func (r *RequireIndexRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	if err := r.check("UpdateFieldsReturning", filter); err != nil {
+		return nil, err
+	}
+	return r.Repository.UpdateFieldsReturning(filter, fields)
+}
+
+// Exists checks if there is at least one record matching the filter, if the filter is backed by
+// an index.
+func (r *RequireIndexRepository) Exists(filter Filter) (bool, error) {
+	if err := r.check("Exists", filter); err != nil {
+		return false, err
+	}
+	return r.Repository.Exists(filter)
+}
+
+// Count returns the number of records matching the filter, if the filter is backed by an index.
+func (r *RequireIndexRepository) Count(filter Filter) (int, error) {
+	if err := r.check("Count", filter); err != nil {
+		return 0, err
+	}
+	return r.Repository.Count(filter)
+}
+
+// CountBoth returns the number of records matching the filter, if the filter is backed by an
+// index, and the number of all the records.
+func (r *RequireIndexRepository) CountBoth(filter Filter) (int64, int64, error) {
+	if err := r.check("CountBoth", filter); err != nil {
+		return 0, 0, err
+	}
+	return r.Repository.CountBoth(filter)
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records, if the
+// filter is backed by an index.
+func (r *RequireIndexRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := r.check("ArrayAppend", filter); err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayAppend(filter, field, values...)
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records, if the
+// filter is backed by an index.
+func (r *RequireIndexRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	if err := r.check("ArrayRemove", filter); err != nil {
+		return 0, err
+	}
+	return r.Repository.ArrayRemove(filter, field, values...)
+}
+
+// UpdatePath sets the value at the path of the matched records, if the filter is backed by an
+// index.
+func (r *RequireIndexRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	if err := r.check("UpdatePath", filter); err != nil {
+		return 0, err
+	}
+	return r.Repository.UpdatePath(filter, path, value)
+}
+
+// Bulk returns a BulkOp that checks the filters of the updates and the deletes before any of the
+// operations is executed. None of the operations is executed if a filter is not backed by an index.
+func (r *RequireIndexRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		for _, operation := range operations {
+			if operation.Kind == BulkInsert {
+				continue
+			}
+			if err := r.check("Bulk", operation.Filter); err != nil {
+				return BulkResult{}, err
+			}
+		}
+		return runBulk(r.Repository, operations)
+	})
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *RequireIndexRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *RequireIndexRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context. The filters are not checked
+// if the context is derived with AllowUnindexedQueries.
+func (r *RequireIndexRepository) WithContext(ctx context.Context) Repository {
+	return &RequireIndexRepository{
+		Repository: r.Repository.WithContext(ctx),
+		allowed:    r.allowed,
+		skip:       unindexedQueriesAllowed(ctx),
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *RequireIndexRepository) WithSession(session *Session) Repository {
+	return &RequireIndexRepository{
+		Repository: r.Repository.WithSession(session),
+		allowed:    r.allowed,
+		skip:       r.skip,
+	}
+}
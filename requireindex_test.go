@@ -0,0 +1,45 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRequireIndex(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":             "users",
+		"indexes":          []Index{NewNonUniqueIndex("email")},
+		"requireIndex":     true,
+		"unindexedQueries": []interface{}{[]interface{}{"age"}},
+	})
+	for _, name := range []string{"John", "Jane"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name, Email: name + "@example.com", Age: 30}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := repo.GetAll(NewFilter().Match("name", "John"), &memoryTestEntry{}, "", "", 0, 0)
+	var indexErr NoSupportingIndexError
+	if !errors.As(err, &indexErr) || indexErr.Operation != "GetAll" || len(indexErr.Fields) != 1 || indexErr.Fields[0] != "name" {
+		t.Fatal("Expected the unindexed filter to be refused. Got: ", err)
+	}
+	if !IsErrNoSupportingIndex(err) || !IsErrInvalidInput(err) {
+		t.Fatal("Expected IsErrNoSupportingIndex and IsErrInvalidInput to report the error.")
+	}
+	if err = repo.DeleteAll(NewFilter()); !IsErrNoSupportingIndex(err) {
+		t.Fatal("Expected the delete with an empty filter to be refused. Got: ", err)
+	}
+
+	entry := &memoryTestEntry{}
+	if _, err = repo.GetOne(NewFilter().Match("email", "John@example.com"), entry); err != nil || entry.Name != "John" {
+		t.Fatal("Expected the indexed filter to be allowed. Got: ", entry, err)
+	}
+	if count, err := repo.Count(NewFilter().Match("age", 30)); err != nil || count != 2 {
+		t.Fatal("Expected the allowlisted filter to be allowed. Got: ", count, err)
+	}
+	ctx := AllowUnindexedQueries(context.Background())
+	if count, err := repo.WithContext(ctx).Count(NewFilter()); err != nil || count != 2 {
+		t.Fatal("Expected the overridden check to allow the unindexed filter. Got: ", count, err)
+	}
+}
@@ -0,0 +1,393 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// RetryPolicy is the policy of retrying the failed operations of a RetryRepository.
+type RetryPolicy struct {
+	// Attempts is the maximal number of attempts of an operation, including the first one. Less
+	// than 2 means the operations are not retried.
+	Attempts int
+	// Backoff is the wait before the first retry. The wait is doubled for each next retry.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between the retries. Zero means no cap.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the wait, between 0 and 1, that is random.
+	Jitter float64
+	// AttemptTimeout limits the duration of each attempt. Zero means the attempts are limited
+	// only by the deadline of the context of the repository, if any.
+	AttemptTimeout time.Duration
+	// TotalBudget makes the whole operation, including the retries and the waits between them,
+	// respect the deadline of the context of the repository: each attempt gets the remaining time
+	// to the deadline divided by the number of the remaining attempts (and at most the
+	// AttemptTimeout, if set), and a retry whose wait would end after the deadline is not made.
+	// Without a deadline in the context, the attempts are limited by the AttemptTimeout only.
+	TotalBudget bool
+	// Retryable reports if the error of an attempt is retried. By default the connection errors
+	// (see ErrConnectionFailed), the throttled operations (see ErrThrottled) and the attempts that
+	// timed out are retried.
+	Retryable func(err error) bool
+}
+
+// wait returns the wait before the retry (counted from 1), with the jitter applied.
+func (p RetryPolicy) wait(retry int) time.Duration {
+	return backoffWait(p.Backoff, p.MaxBackoff, p.Jitter, retry)
+}
+
+// retryable checks if the error of an attempt is retried.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return isConnectionError(err) || IsErrThrottled(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryRepository retries the failed operations of the wrapped repository with the retry policy.
+// Only the operations that are safe to repeat are retried: the reads, DeleteAll,
+// UpdateFieldsReturning, UpdatePath and EnsureIndexes. The other writes are executed once, as an
+// attempt that failed may still have written. A throttled operation (ThrottledError) is retried
+// after at least the delay suggested by the database.
+//
+// Once the deadline of the context of the repository passes, the operation is not retried and
+// returns context.DeadlineExceeded. With RetryPolicy.TotalBudget, the retries divide the time to
+// the deadline between them, so the whole operation completes within the deadline:
+//
+//	repo := backends.NewRetryRepository(userRepo, backends.RetryPolicy{
+//		Attempts:    3,
+//		Backoff:     10 * time.Millisecond,
+//		TotalBudget: true,
+//	})
+//	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+//	defer cancel()
+//	user, err := repo.WithContext(ctx).GetOne(filter, &User{})
+//
+// Like with TimeoutRepository, the MongoDB and in-memory operations cannot be cancelled, so an
+// attempt that times out runs to completion in the background and its result is discarded.
+type RetryRepository struct {
+	Repository
+	policy RetryPolicy
+	ctx    context.Context
+	stats  *queryStatsRecorder
+}
+
+// NewRetryRepository wraps the repository so its failed operations are retried with the policy.
+func NewRetryRepository(repo Repository, policy RetryPolicy) *RetryRepository {
+	return &RetryRepository{
+		Repository: repo,
+		policy:     policy,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// run executes the operation until it succeeds, fails with an error that is not retried, the
+// attempts are used up or the deadline of the context passes. It returns the result of the
+// successful attempt. The result of an attempt that timed out is discarded, even if the attempt
+// completes later.
+func (r *RetryRepository) run(operation func(repo Repository) (interface{}, error)) (interface{}, error) {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := r.attemptContext(ctx, attempt)
+		result, err := r.runAttempt(attemptCtx, operation)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= r.policy.Attempts || !r.policy.retryable(err) {
+			return result, err
+		}
+
+		wait := r.policy.wait(attempt)
+		if retryAfter := throttleRetryAfter(err); retryAfter > wait {
+			// the database suggested a longer delay
+			wait = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && r.policy.TotalBudget && time.Until(deadline) <= wait {
+			// the retry would not complete within the deadline
+			return nil, context.DeadlineExceeded
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attemptResult is the result of an attempt of an operation.
+type attemptResult struct {
+	value interface{}
+	err   error
+}
+
+// runAttempt runs the operation on the repository bound to the context of the attempt and waits
+// until it completes or the context is done.
+func (r *RetryRepository) runAttempt(ctx context.Context, operation func(repo Repository) (interface{}, error)) (interface{}, error) {
+	done := make(chan attemptResult, 1)
+	go func() {
+		value, err := operation(r.Repository.WithContext(ctx))
+		done <- attemptResult{value: value, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// attemptContext returns the context of the attempt, limited by the AttemptTimeout and, with the
+// TotalBudget, by the share of the attempt of the remaining time to the deadline.
+func (r *RetryRepository) attemptContext(ctx context.Context, attempt int) (context.Context, context.CancelFunc) {
+	timeout := r.policy.AttemptTimeout
+	if deadline, ok := ctx.Deadline(); ok && r.policy.TotalBudget {
+		remaining := r.policy.Attempts - attempt + 1
+		if remaining < 1 {
+			remaining = 1
+		}
+		share := time.Until(deadline) / time.Duration(remaining)
+		if timeout <= 0 || share < timeout {
+			timeout = share
+		}
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *RetryRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *RetryRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context, whose deadline limits the
+// operations including their retries.
+func (r *RetryRepository) WithContext(ctx context.Context) Repository {
+	return &RetryRepository{
+		Repository: r.Repository,
+		policy:     r.policy,
+		ctx:        ctx,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session, with the same policy.
+func (r *RetryRepository) WithSession(session *Session) Repository {
+	return &RetryRepository{
+		Repository: r.Repository.WithSession(session),
+		policy:     r.policy,
+		ctx:        r.ctx,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// GetOne fetches only one record for given filter.
+func (r *RetryRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.getOne(result, func(repo Repository, result interface{}) (interface{}, error) {
+		return repo.GetOne(filter, result)
+	})
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *RetryRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	return r.getOne(result, func(repo Repository, result interface{}) (interface{}, error) {
+		return repo.GetOneWithOpts(filter, result, opts)
+	})
+}
+
+// getOne runs the read of one record with retries. Each attempt decodes the record into its own
+// value, as an attempt that timed out may still be running, and only the record of the successful
+// attempt is copied into the result.
+func (r *RetryRepository) getOne(result interface{}, get func(repo Repository, result interface{}) (interface{}, error)) (interface{}, error) {
+	resultValue := reflect.ValueOf(result)
+	if resultValue.Kind() != reflect.Ptr || resultValue.IsNil() {
+		return r.run(func(repo Repository) (interface{}, error) {
+			return get(repo, result)
+		})
+	}
+
+	found, err := r.run(func(repo Repository) (interface{}, error) {
+		attemptResult, err := CreateNewAsExample(result)
+		if err != nil {
+			return nil, err
+		}
+		return get(repo, attemptResult)
+	})
+	if err != nil {
+		return found, err
+	}
+
+	foundValue := reflect.ValueOf(found)
+	if foundValue.Kind() == reflect.Ptr && !foundValue.IsNil() && foundValue.Type() == resultValue.Type() {
+		resultValue.Elem().Set(foundValue.Elem())
+		return result, nil
+	}
+	return found, nil
+}
+
+// GetAll fetches all matched records for given filter.
+func (r *RetryRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		results, err := repo.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return results, err
+	})
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *RetryRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		first, err := repo.GetFirst(filter, resultsTypeHint, order, sorting)
+		r.stats.set(repo.LastQueryStats())
+		return first, err
+	})
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options. The
+// DecodeErrors are returned along with the decoded records, as the read completed.
+func (r *RetryRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		results, err := repo.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+		r.stats.set(repo.LastQueryStats())
+		return results, err
+	})
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *RetryRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		results, err := repo.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return results, err
+	})
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition.
+func (r *RetryRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		results, err := repo.QueryRange(hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return results, err
+	})
+}
+
+// GetAllWithHint fetches all matched records, hinting the backend to use the named index.
+func (r *RetryRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		results, err := repo.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return results, err
+	})
+}
+
+// DeleteAll deletes all matched records for given filter.
+func (r *RetryRepository) DeleteAll(filter Filter) error {
+	_, err := r.run(func(repo Repository) (interface{}, error) {
+		return nil, repo.DeleteAll(filter)
+	})
+	return err
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs.
+func (r *RetryRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	ids, err := r.run(func(repo Repository) (interface{}, error) {
+		return repo.UpdateFieldsReturning(filter, fields)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids.([]interface{}), nil
+}
+
+// UpdatePath sets the value at the path of the matched records.
+func (r *RetryRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	updated, err := r.run(func(repo Repository) (interface{}, error) {
+		return repo.UpdatePath(filter, path, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated.(int64), nil
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (r *RetryRepository) Exists(filter Filter) (bool, error) {
+	exists, err := r.run(func(repo Repository) (interface{}, error) {
+		return repo.Exists(filter)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.(bool), nil
+}
+
+// Count returns the number of records matching the filter.
+func (r *RetryRepository) Count(filter Filter) (int, error) {
+	count, err := r.run(func(repo Repository) (interface{}, error) {
+		return repo.Count(filter)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.(int), nil
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records.
+func (r *RetryRepository) CountBoth(filter Filter) (int64, int64, error) {
+	counts, err := r.run(func(repo Repository) (interface{}, error) {
+		filtered, total, err := repo.CountBoth(filter)
+		return [2]int64{filtered, total}, err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts.([2]int64)[0], counts.([2]int64)[1], nil
+}
+
+// GetByIDs fetches the records with the given IDs.
+func (r *RetryRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	return r.run(func(repo Repository) (interface{}, error) {
+		return repo.GetByIDs(ids, resultHint)
+	})
+}
+
+// DescribeRepository returns the size and the capacity of the collection/table.
+func (r *RetryRepository) DescribeRepository() (RepositoryStats, error) {
+	stats, err := r.run(func(repo Repository) (interface{}, error) {
+		return repo.DescribeRepository()
+	})
+	if err != nil {
+		return RepositoryStats{}, err
+	}
+	return stats.(RepositoryStats), nil
+}
+
+// EnsureIndexes creates the indexes that do not exist yet.
+func (r *RetryRepository) EnsureIndexes(indexes []Index) error {
+	_, err := r.run(func(repo Repository) (interface{}, error) {
+		return nil, repo.EnsureIndexes(indexes)
+	})
+	return err
+}
+
+// LastQueryStats returns the statistics of the last successful attempt of the last read.
+func (r *RetryRepository) LastQueryStats() QueryStats {
+	return r.stats.get()
+}
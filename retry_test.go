@@ -0,0 +1,137 @@
+package backends
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingRepository fails the lookups of the wrapped repository, after a delay, until it has
+// failed the given number of times.
+type failingRepository struct {
+	Repository
+	delay    time.Duration
+	failures int32
+	calls    *int32
+}
+
+func (r *failingRepository) WithContext(ctx context.Context) Repository {
+	return &failingRepository{Repository: r.Repository.WithContext(ctx), delay: r.delay, failures: r.failures, calls: r.calls}
+}
+
+func (r *failingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	call := atomic.AddInt32(r.calls, 1)
+	time.Sleep(r.delay)
+	if r.failures < 0 || call <= r.failures {
+		return nil, ErrConnectionFailed("connection refused")
+	}
+	return r.Repository.GetOne(filter, result)
+}
+
+func TestRetryRepository(t *testing.T) {
+	memoryRepo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	saved, err := memoryRepo.Save(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter().Match("id", saved.(*memoryTestEntry).ID)
+
+	calls := int32(0)
+	repo := NewRetryRepository(&failingRepository{Repository: memoryRepo, failures: 2, calls: &calls}, RetryPolicy{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	})
+	found, err := repo.GetOne(filter, &memoryTestEntry{})
+	if err != nil {
+		t.Fatal("Expected the third attempt to succeed. Got: ", err)
+	}
+	if found.(*memoryTestEntry).Name != "John" || calls != 3 {
+		t.Fatal("Expected the record found on the third attempt. Got: ", found, calls)
+	}
+
+	calls = 0
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	repo = NewRetryRepository(&failingRepository{Repository: memoryRepo, failures: -1, calls: &calls}, policy)
+	if _, err = repo.GetOne(filter, &memoryTestEntry{}); !IsErrConnectionFailed(err) || calls != 3 {
+		t.Fatal("Expected the error of the last attempt. Got: ", err, calls)
+	}
+
+	calls = 0
+	repo = NewRetryRepository(&failingRepository{Repository: memoryRepo, calls: &calls}, policy)
+	if _, err = repo.GetOne(NewFilter().Match("name", "Jane"), &memoryTestEntry{}); !IsErrNotFound(err) || calls != 1 {
+		t.Fatal("Expected the error that is not retried to be returned at once. Got: ", err, calls)
+	}
+}
+
+func TestRetryRepositoryTotalBudget(t *testing.T) {
+	memoryRepo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	calls := int32(0)
+	// the attempts always fail, each after 200ms
+	repo := NewRetryRepository(&failingRepository{Repository: memoryRepo, delay: 200 * time.Millisecond, failures: -1, calls: &calls}, RetryPolicy{
+		Attempts:    4,
+		TotalBudget: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := repo.WithContext(ctx).GetOne(NewFilter().Match("name", "John"), &memoryTestEntry{})
+	elapsed := time.Since(start)
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected the operation to exceed the deadline. Got: ", err)
+	}
+	if elapsed > 150*time.Millisecond {
+		t.Fatal("Expected the operation with the retries to complete within the deadline. Took: ", elapsed)
+	}
+	if atomic.LoadInt32(&calls) != 4 {
+		t.Fatal("Expected the deadline divided between the 4 attempts. Got attempts: ", atomic.LoadInt32(&calls))
+	}
+}
+
+// slowFirstRepository writes a stale record into the result of the first lookup after a delay,
+// like an attempt that times out and completes later.
+type slowFirstRepository struct {
+	Repository
+	calls *int32
+}
+
+func (r *slowFirstRepository) WithContext(ctx context.Context) Repository {
+	return &slowFirstRepository{Repository: r.Repository.WithContext(ctx), calls: r.calls}
+}
+
+func (r *slowFirstRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	if atomic.AddInt32(r.calls, 1) == 1 {
+		time.Sleep(30 * time.Millisecond)
+		result.(*memoryTestEntry).Name = "Stale"
+		return result, nil
+	}
+	return r.Repository.GetOne(filter, result)
+}
+
+func TestRetryRepositoryTimedOutAttempt(t *testing.T) {
+	memoryRepo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	if _, err := memoryRepo.Save(&memoryTestEntry{Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := int32(0)
+	repo := NewRetryRepository(&slowFirstRepository{Repository: memoryRepo, calls: &calls}, RetryPolicy{
+		Attempts:       2,
+		AttemptTimeout: 10 * time.Millisecond,
+	})
+	result := &memoryTestEntry{}
+	found, err := repo.GetOne(NewFilter().Match("name", "John"), result)
+	if err != nil {
+		t.Fatal("Expected the second attempt to succeed. Got: ", err)
+	}
+	if found.(*memoryTestEntry).Name != "John" || result.Name != "John" {
+		t.Fatal("Expected the record of the second attempt. Got: ", found, result)
+	}
+
+	// the first attempt completes after the retry and must not write into the result
+	time.Sleep(50 * time.Millisecond)
+	if result.Name != "John" {
+		t.Fatal("Expected the timed out attempt not to change the result. Got: ", result)
+	}
+}
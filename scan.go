@@ -0,0 +1,115 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// scanBatchSize is the default number of records in the batches passed to the ParallelScan
+// callback. Set the batch size of the repository definition to change it.
+const scanBatchSize = 100
+
+// batchSizeOr returns the batch size of the definition, or the given default of the backend
+// if the definition does not set one.
+func batchSizeOr(def RepositoryDefinition, defaultSize int) int {
+	if batchSize := def.GetBatchSize(); batchSize > 0 {
+		return batchSize
+	}
+	return defaultSize
+}
+
+// checkBatchSize validates the batch size of a definition or of the read options. Zero means
+// the default batch size.
+func checkBatchSize(batchSize int) error {
+	if batchSize < 0 {
+		return ErrInvalidInput(fmt.Sprintf("the batch size must not be negative, got %d", batchSize))
+	}
+	return nil
+}
+
+// scanSegments runs the scan of each segment concurrently. A scan calls fn with its batches and
+// stops when the context is done. The first error of a scan cancels the context of the other
+// scans and is returned.
+func scanSegments(ctx context.Context, segments int, scan func(ctx context.Context, segment int) error) error {
+	if segments < 1 {
+		return ErrInvalidInput(fmt.Sprintf("the number of segments must be at least 1, got %d", segments))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var scanErr error
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := scan(ctx, segment); err != nil {
+				once.Do(func() {
+					scanErr = err
+					cancel()
+				})
+			}
+		}(segment)
+	}
+	wg.Wait()
+	return scanErr
+}
+
+// scanInPages is the single pass ParallelScan of the backends without parallel scans. It reads
+// the matching records a page of batchSize records at a time, in the order of the property, and
+// calls fn with each page.
+func scanInPages(repo Repository, filter Filter, order string, segments, batchSize int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	if segments < 1 {
+		return ErrInvalidInput(fmt.Sprintf("the number of segments must be at least 1, got %d", segments))
+	}
+
+	for offset := 0; ; offset += batchSize {
+		batch, err := repo.GetAll(filter, resultsTypeHint, order, Ascending.String(), batchSize, offset)
+		if err != nil {
+			if IsErrNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		count := resultsCount(batch)
+		if count == 0 {
+			return nil
+		}
+		if err := fn(reflect.Indirect(reflect.ValueOf(batch)).Interface()); err != nil {
+			return err
+		}
+		if count < int64(batchSize) {
+			return nil
+		}
+	}
+}
+
+// scanSlice calls fn with the batches of the records of the segment: the records at the
+// positions i of the slice for which i % segments is the segment.
+func scanSlice(ctx context.Context, records reflect.Value, segment, segments, batchSize int, fn func(batch interface{}) error) error {
+	batch := reflect.MakeSlice(records.Type(), 0, batchSize)
+	for i := segment; i < records.Len(); i += segments {
+		batch = reflect.Append(batch, records.Index(i))
+		if batch.Len() < batchSize {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(batch.Interface()); err != nil {
+			return err
+		}
+		batch = reflect.MakeSlice(records.Type(), 0, batchSize)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn(batch.Interface())
+}
@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// pagingRepository counts the GetAll round trips to the wrapped repository.
+type pagingRepository struct {
+	Repository
+	calls int
+}
+
+func (r *pagingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	r.calls++
+	return r.Repository.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+}
+
+func TestScanInPagesBatchSize(t *testing.T) {
+	repo := &pagingRepository{Repository: newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "jobs"})}
+	for i := 0; i < 250; i++ {
+		if _, err := repo.Save(&memoryJob{Status: "queued"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for batchSize, roundTrips := range map[int]int{100: 3, 40: 7, 250: 2, 500: 1} {
+		repo.calls = 0
+		visited := 0
+		err := scanInPages(repo, NewFilter(), "id", 1, batchSize, &memoryJob{}, func(batch interface{}) error {
+			if size := len(batch.([]*memoryJob)); size > batchSize {
+				t.Fatalf("Expected at most %d records in a batch. Got: %d", batchSize, size)
+			}
+			visited += len(batch.([]*memoryJob))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visited != 250 || repo.calls != roundTrips {
+			t.Fatalf("Expected %d round trips for the batch size %d. Got %d round trips, %d records", roundTrips, batchSize, repo.calls, visited)
+		}
+	}
+}
+
+func TestBatchSizeValidation(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	if _, err := backend.DefineRepository("jobs", RepositoryDefinitionMap{"name": "jobs", "batchSize": -1}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the negative batch size. Got: ", err)
+	}
+	if _, err := backend.DefineRepository("jobs", RepositoryDefinitionMap{"name": "jobs", "batchSize": 2.5}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the fractional batch size. Got: ", err)
+	}
+
+	// a whole float64, like in a definition decoded from JSON
+	repo, err := backend.DefineRepository("jobs", RepositoryDefinitionMap{"name": "jobs", "batchSize": 25.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 60; i++ {
+		if _, err := repo.Save(&memoryJob{Status: "queued"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batches := 0
+	if err := repo.ParallelScan(NewFilter(), 1, &memoryJob{}, func(batch interface{}) error {
+		batches++
+		return nil
+	}); err != nil || batches != 3 {
+		t.Fatal("Expected the scan in batches of the batch size of the definition. Got: ", batches, err)
+	}
+
+	if _, err := repo.GetAllWithOpts(NewFilter(), &memoryJob{}, "", "", 0, 0, ReadOpts{BatchSize: -5}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the negative batch size of the read. Got: ", err)
+	}
+}
@@ -0,0 +1,397 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Serializer converts the objects stored as opaque blobs by a SerializingRepository to bytes and
+// back.
+type Serializer interface {
+	// Marshal returns the bytes of the object.
+	Marshal(object interface{}) ([]byte, error)
+	// Unmarshal decodes the bytes into the result, a pointer.
+	Unmarshal(data []byte, result interface{}) error
+}
+
+// The serializers of the "serializer" entry of the repository definition.
+const (
+	// SerializerJSON is the name of JSONSerializer.
+	SerializerJSON = "json"
+	// SerializerBSON is the name of BSONSerializer.
+	SerializerBSON = "bson"
+	// SerializerGob is the name of GobSerializer.
+	SerializerGob = "gob"
+)
+
+// JSONSerializer serializes the objects as JSON, readable when debugging the stored payloads and
+// by the services in other languages.
+type JSONSerializer struct{}
+
+// Marshal returns the JSON of the object.
+func (JSONSerializer) Marshal(object interface{}) ([]byte, error) {
+	return json.Marshal(object)
+}
+
+// Unmarshal decodes the JSON into the result.
+func (JSONSerializer) Unmarshal(data []byte, result interface{}) error {
+	return json.Unmarshal(data, result)
+}
+
+// BSONSerializer serializes the objects as BSON documents, with the bson tags of the structs. The
+// object must be a struct or a map.
+type BSONSerializer struct{}
+
+// Marshal returns the BSON document of the object.
+func (BSONSerializer) Marshal(object interface{}) ([]byte, error) {
+	return bson.Marshal(object)
+}
+
+// Unmarshal decodes the BSON document into the result.
+func (BSONSerializer) Unmarshal(data []byte, result interface{}) error {
+	return bson.Unmarshal(data, result)
+}
+
+// GobSerializer serializes the objects with encoding/gob, the most compact of the serializers for
+// the Go structs. The payloads can only be read by Go, and the concrete types of the interface
+// values must be registered with gob.Register.
+type GobSerializer struct{}
+
+// Marshal returns the gob encoding of the object.
+func (GobSerializer) Marshal(object interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(object); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal decodes the gob encoding into the result.
+func (GobSerializer) Unmarshal(data []byte, result interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(result)
+}
+
+// SerializerByName returns the serializer of the name: SerializerJSON, SerializerBSON or
+// SerializerGob. Any other name is ErrInvalidInput.
+func SerializerByName(name string) (Serializer, error) {
+	switch name {
+	case SerializerJSON:
+		return JSONSerializer{}, nil
+	case SerializerBSON:
+		return BSONSerializer{}, nil
+	case SerializerGob:
+		return GobSerializer{}, nil
+	}
+	return nil, ErrInvalidInput(fmt.Sprintf("unknown serializer %q", name))
+}
+
+// serializedPayload is the property of the stored records holding the serialized objects.
+const serializedPayload = "payload"
+
+// SerializingRepository stores the objects as opaque blobs serialized with a Serializer: each
+// record has the ID and the "payload" property with the bytes of the object. The repositories
+// defined with a serializer ("serializer") are wrapped with it by DefineRepository.
+//
+// Save, SaveWithOpts, SaveWithTimestamp, SaveUpsert, SaveIf, ReplaceOne and the inserts of Bulk
+// serialize the objects, and GetOne, GetAll and their variants and GetByIDs deserialize them
+// into the results. Only the ID can be queried, as the other properties are in the payload. The
+// updates of the fields, FindAndModify, UpsertAll and the updates of Bulk cannot change the
+// payload, so they fail with ErrUnsupported.
+type SerializingRepository struct {
+	Repository
+	serializer Serializer
+	idField    string
+}
+
+// NewSerializingRepository wraps the repository so the objects are stored serialized with the
+// serializer, with their ID in the idField property.
+func NewSerializingRepository(repo Repository, serializer Serializer, idField string) *SerializingRepository {
+	return &SerializingRepository{
+		Repository: repo,
+		serializer: serializer,
+		idField:    idField,
+	}
+}
+
+// serialize returns the stored record of the object: its ID, if set, and its payload.
+func (r *SerializingRepository) serialize(object interface{}) (*map[string]interface{}, error) {
+	properties, err := InterfaceToMap(object)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := r.serializer.Marshal(object)
+	if err != nil {
+		return nil, ErrInvalidInput(err)
+	}
+	record := map[string]interface{}{serializedPayload: payload}
+	if id, ok := (*properties)[r.idField]; ok && id != nil && id != "" {
+		record[r.idField] = id
+	}
+	return &record, nil
+}
+
+// deserialize decodes the payload of the stored record into the result, and sets the ID of the
+// record on it, as the payload of a new record was serialized before the ID was generated.
+func (r *SerializingRepository) deserialize(stored interface{}, result interface{}) error {
+	record, err := rawRecord(stored)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	switch value := record[serializedPayload].(type) {
+	case string:
+		// the bytes are base64 strings in the JSON form of the record
+		if payload, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return ErrInvalidInput(fmt.Sprintf("invalid payload: %s", err.Error()))
+		}
+	default:
+		return ErrInvalidInput(fmt.Sprintf("the record has no payload, got %v", value))
+	}
+	if err = r.serializer.Unmarshal(payload, result); err != nil {
+		return ErrInvalidInput(err)
+	}
+	if id, ok := record[r.idField]; ok && id != nil {
+		return MapToInterface(&map[string]interface{}{r.idField: id}, result)
+	}
+	return nil
+}
+
+// deserializeAll decodes the payloads of the stored records into a pointer to a slice of the type
+// of the results hint. The nil records, like the missing records of GetByIDs, stay nil.
+func (r *SerializingRepository) deserializeAll(records interface{}, resultsTypeHint interface{}) (interface{}, error) {
+	resultsTypeHint, err := resultsHintPtr(resultsTypeHint)
+	if err != nil {
+		return nil, err
+	}
+	results := NewSliceOfType(resultsTypeHint)
+	err = IterateOverSlice(records, func(i int, record interface{}) error {
+		if value := reflect.ValueOf(record); !value.IsValid() || (value.Kind() == reflect.Ptr && value.IsNil()) {
+			results = reflect.Append(results, reflect.Zero(results.Type().Elem()))
+			return nil
+		}
+		result, err := CreateNewAsExample(resultsTypeHint)
+		if err != nil {
+			return err
+		}
+		if err = r.deserialize(record, result); err != nil {
+			return err
+		}
+		results = reflect.Append(results, reflect.ValueOf(result))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slicePointer := reflect.New(results.Type())
+	slicePointer.Elem().Set(results)
+	return slicePointer.Interface(), nil
+}
+
+// saved deserializes the stored record returned by a write into the object.
+func (r *SerializingRepository) saved(stored interface{}, object interface{}) (interface{}, error) {
+	if err := r.deserialize(stored, object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// GetOne fetches the record matching the filter and deserializes it into the result.
+func (r *SerializingRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	return r.GetOneWithOpts(filter, result, ReadOpts{})
+}
+
+// GetOneWithOpts fetches the record matching the filter using the given read options and
+// deserializes it into the result.
+func (r *SerializingRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	record, err := r.Repository.GetOneWithOpts(filter, &map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.deserialize(record, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetAll fetches all matched records and deserializes them.
+func (r *SerializingRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return r.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, ReadOpts{})
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *SerializingRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	return firstResult(r.GetAll(filter, resultsTypeHint, order, sorting, 1, 0))
+}
+
+// GetAllWithOpts fetches all matched records using the given read options and deserializes them.
+func (r *SerializingRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	records, err := r.Repository.GetAllWithOpts(filter, &map[string]interface{}{}, order, sorting, limit, offset, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.deserializeAll(records, resultsTypeHint)
+}
+
+// GetByIDs fetches the records with the given IDs and deserializes them.
+func (r *SerializingRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	records, err := r.Repository.GetByIDs(ids, &map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return r.deserializeAll(records, resultHint)
+}
+
+// Save serializes the object and saves it. The ID of a new record is set on the object.
+func (r *SerializingRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	return r.SaveWithOpts(object, filter, WriteOpts{})
+}
+
+// SaveWithOpts serializes the object and saves it using the given write options.
+func (r *SerializingRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	record, err := r.serialize(object)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.Repository.SaveWithOpts(record, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.saved(stored, object)
+}
+
+// SaveWithTimestamp serializes the object and saves it, returning the time of the write.
+func (r *SerializingRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	record, err := r.serialize(object)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	stored, timestamp, err := r.Repository.SaveWithTimestamp(record, filter)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	result, err := r.saved(stored, object)
+	return result, timestamp, err
+}
+
+// SaveUpsert serializes the object and updates the record matching the filter, or inserts it.
+func (r *SerializingRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	record, err := r.serialize(object)
+	if err != nil {
+		return nil, false, err
+	}
+	stored, inserted, err := r.Repository.SaveUpsert(record, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := r.saved(stored, object)
+	return result, inserted, err
+}
+
+// SaveIf serializes the object and saves it if the record matches the condition.
+func (r *SerializingRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	record, err := r.serialize(object)
+	if err != nil {
+		return nil, false, err
+	}
+	stored, saved, err := r.Repository.SaveIf(record, filter, condition)
+	if err != nil || !saved {
+		return nil, saved, err
+	}
+	result, err := r.saved(stored, object)
+	return result, saved, err
+}
+
+// ReplaceOne serializes the object and replaces the record matching the filter with it.
+func (r *SerializingRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	record, err := r.serialize(object)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.Repository.ReplaceOne(filter, record)
+	if err != nil {
+		return nil, err
+	}
+	return r.saved(stored, object)
+}
+
+// Bulk returns a BulkOp that serializes the inserted objects. The updates fail with
+// ErrUnsupported.
+func (r *SerializingRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		serialized := make([]BulkOperation, len(operations))
+		for i, operation := range operations {
+			switch operation.Kind {
+			case BulkInsert:
+				record, err := r.serialize(operation.Object)
+				if err != nil {
+					return BulkResult{}, BulkError{Operation: i, Cause: err}
+				}
+				operation.Object = record
+			case BulkUpdate:
+				return BulkResult{}, BulkError{Operation: i, Cause: ErrUnsupported("the fields of the serialized records cannot be updated")}
+			}
+			serialized[i] = operation
+		}
+		return runBulk(r.Repository, serialized)
+	})
+}
+
+// FindAndModify is not supported, as the fields of the serialized records cannot be updated.
+func (r *SerializingRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	return nil, ErrUnsupported("the fields of the serialized records cannot be updated")
+}
+
+// UpsertAll is not supported, as the conflict keys are in the payloads.
+func (r *SerializingRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	return nil, ErrUnsupported("the serialized records cannot be upserted by their fields")
+}
+
+// UpdateFieldsReturning is not supported, as the fields of the serialized records cannot be
+// updated.
+func (r *SerializingRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	return nil, ErrUnsupported("the fields of the serialized records cannot be updated")
+}
+
+// ArrayAppend is not supported, as the fields of the serialized records cannot be updated.
+func (r *SerializingRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	return 0, ErrUnsupported("the fields of the serialized records cannot be updated")
+}
+
+// ArrayRemove is not supported, as the fields of the serialized records cannot be updated.
+func (r *SerializingRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	return 0, ErrUnsupported("the fields of the serialized records cannot be updated")
+}
+
+// UpdatePath is not supported, as the fields of the serialized records cannot be updated.
+func (r *SerializingRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	return 0, ErrUnsupported("the fields of the serialized records cannot be updated")
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *SerializingRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *SerializingRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context.
+func (r *SerializingRepository) WithContext(ctx context.Context) Repository {
+	return NewSerializingRepository(r.Repository.WithContext(ctx), r.serializer, r.idField)
+}
+
+// WithSession returns a copy of the repository bound to the session.
+func (r *SerializingRepository) WithSession(session *Session) Repository {
+	return NewSerializingRepository(r.Repository.WithSession(session), r.serializer, r.idField)
+}
@@ -0,0 +1,125 @@
+package backends
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+type serializerTestAddress struct {
+	City string `json:"city" bson:"city"`
+	Zip  string `json:"zip" bson:"zip"`
+}
+
+type serializerTestEntry struct {
+	ID        string                `json:"id" bson:"id"`
+	Name      string                `json:"name" bson:"name"`
+	Tags      []string              `json:"tags" bson:"tags"`
+	Address   serializerTestAddress `json:"address" bson:"address"`
+	CreatedAt time.Time             `json:"createdAt" bson:"createdAt"`
+}
+
+func TestSerializingRepository(t *testing.T) {
+	for _, name := range []string{SerializerJSON, SerializerBSON, SerializerGob} {
+		serializer, err := SerializerByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collection := NewMemoryCollection(RepositoryDefinitionMap{"name": "blobs"})
+		repo := NewSerializingRepository(collection, serializer, "id")
+
+		entry := &serializerTestEntry{
+			Name:      "John",
+			Tags:      []string{"admin", "ops"},
+			Address:   serializerTestAddress{City: "Skopje", Zip: "1000"},
+			CreatedAt: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+		}
+		if _, err = repo.Save(entry, nil); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if entry.ID == "" {
+			t.Fatalf("%s: expected the ID to be set on the saved entry", name)
+		}
+
+		result, err := repo.GetOne(NewFilter().Match("id", entry.ID), &serializerTestEntry{})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		read := result.(*serializerTestEntry)
+		if !reflect.DeepEqual(read, entry) {
+			t.Fatalf("%s: expected %+v to round-trip. Got: %+v", name, entry, read)
+		}
+
+		results, err := repo.GetAll(nil, &serializerTestEntry{}, "", "", 0, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if all := *(results.(*[]*serializerTestEntry)); len(all) != 1 || !reflect.DeepEqual(all[0], entry) {
+			t.Fatalf("%s: expected the entry to round-trip with GetAll. Got: %v", name, all)
+		}
+
+		stored, err := GetOneRaw(collection, NewFilter().Match("id", entry.ID))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, ok := stored["name"]; ok || stored["payload"] == nil {
+			t.Fatalf("%s: expected the entry to be stored as a payload. Got: %v", name, stored)
+		}
+	}
+}
+
+func TestDefineRepositoryWithSerializer(t *testing.T) {
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+
+	if _, err := backend.DefineRepository("invalid", RepositoryDefinitionMap{"name": "invalid", "serializer": "xml"}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error for the unknown serializer. Got: ", err)
+	}
+
+	repo, err := backend.DefineRepository("blobs", RepositoryDefinitionMap{"name": "blobs", "customId": true, "serializer": SerializerGob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Save(&serializerTestEntry{ID: "1", Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.GetOne(NewFilter().Match("id", "1"), &serializerTestEntry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := result.(*serializerTestEntry); entry.Name != "John" {
+		t.Fatalf("Expected John. Got: %+v", entry)
+	}
+	if _, err = repo.FindAndModify(NewFilter().Match("id", "1"), map[string]interface{}{"name": "Mary"}, 1, nil); !IsErrUnsupported(err) {
+		t.Fatal("Expected the fields of the serialized records not to be updatable. Got: ", err)
+	}
+}
+
+func TestDefineRepositoryWithSerializerReadReplica(t *testing.T) {
+	replica := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {})
+	backend := NewRepositoriesBackend(context.Background(), &config.DBInfo{}, MemoryRepoBuilder, func() {}, WithReadReplica(replica))
+
+	def := RepositoryDefinitionMap{"name": "blobs", "customId": true, "serializer": SerializerGob}
+	repo, err := backend.DefineRepository("blobs", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaRepo, err := replica.GetRepository("blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replicaRepo.Save(&serializerTestEntry{ID: "2", Name: "John"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records read from the replica are deserialized once
+	result, err := repo.GetOne(NewFilter().Match("id", "2"), &serializerTestEntry{})
+	if err != nil {
+		t.Fatal("Expected the record of the replica. Got: ", err)
+	}
+	if entry := result.(*serializerTestEntry); entry.Name != "John" {
+		t.Fatalf("Expected John. Got: %+v", entry)
+	}
+}
@@ -0,0 +1,80 @@
+package backends
+
+import "sync"
+
+// Session groups the operations of the repositories bound to it with Repository.WithSession,
+// so they are causally consistent: a read made in the session observes the writes made earlier
+// in the same session, which the reads from a read replica do not guarantee. Start a session
+// with Backend.StartSession and end it with End once the operations are done:
+// 		session := backend.StartSession()
+// 		defer session.End()
+//
+// 		repo := userRepo.WithSession(session)
+// 		repo.Save(user, nil)
+// 		repo.GetOne(filter, &User{}) // observes the saved user
+//
+// How the backends provide the consistency:
+// 	- MongoDB: the repositories of the session share a copy of the MongoDB session in the Strong
+// 	  mode, so the reads and writes go to the primary over the same connection.
+// 	- DynamoDB: the reads of the session are strongly consistent. The queries of a global
+// 	  secondary index (GetAllByIndex) cannot be, so they may not observe the latest writes.
+// 	- in-memory: the reads always observe the writes, so the session has no effect.
+// In any case the reads of the session do not go to the read replica of the backend.
+//
+// A session is safe to use concurrently, but the operations made concurrently are not ordered.
+// The repositories bound to a session must not be used after End.
+type Session struct {
+	mutex     sync.Mutex
+	resources map[string]interface{}
+	closers   []func()
+	ended     bool
+}
+
+// newSession creates a session with no resources.
+func newSession() *Session {
+	return &Session{
+		resources: map[string]interface{}{},
+	}
+}
+
+// resource returns the resource of the session for the key, creating it with create on the
+// first call. The close function returned by create is called when the session ends.
+func (s *Session) resource(key string, create func() (interface{}, func())) interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if resource, ok := s.resources[key]; ok {
+		return resource
+	}
+	resource, closeFn := create()
+	s.resources[key] = resource
+	if closeFn != nil {
+		s.closers = append(s.closers, closeFn)
+	}
+	return resource
+}
+
+// Ended checks if the session was ended with End.
+func (s *Session) Ended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ended
+}
+
+// End ends the session and releases its resources, like the MongoDB session copy. It is safe
+// to call it more than once.
+func (s *Session) End() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ended {
+		return
+	}
+	for _, closeFn := range s.closers {
+		closeFn()
+	}
+	s.closers = nil
+	s.resources = map[string]interface{}{}
+	s.ended = true
+}
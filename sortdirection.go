@@ -0,0 +1,69 @@
+package backends
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SortDirection is the direction of the sorting of the reads, the sorting argument of
+// Repository.GetAll and its variants and the SortKey.Sorting:
+// 		results, err := repo.GetAll(filter, &User{}, "createdAt", backends.Descending.String(), 10, 0)
+// or, with the typed direction:
+// 		results, err := backends.GetAllSorted(repo, filter, &User{}, "createdAt", backends.Descending, 10, 0)
+// The sorting is parsed with ParseSortDirection, so the reads fail with ErrInvalidInput for an
+// unknown sorting, instead of sorting the results in an unexpected direction.
+type SortDirection string
+
+const (
+	// Ascending sorts from the lowest value to the highest. It is the default.
+	Ascending SortDirection = "asc"
+	// Descending sorts from the highest value to the lowest.
+	Descending SortDirection = "desc"
+)
+
+// ParseSortDirection returns the direction of the sorting: "asc" or "desc", or Ascending for an
+// empty sorting. The spellings accepted by the reads before SortDirection, "ascending" and
+// "descending" in any case, are accepted too, so the existing callers keep working. Any other
+// value is ErrInvalidInput.
+func ParseSortDirection(sorting string) (SortDirection, error) {
+	switch strings.ToLower(sorting) {
+	case "", "asc", "ascending":
+		return Ascending, nil
+	case "desc", "descending":
+		return Descending, nil
+	}
+	return "", ErrInvalidInput(fmt.Sprintf("unknown sorting %q, expected %q or %q", sorting, Ascending, Descending))
+}
+
+// String returns the sorting of the direction, as passed to Repository.GetAll.
+func (d SortDirection) String() string {
+	return string(d)
+}
+
+// Set parses the sorting into the direction, see ParseSortDirection. With String, it makes a
+// SortDirection usable as a flag.Value.
+func (d *SortDirection) Set(sorting string) error {
+	direction, err := ParseSortDirection(sorting)
+	if err != nil {
+		return err
+	}
+	*d = direction
+	return nil
+}
+
+// checkSorting checks that the sorting argument of a read is a SortDirection.
+func checkSorting(sorting string) error {
+	_, err := ParseSortDirection(sorting)
+	return err
+}
+
+// isDescending checks if the sorting argument of a read, or of a SortKey, is Descending.
+func isDescending(sorting string) bool {
+	direction, _ := ParseSortDirection(sorting)
+	return direction == Descending
+}
+
+// GetAllSorted reads the records like Repository.GetAll, with the typed direction of the sorting.
+func GetAllSorted(repo Repository, filter Filter, resultsTypeHint interface{}, order string, direction SortDirection, limit int, offset int) (interface{}, error) {
+	return repo.GetAll(filter, resultsTypeHint, order, direction.String(), limit, offset)
+}
@@ -0,0 +1,55 @@
+package backends
+
+import "testing"
+
+func TestParseSortDirection(t *testing.T) {
+	valid := map[string]SortDirection{
+		"":           Ascending,
+		"asc":        Ascending,
+		"desc":       Descending,
+		"ascending":  Ascending,
+		"DESC":       Descending,
+		"Descending": Descending,
+	}
+	for sorting, expected := range valid {
+		if direction, err := ParseSortDirection(sorting); err != nil || direction != expected {
+			t.Fatalf("Expected %q to parse to %s. Got: %s, %v", sorting, expected, direction, err)
+		}
+	}
+	for _, sorting := range []string{"down", "ascend", "-1"} {
+		if _, err := ParseSortDirection(sorting); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected %q to fail to parse. Got: %v", sorting, err)
+		}
+	}
+
+	direction := Ascending
+	if err := direction.Set("down"); !IsErrInvalidInput(err) || direction != Ascending {
+		t.Fatal("Expected the setter to reject an invalid sorting and keep the direction. Got: ", direction, err)
+	}
+
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for _, name := range []string{"Ann", "Bob"} {
+		if _, err := repo.Save(&memoryTestEntry{Name: name}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.GetAll(nil, &memoryTestEntry{}, "name", "down", 0, 0); !IsErrInvalidInput(err) {
+		t.Fatal("Expected GetAll to reject an invalid sorting. Got: ", err)
+	}
+
+	// the legacy spelling sorts in its direction, like the typed direction
+	legacy, err := repo.GetAll(nil, &memoryTestEntry{}, "name", "descending", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := GetAllSorted(repo, nil, &memoryTestEntry{}, "name", Descending, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, results := range []interface{}{legacy, typed} {
+		entries := *(results.(*[]*memoryTestEntry))
+		if len(entries) != 2 || entries[0].Name != "Bob" {
+			t.Fatal("Expected the records in the descending order. Got: ", entries)
+		}
+	}
+}
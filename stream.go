@@ -0,0 +1,51 @@
+package backends
+
+import "context"
+
+// StreamAll reads the records of the repository matching the filter with a Cursor and sends them,
+// decoded into the type of the results hint, on the returned results channel, for pipelines and
+// worker pools:
+// 		results, errs := backends.StreamAll(ctx, jobRepo, filter, &Job{}, backends.CursorOpts{})
+// 		for record := range results {
+// 			process(record.(*Job))
+// 		}
+// 		if err := <-errs; err != nil {
+// 			return err
+// 		}
+//
+// Both channels are closed when the stream ends. A failed read, or the cancellation of the
+// context, ends the stream and its error is sent on the errors channel, which holds at most one
+// error and does not block the stream. When the context is cancelled, the stream stops even if
+// nothing reads the results anymore, so cancel the context to abandon a stream without leaking
+// its goroutine. The records are read with the repository bound to the context.
+func StreamAll(ctx context.Context, repo Repository, filter Filter, resultsTypeHint interface{}, opts CursorOpts) (<-chan interface{}, <-chan error) {
+	results := make(chan interface{})
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(results)
+
+		cursor := NewCursor(repo.WithContext(ctx), filter, resultsTypeHint, opts)
+		for {
+			if err := ctx.Err(); err != nil {
+				errs <- err
+				return
+			}
+			if !cursor.Next() {
+				break
+			}
+			select {
+			case results <- cursor.Record():
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return results, errs
+}
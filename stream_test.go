@@ -0,0 +1,58 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStreamAll(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+	for i := 0; i < 50; i++ {
+		if _, err := repo.Save(&memoryTestEntry{Name: fmt.Sprintf("user-%d", i)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, errs := StreamAll(context.Background(), repo, NewFilter(), &memoryTestEntry{}, CursorOpts{BatchSize: 7})
+	count := 0
+	for record := range results {
+		if record.(*memoryTestEntry).Name == "" {
+			t.Fatal("Expected the decoded records. Got: ", record)
+		}
+		count++
+	}
+	if err := <-errs; err != nil || count != 50 {
+		t.Fatal("Expected all the records streamed. Got: ", count, err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errs = StreamAll(ctx, repo, NewFilter(), &memoryTestEntry{}, CursorOpts{BatchSize: 7})
+	for i := 0; i < 10; i++ {
+		<-results
+	}
+	cancel()
+
+	// at most the record being sent when the context was cancelled is still received
+	received := 0
+	for range results {
+		received++
+	}
+	if received > 1 {
+		t.Fatal("Expected the stream to stop on the cancellation. Got more records: ", received)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Fatal("Expected the cancellation on the errors channel. Got: ", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - goroutines; leaked > 0 {
+		t.Fatal("Expected no goroutines leaked by the cancelled stream. Got: ", leaked)
+	}
+}
@@ -2,35 +2,64 @@ package backends
 
 import "github.com/Microkubes/microservice-tools/config"
 
-// addSupported adds new backends
-func addSupported(manager BackendManager) {
-	manager.SupportBackend("mongodb", MongoDBBackendBuilder, map[string]interface{}{
-		"dbName":   "string",
-		"host":     "string",
-		"database": "string",
-		"collections": map[string]interface{}{
-			"string": map[string]interface{}{
-				"indexes":   "string array",
-				"enableTTL": "bool",
-				"TTL":       "int",
+// collectionsSpec describes the per-collection/table configuration, common for all backends.
+func collectionsSpec(settings ...PropertySpec) PropertySpec {
+	return PropertySpec{
+		Name:        "collections",
+		Type:        "map",
+		Description: "Collections/tables configuration, by collection/table name",
+		Properties: []PropertySpec{
+			{
+				Name:        "string",
+				Type:        "map",
+				Description: "Configuration of a single collection/table",
+				Properties:  settings,
 			},
 		},
-		"user": "string",
-		"pass": "string",
+	}
+}
+
+var (
+	indexesSpec = PropertySpec{
+		Name:        "indexes",
+		Type:        "string array",
+		Description: "Fields to create indexes on",
+	}
+	enableTTLSpec = PropertySpec{
+		Name:        "enableTTL",
+		Type:        "bool",
+		Default:     false,
+		Description: "Expire the entries after the TTL",
+	}
+	ttlSpec = PropertySpec{
+		Name:        "TTL",
+		Type:        "int",
+		Description: "Time to live of the entries in seconds",
+	}
+)
+
+// addSupported adds new backends
+func addSupported(manager BackendManager) {
+	manager.SupportBackendWithSchema("mongodb", MongoDBBackendBuilder, []PropertySpec{
+		{Name: "dbName", Type: "string", Required: true, Description: "Backend type - mongodb"},
+		{Name: "host", Type: "string", Required: true, Description: "MongoDB host, host:port"},
+		{Name: "database", Type: "string", Required: true, Description: "Name of the database"},
+		collectionsSpec(indexesSpec, enableTTLSpec, ttlSpec),
+		{Name: "user", Type: "string", Description: "Username for authentication"},
+		{Name: "pass", Type: "string", Description: "Password for authentication"},
 	})
 
-	manager.SupportBackend("dynamodb", DynamoDBBackendBuilder, map[string]interface{}{
-		"dbName":      "string",
-		"credentials": "string",
-		"awsRegion":   "string",
-		"database":    "string",
-		"collections": map[string]interface{}{
-			"string": map[string]interface{}{
-				"indexes":   "string array",
-				"enableTTL": "bool",
-				"TTL":       "int",
-			},
-		},
+	manager.SupportBackendWithSchema("dynamodb", DynamoDBBackendBuilder, []PropertySpec{
+		{Name: "dbName", Type: "string", Required: true, Description: "Backend type - dynamodb"},
+		{Name: "credentials", Type: "string", Description: "Path to the AWS shared credentials file. Required if static credentials are not set"},
+		{Name: "awsRegion", Type: "string", Required: true, Description: "AWS region of the tables"},
+		{Name: "database", Type: "string", Description: "Name of the database"},
+		collectionsSpec(indexesSpec, enableTTLSpec, ttlSpec),
+	})
+
+	manager.SupportBackendWithSchema("memory", MemoryBackendBuilder, []PropertySpec{
+		{Name: "dbName", Type: "string", Required: true, Description: "Backend type - memory"},
+		collectionsSpec(indexesSpec),
 	})
 }
 
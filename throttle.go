@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrThrottled is the error class for the operations refused by the database because the request
+// rate or the provisioned throughput of the collection/table is exceeded. The operation can be
+// retried later, see ThrottledError.
+var ErrThrottled = ErrorClass("throttled")
+
+// IsErrThrottled check of the error is of the ErrThrottled class.
+func IsErrThrottled(err error) bool {
+	var throttled ThrottledError
+	if errors.As(err, &throttled) {
+		return true
+	}
+	return IsErrorOfType(err, ErrThrottled(""))
+}
+
+// ThrottledError is returned when the database throttles an operation, in place of the native
+// error of the backend, so the retry logic can handle the throttling of all the backends the
+// same way. RetryRepository retries it by default, waiting at least the RetryAfter:
+// 		var throttled backends.ThrottledError
+// 		if errors.As(err, &throttled) {
+// 			time.Sleep(throttled.RetryAfter)
+// 		}
+type ThrottledError struct {
+	// RetryAfter is the delay before a retry suggested by the database, or zero if the database
+	// does not suggest one, like DynamoDB.
+	RetryAfter time.Duration
+	// Cause is the native error of the backend.
+	Cause error
+}
+
+// Error returns the error message.
+func (e ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("throttled, retry after %s: %s", e.RetryAfter, e.Cause.Error())
+	}
+	return fmt.Sprintf("throttled: %s", e.Cause.Error())
+}
+
+// Unwrap returns the native error of the backend.
+func (e ThrottledError) Unwrap() error {
+	return e.Cause
+}
+
+// ThrottlingDetector recognizes the native throttling errors of a backend. It returns the delay
+// before a retry suggested by the database (zero if there is none) and true if err is a
+// throttling error.
+type ThrottlingDetector func(err error) (retryAfter time.Duration, ok bool)
+
+// WrapThrottlingError uses the detector to check if err is a throttling error and if so, converts
+// it to ThrottledError. Any other error is returned unchanged.
+func WrapThrottlingError(err error, detect ThrottlingDetector) error {
+	if err == nil {
+		return nil
+	}
+	if _, isThrottled := err.(ThrottledError); isThrottled {
+		return err
+	}
+	retryAfter, ok := detect(err)
+	if !ok {
+		return err
+	}
+	return ThrottledError{RetryAfter: retryAfter, Cause: err}
+}
+
+// throttleRetryAfter returns the delay before a retry suggested by a throttling error.
+func throttleRetryAfter(err error) time.Duration {
+	var throttled ThrottledError
+	if errors.As(err, &throttled) {
+		return throttled.RetryAfter
+	}
+	return 0
+}
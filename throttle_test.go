@@ -0,0 +1,66 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// throttlingRepository fails the counts of the wrapped repository with the native throttling
+// error of DynamoDB, normalized like DynamoCollection does, until it has failed once.
+type throttlingRepository struct {
+	Repository
+	retryAfter time.Duration
+	calls      *int32
+}
+
+func (r *throttlingRepository) WithContext(ctx context.Context) Repository {
+	return &throttlingRepository{Repository: r.Repository.WithContext(ctx), retryAfter: r.retryAfter, calls: r.calls}
+}
+
+func (r *throttlingRepository) Count(filter Filter) (int, error) {
+	if atomic.AddInt32(r.calls, 1) == 1 {
+		native := awserr.NewRequestFailure(awserr.New("ProvisionedThroughputExceededException", "rate exceeded", nil), 400, "request-id")
+		return 0, WrapThrottlingError(native, func(err error) (time.Duration, bool) {
+			_, ok := detectThrottling(err)
+			return r.retryAfter, ok
+		})
+	}
+	return r.Repository.Count(filter)
+}
+
+func TestThrottledError(t *testing.T) {
+	memoryRepo := newMemoryTestRepo(t, RepositoryDefinitionMap{"name": "users"})
+
+	calls := int32(0)
+	_, err := (&throttlingRepository{Repository: memoryRepo, calls: &calls}).Count(nil)
+	var throttled ThrottledError
+	if !IsErrThrottled(err) || !errors.As(err, &throttled) {
+		t.Fatal("Expected the throttling error of DynamoDB to be ErrThrottled. Got: ", err)
+	}
+	if ae, ok := throttled.Cause.(awserr.Error); !ok || ae.Code() != "ProvisionedThroughputExceededException" {
+		t.Fatal("Expected the native error as the cause. Got: ", throttled.Cause)
+	}
+
+	conditional := awserr.NewRequestFailure(awserr.New("ConditionalCheckFailedException", "failed", nil), 400, "request-id")
+	if err = WrapThrottlingError(conditional, detectThrottling); err != conditional || IsErrThrottled(err) {
+		t.Fatal("Expected the other errors to be returned unchanged. Got: ", err)
+	}
+
+	calls = 0
+	repo := NewRetryRepository(&throttlingRepository{Repository: memoryRepo, retryAfter: 50 * time.Millisecond, calls: &calls}, RetryPolicy{
+		Attempts: 2,
+		Backoff:  time.Millisecond,
+	})
+	start := time.Now()
+	if _, err = repo.Count(nil); err != nil || calls != 2 {
+		t.Fatal("Expected the throttled count to be retried. Got: ", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatal("Expected the retry to wait the suggested delay. Waited: ", elapsed)
+	}
+}
@@ -0,0 +1,479 @@
+package backends
+
+import (
+	"context"
+	"time"
+)
+
+// TimeoutRepository limits the duration of the operations of the wrapped repository.
+// An operation that does not complete in time returns context.DeadlineExceeded. The DynamoDB
+// requests are cancelled with the context, but the MongoDB and in-memory operations cannot be
+// cancelled, so they run to completion in the background and their result is discarded.
+// The operation may still decode into the result object after it times out, so the caller
+// must not use the result object of a timed out operation.
+type TimeoutRepository struct {
+	Repository
+	timeout time.Duration
+	ctx     context.Context
+	stats   *queryStatsRecorder
+}
+
+// NewTimeoutRepository wraps the repository so its operations time out after the given timeout.
+func NewTimeoutRepository(repo Repository, timeout time.Duration) *TimeoutRepository {
+	return &TimeoutRepository{
+		Repository: repo,
+		timeout:    timeout,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// run executes the operation on the repository bound to the operation context and waits
+// until the operation completes or the context is done.
+func (r *TimeoutRepository) run(operation func(repo Repository) error) error {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	return runUntilDone(ctx, func() error {
+		return operation(r.Repository.WithContext(ctx))
+	})
+}
+
+// runUntilDone runs the operation and waits until it completes or the context is done. The
+// operations that cannot be cancelled run to completion in the background.
+func runUntilDone(ctx context.Context, operation func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- operation()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Migrate applies the migrations through the wrapper, see Repository.Migrate.
+func (r *TimeoutRepository) Migrate(ctx context.Context, from, to int, migrations map[int]MigrationFunc) error {
+	return r.Repository.Migrate(ctx, from, to, bindMigrations(migrations, r))
+}
+
+// SaveIdempotent saves the object through the repository, see Repository.SaveIdempotent.
+func (r *TimeoutRepository) SaveIdempotent(object interface{}, idempotencyKey string, ttl time.Duration) (interface{}, bool, error) {
+	return r.Repository.SaveIdempotent(bindSave(object, r), idempotencyKey, ttl)
+}
+
+// WithContext returns a copy of the repository bound to the context. If the context has
+// a deadline, it is used instead of the default timeout.
+func (r *TimeoutRepository) WithContext(ctx context.Context) Repository {
+	return &TimeoutRepository{
+		Repository: r.Repository,
+		timeout:    r.timeout,
+		ctx:        ctx,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// WithSession returns a copy of the repository bound to the session, with the same timeout.
+func (r *TimeoutRepository) WithSession(session *Session) Repository {
+	return &TimeoutRepository{
+		Repository: r.Repository.WithSession(session),
+		timeout:    r.timeout,
+		ctx:        r.ctx,
+		stats:      newQueryStatsRecorder(),
+	}
+}
+
+// GetOne fetches only one record for given filter
+func (r *TimeoutRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	var found interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		found, err = repo.GetOne(filter, result)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// GetOneWithOpts fetches only one record for given filter using the given read options.
+func (r *TimeoutRepository) GetOneWithOpts(filter Filter, result interface{}, opts ReadOpts) (interface{}, error) {
+	var found interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		found, err = repo.GetOneWithOpts(filter, result, opts)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// GetAll fetches all matched records for given filter
+func (r *TimeoutRepository) GetAll(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.GetAll(filter, resultsTypeHint, order, sorting, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetFirst fetches the first of the matched records in the given order.
+func (r *TimeoutRepository) GetFirst(filter Filter, resultsTypeHint interface{}, order string, sorting string) (interface{}, error) {
+	var first interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		first, err = repo.GetFirst(filter, resultsTypeHint, order, sorting)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return first, nil
+}
+
+// GetAllWithOpts fetches all matched records for given filter using the given read options.
+func (r *TimeoutRepository) GetAllWithOpts(filter Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int, opts ReadOpts) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.GetAllWithOpts(filter, resultsTypeHint, order, sorting, limit, offset, opts)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		if decodeErrs, ok := err.(DecodeErrors); ok {
+			// the read completed, without the records that could not be decoded
+			return results, decodeErrs
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetAllByIndex fetches all matched records using the named index.
+func (r *TimeoutRepository) GetAllByIndex(indexName string, filter Filter, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.GetAllByIndex(indexName, filter, resultsTypeHint, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryRange fetches the records matching the hash key value and the range condition.
+func (r *TimeoutRepository) QueryRange(hashValue interface{}, rangeOp string, rangeValue interface{}, resultsTypeHint interface{}, limit int, offset int) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.QueryRange(hashValue, rangeOp, rangeValue, resultsTypeHint, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetAllWithHint fetches all matched records using the index hint.
+func (r *TimeoutRepository) GetAllWithHint(filter Filter, indexName string, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.GetAllWithHint(filter, indexName, resultsTypeHint, order, sorting, limit, offset)
+		r.stats.set(repo.LastQueryStats())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ParallelScan reads all matched records in segments. The timeout applies to the whole scan,
+// so bind the repository to a context with a longer deadline with WithContext for the exports
+// of large tables.
+func (r *TimeoutRepository) ParallelScan(filter Filter, segments int, resultsTypeHint interface{}, fn func(batch interface{}) error) error {
+	return r.run(func(repo Repository) error {
+		return repo.ParallelScan(filter, segments, resultsTypeHint, fn)
+	})
+}
+
+// LastQueryStats returns the stats of the most recent GetAll of the repository. The operations
+// run on a copy of the wrapped repository bound to the operation context, so the stats are taken
+// from the copy once the query completes.
+func (r *TimeoutRepository) LastQueryStats() QueryStats {
+	return r.stats.get()
+}
+
+// GetByIDs fetches the records with the given IDs.
+func (r *TimeoutRepository) GetByIDs(ids []interface{}, resultHint interface{}) (interface{}, error) {
+	var results interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.GetByIDs(ids, resultHint)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DescribeRepository returns the stats of the collection/table.
+func (r *TimeoutRepository) DescribeRepository() (RepositoryStats, error) {
+	var stats RepositoryStats
+	if err := r.run(func(repo Repository) (err error) {
+		stats, err = repo.DescribeRepository()
+		return err
+	}); err != nil {
+		return RepositoryStats{}, err
+	}
+	return stats, nil
+}
+
+// EnsureIndexes creates the indexes that the collection/table does not have.
+func (r *TimeoutRepository) EnsureIndexes(indexes []Index) error {
+	return r.run(func(repo Repository) error {
+		return repo.EnsureIndexes(indexes)
+	})
+}
+
+// Save creates new record or updates the existing one.
+func (r *TimeoutRepository) Save(object interface{}, filter Filter) (interface{}, error) {
+	var saved interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		saved, err = repo.Save(object, filter)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// SaveWithOpts creates new record or updates the existing one using the given write options.
+func (r *TimeoutRepository) SaveWithOpts(object interface{}, filter Filter, opts WriteOpts) (interface{}, error) {
+	var saved interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		saved, err = repo.SaveWithOpts(object, filter, opts)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// SaveWithTimestamp creates new record or updates the existing one and returns the time of the write.
+func (r *TimeoutRepository) SaveWithTimestamp(object interface{}, filter Filter) (interface{}, time.Time, error) {
+	var saved interface{}
+	var committedAt time.Time
+	if err := r.run(func(repo Repository) (err error) {
+		saved, committedAt, err = repo.SaveWithTimestamp(object, filter)
+		return err
+	}); err != nil {
+		return nil, time.Time{}, err
+	}
+	return saved, committedAt, nil
+}
+
+// SaveUpsert updates the record matching the filter or inserts a new record.
+func (r *TimeoutRepository) SaveUpsert(object interface{}, filter Filter) (interface{}, bool, error) {
+	var saved interface{}
+	var created bool
+	if err := r.run(func(repo Repository) (err error) {
+		saved, created, err = repo.SaveUpsert(object, filter)
+		return err
+	}); err != nil {
+		return nil, false, err
+	}
+	return saved, created, nil
+}
+
+// SaveIf updates the record matching the filter only if it also matches the condition.
+func (r *TimeoutRepository) SaveIf(object interface{}, filter Filter, condition Filter) (interface{}, bool, error) {
+	var saved interface{}
+	var applied bool
+	if err := r.run(func(repo Repository) (err error) {
+		saved, applied, err = repo.SaveIf(object, filter, condition)
+		return err
+	}); err != nil {
+		return nil, false, err
+	}
+	return saved, applied, nil
+}
+
+// FindAndModify claims up to limit records matching the filter.
+func (r *TimeoutRepository) FindAndModify(filter Filter, update map[string]interface{}, limit int, sort []SortKey) (interface{}, error) {
+	var claimed interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		claimed, err = repo.FindAndModify(filter, update, limit, sort)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// UpsertAll inserts or updates the objects matched by the conflict keys.
+func (r *TimeoutRepository) UpsertAll(objects []interface{}, conflictKeys []string) ([]interface{}, error) {
+	var results []interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		results, err = repo.UpsertAll(objects, conflictKeys)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Bulk returns a BulkOp whose execution is limited by the timeout as a whole.
+func (r *TimeoutRepository) Bulk() BulkOp {
+	return newBulkOp(func(operations []BulkOperation) (BulkResult, error) {
+		var result BulkResult
+		err := r.run(func(repo Repository) (err error) {
+			result, err = runBulk(repo, operations)
+			return err
+		})
+		return result, err
+	})
+}
+
+// ReplaceOne replaces the record matching the filter and returns the previous record.
+func (r *TimeoutRepository) ReplaceOne(filter Filter, object interface{}) (interface{}, error) {
+	var previous interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		previous, err = repo.ReplaceOne(filter, object)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// DeleteOne deletes only one record for given filter
+func (r *TimeoutRepository) DeleteOne(filter Filter) error {
+	return r.run(func(repo Repository) error {
+		return repo.DeleteOne(filter)
+	})
+}
+
+// DeleteIf deletes the record matching the filter only if it also matches the condition.
+func (r *TimeoutRepository) DeleteIf(filter Filter, condition Filter) (bool, error) {
+	var deleted bool
+	if err := r.run(func(repo Repository) (err error) {
+		deleted, err = repo.DeleteIf(filter, condition)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// DeleteAll deletes all matched records for given filter
+func (r *TimeoutRepository) DeleteAll(filter Filter) error {
+	return r.run(func(repo Repository) error {
+		return repo.DeleteAll(filter)
+	})
+}
+
+// DeleteAllReturning deletes all matched records and returns their IDs.
+func (r *TimeoutRepository) DeleteAllReturning(filter Filter) ([]interface{}, error) {
+	var ids []interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		ids, err = repo.DeleteAllReturning(filter)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// UpdateFieldsReturning sets the fields on all matched records and returns their IDs.
+func (r *TimeoutRepository) UpdateFieldsReturning(filter Filter, fields map[string]interface{}) ([]interface{}, error) {
+	var ids []interface{}
+	if err := r.run(func(repo Repository) (err error) {
+		ids, err = repo.UpdateFieldsReturning(filter, fields)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ArrayAppend appends the values to the array of the field of the matched records.
+func (r *TimeoutRepository) ArrayAppend(filter Filter, field string, values ...interface{}) (int64, error) {
+	var updated int64
+	if err := r.run(func(repo Repository) (err error) {
+		updated, err = repo.ArrayAppend(filter, field, values...)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// ArrayRemove removes the values from the array of the field of the matched records.
+func (r *TimeoutRepository) ArrayRemove(filter Filter, field string, values ...interface{}) (int64, error) {
+	var updated int64
+	if err := r.run(func(repo Repository) (err error) {
+		updated, err = repo.ArrayRemove(filter, field, values...)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// UpdatePath sets the value at the path of the matched records.
+func (r *TimeoutRepository) UpdatePath(filter Filter, path string, value interface{}) (int64, error) {
+	var updated int64
+	if err := r.run(func(repo Repository) (err error) {
+		updated, err = repo.UpdatePath(filter, path, value)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// Exists checks if there is at least one record matching the filter.
+func (r *TimeoutRepository) Exists(filter Filter) (bool, error) {
+	var exists bool
+	if err := r.run(func(repo Repository) (err error) {
+		exists, err = repo.Exists(filter)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// Count returns the number of records matching the filter.
+func (r *TimeoutRepository) Count(filter Filter) (int, error) {
+	var count int
+	if err := r.run(func(repo Repository) (err error) {
+		count, err = repo.Count(filter)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountBoth returns the number of records matching the filter and the number of all the records.
+func (r *TimeoutRepository) CountBoth(filter Filter) (int64, int64, error) {
+	var filtered, total int64
+	if err := r.run(func(repo Repository) (err error) {
+		filtered, total, err = repo.CountBoth(filter)
+		return err
+	}); err != nil {
+		return 0, 0, err
+	}
+	return filtered, total, nil
+}
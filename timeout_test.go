@@ -0,0 +1,102 @@
+package backends
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// slowRepository delays the lookups of the wrapped repository.
+type slowRepository struct {
+	Repository
+	delay time.Duration
+}
+
+func (r *slowRepository) WithContext(ctx context.Context) Repository {
+	return &slowRepository{Repository: r.Repository.WithContext(ctx), delay: r.delay}
+}
+
+func (r *slowRepository) GetOne(filter Filter, result interface{}) (interface{}, error) {
+	time.Sleep(r.delay)
+	return r.Repository.GetOne(filter, result)
+}
+
+func newSlowTestBackend(t *testing.T, delay time.Duration, timeout time.Duration) Backend {
+	builder := func(def RepositoryDefinition, backend Backend) (Repository, error) {
+		return &slowRepository{Repository: newMemoryTestRepo(t, def.(RepositoryDefinitionMap)), delay: delay}, nil
+	}
+	return NewRepositoriesBackend(context.Background(), &config.DBInfo{}, builder, func() {}, WithDefaultQueryTimeout(timeout))
+}
+
+func TestDefaultQueryTimeout(t *testing.T) {
+	backend := newSlowTestBackend(t, 200*time.Millisecond, 20*time.Millisecond)
+	repo, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Save(&memoryTestEntry{Name: "John"}, nil)
+	if err != nil {
+		t.Fatal("Expected the fast operation to complete. Got: ", err)
+	}
+	filter := NewFilter().Match("id", result.(*memoryTestEntry).ID)
+
+	if _, err = repo.GetOne(filter, &memoryTestEntry{}); err != context.DeadlineExceeded {
+		t.Fatal("Expected the slow operation to time out. Got: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err = repo.WithContext(ctx).GetOne(filter, &memoryTestEntry{}); err != nil {
+		t.Fatal("Expected the deadline of the caller to take precedence. Got: ", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err = repo.WithContext(ctx).GetOne(filter, &memoryTestEntry{}); err != context.DeadlineExceeded {
+		t.Fatal("Expected the operation to time out with the deadline of the caller. Got: ", err)
+	}
+}
+
+func TestRepositoryQueryTimeout(t *testing.T) {
+	backend := newSlowTestBackend(t, 100*time.Millisecond, time.Second)
+	reports, err := backend.DefineRepository("reports", RepositoryDefinitionMap{"name": "reports", "queryTimeout": "10ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := backend.DefineRepository("users", RepositoryDefinitionMap{"name": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = reports.GetOne(NewFilter().Match("name", "daily"), &memoryTestEntry{}); err != context.DeadlineExceeded {
+		t.Fatal("Expected the timeout of the repository to replace the default timeout. Got: ", err)
+	}
+	if _, err = users.GetOne(NewFilter().Match("name", "John"), &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected the other repositories to keep the default timeout. Got: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err = reports.WithContext(ctx).GetOne(NewFilter().Match("name", "daily"), &memoryTestEntry{}); !IsErrNotFound(err) {
+		t.Fatal("Expected the deadline of the caller to take precedence. Got: ", err)
+	}
+
+	if _, err = backend.DefineRepository("audit", RepositoryDefinitionMap{"name": "audit", "queryTimeout": -time.Second}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected a negative timeout to be invalid. Got: ", err)
+	}
+	for _, timeout := range []interface{}{"30 seconds", true} {
+		if _, err = backend.DefineRepository("audit", RepositoryDefinitionMap{"name": "audit", "queryTimeout": timeout}); !IsErrInvalidInput(err) {
+			t.Fatalf("Expected the timeout %v to be invalid. Got: %v", timeout, err)
+		}
+	}
+
+	// the numbers are seconds, like in a definition decoded from JSON
+	for timeout, expected := range map[interface{}]time.Duration{30: 30 * time.Second, 0.5: 500 * time.Millisecond, "2m": 2 * time.Minute} {
+		if got := (RepositoryDefinitionMap{"queryTimeout": timeout}).GetQueryTimeout(); got != expected {
+			t.Fatalf("Expected the timeout %v to be %s. Got: %s", timeout, expected, got)
+		}
+	}
+}
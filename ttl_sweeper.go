@@ -0,0 +1,103 @@
+package backends
+
+import (
+	"context"
+	"time"
+)
+
+// Clock returns the current time. The sweeper uses time.Now unless a different clock is set.
+type Clock func() time.Time
+
+// TTLSweeper deletes the expired records from a repository of a backend that does not expire
+// the records natively, like the in-memory backend. A record is expired when the value of
+// its TTL attribute (RepositoryDefinition.GetTTLAttribute) is older than GetTTL() seconds.
+//
+// The sweeper only issues DeleteAll with a filter on the TTL attribute, so it is safe to run
+// a sweeper for the same repository on multiple instances of a service. The instances race to
+// delete the same expired records, which is harmless - a record deleted by one instance is simply
+// not matched by the others. The only cost is the extra delete requests to the backend.
+// Note that the records are deleted at most one interval after they expire, so they may still
+// be read in the meantime.
+type TTLSweeper struct {
+	repo      Repository
+	attribute string
+	ttl       time.Duration
+	now       Clock
+	logger    Logger
+}
+
+// NewTTLSweeper creates a sweeper for the repository defined with the given definition.
+// TTL must be enabled on the definition.
+func NewTTLSweeper(repo Repository, repoDef RepositoryDefinition) (*TTLSweeper, error) {
+	if !repoDef.EnableTTL() {
+		return nil, ErrInvalidInput("TTL is not enabled for " + repoDef.GetName())
+	}
+	if repoDef.GetTTLAttribute() == "" {
+		return nil, ErrInvalidInput("TTL attribute is reqired when TTL is enabled")
+	}
+	if repoDef.GetTTL() <= 0 {
+		return nil, ErrInvalidInput("TTL value is missing and must be greater than zero")
+	}
+
+	return &TTLSweeper{
+		repo:      repo,
+		attribute: repoDef.GetTTLAttribute(),
+		ttl:       time.Duration(repoDef.GetTTL()) * time.Second,
+		now:       time.Now,
+		logger:    nopLogger{},
+	}, nil
+}
+
+// WithClock sets the clock used to decide which records are expired.
+func (s *TTLSweeper) WithClock(clock Clock) *TTLSweeper {
+	s.now = clock
+	return s
+}
+
+// WithLogger sets the logger of the sweep errors.
+func (s *TTLSweeper) WithLogger(logger Logger) *TTLSweeper {
+	s.logger = logger
+	return s
+}
+
+// Sweep deletes the records that are expired at the moment.
+func (s *TTLSweeper) Sweep() error {
+	expiredBefore := s.now().Add(-s.ttl)
+	return s.repo.DeleteAll(NewFilter().Lt(s.attribute, expiredBefore))
+}
+
+// Start sweeps the expired records every interval in the background, until the context is
+// cancelled. The returned channel is closed once the sweeper stops.
+// The sweep errors are logged to the logger of the sweeper (see WithLogger) and the sweeper
+// keeps running.
+func (s *TTLSweeper) Start(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Sweep(); err != nil {
+					s.logger.Errorf("TTL sweep failed: %s", err.Error())
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// StartTTLSweeper starts a TTL sweeper for the in-memory collection.
+// See TTLSweeper for details.
+func (c *MemoryCollection) StartTTLSweeper(ctx context.Context, interval time.Duration) (<-chan struct{}, error) {
+	sweeper, err := NewTTLSweeper(c, c.repoDef)
+	if err != nil {
+		return nil, err
+	}
+	return sweeper.WithLogger(c.logger).Start(ctx, interval), nil
+}
@@ -0,0 +1,101 @@
+package backends
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type ttlTestEntry struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func TestTTLSweeper(t *testing.T) {
+	def := RepositoryDefinitionMap{
+		"name":         "tokens",
+		"enableTtl":    true,
+		"ttlAttribute": "created_at",
+		"ttl":          3600,
+	}
+	repo := newMemoryTestRepo(t, def)
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	for name, createdAt := range map[string]time.Time{
+		"old":    now.Add(-2 * time.Hour),
+		"recent": now.Add(-30 * time.Minute),
+		"new":    now,
+	} {
+		if _, err := repo.Save(&ttlTestEntry{Name: name, CreatedAt: createdAt}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweeper, err := NewTTLSweeper(repo, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweeper.WithClock(func() time.Time {
+		return now
+	})
+
+	expectRemaining := func(expected ...string) {
+		t.Helper()
+		results, err := repo.GetAll(NewFilter(), &ttlTestEntry{}, "name", "asc", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := *(results.(*[]*ttlTestEntry))
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		if !strArrEq(names, expected) {
+			t.Fatalf("Expected the remaining entries to be %v, got %v", expected, names)
+		}
+	}
+
+	if err = sweeper.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	expectRemaining("new", "recent")
+
+	now = now.Add(45 * time.Minute)
+	if err = sweeper.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	expectRemaining("new")
+
+	now = now.Add(time.Hour)
+	if err = sweeper.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	expectRemaining()
+}
+
+func TestTTLSweeperStopsOnCancel(t *testing.T) {
+	repo := newMemoryTestRepo(t, RepositoryDefinitionMap{
+		"name":         "sessions",
+		"enableTtl":    true,
+		"ttlAttribute": "created_at",
+		"ttl":          60,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := repo.(*MemoryCollection).StartTTLSweeper(ctx, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the sweeper to stop when the context is cancelled")
+	}
+
+	if _, err = NewTTLSweeper(repo, RepositoryDefinitionMap{"name": "sessions"}); !IsErrInvalidInput(err) {
+		t.Fatal("Expected an error when TTL is not enabled. Got: ", err)
+	}
+}
@@ -0,0 +1,170 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ChangeType is the kind of the change of a ChangeEvent.
+type ChangeType string
+
+const (
+	// ChangeInsert is the insert of a new record.
+	ChangeInsert ChangeType = "insert"
+	// ChangeUpdate is the update or the replacement of a record.
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete is the delete of a record.
+	ChangeDelete ChangeType = "delete"
+)
+
+// ChangeEvent is a change of a record of a repository, sent by Repository.Watch.
+type ChangeEvent struct {
+	// Type is the kind of the change.
+	Type ChangeType
+	// ID is the ID of the changed record.
+	ID string
+	// Document is the record after the change, in the form described in GetOneRaw. It is nil
+	// for the deletes, and for the updates of a record deleted before it was read.
+	Document map[string]interface{}
+}
+
+// changeStream is a native stream of the changes of a repository, read by watchStream.
+type changeStream interface {
+	// Next blocks until the next change, and returns false when the stream ends.
+	Next() (ChangeEvent, bool)
+	// Close ends the stream, unblocking a pending Next.
+	Close() error
+}
+
+// watchStream sends the changes of the stream whose document matches the filter on the returned
+// channel, see Repository.Watch. The deletes have no document, so they are all sent. The stream
+// is closed when the context is cancelled, and the channel once the stream ends.
+func watchStream(ctx context.Context, stream changeStream, filter Filter) (<-chan ChangeEvent, error) {
+	if _, err := matchRecord(map[string]interface{}{}, filter); err != nil {
+		stream.Close()
+		return nil, ErrInvalidInput(err)
+	}
+
+	events := make(chan ChangeEvent)
+	done := make(chan struct{})
+	var closeOnce sync.Once
+	closeStream := func() {
+		closeOnce.Do(func() {
+			stream.Close()
+		})
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeStream()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer closeStream()
+
+		for {
+			event, ok := stream.Next()
+			if !ok {
+				return
+			}
+			if event.Document != nil {
+				matched, err := matchRecord(event.Document, filter)
+				if err != nil || !matched {
+					continue
+				}
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// mapChangeEvents returns the events converted with the convert function, for the wrappers that
+// change the stored records. The converted channel is closed once the events channel is.
+func mapChangeEvents(ctx context.Context, events <-chan ChangeEvent, convert func(event ChangeEvent) ChangeEvent) <-chan ChangeEvent {
+	converted := make(chan ChangeEvent)
+	go func() {
+		defer close(converted)
+		for event := range events {
+			select {
+			case converted <- convert(event):
+			case <-ctx.Done():
+				// the events channel is closed after the cancellation
+			}
+		}
+	}()
+	return converted
+}
+
+// mongoChangeStream is the change stream of a MongoDB collection, read with a $changeStream
+// aggregation on its own session, so closing the session unblocks the pending read.
+type mongoChangeStream struct {
+	collection *MongoCollection
+	session    *mgo.Session
+	iter       *mgo.Iter
+}
+
+// mongoChange is a document of a MongoDB change stream.
+type mongoChange struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   bson.M `bson:"documentKey"`
+	FullDocument  bson.M `bson:"fullDocument"`
+}
+
+// Next returns the next insert, update, replace or delete of the collection. The stream ends with
+// the other events, like the drop of the collection.
+func (s *mongoChangeStream) Next() (ChangeEvent, bool) {
+	for {
+		change := mongoChange{}
+		if !s.iter.Next(&change) {
+			return ChangeEvent{}, false
+		}
+
+		event := ChangeEvent{}
+		switch change.OperationType {
+		case "insert":
+			event.Type = ChangeInsert
+		case "update", "replace":
+			event.Type = ChangeUpdate
+		case "delete":
+			event.Type = ChangeDelete
+		default:
+			return ChangeEvent{}, false
+		}
+
+		switch id := change.DocumentKey["_id"].(type) {
+		case bson.ObjectId:
+			event.ID = id.Hex()
+		default:
+			event.ID = fmt.Sprint(id)
+		}
+		if change.FullDocument != nil {
+			document, err := s.collection.rawDocument(change.FullDocument)
+			if err != nil {
+				continue
+			}
+			event.Document = document
+		}
+		return event, true
+	}
+}
+
+// Close closes the session of the stream.
+func (s *mongoChangeStream) Close() error {
+	s.session.Close()
+	return nil
+}
@@ -0,0 +1,139 @@
+package backends
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChangeStream is a change stream fed with the events of a channel.
+type fakeChangeStream struct {
+	events    chan ChangeEvent
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeChangeStream() *fakeChangeStream {
+	return &fakeChangeStream{events: make(chan ChangeEvent), closed: make(chan struct{})}
+}
+
+func (s *fakeChangeStream) Next() (ChangeEvent, bool) {
+	select {
+	case event := <-s.events:
+		return event, true
+	case <-s.closed:
+		return ChangeEvent{}, false
+	}
+}
+
+func (s *fakeChangeStream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
+
+// fakeWatchRepository is a repository whose changes are read from a fake stream.
+type fakeWatchRepository struct {
+	Repository
+	stream *fakeChangeStream
+}
+
+func (r *fakeWatchRepository) Watch(ctx context.Context, filter Filter) (<-chan ChangeEvent, error) {
+	return watchStream(ctx, r.stream, filter)
+}
+
+func receiveChange(t *testing.T, events <-chan ChangeEvent) ChangeEvent {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Expected a change event. The channel is closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Expected a change event. Got none")
+	}
+	return ChangeEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	stream := newFakeChangeStream()
+	repo := &fakeWatchRepository{Repository: NewMemoryCollection(RepositoryDefinitionMap{"name": "users"}), stream: stream}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := repo.Watch(ctx, NewFilter().Match("active", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		stream.events <- ChangeEvent{Type: ChangeInsert, ID: "1", Document: map[string]interface{}{"id": "1", "active": true}}
+		stream.events <- ChangeEvent{Type: ChangeInsert, ID: "2", Document: map[string]interface{}{"id": "2", "active": false}}
+		stream.events <- ChangeEvent{Type: ChangeUpdate, ID: "1", Document: map[string]interface{}{"id": "1", "active": true, "name": "John"}}
+		stream.events <- ChangeEvent{Type: ChangeDelete, ID: "2"}
+	}()
+
+	expected := []ChangeEvent{
+		{Type: ChangeInsert, ID: "1"},
+		{Type: ChangeUpdate, ID: "1"},
+		{Type: ChangeDelete, ID: "2"},
+	}
+	for _, want := range expected {
+		event := receiveChange(t, events)
+		if event.Type != want.Type || event.ID != want.ID {
+			t.Fatalf("Expected the %s of %s. Got the %s of %s", want.Type, want.ID, event.Type, event.ID)
+		}
+		if want.Type == ChangeUpdate && event.Document["name"] != "John" {
+			t.Fatalf("Expected the updated document. Got: %v", event.Document)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("Expected no more events after the cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the channel to be closed after the cancellation")
+	}
+	select {
+	case <-stream.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stream to be closed after the cancellation")
+	}
+}
+
+func TestWatchThroughFieldMapping(t *testing.T) {
+	stream := newFakeChangeStream()
+	repo := NewFieldMappingRepository(&fakeWatchRepository{
+		Repository: NewMemoryCollection(RepositoryDefinitionMap{"name": "users"}),
+		stream:     stream,
+	}, map[string]string{"userName": "user_name"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := repo.Watch(ctx, NewFilter().Match("userName", "john"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		stream.events <- ChangeEvent{Type: ChangeInsert, ID: "2", Document: map[string]interface{}{"id": "2", "user_name": "mary"}}
+		stream.events <- ChangeEvent{Type: ChangeInsert, ID: "1", Document: map[string]interface{}{"id": "1", "user_name": "john"}}
+	}()
+
+	event := receiveChange(t, events)
+	if event.ID != "1" || event.Document["userName"] != "john" {
+		t.Fatalf("Expected the insert of john with the Go names. Got: %+v", event)
+	}
+}
+
+func TestMemoryWatchUnsupported(t *testing.T) {
+	repo := NewMemoryCollection(RepositoryDefinitionMap{"name": "users"})
+	if _, err := repo.Watch(context.Background(), nil); !IsErrUnsupported(err) {
+		t.Fatal("Expected the in-memory backend not to support change streams. Got: ", err)
+	}
+}